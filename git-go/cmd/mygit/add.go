@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// matchPathspec reports whether a path is the given file or lies inside the
+// given directory.
+func matchPathspec(spec, p string) bool {
+	return spec == "." || p == spec || strings.HasPrefix(p, spec+"/")
+}
+
+func cleanPathspec(arg string) string {
+	return filepath.ToSlash(filepath.Clean(arg))
+}
+
+func addCommand(args []string) error {
+	force := false
+	specs := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch arg {
+		case "-f", "--force":
+			force = true
+		default:
+			specs = append(specs, arg)
+		}
+	}
+	if len(specs) == 0 {
+		return errors.New("Nothing specified, nothing added.")
+	}
+
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	var ignore *ignoreMatcher
+	if !force {
+		if ignore, err = newIgnoreMatcher(); err != nil {
+			return err
+		}
+	}
+	files, err := listWorktree(idx, ignore)
+	if err != nil {
+		return err
+	}
+
+	ignored := make([]string, 0)
+	for _, arg := range specs {
+		spec := cleanPathspec(arg)
+		if arg == "-A" || arg == "--all" {
+			spec = "."
+		}
+
+		matched := false
+		for _, p := range files {
+			if matchPathspec(spec, p) {
+				matched = true
+				if err := stageFile(idx, p); err != nil {
+					return err
+				}
+			}
+		}
+		for _, e := range append([]indexEntry(nil), idx.Entries...) {
+			if !matchPathspec(spec, e.Path) {
+				continue
+			}
+			matched = true
+			if _, err := os.Lstat(e.Path); os.IsNotExist(err) {
+				idx.remove(e.Path)
+			}
+		}
+		if matched {
+			continue
+		}
+		// Naming an ignored path is an error, but the others still get
+		// added.
+		if info, err := os.Lstat(spec); err == nil && ignore != nil {
+			if ok, err := ignore.ignored(spec, info.IsDir()); err != nil {
+				return err
+			} else if ok {
+				ignored = append(ignored, spec)
+				continue
+			}
+		}
+		return fmt.Errorf("pathspec '%s' did not match any files", arg)
+	}
+
+	if err := idx.write(); err != nil {
+		return err
+	}
+	if len(ignored) > 0 {
+		return fmt.Errorf("The following paths are ignored by one of your .gitignore files:\n%s\nhint: Use -f if you really want to add them.",
+			strings.Join(ignored, "\n"))
+	}
+	return nil
+}
+
+// stageFile writes the blob of a working tree file and records it in the
+// index, replacing any conflict stages the path had.
+func stageFile(idx *index, p string) error {
+	if e, ok := idx.entry(p); ok {
+		if info, err := os.Lstat(p); err == nil && e.statMatches(info) {
+			return nil
+		}
+	}
+
+	b, info, err := readWorktreeFile(p, true)
+	if err != nil {
+		return err
+	}
+	checksum, err := writeObject("blob", b)
+	if err != nil {
+		return err
+	}
+
+	e, err := newIndexEntry(p, fileMode(info), fmt.Sprintf("%x", checksum))
+	if err != nil {
+		return err
+	}
+	idx.add(e)
+	return nil
+}
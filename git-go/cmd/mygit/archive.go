@@ -0,0 +1,790 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Notes about archives:
+// - Entries come in tree order with the prefix in front of their paths.
+//   A directory is only written once something inside it is, so that
+//   directories left empty by pathspecs or export-ignore are not.
+// - Files are converted as on checkout, with attributes read from the
+//   archived tree unless --worktree-attributes is given. Blobs larger than
+//   core.bigFileThreshold are streamed from the object store unconverted.
+// - Tar archives are laid out like git's: ustar headers with the modes
+//   reduced by tar.umask, 512 byte blocks padded to 10240 byte records, and
+//   a pax global header carrying the commit id. Paths and link targets too
+//   long for the header go in pax extended headers.
+// - Zip archives store files that deflating does not make smaller, give
+//   every entry an extended timestamp and carry the commit id as comment.
+
+var archiveFormats = []string{"tar", "tgz", "tar.gz", "zip"}
+
+type archiveEntry struct {
+	Path   string // with the prefix, directories ending in "/"
+	Mode   int
+	Sha    string
+	Data   []byte    // content of files and link targets
+	Stream io.Reader // content of streamed files, Data being nil
+	Size   int64
+	Binary bool
+}
+
+type archiveWriter interface {
+	add(e archiveEntry) error
+	close() error
+}
+
+type archiver struct {
+	w         archiveWriter
+	prefix    string
+	specs     []string
+	matched   map[string]bool
+	attrs     *attrChecker
+	threshold int64
+
+	// Directories waiting for their first entry.
+	pending []archiveEntry
+}
+
+func archiveCommand(args []string) error {
+	format, prefix, output := "", "", ""
+	level := -1
+	worktreeAttrs, doubleDash := false, false
+	rest := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case doubleDash:
+			rest = append(rest, arg)
+		case arg == "--":
+			doubleDash = true
+		case arg == "-l" || arg == "--list":
+			for _, f := range archiveFormats {
+				fmt.Println(f)
+			}
+			return nil
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		case strings.HasPrefix(arg, "--prefix="):
+			prefix = strings.TrimPrefix(arg, "--prefix=")
+		case strings.HasPrefix(arg, "--output="):
+			output = strings.TrimPrefix(arg, "--output=")
+		case arg == "--format" || arg == "--prefix" || arg == "-o" || arg == "--output":
+			if i+1 == len(args) {
+				return fmt.Errorf("option `%s' requires a value", strings.TrimLeft(arg, "-"))
+			}
+			i++
+			switch arg {
+			case "--format":
+				format = args[i]
+			case "--prefix":
+				prefix = args[i]
+			default:
+				output = args[i]
+			}
+		case arg == "--worktree-attributes":
+			worktreeAttrs = true
+		case len(arg) == 2 && arg[0] == '-' && arg[1] >= '0' && arg[1] <= '9':
+			level = int(arg[1] - '0')
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) == 0 {
+		return errors.New("usage: mygit archive [--format=<fmt>] [--prefix=<prefix>/] [-o <file>] <tree-ish> [<path>...]")
+	}
+
+	if format == "" {
+		format = "tar"
+		for _, f := range archiveFormats {
+			if strings.HasSuffix(output, "."+f) {
+				format = f
+			}
+		}
+	}
+	known := false
+	for _, f := range archiveFormats {
+		known = known || f == format
+	}
+	if !known {
+		return fmt.Errorf("Unknown archive format '%s'", format)
+	}
+	if format == "tar" && level >= 0 {
+		return fmt.Errorf("Argument not supported for format 'tar': -%d", level)
+	}
+
+	tree, commitSha, mtime, err := archiveTree(rest[0])
+	if err != nil {
+		return err
+	}
+	threshold, err := configInt("core.bigFileThreshold", 512<<20)
+	if err != nil {
+		return err
+	}
+	a := &archiver{prefix: prefix, matched: make(map[string]bool), threshold: threshold}
+	for _, spec := range rest[1:] {
+		a.specs = append(a.specs, cleanPathspec(spec))
+	}
+	if worktreeAttrs {
+		a.attrs, err = attributes()
+	} else {
+		var files map[string]treeEntry
+		if files, err = flattenTree(tree); err == nil {
+			a.attrs, err = newAttrChecker(files)
+			// Conversions look attributes up on their own.
+			loadedAttrs = a.attrs
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	buf := bufio.NewWriter(out)
+	out = buf
+	var gz *gzip.Writer
+	if format == "tgz" || format == "tar.gz" {
+		if level < 0 {
+			level = gzip.DefaultCompression
+		}
+		if gz, err = gzip.NewWriterLevel(out, level); err != nil {
+			return err
+		}
+		gz.OS = 3
+		out = gz
+	}
+
+	if format == "zip" {
+		a.w = newZipWriter(out, mtime, level, commitSha)
+	} else {
+		a.w, err = newTarWriter(out, mtime, commitSha)
+		if err != nil {
+			return err
+		}
+	}
+
+	if strings.HasSuffix(prefix, "/") {
+		dir := strings.TrimRight(prefix, "/") + "/"
+		if err := a.w.add(archiveEntry{Path: dir, Mode: modeTree | 0777, Sha: tree}); err != nil {
+			return err
+		}
+	}
+	if err := a.walk(tree, ""); err != nil {
+		return err
+	}
+	for _, spec := range rest[1:] {
+		if !a.matched[cleanPathspec(spec)] {
+			return fmt.Errorf("pathspec '%s' did not match any files", spec)
+		}
+	}
+
+	if err := a.w.close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// archiveTree resolves the tree-ish to archive. The commit it names, if
+// any, gives the id to record and the time of the entries; otherwise they
+// get the current time.
+func archiveTree(arg string) (string, string, int64, error) {
+	if i := strings.IndexByte(arg, ':'); i >= 0 {
+		rev, p := arg[:i], arg[i+1:]
+		tree, err := resolveTree(rev)
+		if err != nil {
+			return "", "", 0, err
+		}
+		if tree, err = treeAt(tree, p); err != nil {
+			return "", "", 0, fmt.Errorf("path '%s' does not exist in '%s'", p, rev)
+		}
+		return tree, "", time.Now().Unix(), nil
+	}
+
+	sha, err := revParse(arg)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("not a valid object name: %s", arg)
+	}
+	tree, err := peel(sha, "tree")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("not a tree object: %s", sha)
+	}
+	if commitSha, err := peel(sha, "commit"); err == nil {
+		c, err := readCommit(commitSha)
+		if err != nil {
+			return "", "", 0, err
+		}
+		return tree, commitSha, c.Time(), nil
+	}
+	return tree, "", time.Now().Unix(), nil
+}
+
+// treeAt returns the tree at a path below another one.
+func treeAt(tree, p string) (string, error) {
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		entries, err := readTree(tree)
+		if err != nil {
+			return "", err
+		}
+		found := false
+		for _, e := range entries {
+			if e.Name == name && e.IsTree() {
+				tree, found = e.Sha, true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("no tree %s", p)
+		}
+	}
+	return tree, nil
+}
+
+// wanted tells whether a path is to be archived, or for a directory
+// whether anything inside it might be.
+func (a *archiver) wanted(p string, dir bool) bool {
+	if len(a.specs) == 0 {
+		return true
+	}
+	ok := false
+	for _, spec := range a.specs {
+		if matchPathspec(spec, p) || strings.ContainsAny(spec, "*?[") && wildmatch(spec, p, 0) {
+			a.matched[spec] = true
+			ok = true
+		} else if dir && (strings.HasPrefix(spec, p+"/") || strings.ContainsAny(spec, "*?[")) {
+			ok = true
+		}
+	}
+	return ok
+}
+
+func (a *archiver) walk(tree, dir string) error {
+	entries, err := readTree(tree)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		p := dir + e.Name
+		if !a.wanted(p, e.IsTree()) {
+			continue
+		}
+		ignored, err := a.attrs.attr(p, "export-ignore")
+		if err != nil {
+			return err
+		}
+		if ignored == attrSet {
+			continue
+		}
+
+		if e.IsTree() {
+			a.pending = append(a.pending, archiveEntry{Path: a.prefix + p + "/", Mode: e.Mode, Sha: e.Sha})
+			if err := a.walk(e.Sha, p+"/"); err != nil {
+				return err
+			}
+			if n := len(a.pending); n > 0 && a.pending[n-1].Path == a.prefix+p+"/" {
+				a.pending = a.pending[:n-1]
+			}
+			continue
+		}
+		if err := a.flushPending(); err != nil {
+			return err
+		}
+		if err := a.add(p, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *archiver) flushPending() error {
+	for _, d := range a.pending {
+		if err := a.w.add(d); err != nil {
+			return err
+		}
+	}
+	a.pending = a.pending[:0]
+	return nil
+}
+
+// add writes a file, a symbolic link or a submodule.
+func (a *archiver) add(p string, e treeEntry) error {
+	entry := archiveEntry{Path: a.prefix + p, Mode: e.Mode, Sha: e.Sha}
+	if e.Mode == modeGitlink {
+		entry.Path += "/"
+		return a.w.add(entry)
+	}
+
+	t, size, r, err := openObject(e.Sha)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if t != "blob" {
+		return fmt.Errorf("object %s is a %s, not a blob", e.Sha, t)
+	}
+	diff, err := a.attrs.attr(p, "diff")
+	if err != nil {
+		return err
+	}
+	if e.Mode != modeSymlink && size > a.threshold {
+		// Whether a streamed file is text is judged by its start.
+		br := bufio.NewReaderSize(r, 16384)
+		start, _ := br.Peek(16384)
+		entry.Binary = diff == attrUnset || diff != attrSet && isBinary(start)
+		entry.Stream, entry.Size = br, size
+		return a.w.add(entry)
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if e.Mode != modeSymlink {
+		if b, err = convertToWorktree(p, b); err != nil {
+			return err
+		}
+	}
+	entry.Binary = diff == attrUnset || diff != attrSet && isBinary(b)
+	entry.Data, entry.Size = b, int64(len(b))
+	return a.w.add(entry)
+}
+
+const (
+	tarBlockSize  = 512
+	tarRecordSize = 20 * tarBlockSize
+	tarMaxSize    = 0o77777777777
+)
+
+type tarWriter struct {
+	w     io.Writer
+	n     int64
+	mtime int64
+	umask int
+}
+
+func newTarWriter(w io.Writer, mtime int64, commitSha string) (*tarWriter, error) {
+	t := &tarWriter{w: w, mtime: mtime, umask: 0o002}
+	if v, ok := configValue("tar.umask"); ok {
+		if v == "user" {
+			t.umask = processUmask()
+		} else {
+			n, err := strconv.ParseInt(v, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad tar.umask %s", v)
+			}
+			t.umask = int(n)
+		}
+	}
+	if commitSha != "" {
+		var h [tarBlockSize]byte
+		copy(h[:], "pax_global_header")
+		ext := paxRecord("comment", commitSha)
+		t.header(&h, 'g', 0o100666, int64(len(ext)))
+		if err := t.writeBlocked(h[:]); err != nil {
+			return nil, err
+		}
+		if err := t.writeBlocked(ext); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// processUmask returns the umask of the process, which can only be read by
+// setting it.
+func processUmask() int {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return mask
+}
+
+// paxRecord formats a pax extended header record, "<length> key=value\n",
+// the length counting its own digits.
+func paxRecord(key, value string) []byte {
+	n := 1 + 1 + len(key) + 1 + len(value) + 1
+	for tmp := 1; n/10 >= tmp; tmp *= 10 {
+		n++
+	}
+	return []byte(fmt.Sprintf("%d %s=%s\n", n, key, value))
+}
+
+// tarPathPrefix returns where to split a path into the prefix and the name
+// fields of a header, 0 when it cannot be.
+func tarPathPrefix(p string, max int) int {
+	i := len(p)
+	if i > 1 && p[i-1] == '/' {
+		i--
+	}
+	if i > max {
+		i = max
+	}
+	for i--; i > 0 && p[i] != '/'; i-- {
+	}
+	return i
+}
+
+func (t *tarWriter) add(e archiveEntry) error {
+	var h [tarBlockSize]byte
+	var ext []byte
+	mode := e.Mode
+	var typeflag byte
+	switch e.Mode {
+	case modeSymlink:
+		typeflag = '2'
+		mode |= 0o777
+	case modeBlob, modeExecutable:
+		typeflag = '0'
+		if mode&0o100 != 0 {
+			mode |= 0o777
+		} else {
+			mode |= 0o666
+		}
+		mode &^= t.umask
+	default:
+		typeflag = '5'
+		mode = (mode | 0o777) &^ t.umask
+	}
+
+	if len(e.Path) > 100 {
+		plen := tarPathPrefix(e.Path, 155)
+		if rest := len(e.Path) - plen - 1; plen > 0 && rest <= 100 {
+			copy(h[345:500], e.Path[:plen])
+			copy(h[0:100], e.Path[plen+1:])
+		} else {
+			copy(h[0:100], e.Sha+".data")
+			ext = append(ext, paxRecord("path", e.Path)...)
+		}
+	} else {
+		copy(h[0:100], e.Path)
+	}
+	if typeflag == '2' {
+		if len(e.Data) > 100 {
+			copy(h[157:257], "see "+e.Sha+".paxheader")
+			ext = append(ext, paxRecord("linkpath", string(e.Data))...)
+		} else {
+			copy(h[157:257], e.Data)
+		}
+	}
+	size := e.Size
+	if typeflag == '0' && size > tarMaxSize {
+		size = 0
+		ext = append(ext, paxRecord("size", strconv.FormatInt(e.Size, 10))...)
+	}
+
+	if len(ext) > 0 {
+		var x [tarBlockSize]byte
+		copy(x[:], e.Sha+".paxheader")
+		t.header(&x, 'x', 0o100666, int64(len(ext)))
+		if err := t.writeBlocked(x[:]); err != nil {
+			return err
+		}
+		if err := t.writeBlocked(ext); err != nil {
+			return err
+		}
+	}
+	if typeflag != '0' {
+		size = 0
+	}
+	t.header(&h, typeflag, mode, size)
+	if err := t.writeBlocked(h[:]); err != nil {
+		return err
+	}
+	if typeflag != '0' || e.Size == 0 {
+		return nil
+	}
+	if e.Stream == nil {
+		return t.writeBlocked(e.Data)
+	}
+	n, err := io.Copy(t.w, e.Stream)
+	t.n += n
+	if err != nil {
+		return err
+	}
+	return t.pad()
+}
+
+// header fills in the fields of a header other than the names.
+func (t *tarWriter) header(h *[tarBlockSize]byte, typeflag byte, mode int, size int64) {
+	copy(h[100:108], fmt.Sprintf("%07o", mode&0o7777))
+	copy(h[108:116], fmt.Sprintf("%07o", 0))
+	copy(h[116:124], fmt.Sprintf("%07o", 0))
+	copy(h[124:136], fmt.Sprintf("%011o", size))
+	copy(h[136:148], fmt.Sprintf("%011o", t.mtime))
+	h[156] = typeflag
+	copy(h[257:263], "ustar\x00")
+	copy(h[263:265], "00")
+	copy(h[265:297], "root")
+	copy(h[297:329], "root")
+	copy(h[329:337], fmt.Sprintf("%07o", 0))
+	copy(h[337:345], fmt.Sprintf("%07o", 0))
+
+	// The checksum is taken with its own field filled with spaces.
+	copy(h[148:156], "        ")
+	sum := 0
+	for _, c := range h {
+		sum += int(c)
+	}
+	copy(h[148:156], fmt.Sprintf("%07o\x00", sum))
+}
+
+// writeBlocked writes data padded to a whole number of blocks.
+func (t *tarWriter) writeBlocked(b []byte) error {
+	n, err := t.w.Write(b)
+	t.n += int64(n)
+	if err != nil {
+		return err
+	}
+	return t.pad()
+}
+
+func (t *tarWriter) pad() error {
+	if rem := t.n % tarBlockSize; rem != 0 {
+		n, err := t.w.Write(make([]byte, tarBlockSize-rem))
+		t.n += int64(n)
+		return err
+	}
+	return nil
+}
+
+// close ends the archive with zeros up to the end of a record, adding a
+// whole record when that leaves less than two blocks.
+func (t *tarWriter) close() error {
+	tail := tarRecordSize - t.n%tarRecordSize
+	if tail < 2*tarBlockSize {
+		tail += tarRecordSize
+	}
+	_, err := t.w.Write(make([]byte, tail))
+	return err
+}
+
+type zipWriter struct {
+	w       io.Writer
+	offset  int64
+	dir     bytes.Buffer
+	entries int
+	mtime   int64
+	level   int
+	comment string
+
+	dosTime, dosDate uint16
+}
+
+func newZipWriter(w io.Writer, mtime int64, level int, commitSha string) *zipWriter {
+	z := &zipWriter{w: w, mtime: mtime, level: level, comment: commitSha}
+	if z.level < 0 {
+		z.level = flate.DefaultCompression
+	}
+	tm := time.Unix(mtime, 0)
+	z.dosDate = uint16(tm.Day() + int(tm.Month())*32 + (tm.Year()-1980)*512)
+	z.dosTime = uint16(tm.Second()/2 + tm.Minute()*32 + tm.Hour()*2048)
+	return z
+}
+
+// countingWriter counts what goes through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+func (z *zipWriter) add(e archiveEntry) error {
+	var method, flags, creator uint16
+	var attr uint32
+	var crc uint32
+	size, compressed := e.Size, int64(0)
+	out := e.Data
+	text := !e.Binary
+	switch e.Mode {
+	case modeBlob, modeExecutable, modeSymlink:
+		switch {
+		case e.Mode == modeSymlink:
+			attr = uint32(e.Mode|0o777) << 16
+			creator = 0x0317
+		case e.Mode&0o111 != 0:
+			attr = uint32(e.Mode) << 16
+			creator = 0x0317
+		}
+		if e.Mode != modeSymlink && z.level != 0 && size > 0 {
+			method = 8
+		}
+		if e.Stream != nil {
+			flags |= 8
+		} else {
+			crc = crc32.ChecksumIEEE(e.Data)
+		}
+		if method == 0 {
+			compressed = size
+		}
+	default:
+		attr = 0x10
+		size = 0
+		text = false
+	}
+
+	if e.Stream == nil && method == 8 {
+		var b bytes.Buffer
+		fw, err := flate.NewWriter(&b, z.level)
+		if err != nil {
+			return err
+		}
+		fw.Write(e.Data)
+		if err := fw.Close(); err != nil {
+			return err
+		}
+		if int64(b.Len()) >= size {
+			method = 0
+			compressed = size
+		} else {
+			out = b.Bytes()
+			compressed = int64(b.Len())
+		}
+	}
+
+	extra := make([]byte, 9)
+	binary.LittleEndian.PutUint16(extra[0:], 0x5455)
+	binary.LittleEndian.PutUint16(extra[2:], 5)
+	extra[4] = 1
+	binary.LittleEndian.PutUint32(extra[5:], uint32(z.mtime))
+
+	offset := z.offset
+	var h bytes.Buffer
+	le := func(v interface{}) { binary.Write(&h, binary.LittleEndian, v) }
+	le(uint32(0x04034b50))
+	le(uint16(10))
+	le(flags)
+	le(method)
+	le(z.dosTime)
+	le(z.dosDate)
+	if e.Stream != nil {
+		le([3]uint32{})
+	} else {
+		le([3]uint32{crc, uint32(compressed), uint32(size)})
+	}
+	le(uint16(len(e.Path)))
+	le(uint16(len(extra)))
+	h.WriteString(e.Path)
+	h.Write(extra)
+	if err := z.write(h.Bytes()); err != nil {
+		return err
+	}
+
+	if e.Stream != nil {
+		cw := &countingWriter{w: z.w}
+		sum := crc32.NewIEEE()
+		r := io.TeeReader(e.Stream, sum)
+		if method == 8 {
+			fw, err := flate.NewWriter(cw, z.level)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(fw, r); err != nil {
+				return err
+			}
+			if err := fw.Close(); err != nil {
+				return err
+			}
+		} else if _, err := io.Copy(cw, r); err != nil {
+			return err
+		}
+		z.offset += cw.n
+		crc, compressed = sum.Sum32(), cw.n
+
+		h.Reset()
+		le([4]uint32{0x08074b50, crc, uint32(compressed), uint32(size)})
+		if err := z.write(h.Bytes()); err != nil {
+			return err
+		}
+	} else if compressed > 0 {
+		if err := z.write(out[:compressed]); err != nil {
+			return err
+		}
+	}
+
+	h.Reset()
+	le(uint32(0x02014b50))
+	le(creator)
+	le(uint16(10))
+	le(flags)
+	le(method)
+	le(z.dosTime)
+	le(z.dosDate)
+	le([3]uint32{crc, uint32(compressed), uint32(size)})
+	le(uint16(len(e.Path)))
+	le(uint16(len(extra)))
+	le(uint16(0)) // comment length
+	le(uint16(0)) // disk
+	if text {
+		le(uint16(1))
+	} else {
+		le(uint16(0))
+	}
+	le(attr)
+	le(uint32(offset))
+	h.WriteString(e.Path)
+	h.Write(extra)
+	z.dir.Write(h.Bytes())
+	z.entries++
+	return nil
+}
+
+func (z *zipWriter) write(b []byte) error {
+	n, err := z.w.Write(b)
+	z.offset += int64(n)
+	return err
+}
+
+// close writes the central directory and the end record.
+func (z *zipWriter) close() error {
+	var h bytes.Buffer
+	le := func(v interface{}) { binary.Write(&h, binary.LittleEndian, v) }
+	le(uint32(0x06054b50))
+	le(uint16(0))
+	le(uint16(0))
+	le(uint16(z.entries))
+	le(uint16(z.entries))
+	le(uint32(z.dir.Len()))
+	le(uint32(z.offset))
+	le(uint16(len(z.comment)))
+	h.WriteString(z.comment)
+	if _, err := z.w.Write(z.dir.Bytes()); err != nil {
+		return err
+	}
+	_, err := z.w.Write(h.Bytes())
+	return err
+}
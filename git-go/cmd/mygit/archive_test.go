@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readTestArchive lists the entries of a tar, tar.gz or zip file, with the
+// content of files and link targets.
+func readTestArchive(t *testing.T, name string) (map[string]string, string) {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]string)
+	if strings.HasSuffix(name, ".zip") {
+		z, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range z.File {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Close()
+			entries[f.Name] = string(content)
+		}
+		return entries, z.Comment
+	}
+
+	var r io.Reader = bytes.NewReader(b)
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	}
+	if len(b)%10240 != 0 && !strings.HasSuffix(name, ".gz") {
+		t.Errorf("%s is %d bytes, not whole tar records", name, len(b))
+	}
+	tr := tar.NewReader(r)
+	comment := ""
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			comment = h.PAXRecords["comment"]
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeSymlink {
+			content = []byte("-> " + h.Linkname)
+		}
+		entries[h.Name] = string(content)
+	}
+	return entries, comment
+}
+
+func TestArchive(t *testing.T) {
+	newTestRepo(t)
+	writeTestFiles(t, map[string]string{"global-attributes": ""})
+	setTestConfig(t, "")
+	long := strings.Repeat("long-directory-name/", 6) + "file"
+	c := testCommit(t, map[string]string{
+		"README":          "read me\n",
+		"src/main.go":     "package main\n",
+		"src/skip.tmp":    "skipped\n",
+		"docs/guide.txt":  "guide\n",
+		long:              "deep\n",
+		".gitattributes":  "*.tmp export-ignore\n*.txt text eol=crlf\n",
+		"empty/.keep.tmp": "only ignored\n",
+	}, 100)
+	if err := updateRef("refs/heads/master", c); err != nil {
+		t.Fatal(err)
+	}
+
+	full := map[string]string{
+		"p/":                     "",
+		"p/.gitattributes":       "*.tmp export-ignore\n*.txt text eol=crlf\n",
+		"p/README":               "read me\n",
+		"p/docs/":                "",
+		"p/docs/guide.txt":       "guide\r\n",
+		"p/src/":                 "",
+		"p/src/main.go":          "package main\n",
+		"p/" + long:              "deep\n",
+		"p/long-directory-name/": "",
+	}
+	for i := 2; i <= 6; i++ {
+		full["p/"+strings.Repeat("long-directory-name/", i)] = ""
+	}
+
+	tests := []struct {
+		args []string
+		out  string
+		want map[string]string
+	}{
+		{[]string{"--prefix=p/", "HEAD"}, "out.tar", full},
+		{[]string{"--prefix=p/", "HEAD"}, "out.tar.gz", full},
+		{[]string{"--prefix=p/", "-9", "HEAD"}, "out.zip", full},
+		{[]string{"HEAD", "src"}, "src.tar", map[string]string{"src/": "", "src/main.go": "package main\n"}},
+		// The attributes come from the archived tree, which has none here.
+		{[]string{"HEAD:src"}, "sub.zip", map[string]string{"main.go": "package main\n", "skip.tmp": "skipped\n"}},
+	}
+	for _, tt := range tests {
+		if err := archiveCommand(append([]string{"-o", tt.out}, tt.args...)); err != nil {
+			t.Fatalf("archive %s: %s", tt.args, err)
+		}
+		forgetAttributes()
+		got, comment := readTestArchive(t, tt.out)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("archive %s to %s =\n%q\nwant\n%q", tt.args, tt.out, got, tt.want)
+		}
+		wantComment := c
+		if strings.Contains(tt.args[len(tt.args)-1], ":") {
+			wantComment = ""
+		}
+		if comment != wantComment {
+			t.Errorf("archive %s to %s has comment %q, want %q", tt.args, tt.out, comment, wantComment)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--format=rar", "HEAD"},
+		{"--format=tar", "-3", "HEAD"},
+		{"HEAD", "nothing"},
+		{"HEAD:nothing"},
+		{"no-such-rev"},
+		{},
+	} {
+		if err := archiveCommand(append([]string{"-o", "fail.tar"}, args...)); err == nil {
+			t.Errorf("archive %q did not fail", args)
+		}
+	}
+}
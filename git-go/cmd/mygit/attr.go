@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Notes about attributes:
+// - Lines of .gitattributes files are a pattern followed by attributes:
+//   "name" sets one, "-name" unsets it, "!name" makes it unspecified again
+//   and "name=value" gives it a value.
+// - Patterns match like ignore patterns, except that they cannot be
+//   negated and only match files.
+// - .git/info/attributes takes precedence over the .gitattributes files,
+//   deepest first, which take precedence over core.attributesFile. Within
+//   a file later lines win.
+// - "[attr]name ..." defines a macro that expands when name is set. Macros
+//   are only read from the top level files; binary is built in.
+
+const (
+	attrSet   = "\x00set"
+	attrUnset = "\x00unset"
+)
+
+type attrAssign struct {
+	Name  string
+	Value string // attrSet, attrUnset, "" for unspecified, or a value
+}
+
+type attrRule struct {
+	Pattern ignorePattern
+	Attrs   []attrAssign
+}
+
+type attrChecker struct {
+	flags int
+	files map[string]treeEntry
+
+	// Rule lists by precedence: info/attributes first, then the
+	// directories, then the global files.
+	info   []attrRule
+	global [][]attrRule
+	perDir map[string][]attrRule
+	macros map[string][]attrAssign
+
+	// The order attributes were first seen in, which "check-attr --all"
+	// lists them in.
+	names []string
+	known map[string]bool
+}
+
+var loadedAttrs *attrChecker
+
+// attributes returns the checker for the working tree, read once.
+func attributes() (*attrChecker, error) {
+	if loadedAttrs == nil {
+		c, err := newAttrChecker(nil)
+		if err != nil {
+			return nil, err
+		}
+		loadedAttrs = c
+	}
+	return loadedAttrs, nil
+}
+
+// forgetAttributes makes the next lookup read the attribute files again.
+func forgetAttributes() {
+	loadedAttrs = nil
+}
+
+// newAttrChecker reads the attribute files. Given the files of the index or
+// of a tree, .gitattributes files come from there instead of the working
+// tree.
+func newAttrChecker(files map[string]treeEntry) (*attrChecker, error) {
+	c := &attrChecker{
+		flags:  wmPathname,
+		files:  files,
+		perDir: make(map[string][]attrRule),
+		macros: make(map[string][]attrAssign),
+		known:  make(map[string]bool),
+	}
+	if fold, err := configBool("core.ignoreCase", false); err != nil {
+		return nil, err
+	} else if fold {
+		c.flags |= wmCaseFold
+	}
+
+	builtin, err := c.parse([]byte("[attr]binary -diff -merge -text\n"), "[builtin]", "", true)
+	if err != nil {
+		return nil, err
+	}
+	c.global = append(c.global, builtin)
+
+	paths := make([]string, 0, 2)
+	if v, _ := parseConfigBool(os.Getenv("GIT_ATTR_NOSYSTEM"), false); !v {
+		paths = append(paths, "/etc/gitattributes")
+	}
+	if p, ok := configValue("core.attributesFile"); ok {
+		paths = append(paths, expandUserPath(p))
+	} else if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "git", "attributes"))
+	} else if home := os.Getenv("HOME"); home != "" {
+		paths = append(paths, filepath.Join(home, ".config", "git", "attributes"))
+	}
+	for _, p := range paths {
+		b, err := readAttrFile(p)
+		if err != nil {
+			return nil, err
+		}
+		rules, err := c.parse(b, p, "", true)
+		if err != nil {
+			return nil, err
+		}
+		// Later files take precedence.
+		c.global = append([][]attrRule{rules}, c.global...)
+	}
+
+	if _, err := c.dirRules(""); err != nil {
+		return nil, err
+	}
+	b, err := readAttrFile(".git/info/attributes")
+	if err != nil {
+		return nil, err
+	}
+	if c.info, err = c.parse(b, ".git/info/attributes", "", true); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func readAttrFile(p string) ([]byte, error) {
+	if info, err := os.Stat(p); err != nil || info.IsDir() {
+		return nil, nil
+	}
+	b, err := os.ReadFile(p)
+	if err != nil && errors.Is(err, os.ErrPermission) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// dirRules returns the rules of the .gitattributes in a directory, ""
+// being the top of the working tree.
+func (c *attrChecker) dirRules(dir string) ([]attrRule, error) {
+	if rules, ok := c.perDir[dir]; ok {
+		return rules, nil
+	}
+	p := path.Join(dir, ".gitattributes")
+
+	var b []byte
+	if c.files != nil {
+		if e, ok := c.files[p]; ok {
+			content, err := readObjectOfType(e.Sha, "blob")
+			if err != nil {
+				return nil, err
+			}
+			b = content
+		}
+	} else {
+		content, err := readAttrFile(p)
+		if err != nil {
+			return nil, err
+		}
+		b = content
+	}
+
+	rules, err := c.parse(b, p, dir, dir == "")
+	if err != nil {
+		return nil, err
+	}
+	c.perDir[dir] = rules
+	return rules, nil
+}
+
+func (c *attrChecker) register(name string) {
+	if !c.known[name] {
+		c.known[name] = true
+		c.names = append(c.names, name)
+	}
+}
+
+func (c *attrChecker) parse(b []byte, source, base string, macros bool) ([]attrRule, error) {
+	rules := make([]attrRule, 0)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimLeft(strings.TrimSuffix(s.Text(), "\r"), " \t")
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\xef\xbb\xbf")
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var pattern string
+		if line[0] == '"' {
+			end := 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				continue
+			}
+			unquoted, err := strconv.Unquote(line[:end+1])
+			if err != nil {
+				continue
+			}
+			pattern, line = unquoted, line[end+1:]
+		} else {
+			i := strings.IndexAny(line, " \t")
+			if i < 0 {
+				i = len(line)
+			}
+			pattern, line = line[:i], line[i:]
+		}
+
+		text := line
+		if strings.HasPrefix(pattern, "[attr]") {
+			if !macros {
+				fmt.Fprintf(os.Stderr, "%s%s not allowed: %s:%d\n", pattern, strings.TrimRight(text, " \t"), source, n)
+				continue
+			}
+			if validAttrName(strings.TrimPrefix(pattern, "[attr]")) {
+				c.register(strings.TrimPrefix(pattern, "[attr]"))
+			}
+		}
+
+		attrs := make([]attrAssign, 0)
+		for _, field := range strings.Fields(line) {
+			a := attrAssign{Name: field, Value: attrSet}
+			switch {
+			case strings.HasPrefix(field, "-"):
+				a = attrAssign{Name: field[1:], Value: attrUnset}
+			case strings.HasPrefix(field, "!"):
+				a = attrAssign{Name: field[1:], Value: ""}
+			default:
+				if i := strings.IndexByte(field, '='); i >= 0 {
+					a = attrAssign{Name: field[:i], Value: field[i+1:]}
+				}
+			}
+			if !validAttrName(a.Name) {
+				fmt.Fprintf(os.Stderr, "warning: %s is not a valid attribute name: %s:%d\n", a.Name, source, n)
+				continue
+			}
+			c.register(a.Name)
+			attrs = append(attrs, a)
+		}
+
+		if strings.HasPrefix(pattern, "[attr]") {
+			if name := strings.TrimPrefix(pattern, "[attr]"); validAttrName(name) {
+				c.macros[name] = attrs
+			}
+			continue
+		}
+		if strings.HasPrefix(pattern, "!") {
+			fmt.Fprintf(os.Stderr, "warning: Negative patterns are ignored in git attributes\nUse '\\!' for literal leading exclamation.\n")
+			continue
+		}
+
+		p := ignorePattern{Base: base, Source: source, Line: n, Text: pattern}
+		if strings.HasSuffix(pattern, "/") {
+			p.DirOnly = true
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		p.Basename = !strings.Contains(pattern, "/")
+		p.Pattern = strings.TrimPrefix(pattern, "/")
+		rules = append(rules, attrRule{Pattern: p, Attrs: attrs})
+	}
+	return rules, nil
+}
+
+func validAttrName(name string) bool {
+	if name == "" || name[0] == '-' {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !isConfigKeyChar(c) && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup returns the attributes of a file that are set, unset or have a
+// value.
+func (c *attrChecker) lookup(name string) (map[string]string, error) {
+	levels := [][]attrRule{c.info}
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if dir == "." {
+			dir = ""
+		}
+		rules, err := c.dirRules(dir)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, rules)
+		if dir == "" {
+			break
+		}
+	}
+	levels = append(levels, c.global...)
+
+	decided := make(map[string]bool)
+	values := make(map[string]string)
+	var fill func(attrs []attrAssign)
+	fill = func(attrs []attrAssign) {
+		for i := len(attrs) - 1; i >= 0; i-- {
+			a := attrs[i]
+			if decided[a.Name] {
+				continue
+			}
+			decided[a.Name] = true
+			if a.Value != "" {
+				values[a.Name] = a.Value
+			}
+			if macro, ok := c.macros[a.Name]; ok && a.Value == attrSet {
+				fill(macro)
+			}
+		}
+	}
+	for _, rules := range levels {
+		for i := len(rules) - 1; i >= 0; i-- {
+			if rules[i].Pattern.matches(name, false, c.flags) {
+				fill(rules[i].Attrs)
+			}
+		}
+	}
+	return values, nil
+}
+
+// attr returns one attribute of a file, "" when it is unspecified.
+func (c *attrChecker) attr(name, attr string) (string, error) {
+	values, err := c.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return values[attr], nil
+}
+
+func formatAttrValue(v string) string {
+	switch v {
+	case attrSet:
+		return "set"
+	case attrUnset:
+		return "unset"
+	case "":
+		return "unspecified"
+	}
+	return v
+}
+
+func checkAttrCommand(args []string) error {
+	all, cached, stdin := false, false, false
+	rest := make([]string, 0)
+	doubleDash := -1
+	for _, arg := range args {
+		switch {
+		case doubleDash >= 0:
+			rest = append(rest, arg)
+		case arg == "--":
+			doubleDash = len(rest)
+		case arg == "-a" || arg == "--all":
+			all = true
+		case arg == "--cached":
+			cached = true
+		case arg == "--stdin":
+			stdin = true
+		case strings.HasPrefix(arg, "-") && len(rest) == 0:
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	var names, paths []string
+	switch {
+	case doubleDash >= 0:
+		names, paths = rest[:doubleDash], rest[doubleDash:]
+	case all || stdin:
+		names, paths = nil, rest
+	case len(rest) > 0:
+		names, paths = rest[:1], rest[1:]
+	}
+	if all && len(names) > 0 {
+		return errors.New("attributes and --all both specified")
+	}
+	if !all && len(names) == 0 {
+		return errors.New("no attribute specified")
+	}
+	if stdin {
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			paths = append(paths, s.Text())
+		}
+	}
+	if len(paths) == 0 {
+		return errors.New("No file specified")
+	}
+	for _, name := range names {
+		if !validAttrName(name) {
+			return fmt.Errorf("%s: not a valid attribute name", name)
+		}
+	}
+
+	var files map[string]treeEntry
+	if cached {
+		idx, err := readIndex()
+		if err != nil {
+			return err
+		}
+		files = idx.files()
+	}
+	c, err := newAttrChecker(files)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		values, err := c.lookup(cleanPathspec(p))
+		if err != nil {
+			return err
+		}
+		if all {
+			for _, name := range c.names {
+				if v, ok := values[name]; ok {
+					fmt.Printf("%s: %s: %s\n", p, name, formatAttrValue(v))
+				}
+			}
+			continue
+		}
+		for _, name := range names {
+			fmt.Printf("%s: %s: %s\n", p, name, formatAttrValue(values[name]))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTestFiles writes files, keyed by path, into the working directory.
+func writeTestFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// setTestConfig replaces the repository config and forgets everything
+// read from it.
+func setTestConfig(t *testing.T, config string) {
+	t.Helper()
+	writeTestFiles(t, map[string]string{".git/config": "[core]\n\tattributesFile = global-attributes\n" + config})
+	forgetConfig()
+	forgetAttributes()
+	t.Cleanup(forgetConfig)
+}
+
+func TestAttributes(t *testing.T) {
+	newTestRepo(t)
+	setTestConfig(t, "")
+	writeTestFiles(t, map[string]string{
+		"global-attributes": "*.c diff=cpp whitespace\n",
+		".gitattributes": "[attr]mine text eol=lf -diff\n" +
+			"*.txt text\n" +
+			"*.bin binary\n" +
+			"docs/** eol=crlf\n" +
+			"\"with space.c\" mine\n",
+		"sub/.gitattributes":   "*.txt -text\n*.c !whitespace\n[attr]nested text\n",
+		".git/info/attributes": "override.txt text=auto\n",
+	})
+
+	tests := []struct {
+		name string
+		want map[string]string
+	}{
+		{"a.txt", map[string]string{"text": attrSet}},
+		{"sub/a.txt", map[string]string{"text": attrUnset}},
+		{"a.c", map[string]string{"diff": "cpp", "whitespace": attrSet}},
+		{"sub/a.c", map[string]string{"diff": "cpp"}},
+		{"x.bin", map[string]string{"binary": attrSet, "diff": attrUnset, "merge": attrUnset, "text": attrUnset}},
+		{"docs/deep/a.txt", map[string]string{"text": attrSet, "eol": "crlf"}},
+		{"with space.c", map[string]string{"mine": attrSet, "text": attrSet, "eol": "lf", "diff": attrUnset, "whitespace": attrSet}},
+		{"override.txt", map[string]string{"text": "auto"}},
+		{"other", map[string]string{}},
+	}
+	c, err := attributes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		got, err := c.lookup(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lookup(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, ok := c.macros["nested"]; ok {
+		t.Error("a macro was defined below the top level")
+	}
+}
+
+func TestGatherTextStats(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   textStats
+		binary bool
+	}{
+		{"a\nb\r\nc", textStats{loneLF: 1, crlf: 1, printable: 3}, false},
+		{"a\rb", textStats{loneCR: 1, printable: 2}, true},
+		{"a\x00b", textStats{nul: 1, nonPrintable: 1, printable: 2}, true},
+		{"tab\there\x1a", textStats{printable: 8}, false},
+	}
+	for _, tt := range tests {
+		s := gatherTextStats([]byte(tt.in))
+		if s != tt.want || s.binary() != tt.binary {
+			t.Errorf("gatherTextStats(%q) = %+v, binary %v", tt.in, s, s.binary())
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	newTestRepo(t)
+	writeTestFiles(t, map[string]string{
+		"global-attributes": "",
+		".gitattributes":    "*.txt text\n*.auto text=auto\n*.crlf text eol=crlf\n*.lf text eol=lf\n*.raw -text\n",
+	})
+
+	tests := []struct {
+		config   string
+		path     string
+		worktree string
+		stored   string
+		checkout string
+	}{
+		{"", "a.txt", "one\r\ntwo\n", "one\ntwo\n", "one\ntwo\n"},
+		{"\teol = crlf\n", "a.txt", "one\ntwo\n", "one\ntwo\n", "one\r\ntwo\r\n"},
+		{"", "a.crlf", "one\ntwo\n", "one\ntwo\n", "one\r\ntwo\r\n"},
+		{"\tautocrlf = true\n", "a.lf", "one\r\n", "one\n", "one\n"},
+		{"\tautocrlf = true\n", "a.raw", "one\r\n", "one\r\n", "one\r\n"},
+		{"\tautocrlf = true\n", "none", "one\r\n", "one\n", "one\r\n"},
+		{"\tautocrlf = input\n", "none", "one\r\n", "one\n", "one\n"},
+		{"", "none", "one\r\n", "one\r\n", "one\r\n"},
+		{"", "a.auto", "bin\r\n\x00", "bin\r\n\x00", "bin\r\n\x00"},
+		{"\tautocrlf = true\n", "a.auto", "mixed\r\nlf\n", "mixed\nlf\n", "mixed\r\nlf\r\n"},
+	}
+	for _, tt := range tests {
+		setTestConfig(t, tt.config+"\tsafecrlf = false\n")
+		stored, err := convertToGit(tt.path, []byte(tt.worktree), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(stored) != tt.stored {
+			t.Errorf("%q with %q: stored %q, want %q", tt.path, tt.config, stored, tt.stored)
+		}
+		checkout, err := convertToWorktree(tt.path, []byte(tt.stored))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(checkout) != tt.checkout {
+			t.Errorf("%q with %q: checked out %q, want %q", tt.path, tt.config, checkout, tt.checkout)
+		}
+	}
+
+	setTestConfig(t, "\tsafecrlf = true\n")
+	if _, err := convertToGit("a.txt", []byte("one\r\n"), true); err == nil {
+		t.Error("core.safecrlf=true allowed CRLF to become LF")
+	}
+	if _, err := convertToGit("a.txt", []byte("one\r\n"), false); err != nil {
+		t.Errorf("core.safecrlf checked content that is not written: %s", err)
+	}
+}
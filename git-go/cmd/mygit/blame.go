@@ -0,0 +1,847 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Notes about blame:
+// - Every line of the final version is first blamed on the final commit,
+//   or on the working tree when no revision is given. Commits are visited
+//   newest first, and the lines a commit did not change according to a
+//   diff with each of its parents in turn are passed on to that parent.
+// - When a parent lacks the file, it is looked for under the name it had
+//   before a rename. With -M, lines a commit merely moved within the file
+//   are found in the parent's version too.
+// - Lines of an ignored revision that it changed are passed to the line at
+//   the same place of the hunk in the parent, when there is one.
+// - Root commits and commits outside a revision range are boundaries,
+//   shown with a "^".
+
+// blameMoveScore is how many alphanumeric characters a block of lines
+// needs for -M to consider it moved rather than rewritten.
+const blameMoveScore = 20
+
+// blameOrigin is the version of a file in one commit.
+type blameOrigin struct {
+	Commit   *commit
+	Path     string
+	Blob     string
+	Lines    []string
+	Previous *blameOrigin
+	Boundary bool
+}
+
+// blameLine tells where a line of the final version comes from.
+type blameLine struct {
+	Origin *blameOrigin
+	Line   int
+}
+
+type blamer struct {
+	ignoreSpace bool
+	move        bool
+	ignored     map[string]bool
+	excluded    map[string]bool
+
+	origins map[string]*blameOrigin
+	trees   map[string]map[string]treeEntry
+	lines   []blameLine
+}
+
+// blameOptions are the output settings of the blame command.
+type blameOptions struct {
+	porcelain, linePorcelain bool
+	ranges                   []string
+}
+
+func blameCommand(args []string) error {
+	b := &blamer{
+		ignored: make(map[string]bool),
+		origins: make(map[string]*blameOrigin),
+		trees:   make(map[string]map[string]treeEntry),
+	}
+	var opts blameOptions
+	ignoreFiles := make([]string, 0)
+	for _, e := range configEntries("blame.ignoreRevsFile") {
+		ignoreFiles = append(ignoreFiles, e.Value)
+	}
+	ignoreRevs := make([]string, 0)
+
+	rest := make([]string, 0)
+	doubleDash := -1
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		// Options taking a value accept it glued or as the next argument.
+		value := func(name string) (string, bool) {
+			long := strings.HasPrefix(name, "--")
+			if long && strings.HasPrefix(arg, name+"=") {
+				return strings.TrimPrefix(arg, name+"="), true
+			}
+			if !long && strings.HasPrefix(arg, name) && arg != name {
+				return strings.TrimPrefix(arg, name), true
+			}
+			if arg == name && i+1 < len(args) {
+				i++
+				return args[i], true
+			}
+			return "", false
+		}
+		if doubleDash >= 0 {
+			rest = append(rest, arg)
+			continue
+		}
+		if v, ok := value("-L"); ok {
+			opts.ranges = append(opts.ranges, v)
+			continue
+		}
+		if v, ok := value("--ignore-rev"); ok {
+			ignoreRevs = append(ignoreRevs, v)
+			continue
+		}
+		if v, ok := value("--ignore-revs-file"); ok {
+			// An empty name drops the files given so far, including the
+			// one from the config.
+			if v == "" {
+				ignoreFiles = ignoreFiles[:0]
+			} else {
+				ignoreFiles = append(ignoreFiles, v)
+			}
+			continue
+		}
+		switch {
+		case arg == "--":
+			doubleDash = len(rest)
+		case arg == "-w":
+			b.ignoreSpace = true
+		case arg == "-M" || strings.HasPrefix(arg, "-M") && isDigits(arg[2:]):
+			b.move = true
+		case arg == "--porcelain" || arg == "-p":
+			opts.porcelain = true
+		case arg == "--line-porcelain":
+			opts.porcelain, opts.linePorcelain = true, true
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	var revs []string
+	var file string
+	switch {
+	case doubleDash >= 0 && len(rest)-doubleDash == 1:
+		revs, file = rest[:doubleDash], rest[doubleDash]
+	case doubleDash < 0 && len(rest) == 1:
+		file = rest[0]
+	case doubleDash < 0 && len(rest) == 2:
+		revs, file = rest[:1], rest[1]
+	default:
+		return errors.New("usage: mygit blame [<options>] [<rev>] [--] <file>")
+	}
+	file = cleanPathspec(file)
+
+	for _, p := range ignoreFiles {
+		listed, err := readIgnoreRevsFile(expandUserPath(p))
+		if err != nil {
+			return err
+		}
+		ignoreRevs = append(ignoreRevs, listed...)
+	}
+	for _, rev := range ignoreRevs {
+		sha, err := resolveCommit(rev)
+		if err != nil {
+			return fmt.Errorf("cannot find revision %s to ignore", rev)
+		}
+		b.ignored[sha] = true
+	}
+
+	final, err := b.finalOrigin(revs, file)
+	if err != nil {
+		return err
+	}
+	b.lines = make([]blameLine, len(final.Lines))
+	for i := range b.lines {
+		b.lines[i] = blameLine{Origin: final, Line: i}
+	}
+	if err := b.run(final); err != nil {
+		return err
+	}
+
+	shown, err := blameRanges(opts.ranges, final.Lines, file)
+	if err != nil {
+		return err
+	}
+	if opts.porcelain {
+		b.printPorcelain(shown, final, opts.linePorcelain)
+	} else {
+		b.print(shown, final)
+	}
+	return nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// readIgnoreRevsFile reads the revisions listed one per line in a file
+// for --ignore-revs-file, skipping comments.
+func readIgnoreRevsFile(p string) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("could not open object name list: %s", p)
+	}
+	defer f.Close()
+
+	revs := make([]string, 0)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			revs = append(revs, line)
+		}
+	}
+	return revs, s.Err()
+}
+
+// finalOrigin returns the version of the file that is blamed: the one in
+// the given revision, or the working tree file, which is treated as a
+// commit on top of HEAD.
+func (b *blamer) finalOrigin(revs []string, file string) (*blameOrigin, error) {
+	if len(revs) > 0 {
+		include, exclude, err := parseRevisions(revs)
+		if err != nil {
+			return nil, err
+		}
+		if len(include) != 1 {
+			return nil, errors.New("cannot blame more than one revision")
+		}
+		if len(exclude) > 0 {
+			if b.excluded, err = ancestors(exclude...); err != nil {
+				return nil, err
+			}
+		}
+		c, err := readCommit(include[0])
+		if err != nil {
+			return nil, err
+		}
+		files, err := b.tree(c)
+		if err != nil {
+			return nil, err
+		}
+		e, ok := files[file]
+		if !ok || e.Type() != "blob" {
+			return nil, fmt.Errorf("no such path %s in %s", file, revs[0])
+		}
+		return b.origin(c, file, e.Sha)
+	}
+
+	head, err := headCommit()
+	if err != nil {
+		return nil, err
+	}
+	parents := []string{head}
+	merging, err := readMergeHeads()
+	if err != nil {
+		return nil, err
+	}
+	parents = append(parents, merging...)
+
+	idx, err := readIndex()
+	if err != nil {
+		return nil, err
+	}
+	known := false
+	if _, ok := idx.entry(file); ok {
+		known = true
+	}
+	for _, p := range parents {
+		c, err := readCommit(p)
+		if err != nil {
+			return nil, err
+		}
+		files, err := b.tree(c)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := files[file]; ok {
+			known = true
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("no such path '%s' in HEAD", file)
+	}
+
+	content, _, err := readWorktreeFile(file, false)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ident := fmt.Sprintf("Not Committed Yet <not.committed.yet> %d %s", now.Unix(), now.Format("-0700"))
+	c := &commit{
+		Sha:       strings.Repeat("0", 40),
+		Parents:   parents,
+		Author:    ident,
+		Committer: ident,
+		Message:   fmt.Sprintf("Version of %s from %s\n", file, file),
+	}
+	// The working tree commit has the index for a tree, which is what
+	// renames are detected against.
+	b.trees[c.Sha] = idx.files()
+	o := &blameOrigin{Commit: c, Path: file, Blob: hashObject("blob", content), Lines: splitLines(content)}
+	b.origins[c.Sha+"\x00"+file] = o
+	return o, nil
+}
+
+func (b *blamer) tree(c *commit) (map[string]treeEntry, error) {
+	if files, ok := b.trees[c.Sha]; ok {
+		return files, nil
+	}
+	files, err := flattenTree(c.Tree)
+	if err != nil {
+		return nil, err
+	}
+	b.trees[c.Sha] = files
+	return files, nil
+}
+
+// origin returns the one origin for a file in a commit.
+func (b *blamer) origin(c *commit, p, blob string) (*blameOrigin, error) {
+	key := c.Sha + "\x00" + p
+	if o, ok := b.origins[key]; ok {
+		return o, nil
+	}
+	content, err := readObjectOfType(blob, "blob")
+	if err != nil {
+		return nil, err
+	}
+	o := &blameOrigin{
+		Commit:   c,
+		Path:     p,
+		Blob:     blob,
+		Lines:    splitLines(content),
+		Boundary: len(c.Parents) == 0 || b.excluded[c.Sha],
+	}
+	b.origins[key] = o
+	return o, nil
+}
+
+// parentOrigin finds the file of an origin in a parent commit, following a
+// rename. It returns nil when the parent does not have it.
+func (b *blamer) parentOrigin(o *blameOrigin, sha string) (*blameOrigin, error) {
+	parent, err := readCommit(sha)
+	if err != nil {
+		return nil, err
+	}
+	files, err := b.tree(parent)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := files[o.Path]; ok && e.Type() == "blob" {
+		return b.origin(parent, o.Path, e.Sha)
+	}
+
+	side, err := b.tree(o.Commit)
+	if err != nil {
+		return nil, err
+	}
+	renames, err := detectRenames(files, side)
+	if err != nil {
+		return nil, err
+	}
+	for src, dst := range renames {
+		if dst == o.Path {
+			return b.origin(parent, src, files[src].Sha)
+		}
+	}
+	return nil, nil
+}
+
+// run passes blame down the history until every line rests with the
+// commit that introduced it.
+func (b *blamer) run(final *blameOrigin) error {
+	queue := []*blameOrigin{final}
+	queued := map[*blameOrigin]bool{final: true}
+	for len(queue) > 0 {
+		sort.SliceStable(queue, func(i, j int) bool {
+			return queue[i].Commit.Time() > queue[j].Commit.Time()
+		})
+		o := queue[0]
+		queue = queue[1:]
+		queued[o] = false
+		if o.Boundary {
+			continue
+		}
+
+		passed, err := b.pass(o)
+		if err != nil {
+			return err
+		}
+		for _, p := range passed {
+			if !queued[p] {
+				queued[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return nil
+}
+
+// suspects returns the indexes of the final lines blamed on an origin.
+func (b *blamer) suspects(o *blameOrigin) []int {
+	found := make([]int, 0)
+	for i, l := range b.lines {
+		if l.Origin == o {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// pass hands the lines blamed on an origin that it did not change to its
+// parents, and returns the parents that received some.
+func (b *blamer) pass(o *blameOrigin) ([]*blameOrigin, error) {
+	if len(b.suspects(o)) == 0 {
+		return nil, nil
+	}
+
+	parents := make([]*blameOrigin, 0, len(o.Commit.Parents))
+	for _, sha := range o.Commit.Parents {
+		p, err := b.parentOrigin(o, sha)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			continue
+		}
+		// A parent with the same content takes all of the blame.
+		if p.Blob == o.Blob {
+			for _, i := range b.suspects(o) {
+				b.lines[i].Origin = p
+			}
+			return []*blameOrigin{p}, nil
+		}
+		same := false
+		for _, q := range parents {
+			same = same || q.Blob == p.Blob
+		}
+		if !same {
+			parents = append(parents, p)
+		}
+	}
+	if len(parents) > 0 && o.Previous == nil {
+		o.Previous = parents[0]
+	}
+
+	passed := make(map[*blameOrigin]bool)
+	for _, p := range parents {
+		if b.passTo(o, p, b.unchanged(p, o)) {
+			passed[p] = true
+		}
+	}
+	if b.move {
+		for _, p := range parents {
+			if b.passTo(o, p, b.moved(p, o)) {
+				passed[p] = true
+			}
+		}
+	}
+	if b.ignored[o.Commit.Sha] {
+		for _, p := range parents {
+			if b.passTo(o, p, b.guessed(p, o)) {
+				passed[p] = true
+			}
+		}
+	}
+
+	found := make([]*blameOrigin, 0, len(passed))
+	for _, p := range parents {
+		if passed[p] {
+			found = append(found, p)
+		}
+	}
+	return found, nil
+}
+
+// passTo moves the lines blamed on o to p according to a map from line
+// numbers in o to line numbers in p, -1 where there is no counterpart.
+func (b *blamer) passTo(o, p *blameOrigin, lineMap []int) bool {
+	passed := false
+	for _, i := range b.suspects(o) {
+		if n := lineMap[b.lines[i].Line]; n >= 0 {
+			b.lines[i] = blameLine{Origin: p, Line: n}
+			passed = true
+		}
+	}
+	return passed
+}
+
+func (b *blamer) diff(p, o *blameOrigin) []diffHunk {
+	if b.ignoreSpace {
+		return diffLinesFunc(p.Lines, o.Lines, func(x, y string) bool {
+			return stripSpace(x) == stripSpace(y)
+		})
+	}
+	return diffLines(p.Lines, o.Lines)
+}
+
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// unchanged maps the lines of o that the diff with p keeps.
+func (b *blamer) unchanged(p, o *blameOrigin) []int {
+	lineMap := make([]int, len(o.Lines))
+	i, j := 0, 0
+	for _, h := range append(b.diff(p, o), diffHunk{len(p.Lines), len(p.Lines), len(o.Lines), len(o.Lines)}) {
+		for ; j < h.B0; i, j = i+1, j+1 {
+			lineMap[j] = i
+		}
+		for ; j < h.B1; j++ {
+			lineMap[j] = -1
+		}
+		i = h.A1
+	}
+	return lineMap
+}
+
+// guessed maps the lines an ignored commit changed to the lines at the
+// same offsets of the hunks they were in.
+func (b *blamer) guessed(p, o *blameOrigin) []int {
+	lineMap := make([]int, len(o.Lines))
+	for j := range lineMap {
+		lineMap[j] = -1
+	}
+	for _, h := range b.diff(p, o) {
+		for j := h.B0; j < h.B1 && h.A0+j-h.B0 < h.A1; j++ {
+			lineMap[j] = h.A0 + j - h.B0
+		}
+	}
+	return lineMap
+}
+
+// moved maps blocks of lines still blamed on o that appear elsewhere in
+// p, taking the longest block first.
+func (b *blamer) moved(p, o *blameOrigin) []int {
+	lineMap := make([]int, len(o.Lines))
+	for j := range lineMap {
+		lineMap[j] = -1
+	}
+	blamed := make(map[int]bool)
+	for _, i := range b.suspects(o) {
+		blamed[b.lines[i].Line] = true
+	}
+	eq := func(x, y string) bool { return x == y }
+	if b.ignoreSpace {
+		eq = func(x, y string) bool { return stripSpace(x) == stripSpace(y) }
+	}
+
+	for j := 0; j < len(o.Lines); {
+		if !blamed[j] {
+			j++
+			continue
+		}
+		start, length := 0, 0
+		for i := range p.Lines {
+			n := 0
+			for j+n < len(o.Lines) && i+n < len(p.Lines) && blamed[j+n] && eq(o.Lines[j+n], p.Lines[i+n]) {
+				n++
+			}
+			if n > length {
+				start, length = i, n
+			}
+		}
+		score := 0
+		for _, line := range o.Lines[j : j+length] {
+			score += alnumCount(line)
+		}
+		if length == 0 || score <= blameMoveScore {
+			j++
+			continue
+		}
+		for n := 0; n < length; n++ {
+			lineMap[j+n] = start + n
+		}
+		j += length
+	}
+	return lineMap
+}
+
+func alnumCount(s string) int {
+	n := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// blameRanges turns -L options into the sorted, merged ranges of lines
+// to show, counted from zero with the end excluded.
+func blameRanges(specs []string, lines []string, file string) ([][2]int, error) {
+	if len(specs) == 0 {
+		return [][2]int{{0, len(lines)}}, nil
+	}
+
+	ranges := make([][2]int, 0, len(specs))
+	for _, spec := range specs {
+		from, to := spec, ""
+		if i := strings.IndexByte(spec, ','); i >= 0 {
+			from, to = spec[:i], spec[i+1:]
+		}
+		start, end := 1, len(lines)
+		var err error
+		if from != "" {
+			if start, err = blameRangeLine(from, lines, 1); err != nil {
+				return nil, err
+			}
+		}
+		if to != "" {
+			if end, err = blameRangeLine(to, lines, start); err != nil {
+				return nil, err
+			}
+		}
+		if start > len(lines) {
+			return nil, fmt.Errorf("file %s has only %d line%s", file, len(lines), plural(len(lines)))
+		}
+		if start > end {
+			start, end = end, start
+		}
+		if start < 1 {
+			start = 1
+		}
+		if end > len(lines) {
+			end = len(lines)
+		}
+		ranges = append(ranges, [2]int{start - 1, end})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			if r[1] > last[1] {
+				last[1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged, nil
+}
+
+// blameRangeLine reads one end of a -L range: a line number, "+n" or "-n"
+// relative to the start, or a /regex/ searched from the start.
+func blameRangeLine(s string, lines []string, start int) (int, error) {
+	switch {
+	case strings.HasPrefix(s, "/"):
+		expr := strings.TrimSuffix(s[1:], "/")
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return 0, fmt.Errorf("-L parameter '%s': %s", s, err)
+		}
+		for i := start - 1; i < len(lines); i++ {
+			if re.MatchString(strings.TrimSuffix(lines[i], "\n")) {
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("-L parameter '%s' starting at line %d: No match", expr, start)
+	case strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-"):
+		n, err := strconv.Atoi(s[1:])
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("invalid -L argument '%s'", s)
+		}
+		if s[0] == '+' {
+			return start + n - 1, nil
+		}
+		return start - n + 1, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid -L argument '%s'", s)
+	}
+	return n, nil
+}
+
+// blameGroup is a run of shown lines that come from consecutive lines of
+// one origin.
+type blameGroup struct {
+	Origin      *blameOrigin
+	Line, Final int
+	Count       int
+}
+
+func (b *blamer) groups(ranges [][2]int) []blameGroup {
+	groups := make([]blameGroup, 0)
+	for _, r := range ranges {
+		for i := r[0]; i < r[1]; i++ {
+			l := b.lines[i]
+			if n := len(groups); n > 0 && i > r[0] {
+				g := &groups[n-1]
+				if g.Origin == l.Origin && g.Line+g.Count == l.Line {
+					g.Count++
+					continue
+				}
+			}
+			groups = append(groups, blameGroup{Origin: l.Origin, Line: l.Line, Final: i, Count: 1})
+		}
+	}
+	return groups
+}
+
+// print writes the default output: the abbreviated commit, the file name
+// when the file was renamed, the author, the date and the line number.
+func (b *blamer) print(ranges [][2]int, final *blameOrigin) {
+	groups := b.groups(ranges)
+	showName := false
+	nameWidth, authorWidth, lastLine := 0, 0, 0
+	for _, g := range groups {
+		if g.Origin.Path != final.Path {
+			showName = true
+		}
+		if n := len(g.Origin.Path); n > nameWidth {
+			nameWidth = n
+		}
+		name, _, _ := splitSignature(g.Origin.Commit.Author)
+		if n := utf8.RuneCountInString(name); n > authorWidth {
+			authorWidth = n
+		}
+		if g.Final+g.Count > lastLine {
+			lastLine = g.Final + g.Count
+		}
+	}
+	lineWidth := len(strconv.Itoa(lastLine))
+
+	for _, g := range groups {
+		c := g.Origin.Commit
+		name, _, _ := splitSignature(c.Author)
+		date := formatSignatureDate(c.Author)
+		for n := 0; n < g.Count; n++ {
+			if g.Origin.Boundary {
+				fmt.Printf("^%s", c.Sha[:7])
+			} else {
+				fmt.Printf("%s", c.Sha[:8])
+			}
+			if showName {
+				fmt.Printf(" %-*s", nameWidth, g.Origin.Path)
+			}
+			pad := authorWidth - utf8.RuneCountInString(name)
+			fmt.Printf(" (%s%*s %s %*d) ", name, pad, "", date, lineWidth, g.Final+n+1)
+			printBlameLine(final.Lines[g.Final+n])
+		}
+	}
+}
+
+// printPorcelain writes the output meant for scripts. Commit details are
+// given the first time a commit appears, or on every line with
+// --line-porcelain.
+func (b *blamer) printPorcelain(ranges [][2]int, final *blameOrigin, everyLine bool) {
+	shown := make(map[string]bool)
+	details := func(o *blameOrigin) {
+		c := o.Commit
+		if !shown[c.Sha] || everyLine {
+			shown[c.Sha] = true
+			for _, role := range []string{"author", "committer"} {
+				sig := c.Author
+				if role == "committer" {
+					sig = c.Committer
+				}
+				name, email, date := splitSignature(sig)
+				t, tz := date, ""
+				if i := strings.IndexByte(date, ' '); i >= 0 {
+					t, tz = date[:i], date[i+1:]
+				}
+				fmt.Printf("%s %s\n%s-mail <%s>\n%s-time %s\n%s-tz %s\n", role, name, role, email, role, t, role, tz)
+			}
+			fmt.Printf("summary %s\n", c.Subject())
+			if o.Boundary {
+				fmt.Println("boundary")
+			}
+		} else if !b.moreThanOnePath(c) {
+			return
+		}
+		if o.Previous != nil {
+			fmt.Printf("previous %s %s\n", o.Previous.Commit.Sha, o.Previous.Path)
+		}
+		fmt.Printf("filename %s\n", o.Path)
+	}
+
+	for _, g := range b.groups(ranges) {
+		for n := 0; n < g.Count; n++ {
+			if n == 0 {
+				fmt.Printf("%s %d %d %d\n", g.Origin.Commit.Sha, g.Line+1, g.Final+1, g.Count)
+				details(g.Origin)
+			} else {
+				fmt.Printf("%s %d %d\n", g.Origin.Commit.Sha, g.Line+n+1, g.Final+n+1)
+				if everyLine {
+					details(g.Origin)
+				}
+			}
+			fmt.Print("\t")
+			printBlameLine(final.Lines[g.Final+n])
+		}
+	}
+}
+
+// moreThanOnePath reports whether lines are blamed on a commit under more
+// than one name.
+func (b *blamer) moreThanOnePath(c *commit) bool {
+	path := ""
+	for _, l := range b.lines {
+		if l.Origin.Commit.Sha != c.Sha {
+			continue
+		}
+		if path != "" && l.Origin.Path != path {
+			return true
+		}
+		path = l.Origin.Path
+	}
+	return false
+}
+
+func printBlameLine(line string) {
+	fmt.Print(line)
+	if !strings.HasSuffix(line, "\n") {
+		fmt.Println()
+	}
+}
+
+// formatSignatureDate formats the date of an identity line in the time
+// zone it was recorded in, like "2023-11-14 22:13:20 +0000".
+func formatSignatureDate(sig string) string {
+	_, _, date := splitSignature(sig)
+	tz := ""
+	if i := strings.IndexByte(date, ' '); i >= 0 {
+		tz = date[i+1:]
+	}
+	t := signatureTime(sig)
+	offset := 0
+	if n, err := strconv.Atoi(tz); err == nil {
+		offset = (n/100*60 + n%100) * 60
+	}
+	return time.Unix(t, 0).In(time.FixedZone(tz, offset)).Format("2006-01-02 15:04:05 -0700")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBlameRanges(t *testing.T) {
+	lines := splitLines([]byte("one\ntwo\nthree\nfour\nfive\n"))
+	tests := []struct {
+		specs []string
+		want  [][2]int
+		err   bool
+	}{
+		{nil, [][2]int{{0, 5}}, false},
+		{[]string{"2,3"}, [][2]int{{1, 3}}, false},
+		{[]string{"2,+2"}, [][2]int{{1, 3}}, false},
+		{[]string{"4,-2"}, [][2]int{{2, 4}}, false},
+		{[]string{"3,"}, [][2]int{{2, 5}}, false},
+		{[]string{",2"}, [][2]int{{0, 2}}, false},
+		{[]string{"4,2"}, [][2]int{{1, 4}}, false},
+		{[]string{"2,90"}, [][2]int{{1, 5}}, false},
+		{[]string{"/th/,+1"}, [][2]int{{2, 3}}, false},
+		{[]string{"/f/,/ive/"}, [][2]int{{3, 5}}, false},
+		{[]string{"4,5", "1,1", "2,3"}, [][2]int{{0, 5}}, false},
+		{[]string{"5,5", "1,2"}, [][2]int{{0, 2}, {4, 5}}, false},
+		{[]string{"6"}, nil, true},
+		{[]string{"x"}, nil, true},
+		{[]string{"1,+0"}, nil, true},
+		{[]string{"/nothing/"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := blameRanges(tt.specs, lines, "file")
+		if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("blameRanges(%q) = %v, %v; want %v", tt.specs, got, err, tt.want)
+		}
+	}
+}
+
+// testBlame blames a file at a commit and returns, for each line, the
+// commit it is blamed on.
+func testBlame(t *testing.T, rev, file string, move bool, ignored ...string) []string {
+	t.Helper()
+	b := &blamer{
+		move:    move,
+		ignored: make(map[string]bool),
+		origins: make(map[string]*blameOrigin),
+		trees:   make(map[string]map[string]treeEntry),
+	}
+	for _, sha := range ignored {
+		b.ignored[sha] = true
+	}
+	final, err := b.finalOrigin([]string{rev}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.lines = make([]blameLine, len(final.Lines))
+	for i := range b.lines {
+		b.lines[i] = blameLine{Origin: final, Line: i}
+	}
+	if err := b.run(final); err != nil {
+		t.Fatal(err)
+	}
+	shas := make([]string, len(b.lines))
+	for i, l := range b.lines {
+		shas[i] = l.Origin.Commit.Sha
+	}
+	return shas
+}
+
+func TestBlame(t *testing.T) {
+	newTestRepo(t)
+	long := func(s string) string { return strings.Repeat(s, 30) + "\n" }
+	blockA := long("a") + long("b") + long("c")
+	blockB := long("x") + long("y") + long("z")
+
+	c1 := testCommit(t, map[string]string{"file": "one\ntwo\nthree\n", "moves": blockA + blockB}, 100)
+	c2 := testCommit(t, map[string]string{"file": "one\nTWO\nthree\n", "moves": blockB + blockA}, 200, c1)
+	c3 := testCommit(t, map[string]string{"renamed": "one\nTWO\nthree\nfour\n", "moves": blockB + blockA}, 300, c2)
+
+	tests := []struct {
+		name    string
+		rev     string
+		file    string
+		move    bool
+		ignored []string
+		want    []string
+	}{
+		{"edits", c2, "file", false, nil, []string{c1, c2, c1}},
+		{"rename", c3, "renamed", false, nil, []string{c1, c2, c1, c3}},
+		{"ignored revision", c3, "renamed", false, []string{c2}, []string{c1, c1, c1, c3}},
+		{"moved without -M", c2, "moves", false, nil, []string{c1, c1, c1, c2, c2, c2}},
+		{"moved with -M", c2, "moves", true, nil, []string{c1, c1, c1, c1, c1, c1}},
+	}
+	for _, tt := range tests {
+		if got := testBlame(t, tt.rev, tt.file, tt.move, tt.ignored...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: blamed on %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Notes about bundles:
+// - A bundle starts with "# v2 git bundle" or "# v3 git bundle". Version 3
+//   goes on with capability lines such as "@object-format=sha1".
+// - Then come the prerequisites, "-<sha> <subject>" for each commit the
+//   bundle builds on, and the refs it carries, "<sha> <refname>".
+// - An empty line ends the header and a pack holding the objects the refs
+//   need beyond the prerequisites makes up the rest.
+
+const (
+	bundleSignatureV2 = "# v2 git bundle\n"
+	bundleSignatureV3 = "# v3 git bundle\n"
+)
+
+type bundle struct {
+	Version       int
+	Prerequisites []ref
+	Refs          []ref
+	Pack          []byte
+}
+
+func bundleCommand(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: mygit bundle (create|verify|list-heads|unbundle) <file> [<args>...]")
+	}
+	switch args[0] {
+	case "create":
+		version := 2
+		rest := make([]string, 0)
+		for _, arg := range args[1:] {
+			if strings.HasPrefix(arg, "--version=") {
+				v := strings.TrimPrefix(arg, "--version=")
+				n, err := strconv.Atoi(v)
+				if err != nil || n != 2 && n != 3 {
+					return fmt.Errorf("unsupported bundle version %s", v)
+				}
+				version = n
+				continue
+			}
+			rest = append(rest, arg)
+		}
+		if len(rest) < 2 {
+			return errors.New("usage: mygit bundle create [--version=<n>] <file> <rev-list-args>...")
+		}
+		return createBundle(rest[0], rest[1:], version)
+	case "verify":
+		b, err := readBundle(args[1])
+		if err != nil {
+			return err
+		}
+		if err := verifyBundle(b); err != nil {
+			return err
+		}
+		listBundle(b)
+		fmt.Fprintf(os.Stderr, "%s is okay\n", args[1])
+		return nil
+	case "list-heads", "unbundle":
+		b, err := readBundle(args[1])
+		if err != nil {
+			return err
+		}
+		if args[0] == "unbundle" {
+			if err := unbundle(b); err != nil {
+				return err
+			}
+		}
+		for _, r := range b.Refs {
+			if bundleRefWanted(r.Name, args[2:]) {
+				fmt.Printf("%s %s\n", r.Sha, r.Name)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown bundle subcommand %s", args[0])
+}
+
+// bundleRefWanted tells whether a ref is among those asked for, all refs
+// being wanted when none are named.
+func bundleRefWanted(name string, names []string) bool {
+	for _, n := range names {
+		if name == n || strings.HasSuffix(name, "/"+n) {
+			return true
+		}
+	}
+	return len(names) == 0
+}
+
+// isBundle reports whether a file is a bundle.
+func isBundle(p string) bool {
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	return line == bundleSignatureV2 || line == bundleSignatureV3
+}
+
+func readBundle(p string) (*bundle, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return parseBundle(p, data)
+}
+
+// parseBundle reads the header of a bundle and checks the pack after it.
+// The header is read line by line from data itself, so that the pack
+// starts right after the blank line whatever the size of the bundle.
+func parseBundle(p string, data []byte) (*bundle, error) {
+	b := &bundle{}
+	line, rest := data, []byte(nil)
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line, rest = data[:i], data[i+1:]
+	}
+	switch string(line) + "\n" {
+	case bundleSignatureV2:
+		b.Version = 2
+	case bundleSignatureV3:
+		b.Version = 3
+	default:
+		return nil, fmt.Errorf("'%s' does not look like a v2 or v3 bundle file", p)
+	}
+
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			return nil, fmt.Errorf("'%s' has a truncated header", p)
+		}
+		line, rest = rest[:i], rest[i+1:]
+		if len(line) == 0 {
+			break
+		}
+		if bytes.HasPrefix(line, []byte("@")) && b.Version == 3 {
+			capability := string(line[1:])
+			key, value := capability, ""
+			if j := strings.IndexByte(capability, '='); j >= 0 {
+				key, value = capability[:j], capability[j+1:]
+			}
+			switch {
+			case key == "object-format" && value == "sha1":
+			case key == "object-format":
+				return nil, fmt.Errorf("unsupported object format '%s'", value)
+			default:
+				return nil, fmt.Errorf("unknown capability '%s'", capability)
+			}
+			continue
+		}
+
+		prerequisite := bytes.HasPrefix(line, []byte("-"))
+		sha, name := strings.TrimPrefix(string(line), "-"), ""
+		if j := strings.IndexByte(sha, ' '); j >= 0 {
+			sha, name = sha[:j], sha[j+1:]
+		}
+		if len(sha) != 40 || !isHex(sha) {
+			return nil, fmt.Errorf("unrecognized header: %s", line)
+		}
+		if prerequisite {
+			b.Prerequisites = append(b.Prerequisites, ref{Sha: sha, Name: name})
+		} else {
+			b.Refs = append(b.Refs, ref{Sha: sha, Name: name})
+		}
+	}
+
+	b.Pack = rest
+	if _, err := parsePack(b.Pack); err != nil {
+		return nil, fmt.Errorf("%s: %s", p, err)
+	}
+	return b, nil
+}
+
+// verifyBundle checks that the repository has the commits the bundle
+// builds on.
+func verifyBundle(b *bundle) error {
+	missing := make([]string, 0)
+	for _, r := range b.Prerequisites {
+		if t, _, err := readObject(r.Sha); err != nil || t != "commit" {
+			missing = append(missing, r.Sha+" "+r.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Repository lacks these prerequisite commits:\n%s", strings.Join(missing, "\n"))
+	}
+	return nil
+}
+
+// listBundle describes what a bundle holds and needs, for verify.
+func listBundle(b *bundle) {
+	if len(b.Refs) == 1 {
+		fmt.Println("The bundle contains this ref:")
+	} else {
+		fmt.Printf("The bundle contains these %d refs:\n", len(b.Refs))
+	}
+	for _, r := range b.Refs {
+		fmt.Printf("%s %s\n", r.Sha, r.Name)
+	}
+	switch len(b.Prerequisites) {
+	case 0:
+		fmt.Println("The bundle records a complete history.")
+	case 1:
+		fmt.Println("The bundle requires this ref:")
+	default:
+		fmt.Printf("The bundle requires these %d refs:\n", len(b.Prerequisites))
+	}
+	for _, r := range b.Prerequisites {
+		fmt.Printf("%s \n", r.Sha)
+	}
+	fmt.Println("The bundle uses this hash algorithm: sha1")
+}
+
+// unbundle stores the objects of a bundle in the repository.
+func unbundle(b *bundle) error {
+	if err := verifyBundle(b); err != nil {
+		return err
+	}
+	_, err := storePack(b.Pack)
+	return err
+}
+
+// createBundle writes a bundle of the history the rev-list arguments
+// select, with a ref for each argument naming one.
+func createBundle(file string, args []string, version int) error {
+	include := make([]string, 0)
+	exclude := make([]string, 0)
+	refs := make([]ref, 0)
+	seen := make(map[string]bool)
+	addRef := func(name string) error {
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+		sha, err := resolveRef(name)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, ref{Sha: sha, Name: name})
+		return nil
+	}
+	addRefs := func(prefix string) error {
+		all, err := listRefs(prefix)
+		if err != nil {
+			return err
+		}
+		for _, name := range sortedKeys(all) {
+			if err := addRef(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		var err error
+		switch {
+		case arg == "--all":
+			if err = addRefs("refs/"); err == nil {
+				err = addRef("HEAD")
+			}
+		case arg == "--branches":
+			err = addRefs("refs/heads/")
+		case arg == "--tags":
+			err = addRefs("refs/tags/")
+		case strings.HasPrefix(arg, "^") || strings.Contains(arg, ".."):
+			var in, ex []string
+			in, ex, err = parseRevisions([]string{arg})
+			include, exclude = append(include, in...), append(exclude, ex...)
+			if i := strings.Index(arg, ".."); i >= 0 && err == nil {
+				to := arg[i+2:]
+				if to == "" {
+					to = "HEAD"
+				}
+				if name, dwimErr := dwimRef(to); dwimErr == nil {
+					err = addRef(name)
+				}
+			}
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s", arg)
+		default:
+			var sha string
+			if sha, err = resolveCommit(arg); err == nil {
+				include = append(include, sha)
+				if name, dwimErr := dwimRef(arg); dwimErr == nil {
+					err = addRef(name)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, r := range refs {
+		sha, err := peel(r.Sha, "commit")
+		if err != nil {
+			return err
+		}
+		include = append(include, sha)
+	}
+
+	commits, err := revList(include, exclude)
+	if err != nil {
+		return err
+	}
+	inRange := make(map[string]bool, len(commits))
+	for _, sha := range commits {
+		inRange[sha] = true
+	}
+	kept := make([]ref, 0, len(refs))
+	for _, r := range refs {
+		sha, _ := peel(r.Sha, "commit")
+		if !inRange[sha] {
+			fmt.Fprintf(os.Stderr, "warning: ref '%s' is excluded by the rev-list options\n", r.Name)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	if len(kept) == 0 || len(commits) == 0 {
+		return errors.New("Refusing to create empty bundle.")
+	}
+
+	objects, prerequisites, err := bundleObjects(commits, kept)
+	if err != nil {
+		return err
+	}
+	findDeltas(objects)
+	pack, err := encodePack(objects)
+	if err != nil {
+		return err
+	}
+
+	data := encodeBundle(&bundle{Version: version, Prerequisites: prerequisites, Refs: kept, Pack: pack})
+	if file == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return writeFileAtomic(file, data, 0644)
+}
+
+// encodeBundle lays out the header of a bundle followed by its pack.
+func encodeBundle(bun *bundle) []byte {
+	var b bytes.Buffer
+	if bun.Version == 3 {
+		b.WriteString(bundleSignatureV3)
+		b.WriteString("@object-format=sha1\n")
+	} else {
+		b.WriteString(bundleSignatureV2)
+	}
+	for _, r := range bun.Prerequisites {
+		fmt.Fprintf(&b, "-%s %s\n", r.Sha, r.Name)
+	}
+	for _, r := range bun.Refs {
+		fmt.Fprintf(&b, "%s %s\n", r.Sha, r.Name)
+	}
+	b.WriteString("\n")
+	b.Write(bun.Pack)
+	return b.Bytes()
+}
+
+// bundleObjects lists the objects a bundle must carry: the tags of its
+// refs, the commits in range and what their trees hold beyond the trees of
+// the prerequisites, which are the parents left out of the range.
+func bundleObjects(commits []string, refs []ref) ([]*packObject, []ref, error) {
+	inRange := make(map[string]bool, len(commits))
+	for _, sha := range commits {
+		inRange[sha] = true
+	}
+
+	objects := make([]*packObject, 0)
+	prerequisites := make([]ref, 0)
+	seen := make(map[string]bool)
+	trees := make([]string, 0, len(commits))
+	boundaryTrees := make([]string, 0)
+	// Newest commits go first, as in the packs git writes.
+	for i := len(commits) - 1; i >= 0; i-- {
+		_, content, err := readObject(commits[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		c, err := parseCommit(content)
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, &packObject{Sha: commits[i], Type: OBJ_COMMIT, Content: content})
+		trees = append(trees, c.Tree)
+		for _, p := range c.Parents {
+			if inRange[p] || seen[p] {
+				continue
+			}
+			seen[p] = true
+			parent, err := readCommit(p)
+			if err != nil {
+				return nil, nil, err
+			}
+			prerequisites = append(prerequisites, ref{Sha: p, Name: parent.Subject()})
+			boundaryTrees = append(boundaryTrees, parent.Tree)
+		}
+	}
+
+	for _, r := range refs {
+		for sha := r.Sha; !seen[sha]; {
+			seen[sha] = true
+			t, content, err := readObject(sha)
+			if err != nil {
+				return nil, nil, err
+			}
+			if t != "tag" {
+				break
+			}
+			objects = append(objects, &packObject{Sha: sha, Type: OBJ_TAG, Content: content})
+			if sha, err = tagTarget(content); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	have := make(map[string]bool)
+	if len(boundaryTrees) > 0 {
+		known, err := reachableObjects(boundaryTrees)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, o := range known {
+			have[o.Sha] = true
+		}
+	}
+	contents, err := reachableObjects(trees)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, o := range contents {
+		if !have[o.Sha] {
+			objects = append(objects, &packObject{Sha: o.Sha, Type: objectTypeOf(o.Type), Content: o.Content, Name: o.Name})
+		}
+	}
+	return objects, prerequisites, nil
+}
+
+// fetchBundle stores the objects of a bundle file and returns its refs.
+func fetchBundle(p string) ([]ref, error) {
+	b, err := readBundle(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := unbundle(b); err != nil {
+		return nil, err
+	}
+	return b.Refs, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testPack returns a pack holding one blob of n random bytes, which zlib
+// cannot shrink.
+func testPack(t *testing.T, n int) []byte {
+	t.Helper()
+	content := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(content)
+	pack, err := encodePack([]*packObject{{Sha: hashObject("blob", content), Type: OBJ_BLOB, Content: content}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pack
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	sha := strings.Repeat("ab", 20)
+	for _, test := range []struct {
+		name string
+		b    *bundle
+	}{
+		{"small v2", &bundle{Version: 2, Refs: []ref{{Sha: sha, Name: "refs/heads/main"}}, Pack: testPack(t, 100)}},
+		{"large v2", &bundle{Version: 2, Refs: []ref{{Sha: sha, Name: "refs/heads/main"}}, Pack: testPack(t, 64*1024)}},
+		{"large v3 with prerequisites", &bundle{
+			Version:       3,
+			Prerequisites: []ref{{Sha: strings.Repeat("cd", 20), Name: "base commit"}},
+			Refs:          []ref{{Sha: sha, Name: "refs/heads/main"}, {Sha: sha, Name: "HEAD"}},
+			Pack:          testPack(t, 10*1024),
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data := encodeBundle(test.b)
+			got, err := parseBundle("test.bundle", data)
+			if err != nil {
+				t.Fatalf("parseBundle: %s", err)
+			}
+			if !reflect.DeepEqual(got, test.b) {
+				t.Errorf("parseBundle(encodeBundle(b)) = %+v, want %+v", got, test.b)
+			}
+		})
+	}
+}
+
+func TestParseBundleHeader(t *testing.T) {
+	sha := strings.Repeat("ab", 20)
+	pack := testPack(t, 100)
+	for _, test := range []struct {
+		name    string
+		header  string
+		want    *bundle
+		wantErr string
+	}{
+		{
+			name:   "v2 refs and prerequisites",
+			header: "# v2 git bundle\n-" + sha + " subject\n" + sha + " refs/heads/main\n\n",
+			want: &bundle{
+				Version:       2,
+				Prerequisites: []ref{{Sha: sha, Name: "subject"}},
+				Refs:          []ref{{Sha: sha, Name: "refs/heads/main"}},
+			},
+		},
+		{
+			name:   "v3 capability",
+			header: "# v3 git bundle\n@object-format=sha1\n" + sha + " refs/tags/v1\n\n",
+			want:   &bundle{Version: 3, Refs: []ref{{Sha: sha, Name: "refs/tags/v1"}}},
+		},
+		{
+			name:    "unknown signature",
+			header:  "# v4 git bundle\n\n",
+			wantErr: "does not look like a v2 or v3 bundle file",
+		},
+		{
+			name:    "capability in v3 only",
+			header:  "# v3 git bundle\n@filter=blob:none\n\n",
+			wantErr: "unknown capability 'filter=blob:none'",
+		},
+		{
+			name:    "other object format",
+			header:  "# v3 git bundle\n@object-format=sha256\n\n",
+			wantErr: "unsupported object format 'sha256'",
+		},
+		{
+			name:    "bad object id",
+			header:  "# v2 git bundle\nxyz refs/heads/main\n\n",
+			wantErr: "unrecognized header: xyz refs/heads/main",
+		},
+		{
+			name:    "no blank line",
+			header:  "# v2 git bundle\n" + sha + " refs/heads/main",
+			wantErr: "has a truncated header",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data := []byte(test.header)
+			if test.want != nil {
+				data = append(data, pack...)
+			}
+			got, err := parseBundle("test.bundle", data)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("parseBundle error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBundle: %s", err)
+			}
+			if !bytes.Equal(got.Pack, pack) {
+				t.Errorf("pack starts at the wrong offset")
+			}
+			got.Pack = nil
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseBundle = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseBundleRejectsBrokenPack(t *testing.T) {
+	data := encodeBundle(&bundle{Version: 2, Refs: []ref{{Sha: strings.Repeat("ab", 20), Name: "HEAD"}}, Pack: testPack(t, 5000)})
+	for _, n := range []int{1, 10, 31, len(data) - 1} {
+		if _, err := parseBundle("test.bundle", data[:len(data)-n]); err == nil {
+			t.Errorf("parseBundle accepted a bundle missing its last %d bytes", n)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The sequencer directory keeps what is needed to resume or abort a
+// cherry-pick or revert of several commits:
+//   - head holds the commit HEAD pointed to before the first one.
+//   - todo lists the commits still to be applied, one "pick" or "revert"
+//     line per commit.
+//   - opts holds the command line options, in git's config format.
+const sequencerDir = ".git/sequencer"
+
+type pickOptions struct {
+	Revert       bool
+	NoCommit     bool
+	Mainline     int
+	RecordOrigin bool
+}
+
+func (opts pickOptions) name() string {
+	if opts.Revert {
+		return "revert"
+	}
+	return "cherry-pick"
+}
+
+func cherryPickCommand(args []string, revert bool) (bool, error) {
+	opts := pickOptions{Revert: revert}
+	action := ""
+	revs := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-n", "--no-commit":
+			opts.NoCommit = true
+		case "-x":
+			opts.RecordOrigin = true
+		case "-m", "--mainline":
+			if i+1 == len(args) {
+				return false, fmt.Errorf("option %s requires a value", arg)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				return false, fmt.Errorf("invalid mainline parent %s", args[i])
+			}
+			opts.Mainline = n
+		case "--continue", "--abort":
+			action = arg
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return false, fmt.Errorf("unknown option %s", arg)
+			}
+			revs = append(revs, arg)
+		}
+	}
+
+	switch action {
+	case "--continue":
+		return sequencerContinue()
+	case "--abort":
+		return true, sequencerAbort()
+	}
+
+	if sequencerInProgress() {
+		return false, fmt.Errorf("a cherry-pick or revert is already in progress\n"+
+			"hint: try \"mygit %s (--continue | --abort)\"", opts.name())
+	}
+	if len(revs) == 0 {
+		return false, fmt.Errorf("usage: mygit %s [-n] [-m <parent>] [-x] <commit>... | --continue | --abort", opts.name())
+	}
+
+	todo, err := pickTodo(revs, opts)
+	if err != nil {
+		return false, err
+	}
+
+	head, err := headCommit()
+	if err != nil {
+		return false, err
+	}
+	if head == "" {
+		return false, fmt.Errorf("cannot %s on top of an unborn branch", opts.name())
+	}
+	if err := os.MkdirAll(sequencerDir, 0755); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(path.Join(sequencerDir, "head"), []byte(head+"\n"), 0644); err != nil {
+		return false, err
+	}
+	if err := writePickOptions(opts); err != nil {
+		return false, err
+	}
+	return runPicks(todo, opts)
+}
+
+// pickTodo turns the revisions given on the command line into the list of
+// commits to apply, oldest first for ranges.
+func pickTodo(revs []string, opts pickOptions) ([]string, error) {
+	hasRange := false
+	for _, rev := range revs {
+		if strings.Contains(rev, "..") || strings.HasPrefix(rev, "^") {
+			hasRange = true
+		}
+	}
+	if !hasRange {
+		todo := make([]string, 0, len(revs))
+		for _, rev := range revs {
+			sha, err := resolveCommit(rev)
+			if err != nil {
+				return nil, err
+			}
+			todo = append(todo, sha)
+		}
+		return todo, nil
+	}
+
+	include, exclude, err := parseRevisions(revs)
+	if err != nil {
+		return nil, err
+	}
+	todo, err := revList(include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Revert {
+		// Newest changes are undone first.
+		for i, j := 0, len(todo)-1; i < j; i, j = i+1, j-1 {
+			todo[i], todo[j] = todo[j], todo[i]
+		}
+	}
+	return todo, nil
+}
+
+func runPicks(todo []string, opts pickOptions) (bool, error) {
+	for i, sha := range todo {
+		clean, err := pickCommit(sha, opts)
+		if err != nil {
+			// Keep the state around for --abort once earlier commits
+			// were made.
+			if i == 0 || opts.NoCommit {
+				os.RemoveAll(sequencerDir)
+			} else if err := writePickTodo(todo[i+1:], opts); err != nil {
+				return false, err
+			}
+			return false, err
+		}
+		if !clean {
+			return false, writePickTodo(todo[i+1:], opts)
+		}
+	}
+	return true, os.RemoveAll(sequencerDir)
+}
+
+// pickResult describes a commit's change applied on top of HEAD.
+type pickResult struct {
+	Head     string
+	HeadTree string
+	Parent   string
+	Merge    *treeMergeResult
+}
+
+// applyPick merges the change a commit made, or its inverse when reverting,
+// into HEAD and updates the index and the working tree with the result.
+func applyPick(c *commit, opts pickOptions) (*pickResult, error) {
+	parent := ""
+	switch {
+	case len(c.Parents) > 1 && opts.Mainline == 0:
+		return nil, fmt.Errorf("commit %s is a merge but no -m option was given.", c.Sha)
+	case len(c.Parents) <= 1 && opts.Mainline > 0:
+		return nil, fmt.Errorf("mainline was specified but commit %s is not a merge.", c.Sha)
+	case opts.Mainline > len(c.Parents):
+		return nil, fmt.Errorf("commit %s does not have parent %d", c.Sha, opts.Mainline)
+	case opts.Mainline > 0:
+		parent = c.Parents[opts.Mainline-1]
+	case len(c.Parents) == 1:
+		parent = c.Parents[0]
+	}
+
+	parentTree := ""
+	if parent != "" {
+		var err error
+		parentTree, err = peel(parent, "tree")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	head, err := headCommit()
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := peel(head, "tree")
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := readIndex()
+	if err != nil {
+		return nil, err
+	}
+	if len(idx.unmerged()) > 0 {
+		return nil, fmt.Errorf("%s is not possible because you have unmerged files.", opts.name())
+	}
+	// Without a commit the change goes on top of what is staged, which
+	// may hold the changes of earlier picks.
+	oursTree := headTree
+	if opts.NoCommit {
+		if oursTree, err = idx.writeTree(); err != nil {
+			return nil, err
+		}
+	}
+
+	base, theirs := parentTree, c.Tree
+	theirsLabel := fmt.Sprintf("%s (%s)", c.Sha[:7], c.Subject())
+	baseLabel := "parent of " + theirsLabel
+	if opts.Revert {
+		base, theirs = theirs, base
+		baseLabel, theirsLabel = theirsLabel, baseLabel
+	}
+
+	r, err := mergeTrees(base, oursTree, theirs, treeMergeOptions{
+		OursLabel:   "HEAD",
+		TheirsLabel: theirsLabel,
+		BaseLabel:   baseLabel,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	oursFiles, err := flattenTree(oursTree)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyMergeResult(idx, oursFiles, r, opts.name()); err != nil {
+		return nil, err
+	}
+	return &pickResult{Head: head, HeadTree: headTree, Parent: parent, Merge: r}, nil
+}
+
+// pickCommit applies the change a commit made, or its inverse when
+// reverting, on top of HEAD and commits it. It reports false when the
+// change conflicts.
+func pickCommit(sha string, opts pickOptions) (bool, error) {
+	c, err := readCommit(sha)
+	if err != nil {
+		return false, err
+	}
+	short := sha[:7]
+
+	p, err := applyPick(c, opts)
+	if err != nil {
+		return false, err
+	}
+	r := p.Merge
+	head, headTree, parent := p.Head, p.HeadTree, p.Parent
+
+	msg := pickMessage(c, parent, opts)
+	if !r.Clean() || opts.NoCommit {
+		if err := os.WriteFile(".git/MERGE_MSG", []byte(conflictMessage(msg, r)), 0644); err != nil {
+			return false, err
+		}
+	}
+
+	if !r.Clean() {
+		for _, m := range r.Messages {
+			fmt.Println(m)
+		}
+		if !opts.NoCommit {
+			state := "CHERRY_PICK_HEAD"
+			if opts.Revert {
+				state = "REVERT_HEAD"
+			}
+			if err := updateRef(state, sha); err != nil {
+				return false, err
+			}
+		}
+		verb := "apply"
+		if opts.Revert {
+			verb = "revert"
+		}
+		fmt.Fprintf(os.Stderr, "error: could not %s %s... %s\n", verb, short, c.Subject())
+		fmt.Fprintf(os.Stderr, "hint: after resolving the conflicts, mark the corrected paths\n")
+		fmt.Fprintf(os.Stderr, "hint: with 'mygit add <paths>' and run 'mygit %s --continue'\n", opts.name())
+		return false, nil
+	}
+	if opts.NoCommit {
+		return true, nil
+	}
+
+	if r.Tree == headTree {
+		return false, fmt.Errorf("the change from %s... %s is already present, nothing to commit", short, c.Subject())
+	}
+
+	author := c.Author
+	if opts.Revert {
+		author = signature("AUTHOR")
+	}
+	newSha, err := writeCommitObject(&commit{
+		Tree:      r.Tree,
+		Parents:   []string{head},
+		Author:    author,
+		Committer: signature("COMMITTER"),
+		Message:   msg,
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := updateHead(newSha); err != nil {
+		return false, err
+	}
+	os.Remove(".git/MERGE_MSG")
+
+	summary, err := commitSummary(newSha, msg, false)
+	if err != nil {
+		return false, err
+	}
+	fmt.Println(summary)
+	return true, nil
+}
+
+// pickMessage returns the message of the commit a cherry-pick or a revert
+// creates.
+func pickMessage(c *commit, parent string, opts pickOptions) string {
+	if opts.Revert {
+		msg := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", c.Subject(), c.Sha)
+		if opts.Mainline > 0 {
+			msg += fmt.Sprintf(", reversing\nchanges made to %s", parent)
+		}
+		return msg + ".\n"
+	}
+
+	msg := strings.TrimRight(c.Message, "\n") + "\n"
+	if opts.RecordOrigin {
+		lines := strings.Split(strings.TrimRight(msg, "\n"), "\n")
+		if last := lines[len(lines)-1]; !isTrailer(last) || len(lines) == 1 {
+			msg += "\n"
+		}
+		msg += fmt.Sprintf("(cherry picked from commit %s)\n", c.Sha)
+	}
+	return msg
+}
+
+// isTrailer reports whether a message line looks like a "Key: value"
+// trailer or an earlier cherry-pick note.
+func isTrailer(line string) bool {
+	if strings.HasPrefix(line, "(cherry picked from commit ") {
+		return true
+	}
+	i := strings.Index(line, ": ")
+	return i > 0 && !strings.ContainsAny(line[:i], " \t")
+}
+
+// conflictMessage appends the list of conflicted paths to a commit message,
+// as comments that commit strips again.
+func conflictMessage(msg string, r *treeMergeResult) string {
+	if r.Clean() {
+		return msg
+	}
+	var b strings.Builder
+	b.WriteString(msg)
+	b.WriteString("\n# Conflicts:\n")
+	seen := make(map[string]bool)
+	for _, c := range r.Conflicts {
+		if !seen[c.Path] {
+			seen[c.Path] = true
+			fmt.Fprintf(&b, "#\t%s\n", c.Path)
+		}
+	}
+	return b.String()
+}
+
+func sequencerInProgress() bool {
+	for _, p := range []string{sequencerDir, ".git/CHERRY_PICK_HEAD", ".git/REVERT_HEAD"} {
+		if _, err := os.Stat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func sequencerContinue() (bool, error) {
+	if !sequencerInProgress() {
+		return false, errors.New("no cherry-pick or revert in progress")
+	}
+
+	_, picking := os.Stat(".git/CHERRY_PICK_HEAD")
+	_, reverting := os.Stat(".git/REVERT_HEAD")
+	if picking == nil || reverting == nil {
+		summary, err := commitCommand(nil)
+		if err != nil {
+			return false, err
+		}
+		fmt.Println(summary)
+	}
+
+	todo, opts, err := readPickTodo()
+	if err != nil {
+		return false, err
+	}
+	return runPicks(todo, opts)
+}
+
+func sequencerAbort() error {
+	if !sequencerInProgress() {
+		return errors.New("no cherry-pick or revert in progress")
+	}
+
+	orig, err := headCommit()
+	if err != nil {
+		return err
+	}
+	if b, err := os.ReadFile(path.Join(sequencerDir, "head")); err == nil {
+		orig = strings.TrimSpace(string(b))
+	}
+
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(orig)
+	if err != nil {
+		return err
+	}
+	if err := resetMerge(idx, files); err != nil {
+		return err
+	}
+	if err := idx.write(); err != nil {
+		return err
+	}
+	if err := updateHead(orig); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"CHERRY_PICK_HEAD", "REVERT_HEAD", "MERGE_MSG"} {
+		os.Remove(".git/" + name)
+	}
+	return os.RemoveAll(sequencerDir)
+}
+
+func writePickOptions(opts pickOptions) error {
+	var b strings.Builder
+	b.WriteString("[options]\n")
+	if opts.NoCommit {
+		b.WriteString("\tno-commit = true\n")
+	}
+	if opts.RecordOrigin {
+		b.WriteString("\trecord-origin = true\n")
+	}
+	if opts.Mainline > 0 {
+		fmt.Fprintf(&b, "\tmainline = %d\n", opts.Mainline)
+	}
+	return os.WriteFile(path.Join(sequencerDir, "opts"), []byte(b.String()), 0644)
+}
+
+func writePickTodo(todo []string, opts pickOptions) error {
+	command := "pick"
+	if opts.Revert {
+		command = "revert"
+	}
+
+	var b strings.Builder
+	for _, sha := range todo {
+		c, err := readCommit(sha)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s %s %s\n", command, sha, c.Subject())
+	}
+	return os.WriteFile(path.Join(sequencerDir, "todo"), []byte(b.String()), 0644)
+}
+
+func readPickTodo() ([]string, pickOptions, error) {
+	opts := pickOptions{}
+	if _, err := os.Stat(".git/REVERT_HEAD"); err == nil {
+		opts.Revert = true
+	}
+
+	b, err := os.ReadFile(path.Join(sequencerDir, "opts"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, opts, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), " = ", 2)
+		key, value := kv[0], ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		switch key {
+		case "no-commit":
+			opts.NoCommit = value == "true"
+		case "record-origin":
+			opts.RecordOrigin = value == "true"
+		case "mainline":
+			opts.Mainline, _ = strconv.Atoi(value)
+		}
+	}
+
+	b, err = os.ReadFile(path.Join(sequencerDir, "todo"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, opts, err
+	}
+	todo := make([]string, 0)
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		opts.Revert = fields[0] == "revert"
+		todo = append(todo, fields[1])
+	}
+	return todo, opts, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPickMessage(t *testing.T) {
+	sha, parent := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	for _, test := range []struct {
+		name    string
+		message string
+		opts    pickOptions
+		want    string
+	}{
+		{"cherry-pick", "Fix it\n\nBody\n\n", pickOptions{}, "Fix it\n\nBody\n"},
+		{"-x", "Fix it\n\nBody\n", pickOptions{RecordOrigin: true},
+			"Fix it\n\nBody\n\n(cherry picked from commit " + sha + ")\n"},
+		{"-x after trailers", "Fix it\n\nSigned-off-by: A <a@example.com>\n", pickOptions{RecordOrigin: true},
+			"Fix it\n\nSigned-off-by: A <a@example.com>\n(cherry picked from commit " + sha + ")\n"},
+		{"-x with a subject only", "Key: value\n", pickOptions{RecordOrigin: true},
+			"Key: value\n\n(cherry picked from commit " + sha + ")\n"},
+		{"revert", "Fix it\n\nBody\n", pickOptions{Revert: true},
+			"Revert \"Fix it\"\n\nThis reverts commit " + sha + ".\n"},
+		{"revert of a merge", "Merge branch 'x'\n", pickOptions{Revert: true, Mainline: 1},
+			"Revert \"Merge branch 'x'\"\n\nThis reverts commit " + sha + ", reversing\nchanges made to " + parent + ".\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := &commit{Sha: sha, Message: test.message}
+			if got := pickMessage(c, parent, test.opts); got != test.want {
+				t.Errorf("pickMessage = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsTrailer(t *testing.T) {
+	for line, want := range map[string]bool{
+		"Signed-off-by: A <a@example.com>":    true,
+		"Reviewed-by: B":                      true,
+		"(cherry picked from commit abc1234)": true,
+		"Not a trailer: spaces in the key":    false,
+		"No colon here":                       false,
+		": empty key":                         false,
+	} {
+		if got := isTrailer(line); got != want {
+			t.Errorf("isTrailer(%q) = %v, want %v", line, got, want)
+		}
+	}
+}
+
+func TestPickTodo(t *testing.T) {
+	newTestRepo(t)
+	c1 := testCommit(t, map[string]string{"f": "1\n"}, 100)
+	c2 := testCommit(t, map[string]string{"f": "2\n"}, 200, c1)
+	c3 := testCommit(t, map[string]string{"f": "3\n"}, 300, c2)
+	c4 := testCommit(t, map[string]string{"f": "4\n"}, 400, c3)
+
+	for _, test := range []struct {
+		name string
+		revs []string
+		opts pickOptions
+		want []string
+	}{
+		{"commits in the given order", []string{c3, c1}, pickOptions{}, []string{c3, c1}},
+		{"range, oldest first", []string{c1 + ".." + c4}, pickOptions{}, []string{c2, c3, c4}},
+		{"negated revision", []string{c4, "^" + c2}, pickOptions{}, []string{c3, c4}},
+		{"revert of a range, newest first", []string{c1 + ".." + c4}, pickOptions{Revert: true}, []string{c4, c3, c2}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := pickTodo(test.revs, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("pickTodo = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSequencerState(t *testing.T) {
+	newTestRepo(t)
+	c1 := testCommit(t, map[string]string{"f": "1\n"}, 100)
+	c2 := testCommit(t, map[string]string{"f": "2\n"}, 200, c1)
+	if err := os.MkdirAll(sequencerDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := pickOptions{Revert: true, NoCommit: true, Mainline: 2, RecordOrigin: true}
+	if err := writePickOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePickTodo([]string{c2, c1}, opts); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(sequencerDir + "/todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "revert " + c2 + " commit at 200\nrevert " + c1 + " commit at 100\n"; string(b) != want {
+		t.Errorf("todo = %q, want %q", b, want)
+	}
+
+	todo, got, err := readPickTodo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(todo, []string{c2, c1}) || got != opts {
+		t.Errorf("readPickTodo = %v, %+v, want %v, %+v", todo, got, []string{c2, c1}, opts)
+	}
+	if !sequencerInProgress() {
+		t.Error("sequencerInProgress = false with a sequencer directory")
+	}
+}
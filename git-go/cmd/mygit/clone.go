@@ -1,14 +1,25 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func clone(url, dir string) error {
+	bundled := isBundle(url)
+	// A plain path gets its objects copied rather than fetched.
+	_, isLocal := localPath(url)
+	copied := isLocal && !strings.HasPrefix(url, "file://")
+	if bundled || copied {
+		abs, err := filepath.Abs(url)
+		if err != nil {
+			return err
+		}
+		url = abs
+	}
+
 	err := os.Mkdir(dir, 0755)
 	if err != nil {
 		return err
@@ -24,92 +35,147 @@ func clone(url, dir string) error {
 		return err
 	}
 
-	refs, err := findRefs(url)
-	if err != nil {
-		return err
+	var refs []ref
+	var symrefs map[string]string
+	if bundled {
+		refs, err = fetchBundle(url)
+		if err != nil {
+			return err
+		}
+	} else {
+		t, err := openTransport(url)
+		if err != nil {
+			return err
+		}
+		defer t.close()
+		adv, err := findRefs(t, []string{"HEAD", "refs/heads/", "refs/tags/"})
+		if err != nil {
+			return err
+		}
+		refs, symrefs = adv.Refs, adv.Symrefs
+		if len(refs) == 0 {
+			fmt.Fprintln(os.Stderr, "warning: You appear to have cloned an empty repository.")
+			return nil
+		}
+
+		if copied {
+			if err := copyObjects(url); err != nil {
+				return err
+			}
+		} else {
+			b, err := fetchPack(t, adv, wantObjects(refs), nil)
+			if err != nil {
+				return err
+			}
+			if _, err := storePack(b); err != nil {
+				return err
+			}
+		}
 	}
 
-	b, err := wantRefs(url, refs)
-	if err != nil {
-		return err
-	}
-
-	p, err := parsePack(b)
-	if err != nil {
-		return err
-	}
-
-	return unpack(dir, p)
+	return setupClone(url, refs, symrefs["HEAD"])
 }
 
 type ref struct {
-	Sha  []byte
-	Name []byte
+	Sha  string
+	Name string
 }
 
-func findRefs(url string) ([]ref, error) {
-	url += "/info/refs?service=git-upload-pack"
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
+// setupClone records the refs of the source as remote-tracking branches
+// and tags, configures origin, and checks out the branch HEAD points to.
+// headTarget is the ref HEAD points to when the source said so.
+func setupClone(url string, refs []ref, headTarget string) error {
+	packed := make(map[string]string)
+	var head string
+	for _, r := range refs {
+		switch {
+		case r.Name == "HEAD":
+			head = r.Sha
+		case strings.HasSuffix(r.Name, "^{}"):
+		case strings.HasPrefix(r.Name, "refs/heads/"):
+			packed["refs/remotes/origin/"+strings.TrimPrefix(r.Name, "refs/heads/")] = r.Sha
+		case strings.HasPrefix(r.Name, "refs/tags/"):
+			packed[r.Name] = r.Sha
+		}
 	}
-	defer resp.Body.Close()
-
-	b, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if err := writePackedRefs(packed); err != nil {
+		return err
 	}
 
-	// skip twice
-	b = b[bytes.IndexByte(b, '\n')+1:]
-	b = b[bytes.IndexByte(b, '\n')+1:]
-
-	// remove 0000
-	end := []byte("0000")
-	b = b[:len(b)-len(end)]
-
-	refs := make([]ref, 0)
-	for len(b) > 0 {
-		// skip first 4 bytes
-		b = b[4:]
-
-		sha := b[:bytes.IndexByte(b, ' ')]
-		b = b[len(sha)+1:]
-
-		name := b[:bytes.IndexByte(b, '\n')]
-		b = b[len(name)+1:]
-
-		refs = append(refs, ref{
-			Sha:  sha,
-			Name: name,
-		})
+	const config = ".git/config"
+	if err := setConfig(config, "remote.origin.url", url, "set"); err != nil {
+		return err
+	}
+	if err := setConfig(config, "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*", "set"); err != nil {
+		return err
 	}
 
-	return refs, nil
-}
-
-func wantRefs(url string, refs []ref) ([]byte, error) {
-	url += "/git-upload-pack"
-
-	var body bytes.Buffer
-	for _, ref := range refs {
-		want := fmt.Sprintf("0032want %s\n", string(ref.Sha))
-		body.WriteString(want)
+	branch := strings.TrimPrefix(headTarget, "refs/heads/")
+	if !strings.HasPrefix(headTarget, "refs/heads/") || head == "" {
+		branch = guessRemoteHead(refs, head)
+	}
+	if branch == "" {
+		if head != "" || len(refs) > 0 {
+			fmt.Fprintln(os.Stderr, "warning: remote HEAD refers to nonexistent ref, unable to checkout")
+		}
+		return nil
+	}
+	if err := setSymbolicRef("refs/remotes/origin/HEAD", "refs/remotes/origin/"+branch); err != nil {
+		return err
+	}
+	if err := setSymbolicRef("HEAD", "refs/heads/"+branch); err != nil {
+		return err
+	}
+	if err := updateRefLogged("refs/heads/"+branch, head, "clone: from "+url); err != nil {
+		return err
+	}
+	if err := setConfig(config, "branch."+branch+".remote", "origin", "set"); err != nil {
+		return err
+	}
+	if err := setConfig(config, "branch."+branch+".merge", "refs/heads/"+branch, "set"); err != nil {
+		return err
 	}
-	body.WriteString("0000")
-	body.WriteString("0009done\n")
 
-	resp, err := http.Post(url, "application/x-git-upload-pack-request", &body)
+	tree, err := peel(head, "tree")
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
-
-	b, err := io.ReadAll(resp.Body)
+	files, err := flattenTree(tree)
+	if err != nil {
+		return err
+	}
+	idx, err := readIndex()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	if err := checkoutFiles(idx, map[string]treeEntry{}, files, false, "checkout"); err != nil {
+		return err
+	}
+	return idx.write()
+}
 
-	startAt := bytes.IndexByte(b, '\n') + 1
-	return b[startAt:], nil
+// guessRemoteHead returns the branch HEAD points to, judging by the commit:
+// the default branch name if it matches, else the first branch that does.
+func guessRemoteHead(refs []ref, head string) string {
+	if head == "" {
+		return ""
+	}
+	preferred := "master"
+	if b, ok := configValue("init.defaultBranch"); ok && b != "" {
+		preferred = b
+	}
+	first := ""
+	for _, r := range refs {
+		if !strings.HasPrefix(r.Name, "refs/heads/") || r.Sha != head {
+			continue
+		}
+		branch := strings.TrimPrefix(r.Name, "refs/heads/")
+		if branch == preferred {
+			return branch
+		}
+		if first == "" {
+			first = branch
+		}
+	}
+	return first
 }
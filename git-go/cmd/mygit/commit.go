@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type commit struct {
+	Sha       string
+	Tree      string
+	Parents   []string
+	Author    string
+	Committer string
+	Message   string
+}
+
+func readCommit(sha string) (*commit, error) {
+	b, err := readObjectOfType(sha, "commit")
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := parseCommit(b)
+	if err != nil {
+		return nil, fmt.Errorf("commit %s: %s", sha, err)
+	}
+	c.Sha = sha
+	return c, nil
+}
+
+func parseCommit(b []byte) (*commit, error) {
+	c := commit{}
+
+	end := bytes.Index(b, []byte("\n\n"))
+	if end < 0 {
+		end = len(b)
+	} else {
+		c.Message = string(b[end+2:])
+	}
+
+	for _, line := range strings.Split(string(b[:end]), "\n") {
+		key, value := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			key, value = line[:i], line[i+1:]
+		}
+		switch key {
+		case "tree":
+			c.Tree = value
+		case "parent":
+			c.Parents = append(c.Parents, value)
+		case "author":
+			c.Author = value
+		case "committer":
+			c.Committer = value
+		}
+	}
+
+	if c.Tree == "" {
+		return nil, errors.New("missing tree header")
+	}
+	return &c, nil
+}
+
+func encodeCommit(c *commit) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "tree %s\n", c.Tree)
+	for _, p := range c.Parents {
+		fmt.Fprintf(&b, "parent %s\n", p)
+	}
+	fmt.Fprintf(&b, "author %s\n", c.Author)
+	fmt.Fprintf(&b, "committer %s\n\n", c.Committer)
+	b.WriteString(c.Message)
+	return b.Bytes()
+}
+
+// Time returns the committer timestamp, or zero when the commit carries none.
+func (c *commit) Time() int64 {
+	return signatureTime(c.Committer)
+}
+
+// Subject returns the first line of the commit message.
+func (c *commit) Subject() string {
+	subject := strings.TrimLeft(c.Message, "\n")
+	if i := strings.IndexByte(subject, '\n'); i >= 0 {
+		subject = subject[:i]
+	}
+	return subject
+}
+
+// signature returns the identity line for the author or the committer of a
+// new commit. GIT_AUTHOR_* and GIT_COMMITTER_* environment variables override
+// author.* and committer.* settings, which override user.name and user.email.
+// Without any, the identity comes from the login name and the host name.
+func signature(role string) string {
+	name := identityValue(role, "NAME", "name")
+	if name == "" {
+		if u, err := user.Current(); err == nil {
+			name = u.Name
+			if i := strings.IndexByte(name, ','); i >= 0 {
+				name = name[:i]
+			}
+			if name == "" {
+				name = u.Username
+			}
+		}
+	}
+	email := identityValue(role, "EMAIL", "email")
+	if email == "" {
+		email = os.Getenv("EMAIL")
+	}
+	if email == "" {
+		login := "user"
+		if u, err := user.Current(); err == nil {
+			login = u.Username
+		}
+		host, err := os.Hostname()
+		if err != nil || host == "" {
+			host = "localhost"
+		}
+		email = login + "@" + host
+	}
+
+	date := strings.TrimPrefix(os.Getenv("GIT_"+role+"_DATE"), "@")
+	if date == "" {
+		now := time.Now()
+		date = fmt.Sprintf("%d %s", now.Unix(), now.Format("-0700"))
+	}
+	return fmt.Sprintf("%s <%s> %s", name, email, date)
+}
+
+// identityValue looks up the name or email of an identity in the
+// environment, then in the config.
+func identityValue(role, env, key string) string {
+	if v := os.Getenv("GIT_" + role + "_" + env); v != "" {
+		return v
+	}
+	if v, ok := configValue(strings.ToLower(role) + "." + key); ok && v != "" {
+		return v
+	}
+	v, _ := configValue("user." + key)
+	return v
+}
+
+// signatureIdent returns the "Name <email>" part of an identity line.
+func signatureIdent(sig string) string {
+	if gt := strings.LastIndexByte(sig, '>'); gt >= 0 {
+		return sig[:gt+1]
+	}
+	return sig
+}
+
+// signatureTime extracts the unix timestamp from an identity line such as
+// "Name <email> 1700000000 +0000".
+func signatureTime(sig string) int64 {
+	gt := strings.LastIndexByte(sig, '>')
+	if gt < 0 {
+		return 0
+	}
+	fields := strings.Fields(sig[gt+1:])
+	if len(fields) == 0 {
+		return 0
+	}
+	t, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return t
+}
+
+func commitCommand(args []string) (string, error) {
+	messages := make([]string, 0)
+	allowEmpty := false
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-m" && i+1 < len(args):
+			i++
+			messages = append(messages, args[i])
+		case strings.HasPrefix(arg, "-m"):
+			messages = append(messages, strings.TrimPrefix(arg, "-m"))
+		case arg == "--allow-empty":
+			allowEmpty = true
+		default:
+			return "", fmt.Errorf("unknown option %s", arg)
+		}
+	}
+
+	idx, err := readIndex()
+	if err != nil {
+		return "", err
+	}
+	if len(idx.unmerged()) > 0 {
+		return "", errors.New("Committing is not possible because you have unmerged files.")
+	}
+	tree, err := idx.writeTree()
+	if err != nil {
+		return "", err
+	}
+
+	head, err := headCommit()
+	if err != nil {
+		return "", err
+	}
+	parents := make([]string, 0)
+	if head != "" {
+		parents = append(parents, head)
+	}
+	mergeHeads, err := readMergeHeads()
+	if err != nil {
+		return "", err
+	}
+	parents = append(parents, mergeHeads...)
+
+	if head != "" && len(mergeHeads) == 0 && !allowEmpty {
+		headTree, err := peel(head, "tree")
+		if err != nil {
+			return "", err
+		}
+		if headTree == tree {
+			return "", errors.New("nothing to commit, working tree clean")
+		}
+	}
+
+	msg := strings.Join(messages, "\n\n")
+	if len(messages) == 0 {
+		for _, name := range []string{"MERGE_MSG", "SQUASH_MSG"} {
+			b, err := os.ReadFile(".git/" + name)
+			if err == nil {
+				msg = string(b)
+				break
+			}
+		}
+	}
+	msg = cleanupMessage(msg)
+	if msg == "" {
+		return "", errors.New("Aborting commit due to empty commit message.")
+	}
+
+	author := signature("AUTHOR")
+	if picked, err := resolveRef("CHERRY_PICK_HEAD"); err == nil {
+		// A conflicted cherry-pick keeps the author of the original commit.
+		c, err := readCommit(picked)
+		if err != nil {
+			return "", err
+		}
+		author = c.Author
+	}
+
+	sha, err := writeCommitObject(&commit{
+		Tree:      tree,
+		Parents:   parents,
+		Author:    author,
+		Committer: signature("COMMITTER"),
+		Message:   msg,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := updateHead(sha); err != nil {
+		return "", err
+	}
+	removeMergeState()
+	return commitSummary(sha, msg, head == "")
+}
+
+// removeMergeState forgets about a merge, cherry-pick or revert in progress.
+func removeMergeState() {
+	for _, name := range []string{"MERGE_HEAD", "MERGE_MSG", "MERGE_MODE", "SQUASH_MSG", "CHERRY_PICK_HEAD", "REVERT_HEAD"} {
+		os.Remove(".git/" + name)
+	}
+}
+
+// commitSummary returns the line git prints after making a commit.
+func commitSummary(sha, msg string, root bool) (string, error) {
+	branch, err := currentBranch()
+	if err != nil {
+		return "", err
+	}
+	if branch == "" {
+		branch = "detached HEAD"
+	}
+	if root {
+		branch += " (root-commit)"
+	}
+	subject := msg
+	if i := strings.IndexByte(subject, '\n'); i >= 0 {
+		subject = subject[:i]
+	}
+	return fmt.Sprintf("[%s %s] %s", branch, sha[:7], subject), nil
+}
+
+// readMergeHeads returns the commits recorded in MERGE_HEAD by a merge that
+// is waiting to be committed.
+func readMergeHeads() ([]string, error) {
+	b, err := os.ReadFile(".git/MERGE_HEAD")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return strings.Fields(string(b)), nil
+}
+
+// cleanupMessage strips comment lines and surrounding blank lines from a
+// commit message, the way git does before committing.
+func cleanupMessage(msg string) string {
+	lines := make([]string, 0)
+	for _, l := range strings.Split(msg, "\n") {
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(l, " \t"))
+	}
+	s := strings.Trim(strings.Join(lines, "\n"), "\n")
+	if s == "" {
+		return ""
+	}
+	return s + "\n"
+}
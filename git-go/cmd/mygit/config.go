@@ -0,0 +1,1212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Notes about config files:
+// - "[section]" and `[section "subsection"]` headers start sections. Section
+//   and variable names are case-insensitive, subsections are not.
+// - "name = value" sets a variable, a bare "name" sets it to true. The same
+//   name may be set several times.
+// - Values may be quoted, use the escapes \" \\ \n \t \b and continue on the
+//   next line after a backslash. '#' and ';' start comments outside quotes.
+// - include.path and includeIf.<condition>.path read another file in place.
+// - Files are read from the system, global and local scopes, then "-c"
+//   options; later values win.
+
+const maxConfigIncludeDepth = 10
+
+// configEntry is one variable assignment. Key is "section.name" or
+// "section.subsection.name", lowercased but for the subsection.
+type configEntry struct {
+	Key     string
+	Value   string
+	NoValue bool
+	Scope   string
+	File    string
+
+	// Where the assignment sits in its file, for rewriting it.
+	start, end int
+}
+
+// configSection records where a section of a file is: its header line,
+// the end of its last assignment and the start of the next section.
+type configSection struct {
+	Name      string
+	Start     int
+	HeaderEnd int
+	LastEnd   int
+	End       int
+}
+
+type configParser struct {
+	data   []byte
+	pos    int
+	line   int
+	path   string
+	scope  string
+	follow bool
+	depth  int
+
+	entries  []configEntry
+	sections []configSection
+}
+
+// configParameters holds the "-c name=value" options given before the
+// command.
+var configParameters []string
+
+var loadedConfig []configEntry
+
+// readConfig reads every config file and the command line options once,
+// in order of precedence.
+func readConfig() ([]configEntry, error) {
+	if loadedConfig != nil {
+		return loadedConfig, nil
+	}
+
+	entries := make([]configEntry, 0)
+	for _, scope := range []string{"system", "global", "local"} {
+		for _, p := range configPaths(scope) {
+			e, err := readConfigFile(p, scope, true)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e...)
+		}
+	}
+	e, err := commandConfig()
+	if err != nil {
+		return nil, err
+	}
+	loadedConfig = append(entries, e...)
+	return loadedConfig, nil
+}
+
+// forgetConfig makes the next lookup read the config files again.
+func forgetConfig() {
+	loadedConfig = nil
+}
+
+// configPaths returns the files read for a scope, lowest precedence first.
+func configPaths(scope string) []string {
+	home := os.Getenv("HOME")
+	switch scope {
+	case "system":
+		if v, _ := parseConfigBool(os.Getenv("GIT_CONFIG_NOSYSTEM"), false); v {
+			return nil
+		}
+		if p := os.Getenv("GIT_CONFIG_SYSTEM"); p != "" {
+			return []string{p}
+		}
+		return []string{"/etc/gitconfig"}
+	case "global":
+		if p, ok := os.LookupEnv("GIT_CONFIG_GLOBAL"); ok {
+			return []string{p}
+		}
+		paths := make([]string, 0, 2)
+		xdg := os.Getenv("XDG_CONFIG_HOME")
+		if xdg == "" && home != "" {
+			xdg = filepath.Join(home, ".config")
+		}
+		if xdg != "" {
+			paths = append(paths, filepath.Join(xdg, "git", "config"))
+		}
+		if home != "" {
+			paths = append(paths, filepath.Join(home, ".gitconfig"))
+		}
+		return paths
+	case "local":
+		return []string{".git/config"}
+	}
+	return nil
+}
+
+// configWritePath returns the file that "config" changes for a scope.
+func configWritePath(scope string) (string, error) {
+	paths := configPaths(scope)
+	if len(paths) == 0 {
+		return "", fmt.Errorf("no %s config file", scope)
+	}
+	p := paths[len(paths)-1]
+	switch scope {
+	case "global":
+		// Like git, prefer the XDG file when only it exists.
+		if _, err := os.Stat(p); os.IsNotExist(err) && len(paths) > 1 {
+			if _, err := os.Stat(paths[0]); err == nil {
+				p = paths[0]
+			}
+		}
+	case "local":
+		if _, err := os.Stat(".git"); err != nil {
+			return "", errors.New("not in a git directory")
+		}
+	}
+	return p, nil
+}
+
+func readConfigFile(p, scope string, follow bool) ([]configEntry, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, os.ErrPermission) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cp := &configParser{data: b, path: p, scope: scope, follow: follow}
+	if err := cp.parse(); err != nil {
+		return nil, err
+	}
+	return cp.entries, nil
+}
+
+// commandConfig turns GIT_CONFIG_COUNT/KEY_<n>/VALUE_<n> and the "-c"
+// options into entries.
+func commandConfig() ([]configEntry, error) {
+	entries := make([]configEntry, 0)
+	if s := os.Getenv("GIT_CONFIG_COUNT"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bogus count in GIT_CONFIG_COUNT: %s", s)
+		}
+		for i := 0; i < n; i++ {
+			key, ok := os.LookupEnv(fmt.Sprintf("GIT_CONFIG_KEY_%d", i))
+			if !ok {
+				return nil, fmt.Errorf("missing config key GIT_CONFIG_KEY_%d", i)
+			}
+			value, ok := os.LookupEnv(fmt.Sprintf("GIT_CONFIG_VALUE_%d", i))
+			if !ok {
+				return nil, fmt.Errorf("missing config value GIT_CONFIG_VALUE_%d", i)
+			}
+			canonical, err := canonicalConfigKey(key)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, configEntry{Key: canonical, Value: value, Scope: "command"})
+		}
+	}
+
+	for _, param := range configParameters {
+		kv := strings.SplitN(param, "=", 2)
+		key, value, hasValue := kv[0], "", len(kv) == 2
+		if hasValue {
+			value = kv[1]
+		}
+		canonical, err := canonicalConfigKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("bogus config parameter: %s", param)
+		}
+		entries = append(entries, configEntry{Key: canonical, Value: value, NoValue: !hasValue, Scope: "command"})
+	}
+	return entries, nil
+}
+
+// canonicalConfigKey lowercases the section and the name of a key and
+// checks that they are valid.
+func canonicalConfigKey(key string) (string, error) {
+	first := strings.IndexByte(key, '.')
+	last := strings.LastIndexByte(key, '.')
+	if first <= 0 || last == len(key)-1 {
+		if first < 0 {
+			return "", fmt.Errorf("key does not contain a section: %s", key)
+		}
+		return "", fmt.Errorf("key does not contain variable name: %s", key)
+	}
+
+	section, name := key[:first], key[last+1:]
+	for i := 0; i < len(section); i++ {
+		if !isConfigKeyChar(section[i]) {
+			return "", fmt.Errorf("invalid key: %s", key)
+		}
+	}
+	if !isAlpha(name[0]) {
+		return "", fmt.Errorf("invalid key: %s", key)
+	}
+	for i := 0; i < len(name); i++ {
+		if !isConfigKeyChar(name[i]) {
+			return "", fmt.Errorf("invalid key: %s", key)
+		}
+	}
+	if strings.ContainsRune(key[first:last+1], '\n') {
+		return "", fmt.Errorf("invalid key (newline): %s", key)
+	}
+	return strings.ToLower(section) + key[first:last+1] + strings.ToLower(name), nil
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isConfigKeyChar(c byte) bool {
+	return isAlpha(c) || (c >= '0' && c <= '9') || c == '-'
+}
+
+func isConfigSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func (p *configParser) next() (byte, bool) {
+	if p.pos >= len(p.data) {
+		return 0, false
+	}
+	c := p.data[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c, true
+}
+
+func (p *configParser) peek() byte {
+	if p.pos >= len(p.data) {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+func (p *configParser) errorf() error {
+	return fmt.Errorf("bad config line %d in file %s", p.line+1, p.path)
+}
+
+// lineStart moves back over the indentation before i.
+func (p *configParser) lineStart(i int) int {
+	s := i
+	for s > 0 && isConfigSpace(p.data[s-1]) {
+		s--
+	}
+	if s == 0 || p.data[s-1] == '\n' {
+		return s
+	}
+	return i
+}
+
+// skipLine moves past the end of the current line.
+func (p *configParser) skipLine() {
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			return
+		}
+	}
+}
+
+func (p *configParser) parse() error {
+	// A UTF-8 byte order mark is allowed at the start of the file.
+	if strings.HasPrefix(string(p.data), "\xef\xbb\xbf") {
+		p.pos = 3
+	}
+
+	section := ""
+	for {
+		start := p.pos
+		c, ok := p.next()
+		if !ok {
+			break
+		}
+		switch {
+		case c == '\n' || isConfigSpace(c):
+		case c == '#' || c == ';':
+			p.skipLine()
+		case c == '[':
+			name, err := p.header()
+			if err != nil {
+				return err
+			}
+			section = name
+			for isConfigSpace(p.peek()) {
+				p.pos++
+			}
+			if p.peek() == '\n' {
+				p.next()
+			}
+			p.sections = append(p.sections, configSection{
+				Name:      name,
+				Start:     p.lineStart(start),
+				HeaderEnd: p.pos,
+				LastEnd:   p.pos,
+			})
+		case isAlpha(c):
+			if section == "" {
+				return p.errorf()
+			}
+			name := []byte{c}
+			for isConfigKeyChar(p.peek()) {
+				name = append(name, p.peek())
+				p.pos++
+			}
+			for isConfigSpace(p.peek()) {
+				p.pos++
+			}
+
+			e := configEntry{
+				Key:   section + "." + strings.ToLower(string(name)),
+				Scope: p.scope,
+				File:  p.path,
+				start: p.lineStart(start),
+			}
+			switch p.peek() {
+			case '=':
+				p.pos++
+				v, err := p.value()
+				if err != nil {
+					return err
+				}
+				e.Value = v
+			case 0, '\n', '#', ';':
+				e.NoValue = true
+				p.skipLine()
+			default:
+				return p.errorf()
+			}
+			e.end = p.pos
+			if err := p.add(e); err != nil {
+				return err
+			}
+		default:
+			return p.errorf()
+		}
+	}
+
+	for i := range p.sections {
+		if i+1 < len(p.sections) {
+			p.sections[i].End = p.sections[i+1].Start
+		} else {
+			p.sections[i].End = len(p.data)
+		}
+	}
+	return nil
+}
+
+// header reads a section header after its '['. Old style [section.sub]
+// headers are lowercased entirely.
+func (p *configParser) header() (string, error) {
+	name := make([]byte, 0)
+	for {
+		c, ok := p.next()
+		if !ok {
+			return "", p.errorf()
+		}
+		if c == ']' {
+			if len(name) == 0 {
+				return "", p.errorf()
+			}
+			return strings.ToLower(string(name)), nil
+		}
+		if isConfigSpace(c) {
+			break
+		}
+		if !isConfigKeyChar(c) && c != '.' {
+			return "", p.errorf()
+		}
+		name = append(name, c)
+	}
+
+	for isConfigSpace(p.peek()) {
+		p.pos++
+	}
+	if c, _ := p.next(); c != '"' {
+		return "", p.errorf()
+	}
+	sub := make([]byte, 0)
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			return "", p.errorf()
+		}
+		if c == '"' {
+			break
+		}
+		if c == '\\' {
+			if c, ok = p.next(); !ok || c == '\n' {
+				return "", p.errorf()
+			}
+		}
+		sub = append(sub, c)
+	}
+	if c, _ := p.next(); c != ']' {
+		return "", p.errorf()
+	}
+	return strings.ToLower(string(name)) + "." + string(sub), nil
+}
+
+// value reads the rest of an assignment after its '=', through the end of
+// the line.
+func (p *configParser) value() (string, error) {
+	var b strings.Builder
+	quoted, comment := false, false
+	spaces := 0
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			if quoted {
+				return "", p.errorf()
+			}
+			return b.String(), nil
+		}
+		if comment {
+			continue
+		}
+		if isConfigSpace(c) && !quoted {
+			if b.Len() > 0 {
+				spaces++
+			}
+			continue
+		}
+		if !quoted && (c == '#' || c == ';') {
+			comment = true
+			continue
+		}
+		for ; spaces > 0; spaces-- {
+			b.WriteByte(' ')
+		}
+
+		switch c {
+		case '\\':
+			c, _ = p.next()
+			switch c {
+			case '\n':
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			case 'n':
+				b.WriteByte('\n')
+			case '\\', '"':
+				b.WriteByte(c)
+			default:
+				return "", p.errorf()
+			}
+		case '"':
+			quoted = !quoted
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// add records an assignment and reads the files it includes.
+func (p *configParser) add(e configEntry) error {
+	p.entries = append(p.entries, e)
+	if len(p.sections) > 0 {
+		p.sections[len(p.sections)-1].LastEnd = e.end
+	}
+	if !p.follow || e.NoValue {
+		return nil
+	}
+
+	if e.Key == "include.path" {
+		return p.include(e.Value)
+	}
+	if strings.HasPrefix(e.Key, "includeif.") && strings.HasSuffix(e.Key, ".path") && len(e.Key) > len("includeif..path") {
+		cond := e.Key[len("includeif.") : len(e.Key)-len(".path")]
+		if configCondition(cond, p.path) {
+			return p.include(e.Value)
+		}
+	}
+	return nil
+}
+
+func (p *configParser) include(path string) error {
+	if p.depth >= maxConfigIncludeDepth {
+		return fmt.Errorf("exceeded maximum include depth (%d) while including %s from %s", maxConfigIncludeDepth, path, p.path)
+	}
+	path = expandUserPath(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(p.path), path)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	sub := &configParser{data: b, path: path, scope: p.scope, follow: true, depth: p.depth + 1}
+	if err := sub.parse(); err != nil {
+		return err
+	}
+	p.entries = append(p.entries, sub.entries...)
+	return nil
+}
+
+// configCondition evaluates the condition of an includeIf section.
+func configCondition(cond, from string) bool {
+	switch {
+	case strings.HasPrefix(cond, "gitdir:"):
+		return includeGitdir(strings.TrimPrefix(cond, "gitdir:"), from, 0)
+	case strings.HasPrefix(cond, "gitdir/i:"):
+		return includeGitdir(strings.TrimPrefix(cond, "gitdir/i:"), from, wmCaseFold)
+	case strings.HasPrefix(cond, "onbranch:"):
+		head, err := readSymbolicRef("HEAD")
+		if err != nil || !strings.HasPrefix(head, "refs/heads/") {
+			return false
+		}
+		pattern := strings.TrimPrefix(cond, "onbranch:")
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		return wildmatch(pattern, strings.TrimPrefix(head, "refs/heads/"), wmPathname)
+	}
+	return false
+}
+
+// includeGitdir matches the repository directory against a gitdir pattern:
+// "~/" and "./" are expanded, relative patterns match at any depth and a
+// trailing slash matches everything below.
+func includeGitdir(pattern, from string, flags int) bool {
+	gitDir, err := filepath.Abs(".git")
+	if err != nil {
+		return false
+	}
+	if _, err := os.Stat(gitDir); err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(pattern, "./") {
+		dir, err := filepath.Abs(filepath.Dir(from))
+		if err != nil {
+			return false
+		}
+		pattern = dir + pattern[1:]
+	}
+	pattern = expandUserPath(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = "**/" + pattern
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	if wildmatch(pattern, gitDir, flags|wmPathname) {
+		return true
+	}
+	real, err := filepath.EvalSymlinks(gitDir)
+	return err == nil && real != gitDir && wildmatch(pattern, real, flags|wmPathname)
+}
+
+// expandUserPath replaces a leading "~/" with the home directory.
+func expandUserPath(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		return os.Getenv("HOME") + p[1:]
+	}
+	return p
+}
+
+// parseConfigBool reads a boolean the way git does: true, yes, on, false,
+// no, off, the empty string or a number. A name with no value is true.
+func parseConfigBool(value string, noValue bool) (bool, bool) {
+	if noValue {
+		return true, true
+	}
+	switch strings.ToLower(value) {
+	case "true", "yes", "on":
+		return true, true
+	case "false", "no", "off", "":
+		return false, true
+	}
+	if n, err := parseConfigInt(value); err == nil {
+		return n != 0, true
+	}
+	return false, false
+}
+
+// parseConfigInt reads an integer with an optional k, m or g suffix.
+func parseConfigInt(value string) (int64, error) {
+	if value == "" {
+		return 0, errors.New("invalid unit")
+	}
+	factor := int64(1)
+	num := value
+	if c := value[len(value)-1]; isAlpha(c) {
+		switch toLowerByte(c) {
+		case 'k':
+			factor = 1 << 10
+		case 'm':
+			factor = 1 << 20
+		case 'g':
+			factor = 1 << 30
+		default:
+			return 0, errors.New("invalid unit")
+		}
+		num = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(num, 0, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, errors.New("out of range")
+		}
+		return 0, errors.New("invalid unit")
+	}
+	if n > 0 && n > (1<<63-1)/factor || n < 0 && n < (-1<<63)/factor {
+		return 0, errors.New("out of range")
+	}
+	return n * factor, nil
+}
+
+// configEntries returns the entries of a key, lowest precedence first.
+func configEntries(key string) []configEntry {
+	canonical, err := canonicalConfigKey(key)
+	if err != nil {
+		return nil
+	}
+	entries, _ := readConfig()
+	found := make([]configEntry, 0)
+	for _, e := range entries {
+		if e.Key == canonical {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// configValue returns the value that wins for a key.
+func configValue(key string) (string, bool) {
+	found := configEntries(key)
+	if len(found) == 0 {
+		return "", false
+	}
+	return found[len(found)-1].Value, true
+}
+
+func configBool(key string, def bool) (bool, error) {
+	found := configEntries(key)
+	if len(found) == 0 {
+		return def, nil
+	}
+	e := found[len(found)-1]
+	v, ok := parseConfigBool(e.Value, e.NoValue)
+	if !ok {
+		return false, fmt.Errorf("bad boolean config value '%s' for '%s'", e.Value, key)
+	}
+	return v, nil
+}
+
+func configInt(key string, def int64) (int64, error) {
+	found := configEntries(key)
+	if len(found) == 0 {
+		return def, nil
+	}
+	e := found[len(found)-1]
+	n, err := parseConfigInt(e.Value)
+	if err != nil {
+		return 0, fmt.Errorf("bad numeric config value '%s' for '%s': %s", e.Value, key, err)
+	}
+	return n, nil
+}
+
+// configStatusError carries the exit status git uses for a failed config
+// command.
+type configStatusError struct {
+	Status int
+	Err    error
+}
+
+func (e *configStatusError) Error() string {
+	return e.Err.Error()
+}
+
+type configOptions struct {
+	Action     string
+	File       string
+	Scope      string
+	Type       string
+	Default    *string
+	Includes   bool
+	ShowOrigin bool
+	ShowScope  bool
+	NameOnly   bool
+}
+
+// configCommand implements "config". It returns the exit status.
+func configCommand(args []string) (int, error) {
+	opts := configOptions{}
+	rest := make([]string, 0)
+
+	// The newer "config get|set|unset|list" spelling.
+	if len(args) > 0 {
+		switch args[0] {
+		case "get", "set", "unset", "list":
+			opts.Action = args[0]
+			args = args[1:]
+		}
+	}
+	action := func(a string) error {
+		if opts.Action != "" && opts.Action != a {
+			return errors.New("only one action at a time")
+		}
+		opts.Action = a
+		return nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var err error
+		switch {
+		case arg == "--system" || arg == "--global" || arg == "--local":
+			opts.Scope = strings.TrimPrefix(arg, "--")
+		case arg == "-f" || arg == "--file":
+			if i+1 >= len(args) {
+				return 129, errors.New("option requires a value: --file")
+			}
+			i++
+			opts.File = args[i]
+		case strings.HasPrefix(arg, "--file="):
+			opts.File = strings.TrimPrefix(arg, "--file=")
+		case arg == "--bool" || arg == "--int" || arg == "--bool-or-int" || arg == "--path":
+			opts.Type = strings.TrimPrefix(arg, "--")
+		case strings.HasPrefix(arg, "--type="):
+			opts.Type = strings.TrimPrefix(arg, "--type=")
+			switch opts.Type {
+			case "bool", "int", "bool-or-int", "path":
+			default:
+				return 129, fmt.Errorf("unrecognized --type argument, %s", opts.Type)
+			}
+		case arg == "--default":
+			if i+1 >= len(args) {
+				return 129, errors.New("option requires a value: --default")
+			}
+			i++
+			opts.Default = &args[i]
+		case arg == "--includes":
+			opts.Includes = true
+		case arg == "--show-origin":
+			opts.ShowOrigin = true
+		case arg == "--show-scope":
+			opts.ShowScope = true
+		case arg == "--name-only":
+			opts.NameOnly = true
+		case arg == "--all" && (opts.Action == "get" || opts.Action == "unset"):
+			opts.Action += "-all"
+		case arg == "--all" && opts.Action == "set":
+			opts.Action = "replace-all"
+		case arg == "--append" && opts.Action == "set":
+			opts.Action = "add"
+		case arg == "-l" || arg == "--list":
+			err = action("list")
+		case arg == "--get" || arg == "--get-all" || arg == "--get-regexp" || arg == "--add" ||
+			arg == "--replace-all" || arg == "--unset" || arg == "--unset-all" || arg == "--set":
+			err = action(strings.TrimPrefix(arg, "--"))
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return 129, fmt.Errorf("unknown option %s", arg)
+		default:
+			rest = append(rest, arg)
+		}
+		if err != nil {
+			return 129, err
+		}
+	}
+	if opts.Action == "" {
+		switch len(rest) {
+		case 1:
+			opts.Action = "get"
+		case 2:
+			opts.Action = "set"
+		default:
+			return 129, errors.New("usage: mygit config [<options>] <name> [<value>]")
+		}
+	}
+
+	err := runConfig(opts, rest)
+	if err == nil {
+		return 0, nil
+	}
+	var statusErr *configStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status, statusErr.Err
+	}
+	return 1, err
+}
+
+func runConfig(opts configOptions, args []string) error {
+	want := func(n int) error {
+		if len(args) != n {
+			return &configStatusError{Status: 129, Err: fmt.Errorf("wrong number of arguments, should be %d", n)}
+		}
+		return nil
+	}
+
+	switch opts.Action {
+	case "list":
+		if err := want(0); err != nil {
+			return err
+		}
+		entries, err := opts.read()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if opts.NameOnly {
+				fmt.Println(opts.prefix(e) + e.Key)
+			} else if e.NoValue {
+				fmt.Println(opts.prefix(e) + e.Key)
+			} else {
+				fmt.Println(opts.prefix(e) + e.Key + "=" + e.Value)
+			}
+		}
+		return nil
+
+	case "get", "get-all", "get-regexp":
+		if err := want(1); err != nil {
+			return err
+		}
+		entries, err := opts.read()
+		if err != nil {
+			return err
+		}
+		var match func(string) bool
+		if opts.Action == "get-regexp" {
+			re, err := regexp.Compile(args[0])
+			if err != nil {
+				return &configStatusError{Status: 6, Err: fmt.Errorf("invalid key pattern: %s", args[0])}
+			}
+			match = re.MatchString
+		} else {
+			key, err := canonicalConfigKey(args[0])
+			if err != nil {
+				return err
+			}
+			match = func(k string) bool { return k == key }
+		}
+
+		found := make([]configEntry, 0)
+		for _, e := range entries {
+			if match(e.Key) {
+				found = append(found, e)
+			}
+		}
+		if len(found) == 0 && opts.Default != nil {
+			found = append(found, configEntry{Key: args[0], Value: *opts.Default})
+		}
+		if len(found) == 0 {
+			return &configStatusError{Status: 1, Err: nil}
+		}
+		if opts.Action == "get" {
+			found = found[len(found)-1:]
+		}
+		for _, e := range found {
+			value, err := opts.format(e)
+			if err != nil {
+				return &configStatusError{Status: 128, Err: err}
+			}
+			switch {
+			case opts.Action != "get-regexp":
+				fmt.Println(opts.prefix(e) + value)
+			case opts.NameOnly || (e.NoValue && opts.Type == ""):
+				fmt.Println(opts.prefix(e) + e.Key)
+			default:
+				fmt.Println(opts.prefix(e) + e.Key + " " + value)
+			}
+		}
+		return nil
+
+	case "set", "add", "replace-all":
+		if err := want(2); err != nil {
+			return err
+		}
+		if _, err := canonicalConfigKey(args[0]); err != nil {
+			return err
+		}
+		value := args[1]
+		if opts.Type != "" && opts.Type != "path" {
+			v, err := opts.format(configEntry{Key: args[0], Value: value})
+			if err != nil {
+				return &configStatusError{Status: 128, Err: err}
+			}
+			value = v
+		}
+		p, err := opts.writePath()
+		if err != nil {
+			return err
+		}
+		return setConfig(p, args[0], value, opts.Action)
+
+	case "unset", "unset-all":
+		if err := want(1); err != nil {
+			return err
+		}
+		if _, err := canonicalConfigKey(args[0]); err != nil {
+			return err
+		}
+		p, err := opts.writePath()
+		if err != nil {
+			return err
+		}
+		return unsetConfig(p, args[0], opts.Action == "unset-all")
+	}
+	return fmt.Errorf("unknown action %s", opts.Action)
+}
+
+// read returns the entries the options select: one file, one scope or
+// everything.
+func (opts configOptions) read() ([]configEntry, error) {
+	switch {
+	case opts.File != "":
+		return readConfigFile(opts.File, "command", opts.Includes)
+	case opts.Scope != "":
+		entries := make([]configEntry, 0)
+		for _, p := range configPaths(opts.Scope) {
+			e, err := readConfigFile(p, opts.Scope, opts.Includes)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e...)
+		}
+		return entries, nil
+	}
+	return readConfig()
+}
+
+func (opts configOptions) writePath() (string, error) {
+	if opts.File != "" {
+		return opts.File, nil
+	}
+	scope := opts.Scope
+	if scope == "" {
+		scope = "local"
+	}
+	return configWritePath(scope)
+}
+
+// prefix returns what --show-scope and --show-origin put before an entry.
+func (opts configOptions) prefix(e configEntry) string {
+	s := ""
+	if opts.ShowScope {
+		s += e.Scope + "\t"
+	}
+	if opts.ShowOrigin {
+		if e.File != "" {
+			s += "file:" + e.File + "\t"
+		} else {
+			s += "command line:\t"
+		}
+	}
+	return s
+}
+
+// format converts a value to the type asked for with --type.
+func (opts configOptions) format(e configEntry) (string, error) {
+	switch opts.Type {
+	case "bool":
+		v, ok := parseConfigBool(e.Value, e.NoValue)
+		if !ok {
+			return "", fmt.Errorf("bad boolean config value '%s' for '%s'", e.Value, e.Key)
+		}
+		return strconv.FormatBool(v), nil
+	case "int":
+		n, err := parseConfigInt(e.Value)
+		if err != nil {
+			return "", fmt.Errorf("bad numeric config value '%s' for '%s': %s", e.Value, e.Key, err)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case "bool-or-int":
+		if n, err := parseConfigInt(e.Value); err == nil && !e.NoValue {
+			return strconv.FormatInt(n, 10), nil
+		}
+		v, ok := parseConfigBool(e.Value, e.NoValue)
+		if !ok {
+			return "", fmt.Errorf("bad boolean config value '%s' for '%s'", e.Value, e.Key)
+		}
+		return strconv.FormatBool(v), nil
+	case "path":
+		return expandUserPath(e.Value), nil
+	}
+	return e.Value, nil
+}
+
+// parseConfigForWrite reads a file to rewrite without following includes.
+func parseConfigForWrite(p string) (*configParser, error) {
+	b, err := os.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	cp := &configParser{data: b, path: p}
+	if err := cp.parse(); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// setConfig changes a variable in a file. "set" replaces its single value,
+// "replace-all" every value and "add" adds one more.
+func setConfig(p, key, value, mode string) error {
+	cp, err := parseConfigForWrite(p)
+	if err != nil {
+		return err
+	}
+	canonical, _ := canonicalConfigKey(key)
+	matches := make([]configEntry, 0)
+	for _, e := range cp.entries {
+		if e.Key == canonical {
+			matches = append(matches, e)
+		}
+	}
+	if mode == "set" && len(matches) > 1 {
+		fmt.Fprintf(os.Stderr, "warning: %s has multiple values\n", key)
+		return &configStatusError{Status: 5, Err: fmt.Errorf("cannot overwrite multiple values with a single value\n       Use a regexp, --add or --replace-all to change %s.", key)}
+	}
+
+	section, name := splitConfigKey(key)
+	line := "\t" + name + " = " + quoteConfigValue(value) + "\n"
+	data := cp.data
+	switch {
+	case mode != "add" && len(matches) > 0:
+		// Replace the first assignment and drop the others.
+		out := make([]byte, 0, len(data)+len(line))
+		at := 0
+		for i, e := range matches {
+			out = append(out, data[at:e.start]...)
+			if i == 0 {
+				out = append(out, line...)
+			}
+			at = e.end
+		}
+		out = append(out, data[at:]...)
+		data = out
+	default:
+		at := -1
+		if len(matches) > 0 {
+			at = matches[len(matches)-1].end
+		} else {
+			sectionName, _ := canonicalConfigKey(key)
+			sectionName = sectionName[:strings.LastIndexByte(sectionName, '.')]
+			for _, s := range cp.sections {
+				if s.Name == sectionName {
+					at = s.LastEnd
+				}
+			}
+		}
+		if at < 0 {
+			at = len(data)
+			line = configSectionHeader(section) + line
+		}
+		if at > 0 && data[at-1] != '\n' {
+			line = "\n" + line
+		}
+		out := make([]byte, 0, len(data)+len(line))
+		out = append(out, data[:at]...)
+		out = append(out, line...)
+		out = append(out, data[at:]...)
+		data = out
+	}
+
+	forgetConfig()
+	return writeFileAtomic(p, data, 0644)
+}
+
+// unsetConfig removes a variable from a file, and its section when nothing
+// else is left in it.
+func unsetConfig(p, key string, all bool) error {
+	cp, err := parseConfigForWrite(p)
+	if err != nil {
+		return err
+	}
+	canonical, _ := canonicalConfigKey(key)
+	matches := make([]configEntry, 0)
+	for _, e := range cp.entries {
+		if e.Key == canonical {
+			matches = append(matches, e)
+		}
+	}
+	if len(matches) == 0 {
+		return &configStatusError{Status: 5, Err: nil}
+	}
+	if len(matches) > 1 && !all {
+		fmt.Fprintf(os.Stderr, "warning: %s has multiple values\n", key)
+		return &configStatusError{Status: 5, Err: nil}
+	}
+
+	type span struct{ start, end int }
+	cuts := make([]span, 0, len(matches))
+	for _, e := range matches {
+		cuts = append(cuts, span{e.start, e.end})
+	}
+	removed := func(i int) bool {
+		for _, c := range cuts {
+			if i >= c.start && i < c.end {
+				return true
+			}
+		}
+		return false
+	}
+	for _, s := range cp.sections {
+		empty := true
+		for i := s.HeaderEnd; i < s.End && empty; i++ {
+			if !removed(i) && !isConfigSpace(cp.data[i]) && cp.data[i] != '\n' {
+				empty = false
+			}
+		}
+		if empty {
+			cuts = append(cuts, span{s.Start, s.End})
+		}
+	}
+	sort.Slice(cuts, func(i, j int) bool { return cuts[i].start < cuts[j].start })
+
+	out := make([]byte, 0, len(cp.data))
+	at := 0
+	for _, c := range cuts {
+		if c.start > at {
+			out = append(out, cp.data[at:c.start]...)
+		}
+		if c.end > at {
+			at = c.end
+		}
+	}
+	out = append(out, cp.data[at:]...)
+
+	forgetConfig()
+	return writeFileAtomic(p, out, 0644)
+}
+
+// splitConfigKey splits a key as typed into its section, with any
+// subsection, and its name.
+func splitConfigKey(key string) (string, string) {
+	last := strings.LastIndexByte(key, '.')
+	return key[:last], key[last+1:]
+}
+
+func configSectionHeader(section string) string {
+	i := strings.IndexByte(section, '.')
+	if i < 0 {
+		return "[" + section + "]\n"
+	}
+	name, sub := section[:i], section[i+1:]
+	sub = strings.ReplaceAll(sub, `\`, `\\`)
+	sub = strings.ReplaceAll(sub, `"`, `\"`)
+	return fmt.Sprintf("[%s \"%s\"]\n", name, sub)
+}
+
+// quoteConfigValue escapes a value for writing, quoting it when spaces at
+// its ends or comment characters would otherwise be lost.
+func quoteConfigValue(v string) string {
+	quote := v != "" && (isConfigSpace(v[0]) || isConfigSpace(v[len(v)-1]) || strings.ContainsAny(v, "#;"))
+	var b strings.Builder
+	if quote {
+		b.WriteByte('"')
+	}
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if quote {
+		b.WriteByte('"')
+	}
+	return b.String()
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// configPairs lists the entries of a parser as "key=value" strings, with
+// just the key for a name without a value.
+func configPairs(entries []configEntry) []string {
+	pairs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.NoValue {
+			pairs = append(pairs, e.Key)
+		} else {
+			pairs = append(pairs, e.Key+"="+e.Value)
+		}
+	}
+	return pairs
+}
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{"[core]\n\tbare = false\n", []string{"core.bare=false"}, false},
+		{"[Core]\n\tBare\n", []string{"core.bare"}, false},
+		{"[remote \"Origin\"]\n\turl = x\n", []string{"remote.Origin.url=x"}, false},
+		{"[Remote.Origin]\n\turl = x\n", []string{"remote.origin.url=x"}, false},
+		{"[a]\nb = \" padded \" # comment\n", []string{"a.b= padded "}, false},
+		{"[a]\nb = one  two ; comment\n", []string{"a.b=one  two"}, false},
+		{"[a]\nb = tab\\there \\\"q\\\"\n", []string{"a.b=tab\there \"q\""}, false},
+		{"[a]\nb = first \\\n second\n", []string{"a.b=first  second"}, false},
+		{"\xef\xbb\xbf[a]\nb = 1\nb = 2\n", []string{"a.b=1", "a.b=2"}, false},
+		{"# only a comment\n", []string{}, false},
+		{"b = 1\n", nil, true},
+		{"[a\nb = 1\n", nil, true},
+		{"[a]\nb = \"open\n", nil, true},
+		{"[a]\nb = bad\\q\n", nil, true},
+		{"[a]\n1b = x\n", nil, true},
+	}
+	for _, tt := range tests {
+		p := &configParser{data: []byte(tt.in), path: "config"}
+		err := p.parse()
+		if tt.err {
+			if err == nil {
+				t.Errorf("parse(%q) did not fail", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parse(%q): %s", tt.in, err)
+			continue
+		}
+		if got := configPairs(p.entries); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parse(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalConfigKey(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{"Core.Bare", "core.bare", false},
+		{"Remote.Origin.URL", "remote.Origin.url", false},
+		{"a.Sub.Section.b", "a.Sub.Section.b", false},
+		{"nosection", "", true},
+		{"core.", "", true},
+		{"core.1x", "", true},
+		{"co_re.x", "", true},
+	}
+	for _, tt := range tests {
+		got, err := canonicalConfigKey(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("canonicalConfigKey(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestParseConfigValues(t *testing.T) {
+	bools := []struct {
+		in      string
+		noValue bool
+		want    bool
+		ok      bool
+	}{
+		{"", true, true, true},
+		{"Yes", false, true, true},
+		{"off", false, false, true},
+		{"", false, false, true},
+		{"2", false, true, true},
+		{"0", false, false, true},
+		{"maybe", false, false, false},
+	}
+	for _, tt := range bools {
+		if got, ok := parseConfigBool(tt.in, tt.noValue); got != tt.want || ok != tt.ok {
+			t.Errorf("parseConfigBool(%q, %v) = %v, %v", tt.in, tt.noValue, got, ok)
+		}
+	}
+
+	ints := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{"12", 12, false},
+		{"-3", -3, false},
+		{"2k", 2048, false},
+		{"1M", 1 << 20, false},
+		{"1g", 1 << 30, false},
+		{"1x", 0, true},
+		{"", 0, true},
+		{"99999999999g", 0, true},
+	}
+	for _, tt := range ints {
+		got, err := parseConfigInt(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseConfigInt(%q) = %d, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestConfigIncludes(t *testing.T) {
+	newTestRepo(t)
+	if err := os.WriteFile(".git/HEAD", []byte("ref: refs/heads/topic/one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitDir, err := filepath.Abs(".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"main": strings.Join([]string{
+			"[user]",
+			"\tname = main",
+			"[include]",
+			"\tpath = inc/one",
+			"[includeIf \"gitdir:" + gitDir + "\"]",
+			"\tpath = inc/gitdir",
+			"[includeIf \"gitdir:/nowhere/\"]",
+			"\tpath = inc/never",
+			"[includeIf \"onbranch:topic/\"]",
+			"\tpath = inc/branch",
+			"[includeIf \"onbranch:master\"]",
+			"\tpath = inc/never",
+			"[include]",
+			"\tpath = inc/missing",
+			"",
+		}, "\n"),
+		"inc/one":    "[user]\n\tname = one\n[include]\n\tpath = two\n",
+		"inc/two":    "[user]\n\temail = two@example.com\n",
+		"inc/gitdir": "[x]\n\tgitdir = yes\n",
+		"inc/branch": "[x]\n\tbranch = yes\n",
+		"inc/never":  "[x]\n\tnever = yes\n",
+		"loop":       "[include]\n\tpath = loop\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := readConfigFile("main", "local", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"user.name=main",
+		"include.path=inc/one",
+		"user.name=one",
+		"include.path=two",
+		"user.email=two@example.com",
+		"includeif.gitdir:" + gitDir + ".path=inc/gitdir",
+		"x.gitdir=yes",
+		"includeif.gitdir:/nowhere/.path=inc/never",
+		"includeif.onbranch:topic/.path=inc/branch",
+		"x.branch=yes",
+		"includeif.onbranch:master.path=inc/never",
+		"include.path=inc/missing",
+	}
+	if got := configPairs(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries with includes =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Without following includes only the file itself is read.
+	entries, err = readConfigFile("main", "local", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 7 {
+		t.Errorf("entries without includes = %q", configPairs(entries))
+	}
+
+	if _, err := readConfigFile("loop", "local", true); err == nil || !strings.Contains(err.Error(), "maximum include depth") {
+		t.Errorf("include loop: %v", err)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "config")
+	if err := os.WriteFile(p, []byte("[core]\n\tbare = false\n[user]\n\tname = old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		key, value, mode string
+	}{
+		{"user.name", "new name", "set"},
+		{"core.editor", "vi", "set"},
+		{"remote.origin.url", "/tmp/repo", "set"},
+		{"remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*", "add"},
+		{"remote.origin.fetch", "+refs/tags/*:refs/tags/*", "add"},
+		{"alias.c", " spaced # value ", "set"},
+	}
+	for _, s := range steps {
+		if err := setConfig(p, s.key, s.value, s.mode); err != nil {
+			t.Fatalf("setConfig(%s, %s): %s", s.key, s.value, err)
+		}
+	}
+	if err := setConfig(p, "remote.origin.fetch", "x", "set"); err == nil {
+		t.Error("set replaced a variable with several values")
+	}
+	if err := unsetConfig(p, "core.bare", false); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"[core]",
+		"\teditor = vi",
+		"[user]",
+		"\tname = new name",
+		"[remote \"origin\"]",
+		"\turl = /tmp/repo",
+		"\tfetch = +refs/heads/*:refs/remotes/origin/*",
+		"\tfetch = +refs/tags/*:refs/tags/*",
+		"[alias]",
+		"\tc = \" spaced # value \"",
+		"",
+	}, "\n")
+	if string(b) != want {
+		t.Errorf("config file =\n%s\nwant\n%s", b, want)
+	}
+
+	entries, err := readConfigFile(p, "local", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := configPairs(entries); got[len(got)-1] != "alias.c= spaced # value " {
+		t.Errorf("value read back = %q", got[len(got)-1])
+	}
+}
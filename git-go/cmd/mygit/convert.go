@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Notes about line ending conversion:
+// - Files with the text attribute, or text=auto files that do not look
+//   binary, are stored with LF line endings. Whether they get CRLF in the
+//   working tree depends on eol, core.autocrlf and core.eol.
+// - Without text or eol attributes, core.autocrlf=true or input treats
+//   files as text=auto; otherwise nothing is converted.
+// - Automatic conversion leaves files alone that already have CRs in the
+//   index, or mixed line endings in the working tree.
+// - Line endings are converted on the output of the clean filter and on
+//   the input of the smudge filter, see filter.go.
+
+type crlfAction int
+
+const (
+	crlfUndefined crlfAction = iota
+	crlfBinary
+	crlfText
+	crlfTextInput
+	crlfTextCRLF
+	crlfAuto
+	crlfAutoInput
+	crlfAutoCRLF
+)
+
+func (a crlfAction) auto() bool {
+	return a == crlfAuto || a == crlfAutoInput || a == crlfAutoCRLF
+}
+
+// textStats counts what decides whether content is text and how its lines
+// end.
+type textStats struct {
+	nul, loneCR, loneLF, crlf int
+	printable, nonPrintable   int
+}
+
+func gatherTextStats(b []byte) textStats {
+	var s textStats
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == '\r':
+			if i+1 < len(b) && b[i+1] == '\n' {
+				s.crlf++
+				i++
+			} else {
+				s.loneCR++
+			}
+		case c == '\n':
+			s.loneLF++
+		case c == 127:
+			s.nonPrintable++
+		case c < 32:
+			switch c {
+			case '\b', '\t', '\033', '\014':
+				s.printable++
+			case 0:
+				s.nul++
+				s.nonPrintable++
+			default:
+				s.nonPrintable++
+			}
+		default:
+			s.printable++
+		}
+	}
+	// A trailing DOS end-of-file mark does not count.
+	if len(b) > 0 && b[len(b)-1] == '\032' {
+		s.nonPrintable--
+	}
+	return s
+}
+
+func (s textStats) binary() bool {
+	return s.loneCR > 0 || s.nul > 0 || s.printable>>7 < s.nonPrintable
+}
+
+// autocrlf returns core.autocrlf: "true", "false" or "input".
+func autocrlf() (string, error) {
+	v, ok := configValue("core.autocrlf")
+	if ok && strings.EqualFold(v, "input") {
+		return "input", nil
+	}
+	set, err := configBool("core.autocrlf", false)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(set), nil
+}
+
+// textEOLIsCRLF reports whether text files get CRLF in the working tree
+// when no eol attribute says otherwise.
+func textEOLIsCRLF() (bool, error) {
+	auto, err := autocrlf()
+	if err != nil {
+		return false, err
+	}
+	switch auto {
+	case "true":
+		return true, nil
+	case "input":
+		return false, nil
+	}
+	eol, _ := configValue("core.eol")
+	return strings.EqualFold(eol, "crlf"), nil
+}
+
+// crlfActionFor decides how a path converts from its attributes and the
+// config.
+func crlfActionFor(p string) (crlfAction, error) {
+	c, err := attributes()
+	if err != nil {
+		return 0, err
+	}
+	values, err := c.lookup(p)
+	if err != nil {
+		return 0, err
+	}
+
+	action := attrCRLFAction(values["text"])
+	if action == crlfUndefined {
+		// "crlf" is what text used to be called.
+		action = attrCRLFAction(values["crlf"])
+	}
+	if action != crlfBinary {
+		switch eol := values["eol"]; {
+		case action == crlfAuto && eol == "lf":
+			action = crlfAutoInput
+		case action == crlfAuto && eol == "crlf":
+			action = crlfAutoCRLF
+		case eol == "lf":
+			action = crlfTextInput
+		case eol == "crlf":
+			action = crlfTextCRLF
+		}
+	}
+
+	if action == crlfText {
+		crlf, err := textEOLIsCRLF()
+		if err != nil {
+			return 0, err
+		}
+		if crlf {
+			return crlfTextCRLF, nil
+		}
+		return crlfTextInput, nil
+	}
+	if action == crlfUndefined {
+		auto, err := autocrlf()
+		if err != nil {
+			return 0, err
+		}
+		switch auto {
+		case "true":
+			return crlfAutoCRLF, nil
+		case "input":
+			return crlfAutoInput, nil
+		}
+		return crlfBinary, nil
+	}
+	return action, nil
+}
+
+func attrCRLFAction(v string) crlfAction {
+	switch v {
+	case attrSet:
+		return crlfText
+	case attrUnset:
+		return crlfBinary
+	case "input":
+		return crlfTextInput
+	case "auto":
+		return crlfAuto
+	}
+	return crlfUndefined
+}
+
+// outputCRLF reports whether an action writes CRLF line endings.
+func outputCRLF(action crlfAction) (bool, error) {
+	switch action {
+	case crlfTextCRLF, crlfAutoCRLF:
+		return true, nil
+	case crlfText, crlfAuto:
+		return textEOLIsCRLF()
+	}
+	return false, nil
+}
+
+func willConvertLFToCRLF(s textStats, action crlfAction) (bool, error) {
+	crlf, err := outputCRLF(action)
+	if err != nil || !crlf || s.loneLF == 0 {
+		return false, err
+	}
+	if action.auto() && (s.loneCR > 0 || s.crlf > 0 || s.binary()) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// stagedShas maps the paths of the index to their blobs.
+var stagedShas map[string]string
+
+// hasCRInIndex reports whether the staged version of a path has a CR. The
+// index is read once, not once per file.
+func hasCRInIndex(p string) bool {
+	if stagedShas == nil {
+		idx, err := readIndex()
+		if err != nil {
+			return false
+		}
+		stagedShas = make(map[string]string, len(idx.Entries))
+		for _, e := range idx.Entries {
+			if e.Stage == 0 {
+				stagedShas[e.Path] = e.Sha
+			}
+		}
+	}
+	sha, ok := stagedShas[p]
+	if !ok {
+		return false
+	}
+	b, err := readObjectOfType(sha, "blob")
+	return err == nil && bytes.IndexByte(b, '\r') >= 0
+}
+
+// forgetStagedIndex makes hasCRInIndex read the index again, once it has
+// been written.
+func forgetStagedIndex() {
+	stagedShas = nil
+}
+
+// convertToGit turns working tree content into what is stored for a path:
+// the clean filter runs first, then line endings are converted.
+func convertToGit(p string, b []byte, write bool) ([]byte, error) {
+	b, err := applyFilter(p, b, "clean", write)
+	if err != nil {
+		return nil, err
+	}
+	return crlfToGit(p, b, write)
+}
+
+// crlfToGit converts line endings for storing. When the result is about to
+// be written, core.safecrlf decides whether a change of line endings on the
+// next checkout is a warning or an error.
+func crlfToGit(p string, b []byte, write bool) ([]byte, error) {
+	action, err := crlfActionFor(p)
+	if err != nil {
+		return nil, err
+	}
+	if action == crlfBinary || len(b) == 0 {
+		return b, nil
+	}
+
+	s := gatherTextStats(b)
+	convert := s.crlf > 0
+	if action.auto() {
+		if s.binary() {
+			return b, nil
+		}
+		if convert && hasCRInIndex(p) {
+			convert = false
+		}
+	}
+
+	if write {
+		if err := checkSafeCRLF(p, s, action, convert); err != nil {
+			return nil, err
+		}
+	}
+	if !convert {
+		return b, nil
+	}
+	out := make([]byte, 0, len(b))
+	for i, c := range b {
+		if c == '\r' && i+1 < len(b) && b[i+1] == '\n' {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// checkSafeCRLF simulates adding and checking out content again and
+// complains when its line endings would not survive.
+func checkSafeCRLF(p string, s textStats, action crlfAction, convert bool) error {
+	mode := "warn"
+	if v, ok := configValue("core.safecrlf"); ok && !strings.EqualFold(v, "warn") {
+		set, err := configBool("core.safecrlf", false)
+		if err != nil {
+			return err
+		}
+		mode = fmt.Sprint(set)
+	}
+	if mode == "false" {
+		return nil
+	}
+
+	after := s
+	if convert {
+		after.loneLF += after.crlf
+		after.crlf = 0
+	}
+	crlf, err := willConvertLFToCRLF(after, action)
+	if err != nil {
+		return err
+	}
+	if crlf {
+		after.crlf += after.loneLF
+		after.loneLF = 0
+	}
+
+	var from, to string
+	switch {
+	case s.crlf > 0 && after.crlf == 0:
+		from, to = "CRLF", "LF"
+	case s.loneLF > 0 && after.loneLF == 0:
+		from, to = "LF", "CRLF"
+	default:
+		return nil
+	}
+	if mode == "true" {
+		return fmt.Errorf("%s would be replaced by %s in %s", from, to, p)
+	}
+	fmt.Fprintf(os.Stderr, "warning: in the working copy of '%s', %s will be replaced by %s the next time Git touches it\n", p, from, to)
+	return nil
+}
+
+// convertToWorktree turns stored content into what is checked out for a
+// path, undoing convertToGit in the opposite order.
+func convertToWorktree(p string, b []byte) ([]byte, error) {
+	b, err := crlfToWorktree(p, b)
+	if err != nil {
+		return nil, err
+	}
+	return applyFilter(p, b, "smudge", false)
+}
+
+func crlfToWorktree(p string, b []byte) ([]byte, error) {
+	action, err := crlfActionFor(p)
+	if err != nil {
+		return nil, err
+	}
+	if action == crlfBinary || len(b) == 0 {
+		return b, nil
+	}
+	convert, err := willConvertLFToCRLF(gatherTextStats(b), action)
+	if err != nil || !convert {
+		return b, err
+	}
+
+	out := make([]byte, 0, len(b)+len(b)/16)
+	for i, c := range b {
+		if c == '\n' && (i == 0 || b[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// objectCounts sums up what the object database holds. Loose objects are
+// measured by the disk space they use, packs and garbage by their size.
+type objectCounts struct {
+	Count         int
+	Size          int64
+	InPack        int
+	Packs         int
+	SizePack      int64
+	PrunePackable int
+	Garbage       []string
+	SizeGarbage   int64
+}
+
+func countObjectsCommand(args []string) error {
+	verbose := false
+	for _, arg := range args {
+		switch arg {
+		case "-v", "--verbose":
+			verbose = true
+		default:
+			return fmt.Errorf("unknown option %s", arg)
+		}
+	}
+
+	c, err := countObjects()
+	if err != nil {
+		return err
+	}
+	if !verbose {
+		fmt.Printf("%d objects, %d kilobytes\n", c.Count, c.Size/1024)
+		return nil
+	}
+
+	for _, p := range c.Garbage {
+		fmt.Fprintf(os.Stderr, "warning: garbage found: %s\n", p)
+	}
+	fmt.Printf("count: %d\n", c.Count)
+	fmt.Printf("size: %d\n", c.Size/1024)
+	fmt.Printf("in-pack: %d\n", c.InPack)
+	fmt.Printf("packs: %d\n", c.Packs)
+	fmt.Printf("size-pack: %d\n", c.SizePack/1024)
+	fmt.Printf("prune-packable: %d\n", c.PrunePackable)
+	fmt.Printf("garbage: %d\n", len(c.Garbage))
+	fmt.Printf("size-garbage: %d\n", c.SizeGarbage/1024)
+	return nil
+}
+
+func countObjects() (*objectCounts, error) {
+	c := &objectCounts{Garbage: make([]string, 0)}
+
+	dirs, err := filepath.Glob(".git/objects/[0-9a-f][0-9a-f]")
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			name := filepath.Base(dir) + e.Name()
+			if len(name) != 40 || !isHex(name) || !info.Mode().IsRegular() {
+				c.Garbage = append(c.Garbage, filepath.Join(dir, e.Name()))
+				c.SizeGarbage += info.Size()
+				continue
+			}
+			c.Count++
+			c.Size += diskUsage(info)
+			if hasPackedObject(name) {
+				c.PrunePackable++
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(packDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	files := make(map[string]bool)
+	for _, e := range entries {
+		files[e.Name()] = true
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		name := e.Name()
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+
+		switch {
+		case strings.HasPrefix(name, "pack-") && ext == ".pack" && files[base+".idx"]:
+			c.Packs++
+			c.SizePack += info.Size()
+		case strings.HasPrefix(name, "pack-") && ext == ".idx" && files[base+".pack"]:
+			c.SizePack += info.Size()
+		case strings.HasPrefix(name, "pack-") && (ext == ".rev" || ext == ".keep" || ext == ".bitmap"):
+		default:
+			c.Garbage = append(c.Garbage, filepath.Join(packDir, name))
+			c.SizeGarbage += info.Size()
+		}
+	}
+
+	packs, err := readPackIndexes()
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range packs {
+		c.InPack += len(idx.Shas)
+	}
+	return c, nil
+}
+
+// diskUsage returns the space a file takes on disk, like du does.
+func diskUsage(info os.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512
+	}
+	return info.Size()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCountObjects(t *testing.T) {
+	newTestRepo(t)
+	c1 := testCommit(t, map[string]string{"a": "a\n", "b": "b\n"}, 100)
+	c2 := testCommit(t, map[string]string{"a": "a\n", "b": "b2\n"}, 200, c1)
+	if err := updateRef("refs/heads/master", c2); err != nil {
+		t.Fatal(err)
+	}
+	garbage := filepath.Join(".git", "objects", "ab", "not-an-object")
+	if err := os.MkdirAll(filepath.Dir(garbage), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(garbage, []byte("junk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two commits, two trees and three blobs.
+	c, err := countObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Count != 7 || c.InPack != 0 || c.Packs != 0 || c.Size == 0 {
+		t.Errorf("loose counts = %+v", c)
+	}
+	if len(c.Garbage) != 1 || c.Garbage[0] != garbage || c.SizeGarbage != 4 {
+		t.Errorf("garbage = %v (%d bytes), want [%s]", c.Garbage, c.SizeGarbage, garbage)
+	}
+
+	if err := repack(repackOptions{All: true, Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	forgetPacks()
+	c, err = countObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Count != 7 || c.InPack != 7 || c.Packs != 1 || c.PrunePackable != 7 || c.SizePack == 0 {
+		t.Errorf("counts after repack without -d = %+v", c)
+	}
+}
+
+func TestVerifyPack(t *testing.T) {
+	newTestRepo(t)
+	content := strings.Repeat("some line of text\n", 50)
+	c1 := testCommit(t, map[string]string{"file": content}, 100)
+	c2 := testCommit(t, map[string]string{"file": content + "more\n"}, 200, c1)
+	if err := updateRef("refs/heads/master", c2); err != nil {
+		t.Fatal(err)
+	}
+	if err := repack(repackOptions{All: true, Delete: true, Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	packs, err := readPackIndexes()
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("packs after repack = %d, %v", len(packs), err)
+	}
+	base := strings.TrimSuffix(packs[0].PackPath, ".pack")
+	if err := verifyPack(base, false, false); err != nil {
+		t.Fatalf("verifyPack of a fresh pack: %s", err)
+	}
+
+	if _, err := verifyPackCommand(nil); err == nil {
+		t.Error("verify-pack without a pack did not fail")
+	}
+	if _, err := verifyPackCommand([]string{"--bogus", base + ".idx"}); err == nil {
+		t.Error("verify-pack accepted an unknown option")
+	}
+
+	tests := []struct {
+		name   string
+		damage func(b []byte) []byte
+	}{
+		{"flipped byte", func(b []byte) []byte { b[len(b)/2] ^= 0xff; return b }},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-30] }},
+		{"empty", func(b []byte) []byte { return nil }},
+	}
+	b, err := os.ReadFile(base + ".pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(base+".pack", 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		damaged := tt.damage(append([]byte(nil), b...))
+		if err := os.WriteFile(base+".pack", damaged, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyPack(base, false, false); err == nil {
+			t.Errorf("%s: verifyPack passed a damaged pack", tt.name)
+		}
+	}
+}
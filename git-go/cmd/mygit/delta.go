@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Notes about deltas:
+// - A delta starts with the size of the base and the size of the result,
+//   both as little-endian base-128 varints.
+// - An instruction byte with the high bit set copies a range of the base.
+//   Its low 4 bits say which offset bytes follow and the next 3 bits which
+//   size bytes follow. A size of 0 means 0x10000.
+// - Any other non-zero instruction byte inserts that many literal bytes.
+
+// applyDelta rebuilds an object from its delta base and a delta.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	baseSize, err := parseVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if baseSize != len(base) {
+		return nil, errors.New("delta base size mismatch")
+	}
+	size, err := parseVarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, size)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		switch {
+		case op&0x80 != 0:
+			var offset, n int
+			for i := 0; i < 4; i++ {
+				if op&(1<<i) != 0 {
+					b, err := r.ReadByte()
+					if err != nil {
+						return nil, err
+					}
+					offset |= int(b) << (8 * i)
+				}
+			}
+			for i := 0; i < 3; i++ {
+				if op&(0x10<<i) != 0 {
+					b, err := r.ReadByte()
+					if err != nil {
+						return nil, err
+					}
+					n |= int(b) << (8 * i)
+				}
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if offset+n > len(base) {
+				return nil, errors.New("delta copies past the end of its base")
+			}
+			out = append(out, base[offset:offset+n]...)
+		case op != 0:
+			if int(op) > r.Len() {
+				return nil, errors.New("truncated delta")
+			}
+			literal := make([]byte, op)
+			r.Read(literal)
+			out = append(out, literal...)
+		default:
+			return nil, errors.New("invalid delta instruction")
+		}
+	}
+
+	if len(out) != size {
+		return nil, errors.New("delta result size mismatch")
+	}
+	return out, nil
+}
+
+// deltaBlock is the length of the base chunks createDelta indexes.
+const deltaBlock = 16
+
+// createDelta encodes target as a delta against base. It indexes the base
+// in fixed blocks and extends every block match as far as it goes.
+func createDelta(base, target []byte) []byte {
+	blocks := make(map[string]int)
+	for i := 0; i+deltaBlock <= len(base); i += deltaBlock {
+		key := string(base[i : i+deltaBlock])
+		if _, ok := blocks[key]; !ok {
+			blocks[key] = i
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(appendVarint(nil, len(base)))
+	out.Write(appendVarint(nil, len(target)))
+
+	literal := make([]byte, 0, 127)
+	flush := func() {
+		if len(literal) > 0 {
+			out.WriteByte(byte(len(literal)))
+			out.Write(literal)
+			literal = literal[:0]
+		}
+	}
+
+	for i := 0; i < len(target); {
+		offset, ok := -1, false
+		if i+deltaBlock <= len(target) {
+			offset, ok = blocks[string(target[i:i+deltaBlock])]
+		}
+		if !ok {
+			literal = append(literal, target[i])
+			i++
+			if len(literal) == 127 {
+				flush()
+			}
+			continue
+		}
+
+		// Grow the match backwards into pending literals, then forwards.
+		for len(literal) > 0 && offset > 0 && base[offset-1] == literal[len(literal)-1] {
+			literal = literal[:len(literal)-1]
+			offset--
+			i--
+		}
+		n := 0
+		for i+n < len(target) && offset+n < len(base) && target[i+n] == base[offset+n] {
+			n++
+		}
+		flush()
+		for done := 0; done < n; {
+			chunk := n - done
+			if chunk > 0x10000 {
+				chunk = 0x10000
+			}
+			writeDeltaCopy(&out, offset+done, chunk)
+			done += chunk
+		}
+		i += n
+	}
+	flush()
+	return out.Bytes()
+}
+
+func writeDeltaCopy(out *bytes.Buffer, offset, n int) {
+	op := byte(0x80)
+	args := make([]byte, 0, 7)
+	for i := 0; i < 4; i++ {
+		if b := byte(offset >> (8 * i)); b != 0 {
+			op |= 1 << i
+			args = append(args, b)
+		}
+	}
+	if n != 0x10000 {
+		for i := 0; i < 3; i++ {
+			if b := byte(n >> (8 * i)); b != 0 {
+				op |= 0x10 << i
+				args = append(args, b)
+			}
+		}
+	}
+	out.WriteByte(op)
+	out.Write(args)
+}
+
+// appendVarint appends n as a little-endian base-128 number, the format
+// parseVarint reads.
+func appendVarint(b []byte, n int) []byte {
+	for n >= 0x80 {
+		b = append(b, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(b, byte(n))
+}
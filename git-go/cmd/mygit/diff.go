@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffHunk describes a changed region: lines [A0, A1) of the old text were
+// replaced by lines [B0, B1) of the new text.
+type diffHunk struct {
+	A0, A1 int
+	B0, B1 int
+}
+
+// splitLines splits text into lines, keeping the line terminators so that the
+// lines can be joined back into the exact original text.
+func splitLines(b []byte) []string {
+	lines := make([]string, 0)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			lines = append(lines, string(b))
+			break
+		}
+		lines = append(lines, string(b[:i+1]))
+		b = b[i+1:]
+	}
+	return lines
+}
+
+func isBinary(b []byte) bool {
+	if len(b) > 8000 {
+		b = b[:8000]
+	}
+	return bytes.IndexByte(b, '\x00') >= 0
+}
+
+// diffLines computes the differences between two sequences of lines using
+// Myers' algorithm and returns them as a list of hunks.
+func diffLines(a, b []string) []diffHunk {
+	return diffLinesFunc(a, b, func(x, y string) bool { return x == y })
+}
+
+// diffLinesFunc is diffLines with a custom line equality.
+func diffLinesFunc(a, b []string, eq func(x, y string) bool) []diffHunk {
+	// Trim the common prefix and suffix, they never take part in a change.
+	pre := 0
+	for pre < len(a) && pre < len(b) && eq(a[pre], b[pre]) {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && eq(a[len(a)-1-suf], b[len(b)-1-suf]) {
+		suf++
+	}
+
+	matches := myers(a[pre:len(a)-suf], b[pre:len(b)-suf], eq)
+
+	hunks := make([]diffHunk, 0)
+	i, j := 0, 0
+	for _, m := range append(matches, [2]int{len(a) - pre - suf, len(b) - pre - suf}) {
+		if m[0] > i || m[1] > j {
+			hunks = append(hunks, diffHunk{
+				A0: pre + i, A1: pre + m[0],
+				B0: pre + j, B1: pre + m[1],
+			})
+		}
+		i, j = m[0]+1, m[1]+1
+	}
+	return hunks
+}
+
+// myers returns the pairs of indexes of matching lines in a shortest edit
+// script turning a into b.
+func myers(a, b []string, eq func(x, y string) bool) [][2]int {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return nil
+	}
+
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	trace := make([][]int, 0)
+
+	var d int
+loop:
+	for d = 0; d <= max; d++ {
+		// Only diagonals -d-1..d+1 are needed when backtracking step d.
+		snapshot := make([]int, 2*d+3)
+		copy(snapshot, v[offset-d-1:])
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && eq(a[x], b[y]) {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break loop
+			}
+		}
+	}
+
+	// Walk the trace backwards to recover the matching lines.
+	matches := make([][2]int, 0)
+	x, y := n, m
+	for ; d > 0; d-- {
+		v := trace[d]
+		at := d + 1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[at+k-1] < v[at+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[at+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			matches = append(matches, [2]int{x, y})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		matches = append(matches, [2]int{x, y})
+	}
+
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return matches
+}
+
+// lineSimilarity returns a score between 0 and 100 telling how much of two
+// texts is made of the same lines.
+func lineSimilarity(a, b []byte) int {
+	if len(a) == 0 && len(b) == 0 {
+		return 100
+	}
+
+	count := make(map[string]int)
+	for _, l := range strings.SplitAfter(string(a), "\n") {
+		count[l] += len(l)
+	}
+	common := 0
+	for _, l := range strings.SplitAfter(string(b), "\n") {
+		if count[l] > 0 {
+			count[l] -= len(l)
+			common += len(l)
+		}
+	}
+
+	size := len(a)
+	if len(b) > size {
+		size = len(b)
+	}
+	return common * 100 / size
+}
+
+// printDiffStat prints the "--stat" summary of the changes between two sets
+// of files, scaling the graph to fit 80 columns like git does.
+func printDiffStat(from, to map[string]treeEntry) error {
+	type fileStat struct {
+		path          string
+		added, delete int
+		binary        string
+	}
+
+	paths := make(map[string]string)
+	for p, e := range to {
+		if old, ok := from[p]; !ok || old != e {
+			paths[p] = p
+		}
+	}
+	for p := range from {
+		if _, ok := to[p]; !ok {
+			paths[p] = p
+		}
+	}
+
+	read := func(files map[string]treeEntry, p string) ([]byte, error) {
+		e, ok := files[p]
+		if !ok || e.Mode == modeGitlink {
+			return nil, nil
+		}
+		return readObjectOfType(e.Sha, "blob")
+	}
+
+	stats := make([]fileStat, 0, len(paths))
+	nameWidth, maxChange, added, deleted := 0, 0, 0, 0
+	for _, p := range sortedKeys(paths) {
+		a, err := read(from, p)
+		if err != nil {
+			return err
+		}
+		b, err := read(to, p)
+		if err != nil {
+			return err
+		}
+
+		st := fileStat{path: p}
+		if isBinary(a) || isBinary(b) {
+			st.binary = fmt.Sprintf("Bin %d -> %d bytes", len(a), len(b))
+		} else {
+			al, bl := splitLines(a), splitLines(b)
+			for _, h := range diffLines(al, bl) {
+				st.delete += h.A1 - h.A0
+				st.added += h.B1 - h.B0
+			}
+		}
+		stats = append(stats, st)
+
+		if len(p) > nameWidth {
+			nameWidth = len(p)
+		}
+		if st.added+st.delete > maxChange {
+			maxChange = st.added + st.delete
+		}
+		added += st.added
+		deleted += st.delete
+	}
+	if len(stats) == 0 {
+		return nil
+	}
+
+	numWidth := len(fmt.Sprint(maxChange))
+	graphWidth := 80 - nameWidth - numWidth - 6
+	if graphWidth < 10 {
+		graphWidth = 10
+	}
+	for _, st := range stats {
+		if st.binary != "" {
+			fmt.Printf(" %-*s | %s\n", nameWidth, st.path, st.binary)
+			continue
+		}
+		plus, minus := st.added, st.delete
+		if maxChange > graphWidth {
+			plus = scaleStat(plus, maxChange, graphWidth)
+			minus = scaleStat(minus, maxChange, graphWidth)
+		}
+		fmt.Printf(" %-*s | %*d %s%s\n", nameWidth, st.path, numWidth, st.added+st.delete,
+			strings.Repeat("+", plus), strings.Repeat("-", minus))
+	}
+
+	summary := fmt.Sprintf(" %d file%s changed", len(stats), plural(len(stats)))
+	if added > 0 {
+		summary += fmt.Sprintf(", %d insertion%s(+)", added, plural(added))
+	}
+	if deleted > 0 {
+		summary += fmt.Sprintf(", %d deletion%s(-)", deleted, plural(deleted))
+	}
+	fmt.Println(summary)
+	return nil
+}
+
+func scaleStat(n, total, width int) int {
+	if n == 0 {
+		return 0
+	}
+	if n = n * width / total; n == 0 {
+		return 1
+	}
+	return n
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
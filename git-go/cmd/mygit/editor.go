@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// launchEditor lets the user edit a file. The sequence editor, used for
+// rebase todo lists, is looked up first when asked for.
+func launchEditor(p string, sequence bool) error {
+	editor := "vi"
+	for _, source := range editorSources(sequence) {
+		v := os.Getenv(source)
+		if strings.Contains(source, ".") {
+			v, _ = configValue(source)
+		}
+		if v != "" {
+			editor = v
+			break
+		}
+	}
+	if editor == ":" {
+		return nil
+	}
+
+	// Like git, run the editor through the shell so that it may carry
+	// arguments of its own.
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, p)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("there was a problem with the editor '%s'", editor)
+	}
+	return nil
+}
+
+// editorSources lists the environment variables and config keys naming the
+// editor, in the order git tries them.
+func editorSources(sequence bool) []string {
+	sources := []string{"GIT_EDITOR", "core.editor", "VISUAL", "EDITOR"}
+	if sequence {
+		sources = append([]string{"GIT_SEQUENCE_EDITOR", "sequence.editor"}, sources...)
+	}
+	return sources
+}
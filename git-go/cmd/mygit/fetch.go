@@ -0,0 +1,588 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Notes about fetching:
+// - A refspec "[+]<src>:<dst>" says which remote refs to fetch and which
+//   local refs to store them in. A "*" on both sides matches the rest of
+//   a name. Without a destination the refs only go to FETCH_HEAD.
+// - Local refs only move forward unless the refspec starts with "+".
+//   Existing tags are never moved without it.
+// - Every ref fetched is listed in .git/FETCH_HEAD. The ones a pull
+//   would merge come first; the others are marked "not-for-merge".
+// - A named remote brings its URL and, when no refspecs are given, the
+//   refspecs in remote.<name>.fetch. Without a remote the one of the
+//   current branch is used, else "origin".
+// - Tags pointing to fetched history are fetched along when some refspec
+//   stores into a local ref, unless --no-tags or remote.<name>.tagOpt
+//   says otherwise.
+// - --prune (or remote.<name>.prune, fetch.prune) deletes the local refs
+//   the refspecs map remote refs to when those remote refs are gone.
+
+type refspec struct {
+	Src   string
+	Dst   string
+	Force bool
+}
+
+func parseRefspec(s string) (refspec, error) {
+	r := refspec{}
+	if strings.HasPrefix(s, "+") {
+		r.Force, s = true, s[1:]
+	}
+	r.Src = s
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		r.Src, r.Dst = s[:i], s[i+1:]
+	}
+	if strings.Count(r.Src, "*") > 1 || strings.Count(r.Dst, "*") > 1 ||
+		r.Dst != "" && strings.Contains(r.Src, "*") != strings.Contains(r.Dst, "*") {
+		return r, fmt.Errorf("invalid refspec '%s'", s)
+	}
+	return r, nil
+}
+
+// match maps a remote ref to its local name, reporting whether the refspec
+// covers the ref.
+func (r refspec) match(name string) (string, bool) {
+	i := strings.IndexByte(r.Src, '*')
+	if i < 0 {
+		return r.Dst, name == r.Src
+	}
+	prefix, suffix := r.Src[:i], r.Src[i+1:]
+	if len(name) < len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return strings.Replace(r.Dst, "*", name[len(prefix):len(name)-len(suffix)], 1), true
+}
+
+// matchLocal maps a local ref back to the remote ref the refspec would
+// store there, reporting whether the refspec covers the local ref.
+func (r refspec) matchLocal(name string) (string, bool) {
+	if r.Dst == "" {
+		return "", false
+	}
+	return refspec{Src: r.Dst, Dst: r.Src}.match(name)
+}
+
+// fetchedRef is a remote ref picked by a refspec, with where it goes.
+type fetchedRef struct {
+	ref
+	Local       string
+	Force       bool
+	NotForMerge bool
+}
+
+func fetchCommand(args []string) (bool, error) {
+	prune, pruneSet, tags := false, false, true
+	rest := make([]string, 0, len(args))
+	doubleDash := false
+	for _, arg := range args {
+		switch {
+		case doubleDash || arg == "-" || !strings.HasPrefix(arg, "-"):
+			rest = append(rest, arg)
+		case arg == "--":
+			doubleDash = true
+		case arg == "-p" || arg == "--prune":
+			prune, pruneSet = true, true
+		case arg == "--no-prune":
+			prune, pruneSet = false, true
+		case arg == "-n" || arg == "--no-tags":
+			tags = false
+		default:
+			return false, fmt.Errorf("unknown option '%s'", arg)
+		}
+	}
+
+	remote := ""
+	if len(rest) > 0 {
+		remote, rest = rest[0], rest[1:]
+	} else {
+		var err error
+		if remote, err = defaultRemote(); err != nil {
+			return false, err
+		}
+	}
+	url, named := configValue("remote." + remote + ".url")
+	if !named {
+		url = remote
+	}
+
+	specs := make([]refspec, 0)
+	configured := named && len(rest) == 0
+	if configured {
+		for _, e := range configEntries("remote." + remote + ".fetch") {
+			r, err := parseRefspec(e.Value)
+			if err != nil {
+				return false, err
+			}
+			specs = append(specs, r)
+		}
+		if tagOpt, _ := configValue("remote." + remote + ".tagOpt"); tagOpt == "--no-tags" {
+			tags = false
+		}
+	}
+	for _, arg := range rest {
+		r, err := parseRefspec(arg)
+		if err != nil {
+			return false, err
+		}
+		specs = append(specs, r)
+	}
+	if len(specs) == 0 {
+		specs = append(specs, refspec{Src: "HEAD"})
+	}
+	if !pruneSet {
+		var err error
+		if prune, err = configBool("fetch.prune", false); err != nil {
+			return false, err
+		}
+		if named {
+			if prune, err = configBool("remote."+remote+".prune", prune); err != nil {
+				return false, err
+			}
+		}
+	}
+	follow := false
+	for _, spec := range specs {
+		follow = follow || tags && spec.Dst != ""
+	}
+
+	var refs []ref
+	var t transport
+	var adv *refAdvertisement
+	var err error
+	if isBundle(url) {
+		if refs, err = fetchBundle(url); err != nil {
+			return false, err
+		}
+	} else {
+		prefixes := refPrefixes(specs)
+		if follow {
+			prefixes = append(prefixes, "refs/tags/")
+		}
+		if t, err = openTransport(url); err != nil {
+			return false, err
+		}
+		defer t.close()
+		if adv, err = findRefs(t, prefixes); err != nil {
+			return false, err
+		}
+		refs = adv.Refs
+	}
+
+	fetched, err := mapRefspecs(refs, specs)
+	if err != nil {
+		return false, err
+	}
+	if follow {
+		if fetched, err = followTags(refs, fetched); err != nil {
+			return false, err
+		}
+	}
+	if configured {
+		if err := markForMerge(fetched, remote, specs); err != nil {
+			return false, err
+		}
+	}
+	// The refs to merge come first, in FETCH_HEAD and in the report.
+	sort.SliceStable(fetched, func(i, j int) bool {
+		return !fetched[i].NotForMerge && fetched[j].NotForMerge
+	})
+
+	if err := checkNotCurrentBranch(fetched); err != nil {
+		return false, err
+	}
+
+	if adv != nil {
+		wants := make([]ref, 0, len(fetched))
+		for _, f := range fetched {
+			if !hasObject(f.Sha) {
+				wants = append(wants, f.ref)
+			}
+		}
+		if len(wants) > 0 {
+			if err := fetchMissing(t, adv, wantObjects(wants)); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	var pruned []string
+	if prune {
+		if pruned, err = pruneRefs(refs, specs); err != nil {
+			return false, err
+		}
+	}
+	if err := writeFetchHead(url, fetched); err != nil {
+		return false, err
+	}
+	reflogPrefix := strings.TrimSpace("fetch " + strings.Join(args, " "))
+	return updateFetchedRefs(url, fetched, pruned, reflogPrefix)
+}
+
+// checkNotCurrentBranch refuses a fetch that would move the branch checked
+// out, before anything is fetched or written.
+func checkNotCurrentBranch(fetched []fetchedRef) error {
+	branch, err := readSymbolicRef("HEAD")
+	if err != nil {
+		return err
+	}
+	for _, f := range fetched {
+		if f.Local != "" && f.Local == branch {
+			wd, _ := os.Getwd()
+			return fmt.Errorf("refusing to fetch into branch '%s' checked out at '%s'", branch, wd)
+		}
+	}
+	return nil
+}
+
+// defaultRemote returns the remote of the current branch, else "origin".
+func defaultRemote() (string, error) {
+	branch, err := currentBranch()
+	if err != nil {
+		return "", err
+	}
+	if name, ok := configValue("branch." + branch + ".remote"); ok && branch != "" {
+		return name, nil
+	}
+	return "origin", nil
+}
+
+// refPrefixes lists the prefixes of the remote refs the refspecs may
+// pick, so that a v2 server lists only those.
+func refPrefixes(specs []refspec) []string {
+	prefixes := make([]string, 0)
+	for _, spec := range specs {
+		if i := strings.IndexByte(spec.Src, '*'); i >= 0 {
+			prefixes = append(prefixes, spec.Src[:i])
+			continue
+		}
+		for _, name := range dwimRemoteNames(spec.Src) {
+			prefixes = append(prefixes, name)
+		}
+	}
+	return prefixes
+}
+
+// dwimRemoteNames lists the full names a short remote ref name may stand
+// for, in the order they are tried.
+func dwimRemoteNames(name string) []string {
+	return []string{
+		name,
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+		"refs/remotes/" + name + "/HEAD",
+	}
+}
+
+// fetchMissing downloads the wanted objects, offering the local history
+// so that only what is missing comes, and stores the pack.
+func fetchMissing(t transport, adv *refAdvertisement, wants []string) error {
+	local, err := listRefs("refs/")
+	if err != nil {
+		return err
+	}
+	tips := make([]string, 0, len(local)+1)
+	for _, name := range sortedKeys(local) {
+		tips = append(tips, local[name])
+	}
+	if head, err := resolveRef("HEAD"); err == nil {
+		tips = append(tips, head)
+	}
+	haves, err := newHaveWalker(tips)
+	if err != nil {
+		return err
+	}
+
+	b, err := fetchPack(t, adv, wants, haves)
+	if err != nil {
+		return err
+	}
+	_, err = storePack(b)
+	return err
+}
+
+// followTags adds the remote tags that point into history being fetched
+// or already here, and that are not here yet.
+func followTags(refs []ref, fetched []fetchedRef) ([]fetchedRef, error) {
+	peeled := make(map[string]string)
+	for _, r := range refs {
+		if strings.HasSuffix(r.Name, "^{}") {
+			peeled[strings.TrimSuffix(r.Name, "^{}")] = r.Sha
+		}
+	}
+	wanted := make(map[string]bool)
+	taken := make(map[string]bool)
+	for _, f := range fetched {
+		wanted[f.Sha] = true
+		taken[f.Name] = true
+	}
+
+	for _, r := range refs {
+		if !strings.HasPrefix(r.Name, "refs/tags/") || strings.HasSuffix(r.Name, "^{}") || taken[r.Name] {
+			continue
+		}
+		if _, err := resolveRef(r.Name); err != errRefNotFound {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		target, ok := peeled[r.Name]
+		if !ok {
+			target = r.Sha
+		}
+		if wanted[target] || hasObject(target) {
+			fetched = append(fetched, fetchedRef{ref: r, Local: r.Name, NotForMerge: true})
+		}
+	}
+	return fetched, nil
+}
+
+// markForMerge picks what a pull from a remote's configured refspecs
+// merges: the branch.<name>.merge refs of the current branch when it
+// follows the remote, else the ref of the first refspec if that names a
+// single ref.
+func markForMerge(fetched []fetchedRef, remote string, specs []refspec) error {
+	branch, err := currentBranch()
+	if err != nil {
+		return err
+	}
+	merge := make(map[string]bool)
+	if r, _ := configValue("branch." + branch + ".remote"); branch != "" && r == remote {
+		for _, e := range configEntries("branch." + branch + ".merge") {
+			merge[e.Value] = true
+		}
+	}
+	first := ""
+	if len(merge) == 0 && len(specs) > 0 && !strings.Contains(specs[0].Src, "*") {
+		first = specs[0].Src
+	}
+	for i := range fetched {
+		f := &fetched[i]
+		switch {
+		case len(merge) > 0:
+			f.NotForMerge = !merge[f.Name]
+		case first != "":
+			f.NotForMerge = i != 0
+		default:
+			f.NotForMerge = true
+		}
+	}
+	return nil
+}
+
+// pruneRefs deletes the local refs the refspecs would store remote refs
+// in, when those remote refs no longer exist. It returns their names.
+func pruneRefs(refs []ref, specs []refspec) ([]string, error) {
+	remote := make(map[string]bool, len(refs))
+	for _, r := range refs {
+		remote[r.Name] = true
+	}
+	pruned := make([]string, 0)
+	for _, spec := range specs {
+		if spec.Dst == "" {
+			continue
+		}
+		prefix := spec.Dst
+		if i := strings.IndexByte(prefix, '*'); i >= 0 {
+			prefix = prefix[:i]
+		}
+		local, err := listRefs(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range sortedKeys(local) {
+			src, ok := spec.matchLocal(name)
+			if !ok || remote[src] {
+				continue
+			}
+			// Symbolic refs such as origin/HEAD are left alone.
+			target, err := readSymbolicRef(name)
+			if err != nil {
+				return nil, err
+			}
+			if target != "" {
+				continue
+			}
+			if err := deleteRef(name); err != nil {
+				return nil, err
+			}
+			pruned = append(pruned, name)
+		}
+	}
+	return pruned, nil
+}
+
+// mapRefspecs picks the remote refs the refspecs ask for. Sources without
+// a "*" are looked up like revisions, "main" finding refs/heads/main.
+func mapRefspecs(refs []ref, specs []refspec) ([]fetchedRef, error) {
+	byName := make(map[string]ref, len(refs))
+	for _, r := range refs {
+		byName[r.Name] = r
+	}
+
+	fetched := make([]fetchedRef, 0)
+	for _, spec := range specs {
+		if strings.Contains(spec.Src, "*") {
+			for _, r := range refs {
+				if strings.HasSuffix(r.Name, "^{}") {
+					continue
+				}
+				if local, ok := spec.match(r.Name); ok {
+					fetched = append(fetched, fetchedRef{ref: r, Local: local, Force: spec.Force})
+				}
+			}
+			continue
+		}
+
+		var found *ref
+		for _, name := range dwimRemoteNames(spec.Src) {
+			if r, ok := byName[name]; ok {
+				found = &r
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("couldn't find remote ref %s", spec.Src)
+		}
+		local := spec.Dst
+		if local != "" && local != "HEAD" && !strings.HasPrefix(local, "refs/") {
+			if strings.HasPrefix(found.Name, "refs/tags/") {
+				local = "refs/tags/" + local
+			} else {
+				local = "refs/heads/" + local
+			}
+		}
+		fetched = append(fetched, fetchedRef{ref: *found, Local: local, Force: spec.Force})
+	}
+	return fetched, nil
+}
+
+// writeFetchHead lists the fetched refs in FETCH_HEAD, described the way
+// merge messages name them.
+func writeFetchHead(url string, fetched []fetchedRef) error {
+	var b strings.Builder
+	for _, f := range fetched {
+		mark := ""
+		if f.NotForMerge {
+			mark = "not-for-merge"
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\n", f.Sha, mark, fetchDescription(f.Name, url))
+	}
+	return os.WriteFile(".git/FETCH_HEAD", []byte(b.String()), 0644)
+}
+
+func fetchDescription(name, url string) string {
+	switch {
+	case name == "HEAD":
+		return url
+	case strings.HasPrefix(name, "refs/heads/"):
+		return fmt.Sprintf("branch '%s' of %s", strings.TrimPrefix(name, "refs/heads/"), url)
+	case strings.HasPrefix(name, "refs/tags/"):
+		return fmt.Sprintf("tag '%s' of %s", strings.TrimPrefix(name, "refs/tags/"), url)
+	case strings.HasPrefix(name, "refs/remotes/"):
+		return fmt.Sprintf("remote-tracking branch '%s' of %s", strings.TrimPrefix(name, "refs/remotes/"), url)
+	}
+	return fmt.Sprintf("'%s' of %s", name, url)
+}
+
+// shortRefName drops the part of a ref name that says what kind it is.
+func shortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+	return name
+}
+
+// updateFetchedRefs stores the fetched refs that have a local name and
+// reports on each like git does, after the pruned refs. It returns false
+// when some update was rejected.
+func updateFetchedRefs(url string, fetched []fetchedRef, pruned []string, reflogPrefix string) (bool, error) {
+	type line struct {
+		flag            byte
+		summary, remote string
+		local, suffix   string
+	}
+	lines := make([]line, 0, len(pruned)+len(fetched))
+	width := 10
+	for _, name := range pruned {
+		lines = append(lines, line{'-', "[deleted]", "(none)", shortRefName(name), ""})
+	}
+	ok := true
+	for _, f := range fetched {
+		remote := shortRefName(f.Name)
+		if f.Local == "" {
+			kind := "branch"
+			if strings.HasPrefix(f.Name, "refs/tags/") {
+				kind = "tag"
+			} else if f.Name != "HEAD" && !strings.HasPrefix(f.Name, "refs/heads/") {
+				kind = "ref"
+			}
+			lines = append(lines, line{'*', kind, remote, "FETCH_HEAD", ""})
+			if len(remote) > width {
+				width = len(remote)
+			}
+			continue
+		}
+		l := line{remote: remote, local: shortRefName(f.Local)}
+		old, err := resolveRef(f.Local)
+		switch {
+		case err == errRefNotFound:
+			l.flag, l.summary = '*', "[new ref]"
+			if strings.HasPrefix(f.Local, "refs/tags/") {
+				l.summary = "[new tag]"
+			} else if strings.HasPrefix(f.Local, "refs/heads/") || strings.HasPrefix(f.Local, "refs/remotes/") {
+				l.summary = "[new branch]"
+			}
+			err = updateRefLogged(f.Local, f.Sha, reflogPrefix+": storing head")
+		case err != nil:
+		case old == f.Sha:
+			// Up to date refs are not worth a line.
+			continue
+		case strings.HasPrefix(f.Local, "refs/tags/") && !f.Force:
+			l.flag, l.summary, l.suffix = '!', "[rejected]", "  (would clobber existing tag)"
+			ok = false
+		default:
+			var ff bool
+			if ff, err = isAncestor(old, f.Sha); err != nil {
+				break
+			}
+			switch {
+			case ff:
+				l.flag, l.summary = ' ', old[:7]+".."+f.Sha[:7]
+				err = updateRefLogged(f.Local, f.Sha, reflogPrefix+": fast-forward")
+			case f.Force:
+				l.flag, l.summary, l.suffix = '+', old[:7]+"..."+f.Sha[:7], "  (forced update)"
+				err = updateRefLogged(f.Local, f.Sha, reflogPrefix+": forced-update")
+			default:
+				l.flag, l.summary, l.suffix = '!', "[rejected]", "  (non-fast-forward)"
+				ok = false
+			}
+		}
+		if err != nil {
+			return false, err
+		}
+		lines = append(lines, l)
+		if len(remote) > width {
+			width = len(remote)
+		}
+	}
+
+	if len(lines) > 0 {
+		fmt.Fprintf(os.Stderr, "From %s\n", url)
+	}
+	for _, l := range lines {
+		fmt.Fprintf(os.Stderr, " %c %-17s %-*s -> %s%s\n", l.flag, l.summary, width, l.remote, l.local, l.suffix)
+	}
+	return ok, nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Notes about negotiation:
+// - Before asking for a pack the client offers "have" lines for commits
+//   it already has, newest first, walking back from its ref tips. The
+//   server acknowledges those it has too, and leaves their history out of
+//   the pack. Once a commit is common its ancestors are not offered.
+// - Over HTTP the server keeps no state, so every round repeats the wants
+//   and the haves found common so far before the new batch. Over SSH the
+//   server remembers them and v0 rounds only bring new haves; v2 rounds
+//   are whole requests either way. Batches start at 16 haves and double.
+// - Once something is common the client gives up after 256 haves in a
+//   row without a new ACK. It stops too when the server says it is ready
+//   or when there is nothing left to offer, and then sends "done".
+// - In v0 this takes multi_ack_detailed: the server answers each round
+//   with "ACK <id> common" or "ACK <id> ready" lines and a NAK. In v2 the
+//   acknowledgments section has "ACK <id>" lines, "NAK" and "ready"; when
+//   ready, the pack comes in the same answer.
+
+const (
+	initialHaves = 16
+	maxHaves     = 1024
+	maxInVain    = 256
+)
+
+// haveWalker lists local commits to offer as haves, newest first.
+type haveWalker struct {
+	queue  []*commit
+	seen   map[string]bool
+	common map[string]bool
+}
+
+// newHaveWalker starts from the commits refs point to. Refs to other
+// objects have no history to offer.
+func newHaveWalker(tips []string) (*haveWalker, error) {
+	w := &haveWalker{seen: make(map[string]bool), common: make(map[string]bool)}
+	for _, tip := range tips {
+		sha, err := peel(tip, "commit")
+		if err != nil {
+			continue
+		}
+		if err := w.push(sha); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *haveWalker) push(sha string) error {
+	if w.seen[sha] {
+		return nil
+	}
+	w.seen[sha] = true
+	c, err := readCommit(sha)
+	if err != nil {
+		return err
+	}
+	i := sort.Search(len(w.queue), func(i int) bool { return w.queue[i].Time() < c.Time() })
+	w.queue = append(w.queue, nil)
+	copy(w.queue[i+1:], w.queue[i:])
+	w.queue[i] = c
+	return nil
+}
+
+// next returns up to n more haves.
+func (w *haveWalker) next(n int) ([]string, error) {
+	haves := make([]string, 0, n)
+	for len(haves) < n && len(w.queue) > 0 {
+		c := w.queue[0]
+		w.queue = w.queue[1:]
+		for _, p := range c.Parents {
+			if w.common[c.Sha] {
+				w.common[p] = true
+			}
+			if err := w.push(p); err != nil {
+				return nil, err
+			}
+		}
+		if !w.common[c.Sha] {
+			haves = append(haves, c.Sha)
+		}
+	}
+	return haves, nil
+}
+
+// ack marks a commit the server has, reporting whether it is news.
+func (w *haveWalker) ack(sha string) bool {
+	if w.common[sha] {
+		return false
+	}
+	w.common[sha] = true
+	return true
+}
+
+// requestCapabilities picks the capabilities to ask for among those the
+// server offers.
+func requestCapabilities(caps capabilities) []string {
+	wanted := make([]string, 0)
+	for _, c := range []string{"multi_ack_detailed", "side-band-64k", "thin-pack", "ofs-delta"} {
+		if caps.has(c) {
+			wanted = append(wanted, c)
+		}
+	}
+	if caps.has("no-progress") && !isTerminal(os.Stderr) {
+		wanted = append(wanted, "no-progress")
+	}
+	if caps.has("agent") {
+		wanted = append(wanted, "agent="+userAgent)
+	}
+	return wanted
+}
+
+// wantObjects lists the objects to ask for, once each and without the
+// peeled tags.
+func wantObjects(refs []ref) []string {
+	wants := make([]string, 0, len(refs))
+	seen := make(map[string]bool)
+	for _, r := range refs {
+		if seen[r.Sha] || strings.HasSuffix(r.Name, "^{}") {
+			continue
+		}
+		seen[r.Sha] = true
+		wants = append(wants, r.Sha)
+	}
+	return wants
+}
+
+// fetchPack asks for the wanted objects and returns the pack that comes
+// back. With haves the pack leaves out what the server finds in common,
+// and may be thin.
+func fetchPack(t transport, adv *refAdvertisement, wants []string, haves *haveWalker) ([]byte, error) {
+	common := make([]string, 0)
+	repeat := adv.Version == 2 || t.stateless()
+	sentWants := false
+	if haves != nil && (adv.Version == 2 || adv.Caps.has("multi_ack_detailed")) {
+		batch, inVain := initialHaves, 0
+		for len(common) == 0 || inVain < maxInVain {
+			next, err := haves.next(batch)
+			if err != nil {
+				return nil, err
+			}
+			if len(next) == 0 {
+				break
+			}
+			round := fetchRound{wants: wants, haves: append(append([]string(nil), common...), next...)}
+			if !repeat {
+				round = fetchRound{haves: next}
+				if !sentWants {
+					round.wants = wants
+				}
+			}
+			if err := round.send(t, adv); err != nil {
+				return nil, err
+			}
+			sentWants = true
+			if round.pack != nil {
+				return round.pack, nil
+			}
+			inVain += len(next)
+			for _, sha := range round.acks {
+				if haves.ack(sha) {
+					common = append(common, sha)
+					inVain = 0
+				}
+			}
+			if round.ready {
+				break
+			}
+			if batch < maxHaves {
+				batch *= 2
+			}
+		}
+	}
+
+	round := fetchRound{wants: wants, haves: common, done: true}
+	if !repeat {
+		round = fetchRound{done: true}
+		if !sentWants {
+			round.wants = wants
+		}
+	}
+	if err := round.send(t, adv); err != nil {
+		return nil, err
+	}
+	return round.pack, nil
+}
+
+// fetchRound is one request of a negotiation and what came back.
+type fetchRound struct {
+	wants, haves []string
+	done         bool
+
+	acks  []string
+	ready bool
+	pack  []byte
+}
+
+func (f *fetchRound) send(t transport, adv *refAdvertisement) error {
+	if adv.Version == 2 {
+		return f.send2(t, adv)
+	}
+
+	var body bytes.Buffer
+	w := newPktWriter(&body)
+	for i, sha := range f.wants {
+		line := "want " + sha
+		// The capabilities go on the first want.
+		if i == 0 {
+			if c := requestCapabilities(adv.Caps); len(c) > 0 {
+				line += " " + strings.Join(c, " ")
+			}
+		}
+		if err := w.writeLine("%s", line); err != nil {
+			return err
+		}
+	}
+	// A server that kept the wants of an earlier round takes only haves.
+	if len(f.wants) > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	for _, sha := range f.haves {
+		if err := w.writeLine("have %s", sha); err != nil {
+			return err
+		}
+	}
+	if f.done {
+		if err := w.writeLine("done"); err != nil {
+			return err
+		}
+	} else if err := w.flush(); err != nil {
+		return err
+	}
+
+	answer, err := t.request("git-upload-pack", &body, adv.Version)
+	if err != nil {
+		return err
+	}
+	defer answer.Close()
+
+	// A round ends with a NAK. After "done" the pack follows the NAK, or
+	// an ACK without status for the last common commit.
+	r := newPktReader(answer)
+	for {
+		typ, line, err := r.readLine()
+		if err != nil {
+			return err
+		}
+		if typ != pktData {
+			return fmt.Errorf("protocol error: expected ACK/NAK, got %s", typ)
+		}
+		if line == "NAK" {
+			break
+		}
+		args := strings.Fields(line)
+		if len(args) < 2 || len(args) > 3 || args[0] != "ACK" {
+			return fmt.Errorf("protocol error: expected ACK/NAK, got '%s'", line)
+		}
+		if len(args) == 2 {
+			break
+		}
+		f.acks = append(f.acks, args[1])
+		f.ready = f.ready || args[2] == "ready"
+	}
+	if !f.done {
+		return nil
+	}
+	if adv.Caps.has("side-band-64k") {
+		f.pack, err = io.ReadAll(newSidebandReader(r, os.Stderr))
+	} else {
+		f.pack, err = io.ReadAll(r.rest())
+	}
+	return err
+}
+
+// send2 makes the request with the v2 fetch command. The pack comes when
+// the server is done or ready.
+func (f *fetchRound) send2(t transport, adv *refAdvertisement) error {
+	args := []string{"thin-pack", "ofs-delta"}
+	if !isTerminal(os.Stderr) {
+		args = append(args, "no-progress")
+	}
+	for _, sha := range f.wants {
+		args = append(args, "want "+sha)
+	}
+	for _, sha := range f.haves {
+		args = append(args, "have "+sha)
+	}
+	if f.done {
+		args = append(args, "done")
+	}
+
+	body, err := command2(t, adv, "fetch", args)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	r := newPktReader(body)
+	for {
+		typ, section, err := r.readLine()
+		if err != nil {
+			return err
+		}
+		if typ != pktData {
+			return fmt.Errorf("protocol error: expected a section, got %s", typ)
+		}
+		switch section {
+		case "packfile":
+			f.pack, err = io.ReadAll(newSidebandReader(r, os.Stderr))
+			return err
+		case "acknowledgments", "shallow-info", "wanted-refs":
+		default:
+			return fmt.Errorf("protocol error: unknown section '%s'", section)
+		}
+		for {
+			typ, line, err := r.readLine()
+			if err != nil {
+				return err
+			}
+			if typ == pktDelim {
+				break
+			}
+			// Without "ready" the acknowledgments end the answer.
+			if typ == pktFlush && section == "acknowledgments" && !f.ready {
+				return nil
+			}
+			if typ != pktData {
+				return fmt.Errorf("protocol error: unexpected %s in %s", typ, section)
+			}
+			if section != "acknowledgments" {
+				continue
+			}
+			switch {
+			case line == "NAK":
+			case line == "ready":
+				f.ready = true
+			case strings.HasPrefix(line, "ACK "):
+				f.acks = append(f.acks, strings.TrimPrefix(line, "ACK "))
+			default:
+				return fmt.Errorf("protocol error: unexpected acknowledgment '%s'", line)
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRefspec(t *testing.T) {
+	for _, test := range []struct {
+		spec    string
+		want    refspec
+		wantErr bool
+	}{
+		{spec: "main", want: refspec{Src: "main"}},
+		{spec: "main:topic", want: refspec{Src: "main", Dst: "topic"}},
+		{spec: "+refs/heads/*:refs/remotes/origin/*", want: refspec{Src: "refs/heads/*", Dst: "refs/remotes/origin/*", Force: true}},
+		{spec: "refs/heads/*", want: refspec{Src: "refs/heads/*"}},
+		{spec: "refs/heads/feat-*-x:refs/remotes/o/*", want: refspec{Src: "refs/heads/feat-*-x", Dst: "refs/remotes/o/*"}},
+		{spec: ":refs/heads/gone", want: refspec{Dst: "refs/heads/gone"}},
+		{spec: "refs/heads/*:refs/remotes/origin/main", wantErr: true},
+		{spec: "refs/heads/main:refs/remotes/origin/*", wantErr: true},
+		{spec: "refs/*/*:refs/x/*", wantErr: true},
+	} {
+		t.Run(test.spec, func(t *testing.T) {
+			got, err := parseRefspec(test.spec)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("parseRefspec(%q) = %+v, want an error", test.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRefspec(%q): %s", test.spec, err)
+			}
+			if got != test.want {
+				t.Errorf("parseRefspec(%q) = %+v, want %+v", test.spec, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRefspecMatch(t *testing.T) {
+	tracking := refspec{Src: "refs/heads/*", Dst: "refs/remotes/origin/*"}
+	for _, test := range []struct {
+		spec   refspec
+		name   string
+		local  string
+		wanted bool
+	}{
+		{tracking, "refs/heads/main", "refs/remotes/origin/main", true},
+		{tracking, "refs/heads/a/b", "refs/remotes/origin/a/b", true},
+		{tracking, "refs/tags/v1", "", false},
+		{refspec{Src: "refs/heads/feat-*-x", Dst: "refs/f/*"}, "refs/heads/feat-1-x", "refs/f/1", true},
+		{refspec{Src: "refs/heads/feat-*-x", Dst: "refs/f/*"}, "refs/heads/feat-x", "", false},
+		{refspec{Src: "refs/heads/main", Dst: "refs/heads/up"}, "refs/heads/main", "refs/heads/up", true},
+		{refspec{Src: "refs/heads/main"}, "refs/heads/main", "", true},
+		{refspec{Src: "refs/heads/main"}, "refs/heads/mainline", "", false},
+	} {
+		local, ok := test.spec.match(test.name)
+		if ok != test.wanted || local != test.local {
+			t.Errorf("%+v.match(%q) = %q, %v, want %q, %v", test.spec, test.name, local, ok, test.local, test.wanted)
+		}
+	}
+
+	for _, test := range []struct {
+		name, remote string
+		wanted       bool
+	}{
+		{"refs/remotes/origin/main", "refs/heads/main", true},
+		{"refs/remotes/upstream/main", "", false},
+	} {
+		remote, ok := tracking.matchLocal(test.name)
+		if ok != test.wanted || remote != test.remote {
+			t.Errorf("matchLocal(%q) = %q, %v, want %q, %v", test.name, remote, ok, test.remote, test.wanted)
+		}
+	}
+	if _, ok := (refspec{Src: "main"}).matchLocal("main"); ok {
+		t.Errorf("a refspec without destination matched a local ref")
+	}
+}
+
+func TestMapRefspecs(t *testing.T) {
+	a, b, c := strings.Repeat("a", 40), strings.Repeat("b", 40), strings.Repeat("c", 40)
+	refs := []ref{
+		{Sha: a, Name: "HEAD"},
+		{Sha: a, Name: "refs/heads/main"},
+		{Sha: b, Name: "refs/heads/topic"},
+		{Sha: c, Name: "refs/tags/v1"},
+		{Sha: a, Name: "refs/tags/v1^{}"},
+	}
+	for _, test := range []struct {
+		name    string
+		specs   []string
+		want    []fetchedRef
+		wantErr string
+	}{
+		{
+			name:  "glob",
+			specs: []string{"+refs/heads/*:refs/remotes/origin/*"},
+			want: []fetchedRef{
+				{ref: refs[1], Local: "refs/remotes/origin/main", Force: true},
+				{ref: refs[2], Local: "refs/remotes/origin/topic", Force: true},
+			},
+		},
+		{
+			name:  "peeled tags are left out",
+			specs: []string{"refs/tags/*:refs/tags/*"},
+			want:  []fetchedRef{{ref: refs[3], Local: "refs/tags/v1"}},
+		},
+		{
+			name:  "short names",
+			specs: []string{"topic", "v1:old"},
+			want: []fetchedRef{
+				{ref: refs[2]},
+				{ref: refs[3], Local: "refs/tags/old"},
+			},
+		},
+		{
+			name:  "short destination of a branch",
+			specs: []string{"main:copy"},
+			want:  []fetchedRef{{ref: refs[1], Local: "refs/heads/copy"}},
+		},
+		{
+			name:  "HEAD",
+			specs: []string{"HEAD"},
+			want:  []fetchedRef{{ref: refs[0]}},
+		},
+		{
+			name:    "missing ref",
+			specs:   []string{"nope"},
+			wantErr: "couldn't find remote ref nope",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			specs := make([]refspec, 0, len(test.specs))
+			for _, s := range test.specs {
+				spec, err := parseRefspec(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				specs = append(specs, spec)
+			}
+			got, err := mapRefspecs(refs, specs)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("mapRefspecs error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("mapRefspecs = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Notes about filter drivers:
+// - The filter attribute names a driver. filter.<name>.clean turns working
+//   tree content into what is stored and filter.<name>.smudge turns it back.
+//   Both are shell commands reading content on stdin and writing the result
+//   on stdout, with "%f" replaced by the quoted path.
+// - filter.<name>.process is one command started once and fed every file
+//   over pkt-lines, as in git's long-running process protocol. When it is
+//   set, clean and smudge are not used.
+// - A failing driver leaves content unchanged unless filter.<name>.required
+//   is set, which makes it an error, as does a missing command.
+// - A filter=lfs path with no lfs driver configured uses the built-in LFS
+//   handling in lfs.go.
+
+type filterDriver struct {
+	Name     string
+	Clean    string
+	Smudge   string
+	Process  string
+	Required bool
+}
+
+// filterDriverFor returns the driver configured for a name, nil when
+// there is none.
+func filterDriverFor(name string) (*filterDriver, error) {
+	d := &filterDriver{Name: name}
+	var ok [3]bool
+	d.Clean, ok[0] = configValue("filter." + name + ".clean")
+	d.Smudge, ok[1] = configValue("filter." + name + ".smudge")
+	d.Process, ok[2] = configValue("filter." + name + ".process")
+	required, err := configBool("filter."+name+".required", false)
+	if err != nil {
+		return nil, err
+	}
+	d.Required = required
+	if !ok[0] && !ok[1] && !ok[2] && !required {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// applyFilter runs the clean or smudge command of the filter driver of a
+// path on content. write tells whether cleaned content is about to be
+// stored.
+func applyFilter(p string, b []byte, command string, write bool) ([]byte, error) {
+	c, err := attributes()
+	if err != nil {
+		return nil, err
+	}
+	name, err := c.attr(p, "filter")
+	if err != nil || name == "" || name == attrSet || name == attrUnset {
+		return b, err
+	}
+	d, err := filterDriverFor(name)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		if name == "lfs" {
+			if command == "clean" {
+				return lfsClean(b, write)
+			}
+			return lfsSmudge(b)
+		}
+		return b, nil
+	}
+
+	var out []byte
+	done := false
+	switch {
+	case d.Process != "":
+		out, done, err = runFilterProcess(d.Process, command, p, b)
+	case command == "clean" && d.Clean != "":
+		out, err = runFilterCommand(d.Clean, p, b)
+		done = err == nil
+	case command == "smudge" && d.Smudge != "":
+		out, err = runFilterCommand(d.Smudge, p, b)
+		done = err == nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+	}
+	if done {
+		return out, nil
+	}
+	if d.Required {
+		if command == "clean" {
+			return nil, fmt.Errorf("%s: clean filter '%s' failed", p, d.Name)
+		}
+		return nil, fmt.Errorf("%s: smudge filter %s failed", p, d.Name)
+	}
+	return b, nil
+}
+
+// runFilterCommand runs a clean or smudge command through the shell.
+func runFilterCommand(command, p string, b []byte) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", strings.ReplaceAll(command, "%f", shellQuote(p)))
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		fmt.Fprintf(os.Stderr, "error: external filter '%s' failed %d\n", command, exit.ExitCode())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("external filter '%s' failed", command)
+	}
+	return out, nil
+}
+
+// shellQuote quotes a string for sh the way git does.
+func shellQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'', '!':
+			b.WriteString(`'\` + s[i:i+1] + `'`)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+type filterProcess struct {
+	command string
+	cmd     *exec.Cmd
+	in      io.WriteCloser
+	w       *pktWriter
+	r       *pktReader
+	caps    map[string]bool
+}
+
+// filterProcesses holds the running process filters by command, nil for
+// those that could not be started.
+var filterProcesses = make(map[string]*filterProcess)
+
+// runFilterProcess hands content to a process filter, starting it first if
+// needed. It reports false when the filter does not do the command.
+func runFilterProcess(command, filter, p string, b []byte) ([]byte, bool, error) {
+	f, started := filterProcesses[command]
+	if !started {
+		var err error
+		f, err = startFilterProcess(command)
+		filterProcesses[command] = f
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if f == nil || !f.caps[filter] {
+		return nil, false, nil
+	}
+
+	out, status, err := f.filter(filter, p, b)
+	if err != nil {
+		// The process is of no use once the conversation broke off.
+		f.close()
+		filterProcesses[command] = nil
+		return nil, false, fmt.Errorf("external filter '%s' failed: %s", command, err)
+	}
+	// Refusing a file, or with "abort" all further ones, is not worth a
+	// message.
+	switch status {
+	case "success":
+		return out, true, nil
+	case "abort":
+		f.caps[filter] = false
+	}
+	return nil, false, nil
+}
+
+func startFilterProcess(command string) (*filterProcess, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cannot fork to run subprocess '%s'", command)
+	}
+	f := &filterProcess{command: command, cmd: cmd, in: in, w: newPktWriter(in), r: newPktReader(out), caps: make(map[string]bool)}
+
+	if err := f.handshake(); err != nil {
+		f.close()
+		return nil, fmt.Errorf("initialization for subprocess '%s' failed", command)
+	}
+	return f, nil
+}
+
+// close ends the conversation and waits for the process to exit.
+func (f *filterProcess) close() error {
+	f.in.Close()
+	// Whatever the process still says goes unheard.
+	io.Copy(io.Discard, f.r.rest())
+	if err := f.cmd.Wait(); err != nil {
+		return fmt.Errorf("external filter '%s' failed: %s", f.command, err)
+	}
+	return nil
+}
+
+// stopFilterProcesses closes the process filters that were started and
+// returns the first failure.
+func stopFilterProcesses() error {
+	var first error
+	for command, f := range filterProcesses {
+		if f == nil {
+			continue
+		}
+		if err := f.close(); err != nil && first == nil {
+			first = err
+		}
+		filterProcesses[command] = nil
+	}
+	return first
+}
+
+// handshake agrees on the protocol version and on the capabilities.
+func (f *filterProcess) handshake() error {
+	if err := f.w.writeLines("git-filter-client", "version=2"); err != nil {
+		return err
+	}
+	lines, err := f.r.readLines()
+	if err != nil {
+		return err
+	}
+	if len(lines) < 2 || lines[0] != "git-filter-server" || lines[1] != "version=2" {
+		return errors.New("unexpected handshake")
+	}
+
+	if err := f.w.writeLines("capability=clean", "capability=smudge"); err != nil {
+		return err
+	}
+	if lines, err = f.r.readLines(); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "capability=") {
+			f.caps[strings.TrimPrefix(line, "capability=")] = true
+		}
+	}
+	return nil
+}
+
+// filter sends one file and returns the converted content with the
+// status the process gave.
+func (f *filterProcess) filter(command, p string, b []byte) ([]byte, string, error) {
+	if err := f.w.writeLines("command="+command, "pathname="+p); err != nil {
+		return nil, "", err
+	}
+	if err := f.w.writeData(b); err != nil {
+		return nil, "", err
+	}
+	if err := f.w.flush(); err != nil {
+		return nil, "", err
+	}
+
+	status, err := readFilterStatus(f.r, "")
+	if err != nil || status != "success" {
+		return nil, status, err
+	}
+	var out []byte
+	for {
+		t, data, err := f.r.read()
+		if err != nil {
+			return nil, "", err
+		}
+		if t == pktFlush {
+			break
+		}
+		if t != pktData {
+			return nil, "", fmt.Errorf("protocol error: unexpected %s", t)
+		}
+		out = append(out, data...)
+	}
+	// The process may still change its mind after sending the content; an
+	// empty list keeps the status.
+	status, err = readFilterStatus(f.r, status)
+	return out, status, err
+}
+
+func readFilterStatus(r *pktReader, status string) (string, error) {
+	lines, err := r.readLines()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "status=") {
+			status = strings.TrimPrefix(line, "status=")
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLFSPointer(t *testing.T) {
+	oid := strings.Repeat("ab", 32)
+	tests := []struct {
+		in   string
+		want lfsPointer
+		ok   bool
+	}{
+		{"version " + lfsPointerVersion + "\noid sha256:" + oid + "\nsize 12\n", lfsPointer{Oid: oid, Size: 12}, true},
+		{"version " + lfsPointerVersion + "\noid sha256:" + oid + "\nsize 0\next-0-foo sha256:x\n", lfsPointer{Oid: oid}, true},
+		{"version " + lfsPointerVersion + "\noid sha256:" + oid + "\n", lfsPointer{}, false},
+		{"version " + lfsPointerVersion + "\nsize 12\n", lfsPointer{}, false},
+		{"version " + lfsPointerVersion + "\noid sha1:" + oid + "\nsize 12\n", lfsPointer{}, false},
+		{"version " + lfsPointerVersion + "\noid sha256:" + oid[:62] + "\nsize 12\n", lfsPointer{}, false},
+		{"version " + lfsPointerVersion + "\noid sha256:" + oid + "\nsize -1\n", lfsPointer{}, false},
+		{"version " + lfsPointerVersion + "\noid sha256:" + oid + "\nsize 12\nnospace\n", lfsPointer{}, false},
+		{"version other\noid sha256:" + oid + "\nsize 12\n", lfsPointer{}, false},
+		{"just some content\n", lfsPointer{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseLFSPointer([]byte(tt.in))
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("parseLFSPointer(%q) = %+v, %v", tt.in, got, ok)
+		}
+	}
+
+	p := lfsPointer{Oid: oid, Size: 42}
+	if got, ok := parseLFSPointer(p.bytes()); !ok || got != p {
+		t.Errorf("pointer %+v reads back as %+v, %v", p, got, ok)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	newTestRepo(t)
+	writeTestFiles(t, map[string]string{
+		"global-attributes": "",
+		".gitattributes": "*.up filter=upper\n*.fail filter=broken\n*.must filter=required\n" +
+			"*.big filter=lfs\n*.none filter=unknown\n",
+	})
+	setTestConfig(t, "[filter \"upper\"]\n"+
+		"\tclean = tr a-z A-Z\n"+
+		"\tsmudge = tr A-Z a-z\n"+
+		"[filter \"broken\"]\n"+
+		"\tclean = exit 1\n"+
+		"[filter \"required\"]\n"+
+		"\tclean = exit 1\n"+
+		"\trequired\n")
+
+	tests := []struct {
+		path, worktree, stored string
+		err                    bool
+	}{
+		{"a.up", "hello\n", "HELLO\n", false},
+		{"a.fail", "kept\n", "kept\n", false},
+		{"a.must", "kept\n", "", true},
+		{"a.none", "kept\n", "kept\n", false},
+		{"plain", "kept\n", "kept\n", false},
+	}
+	for _, tt := range tests {
+		got, err := convertToGit(tt.path, []byte(tt.worktree), false)
+		if (err != nil) != tt.err || string(got) != tt.stored {
+			t.Errorf("clean %s = %q, %v; want %q", tt.path, got, err, tt.stored)
+		}
+	}
+	if got, err := convertToWorktree("a.up", []byte("HELLO\n")); err != nil || string(got) != "hello\n" {
+		t.Errorf("smudge a.up = %q, %v", got, err)
+	}
+
+	// LFS keeps the content aside and stores a pointer.
+	content := bytes.Repeat([]byte("large file\n"), 100)
+	pointer, err := convertToGit("a.big", content, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := parseLFSPointer(pointer)
+	if !ok || p.Size != int64(len(content)) {
+		t.Fatalf("clean a.big = %q", pointer)
+	}
+	if b, err := os.ReadFile(p.path()); err != nil || !bytes.Equal(b, content) {
+		t.Errorf("LFS object %s = %d bytes, %v", p.path(), len(b), err)
+	}
+	if again, err := convertToGit("a.big", pointer, true); err != nil || !bytes.Equal(again, pointer) {
+		t.Errorf("cleaning a pointer changed it to %q, %v", again, err)
+	}
+	if got, err := convertToWorktree("a.big", pointer); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("smudge a.big = %d bytes, %v", len(got), err)
+	}
+
+	missing := lfsPointer{Oid: strings.Repeat("0", 64), Size: 3}
+	if got, err := convertToWorktree("b.big", missing.bytes()); err != nil || !bytes.Equal(got, missing.bytes()) {
+		t.Errorf("smudge of a missing LFS object = %q, %v", got, err)
+	}
+	if err := os.WriteFile(p.path(), content[:10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := convertToWorktree("a.big", pointer); err == nil {
+		t.Error("smudge accepted a corrupt LFS object")
+	}
+}
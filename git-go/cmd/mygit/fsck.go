@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type fsckOptions struct {
+	Unreachable bool
+	NoDangling  bool
+}
+
+// fsckLink is a reference from one object to another, with the type the
+// referring object expects.
+type fsckLink struct {
+	Sha  string
+	Type string
+}
+
+// fsckChecker gathers the objects of the repository and what they point to
+// while reporting the problems it finds.
+type fsckChecker struct {
+	Types  map[string]string
+	Links  map[string][]fsckLink
+	Errors int
+}
+
+func fsckCommand(args []string) (bool, error) {
+	opts := fsckOptions{}
+	for _, arg := range args {
+		switch arg {
+		case "--unreachable":
+			opts.Unreachable = true
+		case "--no-dangling":
+			opts.NoDangling = true
+		case "--full", "--strict":
+		default:
+			return false, fmt.Errorf("unknown option %s", arg)
+		}
+	}
+	return fsck(opts)
+}
+
+// fsck checks every loose and packed object, then the links between them,
+// and reports objects nothing reachable from the refs points to. It
+// reports false when anything is broken.
+func fsck(opts fsckOptions) (bool, error) {
+	f := &fsckChecker{
+		Types: make(map[string]string),
+		Links: make(map[string][]fsckLink),
+	}
+
+	shas, err := looseObjects()
+	if err != nil {
+		return false, err
+	}
+	for _, sha := range shas {
+		f.checkLoose(sha)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(packDir, "pack-*.idx"))
+	if err != nil {
+		return false, err
+	}
+	for _, p := range paths {
+		f.checkPack(p)
+	}
+
+	// Refs pointing nowhere, then everything reachable from the roots.
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return false, err
+	}
+	if head, err := resolveRef("HEAD"); err == nil {
+		refs["HEAD"] = head
+	}
+	for _, name := range sortedKeys(refs) {
+		if _, ok := f.Types[refs[name]]; !ok {
+			f.errorf("%s: invalid sha1 pointer %s", name, refs[name])
+		}
+	}
+	if _, err := resolveRef("HEAD"); err == errRefNotFound {
+		if branch, _ := readSymbolicRef("HEAD"); branch != "" {
+			fmt.Fprintf(os.Stderr, "notice: HEAD points to an unborn branch (%s)\n", strings.TrimPrefix(branch, "refs/heads/"))
+		}
+	}
+
+	roots, err := objectRoots()
+	if err != nil {
+		return false, err
+	}
+	reachable := make(map[string]bool)
+	missing := make(map[string]bool)
+	queue := append([]string(nil), roots...)
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if reachable[sha] {
+			continue
+		}
+		reachable[sha] = true
+		for _, l := range f.Links[sha] {
+			t, ok := f.Types[l.Sha]
+			if !ok {
+				f.errorf("broken link from %7s %s\n              to %7s %s", f.Types[sha], sha, l.Type, l.Sha)
+				if !missing[l.Sha] {
+					missing[l.Sha] = true
+					fmt.Printf("missing %s %s\n", l.Type, l.Sha)
+				}
+				continue
+			}
+			if t != l.Type {
+				f.errorf("object %s is a %s, not a %s", l.Sha, t, l.Type)
+			}
+			queue = append(queue, l.Sha)
+		}
+	}
+
+	// Dangling objects are unreachable ones no other object points to.
+	referenced := make(map[string]bool)
+	for _, links := range f.Links {
+		for _, l := range links {
+			referenced[l.Sha] = true
+		}
+	}
+	all := make(map[string]string, len(f.Types))
+	for sha := range f.Types {
+		all[sha] = sha
+	}
+	for _, sha := range sortedKeys(all) {
+		if reachable[sha] {
+			continue
+		}
+		switch {
+		case opts.Unreachable:
+			fmt.Printf("unreachable %s %s\n", f.Types[sha], sha)
+		case !referenced[sha] && !opts.NoDangling:
+			fmt.Printf("dangling %s %s\n", f.Types[sha], sha)
+		}
+	}
+	return f.Errors == 0, nil
+}
+
+func (f *fsckChecker) errorf(format string, args ...interface{}) {
+	f.Errors++
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
+}
+
+// checkLoose inflates a loose object and checks its header, its hash and
+// its content.
+func (f *fsckChecker) checkLoose(sha string) {
+	p := objectPath(sha)
+	file, err := os.Open(p)
+	if err != nil {
+		f.errorf("%s: %s", p, err)
+		return
+	}
+	defer file.Close()
+
+	r, err := zlib.NewReader(file)
+	if err != nil {
+		f.errorf("%s: corrupt loose object: %s", p, err)
+		return
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		f.errorf("%s: corrupt loose object: %s", p, err)
+		return
+	}
+	t, content, err := parseObject(b)
+	if err != nil {
+		f.errorf("%s: %s", p, err)
+		return
+	}
+	f.checkObject(sha, t, content, p)
+}
+
+// checkPack verifies the checksums of a pack and its index, the CRC of
+// every entry and the hash of every object.
+func (f *fsckChecker) checkPack(idxPath string) {
+	b, err := os.ReadFile(idxPath)
+	if err != nil {
+		f.errorf("%s: %s", idxPath, err)
+		return
+	}
+	idx, err := parsePackIndex(b)
+	if err != nil {
+		f.errorf("%s: %s", idxPath, err)
+		return
+	}
+	idx.PackPath = strings.TrimSuffix(idxPath, ".idx") + ".pack"
+	data, err := idx.packData()
+	if err != nil {
+		f.errorf("%s", err)
+		return
+	}
+	if !bytes.Equal(idx.Checksum[:], data[len(data)-20:]) {
+		f.errorf("%s: index does not match its pack", idxPath)
+		return
+	}
+	p, err := parsePack(data)
+	if err != nil {
+		f.errorf("%s: %s", idx.PackPath, err)
+		return
+	}
+	if p.NumObjects != len(idx.Shas) {
+		f.errorf("%s: pack has %d objects, its index %d", idx.PackPath, p.NumObjects, len(idx.Shas))
+	}
+
+	lengths, err := idx.entryLengths()
+	if err != nil {
+		f.errorf("%s", err)
+		return
+	}
+	for i, sha := range idx.Shas {
+		start := idx.Offsets[i]
+		if start < 12 || start >= int64(len(data)-20) || start+lengths[start] > int64(len(data)-20) {
+			f.errorf("%s: bad offset %d for %s", idx.PackPath, start, sha)
+			continue
+		}
+		if crc32.ChecksumIEEE(data[start:start+lengths[start]]) != idx.CRCs[i] {
+			f.errorf("%s: bad packed object CRC for %s", idx.PackPath, sha)
+			continue
+		}
+		t, content, err := idx.readAt(start)
+		if err != nil {
+			f.errorf("%s: cannot read %s: %s", idx.PackPath, sha, err)
+			continue
+		}
+		f.checkObject(sha, t, content, idx.PackPath)
+	}
+}
+
+func (f *fsckChecker) checkObject(sha, t string, content []byte, where string) {
+	if got := hashObject(t, content); got != sha {
+		f.errorf("hash mismatch for %s (expected %s, got %s)", where, sha, got)
+		return
+	}
+	f.Types[sha] = t
+
+	var problem string
+	switch t {
+	case "blob":
+	case "tree":
+		problem = f.checkTree(sha, content)
+	case "commit":
+		problem = f.checkCommit(sha, content)
+	case "tag":
+		problem = f.checkTag(sha, content)
+	default:
+		problem = "badType: invalid object type " + t
+	}
+	if problem != "" {
+		f.Errors++
+		fmt.Fprintf(os.Stderr, "error in %s %s: %s\n", t, sha, problem)
+	}
+}
+
+func (f *fsckChecker) checkTree(sha string, content []byte) string {
+	entries, err := parseTree(content)
+	if err != nil {
+		return "badTree: " + err.Error()
+	}
+
+	problem := ""
+	for i, e := range entries {
+		switch {
+		case e.Name == "":
+			problem = "emptyName: contains empty pathname"
+		case strings.Contains(e.Name, "/"):
+			problem = "fullPathname: contains full pathnames"
+		case e.Name == ".":
+			problem = "hasDot: contains '.'"
+		case e.Name == "..":
+			problem = "hasDotdot: contains '..'"
+		case strings.EqualFold(e.Name, ".git"):
+			problem = "hasDotgit: contains '.git'"
+		}
+		switch e.Mode {
+		case modeTree, modeBlob, modeExecutable, modeSymlink, modeGitlink:
+		default:
+			problem = fmt.Sprintf("badFilemode: contains bad file mode %o", e.Mode)
+		}
+		if i > 0 {
+			prev := entries[i-1]
+			if prev.Name == e.Name {
+				problem = "duplicateEntries: contains duplicate file entries"
+			} else if treeSortKey(prev) > treeSortKey(e) {
+				problem = "treeNotSorted: not properly sorted"
+			}
+		}
+		if e.Mode != modeGitlink {
+			f.Links[sha] = append(f.Links[sha], fsckLink{Sha: e.Sha, Type: e.Type()})
+		}
+	}
+	return problem
+}
+
+var fsckSignature = regexp.MustCompile(`^[^<>\n]* <[^<>\n]*> [0-9]+ [+-][0-9]{4}$`)
+
+func (f *fsckChecker) checkCommit(sha string, content []byte) string {
+	end := bytes.Index(content, []byte("\n\n"))
+	if end < 0 {
+		end = len(content)
+	}
+	lines := strings.Split(string(content[:end]), "\n")
+
+	next := func(key string) (string, bool) {
+		if len(lines) == 0 || !strings.HasPrefix(lines[0], key+" ") {
+			return "", false
+		}
+		value := strings.TrimPrefix(lines[0], key+" ")
+		lines = lines[1:]
+		return value, true
+	}
+
+	tree, ok := next("tree")
+	if !ok {
+		return "missingTree: invalid format - expected 'tree' line"
+	}
+	if len(tree) != 40 || !isHex(tree) {
+		return "badTreeSha1: invalid 'tree' line format - bad sha1"
+	}
+	f.Links[sha] = append(f.Links[sha], fsckLink{Sha: tree, Type: "tree"})
+	for {
+		parent, ok := next("parent")
+		if !ok {
+			break
+		}
+		if len(parent) != 40 || !isHex(parent) {
+			return "badParentSha1: invalid 'parent' line format - bad sha1"
+		}
+		f.Links[sha] = append(f.Links[sha], fsckLink{Sha: parent, Type: "commit"})
+	}
+
+	author, ok := next("author")
+	if !ok {
+		return "missingAuthor: invalid format - expected 'author' line"
+	}
+	if !fsckSignature.MatchString(author) {
+		return "badAuthor: invalid author/committer line"
+	}
+	committer, ok := next("committer")
+	if !ok {
+		return "missingCommitter: invalid format - expected 'committer' line"
+	}
+	if !fsckSignature.MatchString(committer) {
+		return "badCommitter: invalid author/committer line"
+	}
+	return ""
+}
+
+func (f *fsckChecker) checkTag(sha string, content []byte) string {
+	end := bytes.Index(content, []byte("\n\n"))
+	if end < 0 {
+		end = len(content)
+	}
+	headers := make(map[string]string)
+	order := make([]string, 0)
+	for _, line := range strings.Split(string(content[:end]), "\n") {
+		key, value := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			key, value = line[:i], line[i+1:]
+		}
+		headers[key] = value
+		order = append(order, key)
+	}
+
+	for i, key := range []string{"object", "type", "tag"} {
+		if len(order) <= i || order[i] != key {
+			return fmt.Sprintf("missing%sEntry: invalid format - expected '%s' line", strings.ToUpper(key[:1])+key[1:], key)
+		}
+	}
+	if target := headers["object"]; len(target) != 40 || !isHex(target) {
+		return "badObjectSha1: invalid 'object' line format - bad sha1"
+	}
+	if objectTypeOf(headers["type"]) == 0 {
+		return "badType: invalid 'type' value"
+	}
+	f.Links[sha] = append(f.Links[sha], fsckLink{Sha: headers["object"], Type: headers["type"]})
+
+	if tagger, ok := headers["tagger"]; ok && !fsckSignature.MatchString(tagger) {
+		return "badTagger: invalid tagger line"
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func rawTree(entries ...treeEntry) []byte {
+	var b []byte
+	for _, e := range entries {
+		sha, _ := hex.DecodeString(e.Sha)
+		b = append(b, fmt.Sprintf("%o %s\x00", e.Mode, e.Name)...)
+		b = append(b, sha...)
+	}
+	return b
+}
+
+func TestFsckObjects(t *testing.T) {
+	sha := strings.Repeat("a", 40)
+	sig := "A U Thor <a@example.com> 1700000000 +0000"
+	for _, test := range []struct {
+		name    string
+		typ     string
+		content []byte
+		want    string
+	}{
+		{"good tree", "tree", rawTree(treeEntry{modeBlob, "a", sha}, treeEntry{modeTree, "b", sha}), ""},
+		{"tree with a dot", "tree", rawTree(treeEntry{modeBlob, ".", sha}), "hasDot: contains '.'"},
+		{"tree with .git", "tree", rawTree(treeEntry{modeTree, ".GIT", sha}), "hasDotgit: contains '.git'"},
+		{"tree with a bad mode", "tree", rawTree(treeEntry{0o100664, "a", sha}), "badFilemode: contains bad file mode 100664"},
+		{"unsorted tree", "tree", rawTree(treeEntry{modeBlob, "b", sha}, treeEntry{modeBlob, "a", sha}), "treeNotSorted: not properly sorted"},
+		{"tree sorted as directories", "tree", rawTree(treeEntry{modeTree, "a", sha}, treeEntry{modeBlob, "a.c", sha}), "treeNotSorted: not properly sorted"},
+		{"duplicate entries", "tree", rawTree(treeEntry{modeBlob, "a", sha}, treeEntry{modeBlob, "a", sha}), "duplicateEntries: contains duplicate file entries"},
+
+		{"good commit", "commit", []byte("tree " + sha + "\nparent " + sha + "\nauthor " + sig + "\ncommitter " + sig + "\n\nmsg\n"), ""},
+		{"commit without a tree", "commit", []byte("parent " + sha + "\nauthor " + sig + "\n"), "missingTree: invalid format - expected 'tree' line"},
+		{"bad parent", "commit", []byte("tree " + sha + "\nparent abc\n"), "badParentSha1: invalid 'parent' line format - bad sha1"},
+		{"bad author", "commit", []byte("tree " + sha + "\nauthor A U Thor 1700000000 +0000\n"), "badAuthor: invalid author/committer line"},
+		{"no committer", "commit", []byte("tree " + sha + "\nauthor " + sig + "\n\nmsg\n"), "missingCommitter: invalid format - expected 'committer' line"},
+
+		{"good tag", "tag", []byte("object " + sha + "\ntype commit\ntag v1\ntagger " + sig + "\n\nmsg\n"), ""},
+		{"tag without a type", "tag", []byte("object " + sha + "\ntag v1\n"), "missingTypeEntry: invalid format - expected 'type' line"},
+		{"tag of a bad type", "tag", []byte("object " + sha + "\ntype thing\ntag v1\n"), "badType: invalid 'type' value"},
+		{"bad tagger", "tag", []byte("object " + sha + "\ntype blob\ntag v1\ntagger nobody\n"), "badTagger: invalid tagger line"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fsckChecker{Types: make(map[string]string), Links: make(map[string][]fsckLink)}
+			var got string
+			switch test.typ {
+			case "tree":
+				got = f.checkTree(sha, test.content)
+			case "commit":
+				got = f.checkCommit(sha, test.content)
+			case "tag":
+				got = f.checkTag(sha, test.content)
+			}
+			if got != test.want {
+				t.Errorf("check %s = %q, want %q", test.typ, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFsck(t *testing.T) {
+	newTestRepo(t)
+	c1 := testCommit(t, map[string]string{"a": "a\n"}, 100)
+	c2 := testCommit(t, map[string]string{"a": "a\n", "b": "b\n"}, 200, c1)
+	if err := updateRef("refs/heads/master", c2); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fsck(fsckOptions{}); err != nil || !ok {
+		t.Fatalf("fsck of a good repository = %v, %v", ok, err)
+	}
+
+	// A missing blob is a broken link.
+	if err := os.Remove(objectPath(hashObject("blob", []byte("b\n")))); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fsck(fsckOptions{}); err != nil || ok {
+		t.Errorf("fsck with a missing blob = %v, %v, want false", ok, err)
+	}
+	if _, err := writeObject("blob", []byte("b\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A pack cut short is reported, not a crash.
+	if err := repack(repackOptions{All: true, Delete: true, Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fsck(fsckOptions{}); err != nil || !ok {
+		t.Fatalf("fsck after repack = %v, %v", ok, err)
+	}
+	packs, err := filepath.Glob(filepath.Join(packDir, "pack-*.pack"))
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("packs = %v, %v", packs, err)
+	}
+	b, err := os.ReadFile(packs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(packs[0], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(packs[0], b[:40], 0644); err != nil {
+		t.Fatal(err)
+	}
+	forgetPacks()
+	if ok, err := fsck(fsckOptions{}); err != nil || ok {
+		t.Errorf("fsck with a truncated pack = %v, %v, want false", ok, err)
+	}
+}
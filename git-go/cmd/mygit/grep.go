@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Notes about grep:
+// - Without revisions the tracked files of the working tree are searched,
+//   with --cached their staged blobs, and with revisions the blobs of each
+//   tree, read from the object store and shown as "<rev>:<path>".
+// - Patterns are RE2 regular expressions. Several -e patterns match when
+//   any does; --and binds tighter than --or, which is implied between
+//   patterns, and --not tighter still.
+// - Files are searched by a pool of workers and printed in order. Binary
+//   files, by content or by the diff attribute, are skipped.
+
+// grepExpr is a node of the pattern expression: a regexp, or "and", "or"
+// or "not" over sub-expressions.
+type grepExpr struct {
+	Op   string
+	Re   *regexp.Regexp
+	A, B *grepExpr
+}
+
+func (e *grepExpr) match(line string) bool {
+	switch e.Op {
+	case "and":
+		return e.A.match(line) && e.B.match(line)
+	case "or":
+		return e.A.match(line) || e.B.match(line)
+	case "not":
+		return !e.A.match(line)
+	}
+	return e.Re.MatchString(line)
+}
+
+type grepOptions struct {
+	ignoreCase, lineNumber, nameOnly, count, word bool
+}
+
+// grepTarget is one blob or working tree file to search.
+type grepTarget struct {
+	Name string
+	Sha  string // "" for the working tree file at Name
+	Diff string // the diff attribute, which can mark a file binary
+}
+
+func grepCommand(args []string) (bool, error) {
+	var opts grepOptions
+	cached := false
+	threads := 0
+	if v, ok := configValue("grep.threads"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return false, fmt.Errorf("invalid grep.threads %s", v)
+		}
+		threads = n
+	}
+
+	// Pattern tokens are "-e" followed by the pattern, and the operators.
+	tokens := make([]string, 0)
+	rest := make([]string, 0)
+	specs := make([]string, 0)
+	doubleDash := false
+	args = splitGrepFlags(args)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if doubleDash {
+			specs = append(specs, arg)
+			continue
+		}
+		switch {
+		case arg == "--":
+			doubleDash = true
+		case arg == "-i" || arg == "--ignore-case":
+			opts.ignoreCase = true
+		case arg == "-n" || arg == "--line-number":
+			opts.lineNumber = true
+		case arg == "-l" || arg == "--files-with-matches" || arg == "--name-only":
+			opts.nameOnly = true
+		case arg == "-c" || arg == "--count":
+			opts.count = true
+		case arg == "-w" || arg == "--word-regexp":
+			opts.word = true
+		case arg == "--cached":
+			cached = true
+		case arg == "-E" || arg == "--extended-regexp":
+		case arg == "-e":
+			if i+1 == len(args) {
+				return false, errors.New("switch `e' requires a value")
+			}
+			i++
+			tokens = append(tokens, "-e", args[i])
+		case strings.HasPrefix(arg, "-e"):
+			tokens = append(tokens, "-e", arg[2:])
+		case arg == "--and" || arg == "--or" || arg == "--not" || arg == "(" || arg == ")":
+			tokens = append(tokens, arg)
+		case arg == "--threads" && i+1 < len(args), strings.HasPrefix(arg, "--threads="):
+			v := strings.TrimPrefix(arg, "--threads=")
+			if arg == "--threads" {
+				i++
+				v = args[i]
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return false, fmt.Errorf("invalid --threads %s", v)
+			}
+			threads = n
+		case strings.HasPrefix(arg, "-"):
+			return false, fmt.Errorf("unknown option %s", arg)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if len(tokens) == 0 {
+		if len(rest) == 0 {
+			return false, errors.New("no pattern given")
+		}
+		tokens = append(tokens, "-e", rest[0])
+		rest = rest[1:]
+	}
+	expr, err := parseGrepExpr(tokens, opts)
+	if err != nil {
+		return false, err
+	}
+
+	// What follows the pattern are revisions, up to the first argument that
+	// is not one; pathspecs come after that or after "--".
+	revs := make([]string, 0)
+	for i, arg := range rest {
+		sha, err := revParse(arg)
+		if err != nil {
+			specs = append(rest[i:], specs...)
+			break
+		}
+		if _, err = peel(sha, "tree"); err != nil {
+			return false, err
+		}
+		revs = append(revs, arg)
+	}
+	if cached && len(revs) > 0 {
+		return false, errors.New("--cached cannot be used with revisions")
+	}
+
+	targets, err := grepTargets(revs, cached, specs)
+	if err != nil {
+		return false, err
+	}
+	return runGrep(targets, expr, opts, threads)
+}
+
+// splitGrepFlags splits grouped single letter flags such as "-in".
+func splitGrepFlags(args []string) []string {
+	split := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(split, args[i:]...)
+		}
+		grouped := len(arg) > 2 && arg[0] == '-' && strings.Trim(arg[1:], "inlcw") == ""
+		if !grouped || i > 0 && args[i-1] == "-e" {
+			split = append(split, arg)
+			continue
+		}
+		for _, c := range arg[1:] {
+			split = append(split, "-"+string(c))
+		}
+	}
+	return split
+}
+
+// parseGrepExpr builds the expression of the pattern tokens.
+func parseGrepExpr(tokens []string, opts grepOptions) (*grepExpr, error) {
+	p := &grepExprParser{tokens: tokens, opts: opts}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unmatched %s", p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+type grepExprParser struct {
+	tokens []string
+	pos    int
+	opts   grepOptions
+}
+
+func (p *grepExprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *grepExprParser) or() (*grepExpr, error) {
+	e, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case "--or":
+			p.pos++
+		case "-e", "--not", "(":
+		default:
+			return e, nil
+		}
+		b, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		e = &grepExpr{Op: "or", A: e, B: b}
+	}
+}
+
+func (p *grepExprParser) and() (*grepExpr, error) {
+	e, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "--and" {
+		p.pos++
+		b, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		e = &grepExpr{Op: "and", A: e, B: b}
+	}
+	return e, nil
+}
+
+func (p *grepExprParser) not() (*grepExpr, error) {
+	if p.peek() == "--not" {
+		p.pos++
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &grepExpr{Op: "not", A: e}, nil
+	}
+	return p.atom()
+}
+
+func (p *grepExprParser) atom() (*grepExpr, error) {
+	switch p.peek() {
+	case "(":
+		p.pos++
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("unmatched parenthesis")
+		}
+		p.pos++
+		return e, nil
+	case "-e":
+		pattern := p.tokens[p.pos+1]
+		p.pos += 2
+		if p.opts.word {
+			pattern = `\b(?:` + pattern + `)\b`
+		}
+		if p.opts.ignoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %s", err)
+		}
+		return &grepExpr{Re: re}, nil
+	case "":
+		return nil, errors.New("incomplete pattern expression")
+	}
+	return nil, fmt.Errorf("not a pattern expression %s", p.peek())
+}
+
+// grepTargets lists the files to search, in the order they are shown.
+func grepTargets(revs []string, cached bool, specs []string) ([]grepTarget, error) {
+	matches := func(p string) bool {
+		if len(specs) == 0 {
+			return true
+		}
+		for _, spec := range specs {
+			spec = cleanPathspec(spec)
+			if matchPathspec(spec, p) || strings.ContainsAny(spec, "*?[") && wildmatch(spec, p, 0) {
+				return true
+			}
+		}
+		return false
+	}
+	c, err := attributes()
+	if err != nil {
+		return nil, err
+	}
+	add := func(targets []grepTarget, name, p, sha string) ([]grepTarget, error) {
+		diff, err := c.attr(p, "diff")
+		if err != nil {
+			return nil, err
+		}
+		return append(targets, grepTarget{Name: name, Sha: sha, Diff: diff}), nil
+	}
+
+	targets := make([]grepTarget, 0)
+	if len(revs) == 0 {
+		idx, err := readIndex()
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		for _, e := range idx.Entries {
+			// A conflicted file is searched once in the working tree, and
+			// not at all in the index.
+			if cached && e.Stage != 0 || seen[e.Path] || !matches(e.Path) {
+				continue
+			}
+			seen[e.Path] = true
+			if e.Mode != modeBlob && e.Mode != modeExecutable {
+				continue
+			}
+			sha := ""
+			if cached {
+				sha = e.Sha
+			}
+			if targets, err = add(targets, e.Path, e.Path, sha); err != nil {
+				return nil, err
+			}
+		}
+		return targets, nil
+	}
+
+	for _, rev := range revs {
+		tree, err := resolveTree(rev)
+		if err != nil {
+			return nil, err
+		}
+		files, err := flattenTree(tree)
+		if err != nil {
+			return nil, err
+		}
+		paths := make([]string, 0, len(files))
+		for p := range files {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			e := files[p]
+			if e.Mode != modeBlob && e.Mode != modeExecutable || !matches(p) {
+				continue
+			}
+			if targets, err = add(targets, rev+":"+p, p, e.Sha); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return targets, nil
+}
+
+// runGrep searches the targets with a pool of workers and prints what they
+// find in order. It reports whether anything matched.
+func runGrep(targets []grepTarget, expr *grepExpr, opts grepOptions, threads int) (bool, error) {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	results := make([][]byte, len(targets))
+	errs := make([]error, len(targets))
+	done := make([]chan struct{}, len(targets))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < threads; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = grepTargetOutput(targets[i], expr, opts)
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range targets {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	found := false
+	var firstErr error
+	for i := range targets {
+		<-done[i]
+		if errs[i] != nil && firstErr == nil {
+			firstErr = errs[i]
+		}
+		if firstErr == nil && len(results[i]) > 0 {
+			found = true
+			fmt.Print(string(results[i]))
+		}
+	}
+	wg.Wait()
+	return found, firstErr
+}
+
+// grepTargetOutput searches one file and returns what is printed for it.
+func grepTargetOutput(t grepTarget, expr *grepExpr, opts grepOptions) ([]byte, error) {
+	var b []byte
+	var err error
+	if t.Sha != "" {
+		b, err = readObjectOfType(t.Sha, "blob")
+	} else {
+		// Files deleted from the working tree are left out.
+		if b, err = os.ReadFile(t.Name); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.Diff == attrUnset || t.Diff != attrSet && isBinary(b) {
+		return nil, nil
+	}
+
+	var out bytes.Buffer
+	count := 0
+	for n := 1; len(b) > 0; n++ {
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line, b = b[:i], b[i+1:]
+		} else {
+			b = nil
+		}
+		if !expr.match(string(line)) {
+			continue
+		}
+		count++
+		if opts.nameOnly {
+			break
+		}
+		if opts.count {
+			continue
+		}
+		out.WriteString(t.Name + ":")
+		if opts.lineNumber {
+			out.WriteString(strconv.Itoa(n) + ":")
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+
+	switch {
+	case count == 0:
+		return nil, nil
+	case opts.nameOnly:
+		return []byte(t.Name + "\n"), nil
+	case opts.count:
+		return []byte(fmt.Sprintf("%s:%d\n", t.Name, count)), nil
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitGrepFlags(t *testing.T) {
+	tests := []struct {
+		in, want []string
+	}{
+		{[]string{"-in", "pat"}, []string{"-i", "-n", "pat"}},
+		{[]string{"-e", "-in", "-lc"}, []string{"-e", "-in", "-l", "-c"}},
+		{[]string{"-ix"}, []string{"-ix"}},
+		{[]string{"pat", "--", "-in"}, []string{"pat", "--", "-in"}},
+	}
+	for _, tt := range tests {
+		if got := splitGrepFlags(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitGrepFlags(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseGrepExpr(t *testing.T) {
+	lines := []string{"alpha beta", "alpha", "beta", "gamma", "Alphabet"}
+	tests := []struct {
+		tokens string
+		opts   grepOptions
+		want   []bool
+	}{
+		{"-e alpha", grepOptions{}, []bool{true, true, false, false, false}},
+		{"-e alpha", grepOptions{ignoreCase: true}, []bool{true, true, false, false, true}},
+		{"-e alpha", grepOptions{word: true, ignoreCase: true}, []bool{true, true, false, false, false}},
+		{"-e alpha -e gamma", grepOptions{}, []bool{true, true, false, true, false}},
+		{"-e alpha --and -e beta", grepOptions{}, []bool{true, false, false, false, false}},
+		{"-e alpha --and --not -e beta", grepOptions{}, []bool{false, true, false, false, false}},
+		{"-e gamma --or -e alpha --and -e beta", grepOptions{}, []bool{true, false, false, true, false}},
+		{"( -e gamma --or -e alpha ) --and --not -e beta", grepOptions{}, []bool{false, true, false, true, false}},
+	}
+	for _, tt := range tests {
+		e, err := parseGrepExpr(strings.Fields(tt.tokens), tt.opts)
+		if err != nil {
+			t.Errorf("parseGrepExpr(%s): %s", tt.tokens, err)
+			continue
+		}
+		got := make([]bool, len(lines))
+		for i, line := range lines {
+			got[i] = e.match(line)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %+v matches %v, want %v", tt.tokens, tt.opts, got, tt.want)
+		}
+	}
+
+	for _, tokens := range []string{"", "--not", "( -e a", "-e a )", "-e a --and", "-e ("} {
+		if _, err := parseGrepExpr(strings.Fields(tokens), grepOptions{}); err == nil {
+			t.Errorf("parseGrepExpr(%q) did not fail", tokens)
+		}
+	}
+}
+
+func TestGrepTargets(t *testing.T) {
+	newTestRepo(t)
+	writeTestFiles(t, map[string]string{
+		"global-attributes": "",
+		".gitattributes":    "*.dat -diff\n*.txt diff\n",
+	})
+	setTestConfig(t, "")
+	c := testCommit(t, map[string]string{
+		"README":        "hello world\nsecond line\nhello again\n",
+		"src/main.go":   "package main\n// hello\n",
+		"data.dat":      "hello\n",
+		"binary.bin":    "hello\x00\n",
+		"forced.txt":    "hello\x00\n",
+		"src/other.txt": "nothing here\n",
+	}, 100)
+
+	targets, err := grepTargets([]string{c}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expr, err := parseGrepExpr([]string{"-e", "hello"}, grepOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		opts grepOptions
+		want string
+	}{
+		{grepOptions{}, "README:hello world\nREADME:hello again\nforced.txt:hello\x00\nsrc/main.go:// hello\n"},
+		{grepOptions{lineNumber: true}, "README:1:hello world\nREADME:3:hello again\nforced.txt:1:hello\x00\nsrc/main.go:2:// hello\n"},
+		{grepOptions{count: true}, "README:2\nforced.txt:1\nsrc/main.go:1\n"},
+		{grepOptions{nameOnly: true}, "README\nforced.txt\nsrc/main.go\n"},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		for _, target := range targets {
+			b, err := grepTargetOutput(target, expr, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out.Write(b)
+		}
+		want := strings.ReplaceAll(tt.want, "README", c+":README")
+		want = strings.ReplaceAll(want, "forced.txt", c+":forced.txt")
+		want = strings.ReplaceAll(want, "src/main.go", c+":src/main.go")
+		if out.String() != want {
+			t.Errorf("grep %+v =\n%q\nwant\n%q", tt.opts, out.String(), want)
+		}
+	}
+
+	targets, err = grepTargets([]string{c}, false, []string{"src"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	if want := []string{c + ":src/main.go", c + ":src/other.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("targets in src = %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Notes about ignore files:
+// - Every directory may have a .gitignore for the paths below it. Deeper
+//   files take precedence, then .git/info/exclude, then core.excludesFile.
+//   Within a file the last matching pattern wins.
+// - "!" negates a pattern, a trailing "/" matches directories only. A
+//   pattern with a slash before its end is matched against the path from
+//   the directory of its file, otherwise against the last path component.
+// - A file in an ignored directory cannot be re-included.
+// - Tracked files are never ignored.
+
+type ignorePattern struct {
+	Pattern  string
+	Negated  bool
+	DirOnly  bool
+	Basename bool
+	Base     string
+	Source   string
+	Line     int
+	Text     string
+}
+
+type ignoreMatcher struct {
+	flags  int
+	global [][]ignorePattern
+	perDir map[string][]ignorePattern
+}
+
+func newIgnoreMatcher() (*ignoreMatcher, error) {
+	m := &ignoreMatcher{flags: wmPathname, perDir: make(map[string][]ignorePattern)}
+	if fold, err := configBool("core.ignoreCase", false); err != nil {
+		return nil, err
+	} else if fold {
+		m.flags |= wmCaseFold
+	}
+
+	excludesFile, ok := configValue("core.excludesFile")
+	if ok {
+		excludesFile = expandUserPath(excludesFile)
+	} else if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		excludesFile = filepath.Join(xdg, "git", "ignore")
+	} else if home := os.Getenv("HOME"); home != "" {
+		excludesFile = filepath.Join(home, ".config", "git", "ignore")
+	}
+
+	// Highest precedence first, like the per-directory files.
+	for _, p := range []string{".git/info/exclude", excludesFile} {
+		if p == "" {
+			continue
+		}
+		patterns, err := readIgnoreFile(p, "")
+		if err != nil {
+			return nil, err
+		}
+		m.global = append(m.global, patterns)
+	}
+	return m, nil
+}
+
+func readIgnoreFile(p, base string) ([]ignorePattern, error) {
+	if info, err := os.Stat(p); err != nil || info.IsDir() {
+		return nil, nil
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseIgnorePatterns(b, p, base), nil
+}
+
+func parseIgnorePatterns(b []byte, source, base string) []ignorePattern {
+	patterns := make([]ignorePattern, 0)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSuffix(s.Text(), "\r")
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\xef\xbb\xbf")
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		// Trailing spaces go unless escaped with a backslash.
+		text := line
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = strings.TrimSuffix(line, " ")
+		}
+		text = text[:len(line)]
+
+		p := ignorePattern{Base: base, Source: source, Line: n, Text: text}
+		if strings.HasPrefix(line, "!") {
+			p.Negated = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.DirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		p.Basename = !strings.Contains(line, "/")
+		p.Pattern = strings.TrimPrefix(line, "/")
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// matches checks a path relative to the top of the working tree.
+func (p *ignorePattern) matches(name string, isDir bool, flags int) bool {
+	if p.DirOnly && !isDir {
+		return false
+	}
+	if p.Basename {
+		return wildmatch(p.Pattern, path.Base(name), flags)
+	}
+	if p.Base != "" {
+		if !strings.HasPrefix(name, p.Base+"/") {
+			return false
+		}
+		name = strings.TrimPrefix(name, p.Base+"/")
+	}
+	return wildmatch(p.Pattern, name, flags)
+}
+
+// dirPatterns returns the patterns of the .gitignore in a directory, "" being
+// the top of the working tree.
+func (m *ignoreMatcher) dirPatterns(dir string) ([]ignorePattern, error) {
+	if patterns, ok := m.perDir[dir]; ok {
+		return patterns, nil
+	}
+	p := path.Join(dir, ".gitignore")
+	patterns, err := readIgnoreFile(p, dir)
+	if err != nil {
+		return nil, err
+	}
+	m.perDir[dir] = patterns
+	return patterns, nil
+}
+
+// match returns the pattern that decides whether a path is ignored, nil if
+// none matches. A pattern excluding a leading directory decides for
+// everything inside it.
+func (m *ignoreMatcher) match(name string, isDir bool) (*ignorePattern, error) {
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		p, err := m.matchOne(strings.Join(parts[:i], "/"), true)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil && !p.Negated {
+			return p, nil
+		}
+	}
+	return m.matchOne(name, isDir)
+}
+
+// matchOne checks a path against the patterns that apply to it, without
+// looking at its leading directories.
+func (m *ignoreMatcher) matchOne(name string, isDir bool) (*ignorePattern, error) {
+	levels := make([][]ignorePattern, 0)
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if dir == "." {
+			dir = ""
+		}
+		patterns, err := m.dirPatterns(dir)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, patterns)
+		if dir == "" {
+			break
+		}
+	}
+	levels = append(levels, m.global...)
+
+	for _, patterns := range levels {
+		for i := len(patterns) - 1; i >= 0; i-- {
+			if patterns[i].matches(name, isDir, m.flags) {
+				return &patterns[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// ignored reports whether an untracked path is ignored.
+func (m *ignoreMatcher) ignored(name string, isDir bool) (bool, error) {
+	p, err := m.match(name, isDir)
+	if err != nil {
+		return false, err
+	}
+	return p != nil && !p.Negated, nil
+}
+
+func checkIgnoreCommand(args []string) (bool, error) {
+	verbose, nonMatching, quiet, noIndex, stdin := false, false, false, false, false
+	paths := make([]string, 0)
+	for _, arg := range args {
+		switch arg {
+		case "-v", "--verbose":
+			verbose = true
+		case "-n", "--non-matching":
+			nonMatching = true
+		case "-q", "--quiet":
+			quiet = true
+		case "--no-index":
+			noIndex = true
+		case "--stdin":
+			stdin = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return false, fmt.Errorf("unknown option %s", arg)
+			}
+			paths = append(paths, arg)
+		}
+	}
+	if nonMatching && !verbose {
+		return false, errors.New("--non-matching is only valid with --verbose")
+	}
+	if quiet && verbose {
+		return false, errors.New("cannot have both --quiet and --verbose")
+	}
+	if stdin {
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			paths = append(paths, s.Text())
+		}
+	}
+	if len(paths) == 0 {
+		return false, errors.New("no path specified")
+	}
+
+	m, err := newIgnoreMatcher()
+	if err != nil {
+		return false, err
+	}
+	tracked := make(map[string]bool)
+	if !noIndex {
+		idx, err := readIndex()
+		if err != nil {
+			return false, err
+		}
+		for _, e := range idx.Entries {
+			tracked[e.Path] = true
+		}
+	}
+
+	found := false
+	for _, arg := range paths {
+		name := cleanPathspec(arg)
+		var p *ignorePattern
+		if !tracked[name] {
+			info, err := os.Lstat(name)
+			if p, err = m.match(name, err == nil && info.IsDir()); err != nil {
+				return false, err
+			}
+		}
+
+		// Only -v shows negated matches, which then count as matches.
+		if p != nil && p.Negated && !verbose {
+			p = nil
+		}
+		if p != nil {
+			found = true
+		}
+		switch {
+		case quiet:
+		case p != nil && verbose:
+			fmt.Printf("%s:%d:%s\t%s\n", p.Source, p.Line, p.Text, arg)
+		case p != nil:
+			fmt.Println(arg)
+		case nonMatching:
+			fmt.Printf("::\t%s\n", arg)
+		}
+	}
+	return found, nil
+}
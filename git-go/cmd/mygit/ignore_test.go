@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseIgnorePatterns(t *testing.T) {
+	in := "\xef\xbb\xbf# comment\n*.o\n!keep.o\nbuild/\n/root.txt\ndoc/*.html\ntrailing  \nescaped\\ \r\n!\n\n"
+	got := parseIgnorePatterns([]byte(in), "sub/.gitignore", "sub")
+	want := []ignorePattern{
+		{Pattern: "*.o", Basename: true, Line: 2, Text: "*.o"},
+		{Pattern: "keep.o", Negated: true, Basename: true, Line: 3, Text: "!keep.o"},
+		{Pattern: "build", DirOnly: true, Basename: true, Line: 4, Text: "build/"},
+		{Pattern: "root.txt", Line: 5, Text: "/root.txt"},
+		{Pattern: "doc/*.html", Line: 6, Text: "doc/*.html"},
+		{Pattern: "trailing", Basename: true, Line: 7, Text: "trailing"},
+		{Pattern: "escaped\\ ", Basename: true, Line: 8, Text: "escaped\\ "},
+	}
+	for i := range want {
+		want[i].Base = "sub"
+		want[i].Source = "sub/.gitignore"
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseIgnorePatterns =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	m := &ignoreMatcher{flags: wmPathname, perDir: map[string][]ignorePattern{
+		"":    parseIgnorePatterns([]byte("*.log\n!important.log\nbuild/\n/top.txt\n**/tmp/**\nvendor\n!vendor/keep\n"), ".gitignore", ""),
+		"sub": parseIgnorePatterns([]byte("local.txt\n!*.log\n/only-here\n"), "sub/.gitignore", "sub"),
+	}}
+	m.global = [][]ignorePattern{parseIgnorePatterns([]byte("*.swp\n"), ".git/info/exclude", "")}
+	for _, dir := range []string{"a", "a/tmp", "build", "src", "sub/deeper", "vendor", "x"} {
+		m.perDir[dir] = nil
+	}
+
+	tests := []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{"debug.log", false, true},
+		{"src/debug.log", false, true},
+		{"important.log", false, false},
+		{"sub/debug.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"build/out.bin", false, true},
+		{"src/build/out.bin", false, true},
+		{"top.txt", false, true},
+		{"src/top.txt", false, false},
+		{"a/tmp/x", false, true},
+		{"a/tmpx", false, false},
+		{"sub/local.txt", false, true},
+		{"local.txt", false, false},
+		{"sub/only-here", false, true},
+		{"sub/deeper/only-here", false, false},
+		{"file.swp", false, true},
+		{"x/file.swp", false, true},
+		{"main.go", false, false},
+		// A file in an excluded directory cannot be re-included.
+		{"vendor/keep", false, true},
+	}
+	for _, tt := range tests {
+		got, err := m.ignored(tt.name, tt.isDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("ignored(%q, %v) = %v, want %v", tt.name, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	newTestRepo(t)
+	files := map[string]string{
+		".gitignore":        "*.tmp\n",
+		"sub/.gitignore":    "!keep.tmp\n",
+		".git/info/exclude": "secret\n",
+		".git/config":       "[core]\n\texcludesFile = global-ignore\n",
+		"global-ignore":     "*.bak\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	forgetConfig()
+	t.Cleanup(forgetConfig)
+
+	m, err := newIgnoreMatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"a.tmp", true},
+		{"sub/a.tmp", true},
+		{"sub/keep.tmp", false},
+		{"secret", true},
+		{"old.bak", true},
+		{"kept", false},
+	}
+	for _, tt := range tests {
+		got, err := m.ignored(tt.name, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("ignored(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteTreeIgnored(t *testing.T) {
+	newTestRepo(t)
+	files := map[string]string{
+		".gitignore":      "*.tmp\nbuild/\n",
+		"a.txt":           "a\n",
+		"logs/x.tmp":      "only ignored files\n",
+		"logs/deep/y.tmp": "nested ignored file\n",
+		"build/out":       "ignored directory\n",
+		"sub/keep.txt":    "kept\n",
+		"sub/skip.tmp":    "skipped\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir("empty", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	sha, err := writeTree(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testTree(t, map[string]string{".gitignore": "*.tmp\nbuild/\n", "a.txt": "a\n", "sub/keep.txt": "kept\n"})
+	if got := fmt.Sprintf("%x", sha); got != want {
+		t.Errorf("writeTree = %s, want %s without the ignored and empty directories", got, want)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Notes about the index file (version 2):
+// - The header is "DIRC", a 4 byte version and a 4 byte entry count.
+// - Each entry holds stat data, the mode, the object id, 16 bits of flags
+//   (the stage in bits 12-13 and the name length) and the NUL terminated
+//   path, padded to a multiple of 8 bytes.
+// - Extensions may follow the entries.
+// - The final 20 bytes are a SHA-1 checksum of everything before them.
+
+const indexPath = ".git/index"
+
+type indexEntry struct {
+	CtimeSec, CtimeNsec uint32
+	MtimeSec, MtimeNsec uint32
+	Dev, Ino            uint32
+	Mode                uint32
+	Uid, Gid            uint32
+	Size                uint32
+	Sha                 string
+	Stage               int
+	Path                string
+}
+
+type index struct {
+	Entries []indexEntry
+}
+
+func readIndex() (*index, error) {
+	b, err := os.ReadFile(indexPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &index{}, nil
+		}
+		return nil, err
+	}
+	return parseIndex(b)
+}
+
+func parseIndex(b []byte) (*index, error) {
+	if len(b) < 32 || string(b[:4]) != "DIRC" {
+		return nil, errors.New("index file is corrupt")
+	}
+	checksum := sha1.Sum(b[:len(b)-20])
+	if !bytes.Equal(checksum[:], b[len(b)-20:]) {
+		return nil, errors.New("index file checksum mismatch")
+	}
+
+	version := binary.BigEndian.Uint32(b[4:8])
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+	count := int(binary.BigEndian.Uint32(b[8:12]))
+
+	idx := &index{Entries: make([]indexEntry, 0, count)}
+	pos := 12
+	for i := 0; i < count; i++ {
+		if pos+62 > len(b)-20 {
+			return nil, errors.New("index file is truncated")
+		}
+		field := func(n int) uint32 {
+			return binary.BigEndian.Uint32(b[pos+4*n:])
+		}
+		e := indexEntry{
+			CtimeSec: field(0), CtimeNsec: field(1),
+			MtimeSec: field(2), MtimeNsec: field(3),
+			Dev: field(4), Ino: field(5),
+			Mode: field(6),
+			Uid:  field(7), Gid: field(8),
+			Size: field(9),
+			Sha:  fmt.Sprintf("%x", b[pos+40:pos+60]),
+		}
+		flags := binary.BigEndian.Uint16(b[pos+60:])
+		e.Stage = int(flags>>12) & 3
+
+		start := pos + 62
+		if flags&0x4000 != 0 {
+			// Extended flags, only found in version 3.
+			start += 2
+		}
+		end := bytes.IndexByte(b[start:], '\x00')
+		if end < 0 {
+			return nil, errors.New("index file is truncated")
+		}
+		e.Path = string(b[start : start+end])
+		idx.Entries = append(idx.Entries, e)
+
+		size := start + end - pos + 1
+		pos += (size + 7) / 8 * 8
+	}
+	return idx, nil
+}
+
+func (idx *index) write() error {
+	idx.sort()
+
+	var b bytes.Buffer
+	b.WriteString("DIRC")
+	binary.Write(&b, binary.BigEndian, uint32(2))
+	binary.Write(&b, binary.BigEndian, uint32(len(idx.Entries)))
+
+	for _, e := range idx.Entries {
+		start := b.Len()
+		for _, v := range []uint32{
+			e.CtimeSec, e.CtimeNsec, e.MtimeSec, e.MtimeNsec,
+			e.Dev, e.Ino, e.Mode, e.Uid, e.Gid, e.Size,
+		} {
+			binary.Write(&b, binary.BigEndian, v)
+		}
+		sha, err := decodeSha(e.Sha)
+		if err != nil {
+			return err
+		}
+		b.Write(sha[:])
+
+		nameLen := len(e.Path)
+		if nameLen > 0xfff {
+			nameLen = 0xfff
+		}
+		binary.Write(&b, binary.BigEndian, uint16(e.Stage<<12|nameLen))
+		b.WriteString(e.Path)
+
+		size := b.Len() - start
+		b.Write(make([]byte, 8-size%8))
+	}
+
+	checksum := sha1.Sum(b.Bytes())
+	b.Write(checksum[:])
+
+	// Write to a lock file first so that readers never see a partial index.
+	lock := indexPath + ".lock"
+	if err := os.WriteFile(lock, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(lock, indexPath); err != nil {
+		return err
+	}
+	forgetStagedIndex()
+	return nil
+}
+
+func (idx *index) sort() {
+	sort.SliceStable(idx.Entries, func(i, j int) bool {
+		a, b := idx.Entries[i], idx.Entries[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Stage < b.Stage
+	})
+}
+
+// entry returns the stage 0 entry of a path.
+func (idx *index) entry(p string) (indexEntry, bool) {
+	for _, e := range idx.Entries {
+		if e.Path == p && e.Stage == 0 {
+			return e, true
+		}
+	}
+	return indexEntry{}, false
+}
+
+// add replaces every stage of a path with the given entry.
+func (idx *index) add(e indexEntry) {
+	idx.remove(e.Path)
+	idx.Entries = append(idx.Entries, e)
+}
+
+func (idx *index) remove(p string) {
+	entries := idx.Entries[:0]
+	for _, e := range idx.Entries {
+		if e.Path != p {
+			entries = append(entries, e)
+		}
+	}
+	idx.Entries = entries
+}
+
+// unmerged returns the paths that have entries in stages 1 to 3.
+func (idx *index) unmerged() []string {
+	paths := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, e := range idx.Entries {
+		if e.Stage != 0 && !seen[e.Path] {
+			seen[e.Path] = true
+			paths = append(paths, e.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// files returns the stage 0 entries as tree entries keyed by path.
+func (idx *index) files() map[string]treeEntry {
+	files := make(map[string]treeEntry)
+	for _, e := range idx.Entries {
+		if e.Stage == 0 {
+			files[e.Path] = treeEntry{Mode: int(e.Mode), Sha: e.Sha}
+		}
+	}
+	return files
+}
+
+// writeTree writes the tree objects for the index and returns the root id.
+func (idx *index) writeTree() (string, error) {
+	if paths := idx.unmerged(); len(paths) > 0 {
+		return "", fmt.Errorf("%s: unmerged path", strings.Join(paths, ", "))
+	}
+	return buildTree(idx.files())
+}
+
+// setConflict replaces a path with one entry per stage of a merge conflict.
+func (idx *index) setConflict(c mergeConflict) {
+	idx.remove(c.Path)
+	for i, e := range c.Stages {
+		if e != nil {
+			idx.Entries = append(idx.Entries, indexEntry{
+				Mode:  uint32(e.Mode),
+				Sha:   e.Sha,
+				Stage: i + 1,
+				Path:  c.Path,
+			})
+		}
+	}
+}
+
+// newIndexEntry builds an index entry for a file with the given object id,
+// filling in the stat data the working tree has for it.
+func newIndexEntry(p string, mode int, sha string) (indexEntry, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return indexEntry{}, err
+	}
+
+	e := indexEntry{
+		MtimeSec:  uint32(info.ModTime().Unix()),
+		MtimeNsec: uint32(info.ModTime().Nanosecond()),
+		Mode:      uint32(mode),
+		Size:      uint32(info.Size()),
+		Sha:       sha,
+		Path:      p,
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		e.Dev = uint32(st.Dev)
+		e.Ino = uint32(st.Ino)
+		e.Uid = st.Uid
+		e.Gid = st.Gid
+	}
+	return e, nil
+}
+
+// statMatches reports whether a file looks unchanged since it was added to
+// the index, so that its content does not need to be hashed again.
+func (e indexEntry) statMatches(info os.FileInfo) bool {
+	if e.MtimeSec != uint32(info.ModTime().Unix()) ||
+		e.MtimeNsec != uint32(info.ModTime().Nanosecond()) ||
+		e.Size != uint32(info.Size()) ||
+		e.Mode != uint32(fileMode(info)) {
+		return false
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return e.Ino == uint32(st.Ino)
+	}
+	return true
+}
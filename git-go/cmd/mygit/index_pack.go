@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// Notes about storing received packs:
+// - A pack that comes from elsewhere is kept as it is under
+//   .git/objects/pack, next to an index built by reading every entry and
+//   resolving its deltas to learn the object ids.
+// - A thin pack has REF_DELTA entries whose bases are not in the pack but
+//   in the repository. Those bases are appended to the pack, as git's
+//   index-pack --fix-thin does, so that the stored pack is complete.
+
+// receivedEntry is an entry of a received pack, before and after its delta
+// is resolved.
+type receivedEntry struct {
+	packObject
+	Data       []byte
+	BaseOffset int64
+	BaseSha    string
+	resolved   bool
+}
+
+// storePack indexes a pack and writes both into the object store. It
+// returns the base name of the files.
+func storePack(b []byte) (string, error) {
+	p, err := parsePack(b)
+	if err != nil {
+		return "", err
+	}
+	entries, err := readPackEntries(b, p.NumObjects)
+	if err != nil {
+		return "", err
+	}
+
+	byOffset := make(map[int64]*receivedEntry, len(entries))
+	for _, e := range entries {
+		byOffset[e.offset] = e
+	}
+	bySha := make(map[string]*receivedEntry, len(entries))
+	// Bases found in the repository, for thin packs.
+	outside := make(map[string]*packObject)
+
+	var resolve func(e *receivedEntry) (bool, error)
+	resolve = func(e *receivedEntry) (bool, error) {
+		if e.resolved {
+			return true, nil
+		}
+		var base *packObject
+		switch e.Type {
+		case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+			e.Content = e.Data
+		case OBJ_OFS_DELTA:
+			b, ok := byOffset[e.BaseOffset]
+			if !ok {
+				return false, fmt.Errorf("bad delta base offset %d", e.BaseOffset)
+			}
+			if ok, err := resolve(b); !ok || err != nil {
+				return ok, err
+			}
+			base = &b.packObject
+		case OBJ_REF_DELTA:
+			if b, ok := bySha[e.BaseSha]; ok {
+				base = &b.packObject
+			} else if o, ok := outside[e.BaseSha]; ok {
+				base = o
+			} else {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("bad object type %d in pack", e.Type)
+		}
+		if base != nil {
+			content, err := applyDelta(base.Content, e.Data)
+			if err != nil {
+				return false, err
+			}
+			e.Type, e.Content = base.Type, content
+		}
+		e.Sha = hashObject(e.Type.String(), e.Content)
+		e.resolved = true
+		bySha[e.Sha] = e
+		return true, nil
+	}
+
+	// REF_DELTA bases may come later in the pack than their deltas, so
+	// resolving goes round until nothing changes; then the bases still
+	// missing are looked for in the repository.
+	for {
+		progress := false
+		missing := make([]string, 0)
+		for _, e := range entries {
+			if e.resolved {
+				continue
+			}
+			ok, err := resolve(e)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				progress = true
+			} else if e.Type == OBJ_REF_DELTA {
+				missing = append(missing, e.BaseSha)
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+		if !progress {
+			for _, sha := range missing {
+				if _, ok := outside[sha]; ok {
+					continue
+				}
+				t, content, err := readObject(sha)
+				if err != nil {
+					return "", fmt.Errorf("pack has unresolved delta base %s", sha)
+				}
+				outside[sha] = &packObject{Sha: sha, Type: objectTypeOf(t), Content: content}
+			}
+		}
+	}
+
+	objects := make([]*packObject, 0, len(entries)+len(outside))
+	for _, e := range entries {
+		objects = append(objects, &e.packObject)
+	}
+	if len(outside) > 0 {
+		if b, err = appendPackObjects(b, outside, len(entries)); err != nil {
+			return "", err
+		}
+		for _, o := range outside {
+			objects = append(objects, o)
+		}
+	}
+	name, err := storePackFiles(b, objects)
+	forgetPacks()
+	return name, err
+}
+
+// readPackEntries reads the entries of a pack in order, inflating each
+// one.
+func readPackEntries(b []byte, n int) ([]*receivedEntry, error) {
+	data := b[:len(b)-20]
+	r := bytes.NewReader(data[12:])
+	entries := make([]*receivedEntry, 0, n)
+	for i := 0; i < n; i++ {
+		if r.Len() == 0 {
+			return nil, fmt.Errorf("pack is truncated after %d objects", i)
+		}
+		offset := int64(len(data) - r.Len())
+		size, objType, err := parseHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		e := &receivedEntry{packObject: packObject{Type: objType, offset: offset}}
+
+		switch objType {
+		case OBJ_OFS_DELTA:
+			c, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			back := int64(c & 0x7f)
+			for c&0x80 != 0 {
+				if c, err = r.ReadByte(); err != nil {
+					return nil, err
+				}
+				back = (back+1)<<7 | int64(c&0x7f)
+			}
+			if back <= 0 || back > offset-12 {
+				return nil, fmt.Errorf("bad delta base offset in pack entry at %d", offset)
+			}
+			e.BaseOffset = offset - back
+		case OBJ_REF_DELTA:
+			sha := make([]byte, 20)
+			if _, err := io.ReadFull(r, sha); err != nil {
+				return nil, err
+			}
+			e.BaseSha = fmt.Sprintf("%x", sha)
+		}
+
+		// The reader goes to the end of the zlib stream and no further,
+		// since a bytes.Reader is read a byte at a time.
+		z, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		if e.Data, err = io.ReadAll(z); err != nil {
+			return nil, err
+		}
+		if len(e.Data) != size {
+			return nil, fmt.Errorf("pack entry at %d has the wrong size", offset)
+		}
+		e.crc = crc32.ChecksumIEEE(data[offset:int64(len(data)-r.Len())])
+		entries = append(entries, e)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("pack has %d bytes of garbage", r.Len())
+	}
+	return entries, nil
+}
+
+// appendPackObjects adds whole objects to the end of a pack holding n
+// objects, fixing up its object count and its checksum.
+func appendPackObjects(b []byte, objects map[string]*packObject, n int) ([]byte, error) {
+	out := append([]byte(nil), b[:len(b)-20]...)
+	binary.BigEndian.PutUint32(out[8:12], uint32(n+len(objects)))
+	shas := make([]string, 0, len(objects))
+	for sha := range objects {
+		shas = append(shas, sha)
+	}
+	sort.Strings(shas)
+	for _, sha := range shas {
+		o := objects[sha]
+		o.offset = int64(len(out))
+		entry := appendPackHeader(nil, o.Type, len(o.Content))
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		if _, err := w.Write(o.Content); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		entry = append(entry, z.Bytes()...)
+		o.crc = crc32.ChecksumIEEE(entry)
+		out = append(out, entry...)
+	}
+	checksum := sha1.Sum(out)
+	return append(out, checksum[:]...), nil
+}
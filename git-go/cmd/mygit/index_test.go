@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIndexRoundTrip(t *testing.T) {
+	newTestRepo(t)
+	sha := func(c string) string { return strings.Repeat(c, 40) }
+	idx := &index{}
+	for _, e := range []indexEntry{
+		{MtimeSec: 1700000000, MtimeNsec: 5, Ino: 42, Mode: modeBlob, Size: 3, Sha: sha("a"), Path: "b.txt"},
+		{Mode: modeExecutable, Sha: sha("b"), Path: "a/run.sh"},
+		{Mode: modeSymlink, Sha: sha("c"), Path: "a/link"},
+		// A name whose entry ends exactly on an 8-byte boundary still
+		// gets its NUL padding.
+		{Mode: modeBlob, Sha: sha("d"), Path: strings.Repeat("n", 10)},
+	} {
+		idx.add(e)
+	}
+	idx.setConflict(mergeConflict{Path: "c", Stages: [3]*treeEntry{
+		{Mode: modeBlob, Sha: sha("1")},
+		{Mode: modeBlob, Sha: sha("2")},
+		{Mode: modeBlob, Sha: sha("3")},
+	}})
+	if err := idx.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, idx) {
+		t.Errorf("readIndex = %+v, want %+v", got, idx)
+	}
+	paths := make([]string, 0)
+	for _, e := range got.Entries {
+		paths = append(paths, e.Path)
+	}
+	if want := []string{"a/link", "a/run.sh", "b.txt", "c", "c", "c", "nnnnnnnnnn"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %v, want %v", paths, want)
+	}
+	if u := got.unmerged(); !reflect.DeepEqual(u, []string{"c"}) {
+		t.Errorf("unmerged = %v, want [c]", u)
+	}
+	if _, err := got.writeTree(); err == nil {
+		t.Error("writeTree succeeded with an unmerged path")
+	}
+	if e, ok := got.entry("b.txt"); !ok || e.MtimeNsec != 5 || e.Ino != 42 {
+		t.Errorf("entry(b.txt) = %+v, %v", e, ok)
+	}
+}
+
+func TestParseIndexErrors(t *testing.T) {
+	newTestRepo(t)
+	idx := &index{}
+	idx.add(indexEntry{Mode: modeBlob, Sha: strings.Repeat("a", 40), Path: "file"})
+	if err := idx.write(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := append([]byte(nil), b...)
+	flipped[20] ^= 1
+	for _, test := range []struct {
+		name string
+		b    []byte
+		want string
+	}{
+		{"empty", nil, "index file is corrupt"},
+		{"bad signature", append([]byte("DIRX"), b[4:]...), "index file is corrupt"},
+		{"bad checksum", flipped, "index file checksum mismatch"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parseIndex(test.b); err == nil || err.Error() != test.want {
+				t.Errorf("parseIndex error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
@@ -4,17 +4,48 @@ import (
 	"os"
 )
 
+const defaultConfig = `[core]
+	repositoryformatversion = 0
+	filemode = true
+	bare = false
+	logallrefupdates = true
+`
+
+const defaultExclude = `# git ls-files --others --exclude-from=.git/info/exclude
+# Lines that start with '#' are comments.
+# For a project mostly in C, the following would be a good set of
+# exclude patterns (uncomment them if you want to use them):
+# *.[oa]
+# *~
+`
+
 func initGit() error {
-	for _, dir := range []string{".git", ".git/objects", ".git/refs"} {
+	for _, dir := range []string{".git", ".git/objects", ".git/refs", ".git/info"} {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return err
 		}
 	}
 
-	headFileContents := []byte("ref: refs/heads/master\n")
+	branch := "master"
+	if b, ok := configValue("init.defaultBranch"); ok && b != "" {
+		branch = b
+	}
+	headFileContents := []byte("ref: refs/heads/" + branch + "\n")
 	if err := os.WriteFile(".git/HEAD", headFileContents, 0644); err != nil {
 		return err
 	}
 
+	if _, err := os.Stat(".git/config"); os.IsNotExist(err) {
+		if err := os.WriteFile(".git/config", []byte(defaultConfig), 0644); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(".git/info/exclude"); os.IsNotExist(err) {
+		if err := os.WriteFile(".git/info/exclude", []byte(defaultExclude), 0644); err != nil {
+			return err
+		}
+	}
+	forgetConfig()
+
 	return nil
 }
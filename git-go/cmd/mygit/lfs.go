@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Notes about LFS:
+// - Large files get the filter=lfs attribute. Without an lfs driver in the
+//   config they are handled here the way git-lfs does it: cleaning moves
+//   the content to .git/lfs/objects, named by its SHA-256, and stores a
+//   small pointer file instead; smudging swaps the pointer back.
+// - Pointers whose content is not in .git/lfs/objects are checked out as
+//   they are, there is no LFS server to download it from.
+// - Content that already is a pointer is stored unchanged.
+
+const lfsPointerVersion = "https://git-lfs.github.com/spec/v1"
+
+// lfsPointerMax is the size above which content is never taken for a
+// pointer.
+const lfsPointerMax = 1024
+
+type lfsPointer struct {
+	Oid  string // hex SHA-256 of the content
+	Size int64
+}
+
+func (p lfsPointer) bytes() []byte {
+	return []byte(fmt.Sprintf("version %s\noid sha256:%s\nsize %d\n", lfsPointerVersion, p.Oid, p.Size))
+}
+
+func (p lfsPointer) path() string {
+	return path.Join(".git/lfs/objects", p.Oid[:2], p.Oid[2:4], p.Oid)
+}
+
+// parseLFSPointer recognizes pointer files: "key value" lines starting
+// with the version, and giving at least the oid and the size.
+func parseLFSPointer(b []byte) (lfsPointer, bool) {
+	var p lfsPointer
+	if len(b) > lfsPointerMax || !bytes.HasPrefix(b, []byte("version "+lfsPointerVersion+"\n")) {
+		return p, false
+	}
+	size := false
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")[1:] {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return p, false
+		}
+		key, value := line[:i], line[i+1:]
+		switch key {
+		case "oid":
+			if !strings.HasPrefix(value, "sha256:") {
+				return p, false
+			}
+			oid := strings.TrimPrefix(value, "sha256:")
+			if _, err := hex.DecodeString(oid); len(oid) != 64 || err != nil {
+				return p, false
+			}
+			p.Oid = oid
+		case "size":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return p, false
+			}
+			p.Size, size = n, true
+		}
+	}
+	return p, p.Oid != "" && size
+}
+
+// lfsClean returns the pointer for content, keeping the content itself
+// under .git/lfs when it is about to be stored.
+func lfsClean(b []byte, write bool) ([]byte, error) {
+	if _, ok := parseLFSPointer(b); ok {
+		return b, nil
+	}
+	sum := sha256.Sum256(b)
+	p := lfsPointer{Oid: hex.EncodeToString(sum[:]), Size: int64(len(b))}
+	if !write {
+		return p.bytes(), nil
+	}
+
+	if _, err := os.Stat(p.path()); err == nil {
+		return p.bytes(), nil
+	}
+	if err := os.MkdirAll(path.Dir(p.path()), 0755); err != nil {
+		return nil, err
+	}
+	tmp := p.path() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, p.path()); err != nil {
+		return nil, err
+	}
+	return p.bytes(), nil
+}
+
+// lfsSmudge returns the content a pointer stands for, or the pointer when
+// the content is not there.
+func lfsSmudge(b []byte) ([]byte, error) {
+	p, ok := parseLFSPointer(b)
+	if !ok {
+		return b, nil
+	}
+	content, err := os.ReadFile(p.path())
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	if int64(len(content)) != p.Size || hex.EncodeToString(sum[:]) != p.Oid {
+		return nil, fmt.Errorf("LFS object %s is corrupt", p.Oid)
+	}
+	return content, nil
+}
@@ -7,9 +7,18 @@ import (
 
 // Usage: your_git.sh <command> <arg1> <arg2> ...
 func main() {
+	// "-c name=value" options come before the command.
+	for len(os.Args) > 2 && os.Args[1] == "-c" {
+		configParameters = append(configParameters, os.Args[2])
+		os.Args = append(os.Args[:1], os.Args[3:]...)
+	}
 	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "usage: mygit <command> [<args>...]\n")
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "usage: mygit [-c <name>=<value>] <command> [<args>...]\n")
+		exit(1)
+	}
+	if _, err := readConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config: %s\n", err)
+		exit(128)
 	}
 
 	switch command := os.Args[1]; command {
@@ -17,7 +26,7 @@ func main() {
 		err := initGit()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error initializing git: %s\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 		fmt.Println("Initialized git directory")
 
@@ -26,25 +35,31 @@ func main() {
 		b, err := catFile(sha)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error reading file content: %s\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 		fmt.Print(string(b))
 
 	case "hash-object":
-		filepath := os.Args[3]
-		checksum, err := writeBlob(filepath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing blob object: %s\n", err)
-			os.Exit(1)
+		write := false
+		for _, arg := range os.Args[2:] {
+			if arg == "-w" {
+				write = true
+				continue
+			}
+			checksum, err := hashFile(arg, write)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing blob object: %s\n", err)
+				exit(1)
+			}
+			fmt.Printf("%x\n", checksum)
 		}
-		fmt.Printf("%x\n", checksum)
 
 	case "ls-tree":
 		sha := os.Args[3]
 		names, err := lsTree(sha)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error listing tree: %s\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 		for _, b := range names {
 			fmt.Println(string(b))
@@ -54,13 +69,13 @@ func main() {
 		wd, err := os.Getwd()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error getting current directory: %s\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 
 		checksum, err := writeTree(wd)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing tree object: %s\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 		fmt.Printf("%x\n", checksum)
 
@@ -71,7 +86,7 @@ func main() {
 		checksum, err := writeCommit(treeSha, commitSha, msg)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing commit object: %s\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 		fmt.Printf("%x\n", checksum)
 
@@ -81,11 +96,234 @@ func main() {
 		err := clone(url, dir)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error cloning repository: %s\n", err)
-			os.Exit(1)
+			exit(1)
+		}
+
+	case "bundle":
+		if err := bundleCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error running bundle: %s\n", err)
+			exit(128)
+		}
+
+	case "fetch":
+		ok, err := fetchCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching: %s\n", err)
+			exit(128)
+		}
+		if !ok {
+			exit(1)
+		}
+
+	case "push":
+		ok, err := pushCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error pushing: %s\n", err)
+			exit(128)
+		}
+		if !ok {
+			exit(1)
+		}
+
+	case "pull":
+		clean, err := pullCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error pulling: %s\n", err)
+			exit(128)
+		}
+		if !clean {
+			exit(1)
+		}
+
+	case "merge-base":
+		bases, err := mergeBaseCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finding merge base: %s\n", err)
+			exit(1)
+		}
+		if len(bases) == 0 {
+			exit(1)
+		}
+		for _, sha := range bases {
+			fmt.Println(sha)
+		}
+
+	case "merge-tree":
+		clean, err := mergeTreeCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error merging trees: %s\n", err)
+			exit(128)
+		}
+		if !clean {
+			exit(1)
+		}
+
+	case "add":
+		err := addCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error adding files: %s\n", err)
+			exit(1)
+		}
+
+	case "status":
+		err := statusCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading status: %s\n", err)
+			exit(1)
+		}
+
+	case "commit":
+		summary, err := commitCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error committing: %s\n", err)
+			exit(1)
+		}
+		fmt.Println(summary)
+
+	case "merge":
+		clean, err := mergeCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error merging: %s\n", err)
+			exit(128)
+		}
+		if !clean {
+			exit(1)
+		}
+
+	case "cherry-pick", "revert":
+		clean, err := cherryPickCommand(os.Args[2:], command == "revert")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error applying commits: %s\n", err)
+			exit(128)
+		}
+		if !clean {
+			exit(1)
+		}
+
+	case "reset":
+		if err := resetCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error resetting: %s\n", err)
+			exit(128)
+		}
+
+	case "stash":
+		clean, err := stashCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error stashing: %s\n", err)
+			exit(1)
+		}
+		if !clean {
+			exit(1)
+		}
+
+	case "repack":
+		if err := repackCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error repacking: %s\n", err)
+			exit(1)
+		}
+
+	case "gc":
+		if err := gcCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error collecting garbage: %s\n", err)
+			exit(1)
+		}
+
+	case "fsck":
+		ok, err := fsckCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking objects: %s\n", err)
+			exit(1)
+		}
+		if !ok {
+			exit(1)
+		}
+
+	case "count-objects":
+		if err := countObjectsCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error counting objects: %s\n", err)
+			exit(1)
+		}
+
+	case "verify-pack":
+		ok, err := verifyPackCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error verifying pack: %s\n", err)
+			exit(1)
+		}
+		if !ok {
+			exit(1)
+		}
+
+	case "config":
+		status, err := configCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring: %s\n", err)
+		}
+		exit(status)
+
+	case "check-ignore":
+		found, err := checkIgnoreCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking ignore rules: %s\n", err)
+			exit(128)
+		}
+		if !found {
+			exit(1)
+		}
+
+	case "check-attr":
+		if err := checkAttrCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking attributes: %s\n", err)
+			exit(1)
+		}
+
+	case "blame":
+		if err := blameCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error blaming: %s\n", err)
+			exit(128)
+		}
+
+	case "archive":
+		if err := archiveCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating archive: %s\n", err)
+			exit(128)
+		}
+
+	case "grep":
+		found, err := grepCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error searching: %s\n", err)
+			exit(128)
+		}
+		if !found {
+			exit(1)
+		}
+
+	case "rebase":
+		clean, err := rebaseCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error rebasing: %s\n", err)
+			exit(128)
+		}
+		if !clean {
+			exit(1)
 		}
 
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command %s\n", command)
-		os.Exit(1)
+		exit(1)
+	}
+	exit(0)
+}
+
+// exit stops the filter processes still running before exiting. One that
+// fails turns a success into an error.
+func exit(code int) {
+	if err := stopFilterProcesses(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		if code == 0 {
+			code = 128
+		}
 	}
+	os.Exit(code)
 }
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type mergeOptions struct {
+	FFOnly bool
+	NoFF   bool
+	Squash bool
+	Abort  bool
+
+	Message string
+}
+
+// mergeCommand implements "merge". It reports whether the merge completed
+// without conflicts.
+func mergeCommand(args []string) (bool, error) {
+	opts := mergeOptions{}
+	revs := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--ff-only":
+			opts.FFOnly = true
+		case "--no-ff":
+			opts.NoFF = true
+		case "--ff":
+			opts.NoFF = false
+		case "--squash":
+			opts.Squash = true
+		case "--abort":
+			opts.Abort = true
+		case "-m":
+			if i+1 == len(args) {
+				return false, errors.New("option -m requires a value")
+			}
+			i++
+			opts.Message = args[i]
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return false, fmt.Errorf("unknown option %s", arg)
+			}
+			revs = append(revs, arg)
+		}
+	}
+
+	if opts.Abort {
+		return true, mergeAbort()
+	}
+	if len(revs) != 1 {
+		return false, errors.New("usage: mygit merge [--ff-only | --no-ff] [--squash] [-m <msg>] <commit> | --abort")
+	}
+	if opts.Squash && opts.NoFF {
+		return false, errors.New("options --squash and --no-ff cannot be used together")
+	}
+	return merge(revs[0], opts)
+}
+
+func merge(rev string, opts mergeOptions) (bool, error) {
+	if isMerging() {
+		return false, errors.New("You have not concluded your merge (MERGE_HEAD exists).\nPlease, commit your changes before you merge.")
+	}
+
+	theirs, err := resolveCommit(rev)
+	if err != nil {
+		return false, err
+	}
+	head, err := headCommit()
+	if err != nil {
+		return false, err
+	}
+	idx, err := readIndex()
+	if err != nil {
+		return false, err
+	}
+	if len(idx.unmerged()) > 0 {
+		return false, errors.New("Merging is not possible because you have unmerged files.")
+	}
+
+	headTree, err := headFiles()
+	if err != nil {
+		return false, err
+	}
+	theirsTree, err := commitFiles(theirs)
+	if err != nil {
+		return false, err
+	}
+
+	if head == "" {
+		// Nothing to merge into, the branch simply starts at theirs.
+		if err := checkoutFiles(idx, headTree, theirsTree, false, "merge"); err != nil {
+			return false, err
+		}
+		if err := idx.write(); err != nil {
+			return false, err
+		}
+		return true, updateHead(theirs)
+	}
+
+	upToDate, err := isAncestor(theirs, head)
+	if err != nil {
+		return false, err
+	}
+	if upToDate {
+		fmt.Println("Already up to date.")
+		return true, nil
+	}
+
+	canFastForward, err := isAncestor(head, theirs)
+	if err != nil {
+		return false, err
+	}
+	if canFastForward && !opts.NoFF && !opts.Squash {
+		fmt.Printf("Updating %s..%s\n", head[:7], theirs[:7])
+		fmt.Println("Fast-forward")
+		if err := checkoutFiles(idx, headTree, theirsTree, false, "merge"); err != nil {
+			return false, err
+		}
+		if err := idx.write(); err != nil {
+			return false, err
+		}
+		if err := updateRef("ORIG_HEAD", head); err != nil {
+			return false, err
+		}
+		return true, updateHead(theirs)
+	}
+	if opts.FFOnly && !canFastForward {
+		return false, errors.New("Not possible to fast-forward, aborting.")
+	}
+
+	r, err := mergeCommits(head, theirs, treeMergeOptions{OursLabel: "HEAD", TheirsLabel: rev})
+	if err != nil {
+		return false, err
+	}
+	if err := applyMergeResult(idx, headTree, r, "merge"); err != nil {
+		return false, err
+	}
+	for _, msg := range r.Messages {
+		fmt.Println(msg)
+	}
+	if err := updateRef("ORIG_HEAD", head); err != nil {
+		return false, err
+	}
+
+	msg := opts.Message
+	if msg == "" {
+		msg, err = mergeMessage(rev)
+		if err != nil {
+			return false, err
+		}
+	}
+	msg = strings.TrimRight(msg, "\n") + "\n"
+
+	if opts.Squash {
+		squashMsg, err := squashMessage(head, theirs)
+		if err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(".git/SQUASH_MSG", []byte(squashMsg), 0644); err != nil {
+			return false, err
+		}
+		if r.Clean() {
+			fmt.Println("Squash commit -- not updating HEAD")
+			return true, nil
+		}
+		fmt.Println("Automatic merge failed; fix conflicts and then commit the result.")
+		return false, nil
+	}
+
+	if r.Clean() {
+		sha, err := newCommit(r.Tree, []string{head, theirs}, msg)
+		if err != nil {
+			return false, err
+		}
+		fmt.Println("Merge made by the 'ort' strategy.")
+		return true, updateHead(sha)
+	}
+
+	if err := writeMergeState(theirs, msg, r, opts); err != nil {
+		return false, err
+	}
+	fmt.Println("Automatic merge failed; fix conflicts and then commit the result.")
+	return false, nil
+}
+
+// applyMergeResult updates the index and the working tree from HEAD to the
+// result of a merge, leaving conflicted paths in stages 1 to 3.
+func applyMergeResult(idx *index, head map[string]treeEntry, r *treeMergeResult, action string) error {
+	// The result is committed on top of HEAD, so staged changes would leak
+	// into it.
+	if err := checkOverwrite(idx, head, stagedPaths(idx, head), action); err != nil {
+		return err
+	}
+	if err := checkoutFiles(idx, head, r.Files, false, action); err != nil {
+		return err
+	}
+	for _, c := range r.Conflicts {
+		idx.setConflict(c)
+	}
+	return idx.write()
+}
+
+// stagedPaths returns the paths whose index entry differs from HEAD.
+func stagedPaths(idx *index, head map[string]treeEntry) []string {
+	paths := make([]string, 0)
+	for p, e := range idx.files() {
+		if h, ok := head[p]; !ok || h != e {
+			paths = append(paths, p)
+		}
+	}
+	for p := range head {
+		if _, ok := idx.entry(p); !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// writeMergeState records an unfinished merge so that "commit" can conclude
+// it and "merge --abort" can undo it.
+func writeMergeState(theirs, msg string, r *treeMergeResult, opts mergeOptions) error {
+	if err := os.WriteFile(".git/MERGE_HEAD", []byte(theirs+"\n"), 0644); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(".git/MERGE_MSG", []byte(conflictMessage(msg, r)), 0644); err != nil {
+		return err
+	}
+
+	mode := ""
+	if opts.NoFF {
+		mode = "no-ff"
+	}
+	return os.WriteFile(".git/MERGE_MODE", []byte(mode), 0644)
+}
+
+func mergeAbort() error {
+	if !isMerging() {
+		return errors.New("There is no merge to abort (MERGE_HEAD missing).")
+	}
+
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	head, err := headFiles()
+	if err != nil {
+		return err
+	}
+	if err := resetMerge(idx, head); err != nil {
+		return err
+	}
+	if err := idx.write(); err != nil {
+		return err
+	}
+	for _, name := range []string{"MERGE_HEAD", "MERGE_MSG", "MERGE_MODE"} {
+		os.Remove(".git/" + name)
+	}
+	return nil
+}
+
+func mergeMessage(rev string) (string, error) {
+	msg := fmt.Sprintf("Merge commit '%s'", rev)
+	for _, kind := range []struct{ prefix, name string }{
+		{"refs/heads/", "branch"},
+		{"refs/remotes/", "remote-tracking branch"},
+		{"refs/tags/", "tag"},
+	} {
+		name := strings.TrimPrefix(rev, kind.prefix)
+		if _, err := resolveRef(kind.prefix + name); err == nil {
+			msg = fmt.Sprintf("Merge %s '%s'", kind.name, name)
+			break
+		}
+	}
+
+	branch, err := currentBranch()
+	if err != nil {
+		return "", err
+	}
+	if branch != "" && branch != "main" && branch != "master" {
+		msg += " into " + branch
+	}
+	return msg, nil
+}
+
+// squashMessage lists the commits a squash merge brings in.
+func squashMessage(head, theirs string) (string, error) {
+	merged, err := ancestors(head)
+	if err != nil {
+		return "", err
+	}
+	incoming, err := ancestors(theirs)
+	if err != nil {
+		return "", err
+	}
+
+	shas := make([]string, 0)
+	for sha := range incoming {
+		if !merged[sha] {
+			shas = append(shas, sha)
+		}
+	}
+	shas, err = sortByDate(shas)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("Squashed commit of the following:\n")
+	for _, sha := range shas {
+		c, err := readCommit(sha)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "\ncommit %s\nAuthor: %s\n\n", sha, signatureIdent(c.Author))
+		for _, line := range strings.Split(strings.TrimRight(c.Message, "\n"), "\n") {
+			fmt.Fprintf(&b, "    %s\n", line)
+		}
+	}
+	return b.String(), nil
+}
+
+// commitFiles returns the flattened tree of a commit.
+func commitFiles(sha string) (map[string]treeEntry, error) {
+	tree, err := peel(sha, "tree")
+	if err != nil {
+		return nil, err
+	}
+	return flattenTree(tree)
+}
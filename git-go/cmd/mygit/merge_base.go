@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// ancestors returns every commit reachable from the given commits, including
+// the commits themselves.
+func ancestors(shas ...string) (map[string]bool, error) {
+	seen := make(map[string]bool)
+	queue := append([]string(nil), shas...)
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if seen[sha] {
+			continue
+		}
+		seen[sha] = true
+
+		c, err := readCommit(sha)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, c.Parents...)
+	}
+	return seen, nil
+}
+
+func isAncestor(ancestor, descendant string) (bool, error) {
+	reachable, err := ancestors(descendant)
+	if err != nil {
+		return false, err
+	}
+	return reachable[ancestor], nil
+}
+
+// mergeBases returns the best common ancestors of one commit and a
+// hypothetical merge of all the others, newest first.
+func mergeBases(one string, others ...string) ([]string, error) {
+	if len(others) == 0 {
+		return nil, errors.New("need at least two commits")
+	}
+
+	a, err := ancestors(one)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ancestors(others...)
+	if err != nil {
+		return nil, err
+	}
+
+	common := make([]string, 0)
+	for sha := range a {
+		if b[sha] {
+			common = append(common, sha)
+		}
+	}
+	return reduceCommits(common)
+}
+
+// reduceCommits drops every commit that is an ancestor of another one in the
+// list and sorts the rest by committer date, newest first.
+func reduceCommits(shas []string) ([]string, error) {
+	var parents []string
+	for _, sha := range shas {
+		c, err := readCommit(sha)
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, c.Parents...)
+	}
+
+	// Everything reachable from a parent of a candidate is an ancestor of
+	// that candidate, so it cannot be one of the best.
+	redundant, err := ancestors(parents...)
+	if err != nil {
+		return nil, err
+	}
+
+	best := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, sha := range shas {
+		if !redundant[sha] && !seen[sha] {
+			seen[sha] = true
+			best = append(best, sha)
+		}
+	}
+	return sortByDate(best)
+}
+
+// octopusMergeBases returns the common ancestors of all the given commits.
+func octopusMergeBases(shas []string) ([]string, error) {
+	result := []string{shas[0]}
+	for _, next := range shas[1:] {
+		candidates := make([]string, 0)
+		for _, sha := range result {
+			bases, err := mergeBases(sha, next)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, bases...)
+		}
+
+		var err error
+		result, err = reduceCommits(candidates)
+		if err != nil {
+			return nil, err
+		}
+		if len(result) == 0 {
+			break
+		}
+	}
+	return result, nil
+}
+
+func sortByDate(shas []string) ([]string, error) {
+	times := make(map[string]int64)
+	for _, sha := range shas {
+		c, err := readCommit(sha)
+		if err != nil {
+			return nil, err
+		}
+		times[sha] = c.Time()
+	}
+	sort.SliceStable(shas, func(i, j int) bool {
+		if times[shas[i]] != times[shas[j]] {
+			return times[shas[i]] > times[shas[j]]
+		}
+		return shas[i] < shas[j]
+	})
+	return shas, nil
+}
+
+func mergeBaseCommand(args []string) ([]string, error) {
+	all, octopus := false, false
+	revs := make([]string, 0)
+	for _, arg := range args {
+		switch arg {
+		case "-a", "--all":
+			all = true
+		case "--octopus":
+			octopus = true
+		default:
+			sha, err := resolveCommit(arg)
+			if err != nil {
+				return nil, err
+			}
+			revs = append(revs, sha)
+		}
+	}
+
+	if len(revs) < 2 && !(octopus && len(revs) == 1) {
+		return nil, errors.New("usage: mygit merge-base [-a | --all] [--octopus] <commit> <commit>...")
+	}
+
+	var bases []string
+	var err error
+	if octopus {
+		bases, err = octopusMergeBases(revs)
+	} else {
+		bases, err = mergeBases(revs[0], revs[1:]...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !all && len(bases) > 1 {
+		bases = bases[:1]
+	}
+	return bases, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+const conflictMarkerSize = 7
+
+type mergeFileOptions struct {
+	OursLabel   string
+	BaseLabel   string
+	TheirsLabel string
+
+	// Style is either "merge" or "diff3". The diff3 style also shows the
+	// base version of conflicting regions.
+	Style string
+}
+
+// mergeFile performs a line based three-way merge of ours and theirs against
+// their common base. Conflicting regions are wrapped in conflict markers and
+// the number of conflicts is returned along with the merged text.
+func mergeFile(base, ours, theirs []byte, opts mergeFileOptions) ([]byte, int) {
+	b := splitLines(base)
+	o := splitLines(ours)
+	t := splitLines(theirs)
+
+	type sideHunk struct {
+		diffHunk
+		theirs bool
+	}
+
+	hunks := make([]sideHunk, 0)
+	oh, th := diffLines(b, o), diffLines(b, t)
+	for len(oh) > 0 || len(th) > 0 {
+		if len(th) == 0 || (len(oh) > 0 && oh[0].A0 <= th[0].A0) {
+			hunks = append(hunks, sideHunk{oh[0], false})
+			oh = oh[1:]
+		} else {
+			hunks = append(hunks, sideHunk{th[0], true})
+			th = th[1:]
+		}
+	}
+
+	var out bytes.Buffer
+	conflicts := 0
+	pos := 0
+	for i := 0; i < len(hunks); {
+		// Group hunks from both sides that touch the same base lines.
+		lo, hi := hunks[i].A0, hunks[i].A1
+		j := i + 1
+		for j < len(hunks) && hunks[j].A0 <= hi {
+			if hunks[j].A1 > hi {
+				hi = hunks[j].A1
+			}
+			j++
+		}
+		group := hunks[i:j]
+		i = j
+
+		for _, l := range b[pos:lo] {
+			out.WriteString(l)
+		}
+		pos = hi
+
+		// side returns what one side turned base lines [lo, hi) into.
+		side := func(theirs bool, lines []string) ([]string, bool) {
+			var first, last *sideHunk
+			for k := range group {
+				if group[k].theirs == theirs {
+					if first == nil {
+						first = &group[k]
+					}
+					last = &group[k]
+				}
+			}
+			if first == nil {
+				return b[lo:hi], false
+			}
+			return lines[first.B0-(first.A0-lo) : last.B1+(hi-last.A1)], true
+		}
+		oursLines, oursChanged := side(false, o)
+		theirsLines, theirsChanged := side(true, t)
+
+		switch {
+		case !theirsChanged:
+			writeLines(&out, oursLines)
+		case !oursChanged:
+			writeLines(&out, theirsLines)
+		case equalLines(oursLines, theirsLines):
+			writeLines(&out, oursLines)
+		default:
+			conflicts++
+			writeConflict(&out, b[lo:hi], oursLines, theirsLines, opts)
+		}
+	}
+	for _, l := range b[pos:] {
+		out.WriteString(l)
+	}
+
+	return out.Bytes(), conflicts
+}
+
+func writeConflict(out *bytes.Buffer, base, ours, theirs []string, opts mergeFileOptions) {
+	// Lines both sides agree on at the edges of the conflict are kept out of
+	// the markers, as long as the base is not shown.
+	if opts.Style != "diff3" {
+		pre := 0
+		for pre < len(ours) && pre < len(theirs) && ours[pre] == theirs[pre] {
+			pre++
+		}
+		writeLines(out, ours[:pre])
+		ours, theirs = ours[pre:], theirs[pre:]
+
+		suf := 0
+		for suf < len(ours) && suf < len(theirs) &&
+			ours[len(ours)-1-suf] == theirs[len(theirs)-1-suf] {
+			suf++
+		}
+		defer writeLines(out, ours[len(ours)-suf:])
+		ours, theirs = ours[:len(ours)-suf], theirs[:len(theirs)-suf]
+	}
+
+	marker := func(c byte, label string) {
+		out.WriteString(strings.Repeat(string(c), conflictMarkerSize))
+		if label != "" {
+			out.WriteString(" " + label)
+		}
+		out.WriteByte('\n')
+	}
+
+	marker('<', opts.OursLabel)
+	writeLinesTerminated(out, ours)
+	if opts.Style == "diff3" {
+		marker('|', opts.BaseLabel)
+		writeLinesTerminated(out, base)
+	}
+	marker('=', "")
+	writeLinesTerminated(out, theirs)
+	marker('>', opts.TheirsLabel)
+}
+
+func writeLines(out *bytes.Buffer, lines []string) {
+	for _, l := range lines {
+		out.WriteString(l)
+	}
+}
+
+// writeLinesTerminated writes lines and makes sure the last one ends with a
+// newline, so that a conflict marker can follow it.
+func writeLinesTerminated(out *bytes.Buffer, lines []string) {
+	writeLines(out, lines)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out.WriteByte('\n')
+	}
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
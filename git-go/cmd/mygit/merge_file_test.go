@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestMergeFile(t *testing.T) {
+	labels := mergeFileOptions{OursLabel: "ours", BaseLabel: "base", TheirsLabel: "theirs"}
+	diff3 := labels
+	diff3.Style = "diff3"
+	for _, test := range []struct {
+		name               string
+		base, ours, theirs string
+		opts               mergeFileOptions
+		want               string
+		conflicts          int
+	}{
+		{
+			name:   "changes to different lines",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "A\nb\nc\nd\ne\n",
+			theirs: "a\nb\nc\nd\nE\n",
+			opts:   labels,
+			want:   "A\nb\nc\nd\nE\n",
+		},
+		{
+			name:   "same change on both sides",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nB\nc\n",
+			opts:   labels,
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "one side only",
+			base:   "a\nb\n",
+			ours:   "a\nb\n",
+			theirs: "a\nb\nc\n",
+			opts:   labels,
+			want:   "a\nb\nc\n",
+		},
+		{
+			name:      "conflict",
+			base:      "a\nb\nc\n",
+			ours:      "a\nx\nc\n",
+			theirs:    "a\ny\nc\n",
+			opts:      labels,
+			want:      "a\n<<<<<<< ours\nx\n=======\ny\n>>>>>>> theirs\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:      "conflict in diff3 style",
+			base:      "a\nb\nc\n",
+			ours:      "a\nx\nc\n",
+			theirs:    "a\ny\nc\n",
+			opts:      diff3,
+			want:      "a\n<<<<<<< ours\nx\n||||||| base\nb\n=======\ny\n>>>>>>> theirs\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:      "common lines kept out of the markers",
+			base:      "a\nb\nc\n",
+			ours:      "a\nsame\nx\nc\n",
+			theirs:    "a\nsame\ny\nc\n",
+			opts:      labels,
+			want:      "a\nsame\n<<<<<<< ours\nx\n=======\ny\n>>>>>>> theirs\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:      "two conflicts",
+			base:      "1\n2\n3\n4\n5\n",
+			ours:      "one\n2\n3\n4\nfive\n",
+			theirs:    "uno\n2\n3\n4\ncinco\n",
+			opts:      mergeFileOptions{},
+			want:      "<<<<<<<\none\n=======\nuno\n>>>>>>>\n2\n3\n4\n<<<<<<<\nfive\n=======\ncinco\n>>>>>>>\n",
+			conflicts: 2,
+		},
+		{
+			name:      "no newline at the end",
+			base:      "a",
+			ours:      "b",
+			theirs:    "c",
+			opts:      labels,
+			want:      "<<<<<<< ours\nb\n=======\nc\n>>>>>>> theirs\n",
+			conflicts: 1,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, conflicts := mergeFile([]byte(test.base), []byte(test.ours), []byte(test.theirs), test.opts)
+			if string(got) != test.want || conflicts != test.conflicts {
+				t.Errorf("mergeFile = %q, %d conflicts, want %q, %d conflicts", got, conflicts, test.want, test.conflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMergeAbort(t *testing.T) {
+	newTestRepo(t)
+	c1 := testCommit(t, map[string]string{"a": "base\n", "b": "b\n"}, 100)
+	c2 := testCommit(t, map[string]string{"a": "theirs\n", "b": "b\n", "c": "c\n"}, 200, c1)
+	c3 := testCommit(t, map[string]string{"a": "ours\n", "b": "b\n"}, 300, c1)
+
+	if err := reset(c1, "hard"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := mergeCommand([]string{"--ff-only", c2}); err != nil || !ok {
+		t.Fatalf("merge --ff-only = %v, %v", ok, err)
+	}
+	if head, _ := headCommit(); head != c2 {
+		t.Errorf("HEAD after a fast-forward = %s, want %s", head, c2)
+	}
+
+	if err := reset(c3, "hard"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := mergeCommand([]string{c2}); err != nil || ok {
+		t.Fatalf("conflicting merge = %v, %v", ok, err)
+	}
+	idx, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := idx.unmerged(); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("unmerged paths = %q, want [a]", got)
+	}
+	// An unstaged change to a path the merge left alone survives the abort.
+	if err := os.WriteFile("b", []byte("edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := mergeCommand([]string{"--abort"}); err != nil || !ok {
+		t.Fatalf("merge --abort = %v, %v", ok, err)
+	}
+	c, err := readCommit(c3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx, err = readIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := idx.writeTree(); err != nil || got != c.Tree {
+		t.Errorf("index tree after abort = %s, %v; want %s", got, err, c.Tree)
+	}
+	for p, want := range map[string]string{"a": "ours\n", "b": "edited\n", "c": ""} {
+		b, err := os.ReadFile(p)
+		if want == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s exists after abort", p)
+			}
+		} else if string(b) != want {
+			t.Errorf("%s after abort = %q, %v; want %q", p, b, err, want)
+		}
+	}
+	if isMerging() {
+		t.Error("MERGE_HEAD is still there after abort")
+	}
+	if _, err := mergeCommand([]string{"--abort"}); err == nil {
+		t.Error("merge --abort without a merge did not fail")
+	}
+}
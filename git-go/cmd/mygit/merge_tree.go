@@ -0,0 +1,631 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const renameThreshold = 50
+
+type treeMergeOptions struct {
+	OursLabel   string
+	TheirsLabel string
+	BaseLabel   string
+	Style       string
+}
+
+// mergeConflict records the versions of a path that could not be merged,
+// indexed by stage: 0 is the base, 1 is ours and 2 is theirs.
+type mergeConflict struct {
+	Path   string
+	Stages [3]*treeEntry
+}
+
+type treeMergeResult struct {
+	Tree      string
+	Files     map[string]treeEntry
+	Conflicts []mergeConflict
+	Messages  []string
+}
+
+func (r *treeMergeResult) Clean() bool {
+	return len(r.Conflicts) == 0
+}
+
+func (r *treeMergeResult) message(format string, args ...interface{}) {
+	r.Messages = append(r.Messages, fmt.Sprintf(format, args...))
+}
+
+// mergeCommits merges the trees of two commits against their merge bases.
+// When there are several merge bases they are first merged together into a
+// virtual base, the same way git's recursive strategy does.
+func mergeCommits(ours, theirs string, opts treeMergeOptions) (*treeMergeResult, error) {
+	bases, err := mergeBases(ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	baseTree, err := virtualBaseTree(bases)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BaseLabel == "" {
+		if len(bases) == 1 {
+			opts.BaseLabel = bases[0][:7]
+		} else {
+			opts.BaseLabel = "merged common ancestors"
+		}
+	}
+
+	oursTree, err := peel(ours, "tree")
+	if err != nil {
+		return nil, err
+	}
+	theirsTree, err := peel(theirs, "tree")
+	if err != nil {
+		return nil, err
+	}
+	return mergeTrees(baseTree, oursTree, theirsTree, opts)
+}
+
+// virtualBaseTree returns the tree to use as the base of a merge. It is empty
+// when there are no common ancestors.
+func virtualBaseTree(bases []string) (string, error) {
+	if len(bases) == 0 {
+		return "", nil
+	}
+
+	tree, err := peel(bases[0], "tree")
+	if err != nil {
+		return "", err
+	}
+	for i := 1; i < len(bases); i++ {
+		inner, err := mergeBases(bases[i], bases[:i]...)
+		if err != nil {
+			return "", err
+		}
+		innerTree, err := virtualBaseTree(inner)
+		if err != nil {
+			return "", err
+		}
+		next, err := peel(bases[i], "tree")
+		if err != nil {
+			return "", err
+		}
+
+		// Conflicts are kept in the virtual base along with their markers.
+		r, err := mergeTrees(innerTree, tree, next, treeMergeOptions{
+			OursLabel:   "Temporary merge branch 1",
+			TheirsLabel: "Temporary merge branch 2",
+		})
+		if err != nil {
+			return "", err
+		}
+		tree = r.Tree
+	}
+	return tree, nil
+}
+
+// mergeTrees performs a three-way merge of two trees against a base tree. It
+// always produces a tree; conflicting files are written with conflict markers
+// and reported in the result.
+func mergeTrees(base, ours, theirs string, opts treeMergeOptions) (*treeMergeResult, error) {
+	b, err := flattenTree(base)
+	if err != nil {
+		return nil, err
+	}
+	o, err := flattenTree(ours)
+	if err != nil {
+		return nil, err
+	}
+	t, err := flattenTree(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &treeMergeResult{Files: make(map[string]treeEntry)}
+	m := &treeMerger{opts: opts, result: r, base: b, ours: o, theirs: t}
+
+	oursRenames, err := detectRenames(b, o)
+	if err != nil {
+		return nil, err
+	}
+	theirsRenames, err := detectRenames(b, t)
+	if err != nil {
+		return nil, err
+	}
+	m.applyRenames(oursRenames, theirsRenames)
+
+	paths := make(map[string]bool)
+	for _, files := range []map[string]treeEntry{m.base, m.ours, m.theirs} {
+		for p := range files {
+			paths[p] = true
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	for _, p := range sorted {
+		if err := m.mergePath(p); err != nil {
+			return nil, err
+		}
+	}
+	m.resolveDirectoryConflicts()
+
+	sort.SliceStable(r.Conflicts, func(i, j int) bool {
+		return r.Conflicts[i].Path < r.Conflicts[j].Path
+	})
+
+	r.Tree, err = buildTree(r.Files)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+type treeMerger struct {
+	opts   treeMergeOptions
+	result *treeMergeResult
+
+	base, ours, theirs map[string]treeEntry
+
+	// Paths a file had on the side that did not rename it, keyed by the
+	// new name it was moved to.
+	oursOrigin, theirsOrigin map[string]string
+	renameDeleted            map[string]bool
+}
+
+// detectRenames pairs paths deleted from base with paths added on one side,
+// first by identical content and then by similar content.
+func detectRenames(base, side map[string]treeEntry) (map[string]string, error) {
+	renames := make(map[string]string)
+
+	deleted := make([]string, 0)
+	for p, e := range base {
+		if _, ok := side[p]; !ok && e.Type() == "blob" {
+			deleted = append(deleted, p)
+		}
+	}
+	added := make([]string, 0)
+	for p, e := range side {
+		if _, ok := base[p]; !ok && e.Type() == "blob" {
+			added = append(added, p)
+		}
+	}
+	if len(deleted) == 0 || len(added) == 0 {
+		return renames, nil
+	}
+	sort.Strings(deleted)
+	sort.Strings(added)
+
+	used := make(map[string]bool)
+	for _, src := range deleted {
+		for _, dst := range added {
+			if !used[dst] && base[src].Sha == side[dst].Sha {
+				renames[src] = dst
+				used[dst] = true
+				break
+			}
+		}
+	}
+
+	// Inexact detection compares every pair, so give up on huge changes.
+	if len(deleted)*len(added) > 1000*1000 {
+		return renames, nil
+	}
+
+	contents := make(map[string][]byte)
+	content := func(sha string) ([]byte, error) {
+		if b, ok := contents[sha]; ok {
+			return b, nil
+		}
+		b, err := readObjectOfType(sha, "blob")
+		contents[sha] = b
+		return b, err
+	}
+
+	for _, src := range deleted {
+		if _, ok := renames[src]; ok {
+			continue
+		}
+		a, err := content(base[src].Sha)
+		if err != nil {
+			return nil, err
+		}
+
+		best, bestScore := "", renameThreshold-1
+		for _, dst := range added {
+			if used[dst] {
+				continue
+			}
+			b, err := content(side[dst].Sha)
+			if err != nil {
+				return nil, err
+			}
+			if score := lineSimilarity(a, b); score > bestScore {
+				best, bestScore = dst, score
+			}
+		}
+		if best != "" {
+			renames[src] = best
+			used[best] = true
+		}
+	}
+	return renames, nil
+}
+
+// applyRenames moves entries so that a file renamed on one side lines up with
+// the same file on the other side and in the base, under its new name.
+func (m *treeMerger) applyRenames(oursRenames, theirsRenames map[string]string) {
+	m.oursOrigin = make(map[string]string)
+	m.theirsOrigin = make(map[string]string)
+	m.renameDeleted = make(map[string]bool)
+
+	for _, src := range sortedKeys(oursRenames) {
+		dst := oursRenames[src]
+		if other, ok := theirsRenames[src]; ok {
+			if other == dst {
+				m.base[dst] = m.base[src]
+				delete(m.base, src)
+				continue
+			}
+
+			// Renamed to different names on both sides, keep both.
+			m.result.message("CONFLICT (rename/rename): %s renamed to %s in %s and to %s in %s.",
+				src, dst, m.opts.OursLabel, other, m.opts.TheirsLabel)
+			baseEntry := m.base[src]
+			ours, theirs := m.ours[dst], m.theirs[other]
+			m.result.Conflicts = append(m.result.Conflicts,
+				mergeConflict{Path: dst, Stages: [3]*treeEntry{&baseEntry, &ours, nil}},
+				mergeConflict{Path: other, Stages: [3]*treeEntry{&baseEntry, nil, &theirs}},
+			)
+			delete(m.base, src)
+			continue
+		}
+		m.lineUp(src, dst, m.theirs, m.theirsOrigin)
+	}
+
+	for _, src := range sortedKeys(theirsRenames) {
+		if _, ok := oursRenames[src]; ok {
+			continue
+		}
+		dst := theirsRenames[src]
+		m.lineUp(src, dst, m.ours, m.oursOrigin)
+	}
+}
+
+// lineUp handles a file renamed from src to dst on one side by moving the
+// base and the other side's version to dst as well.
+func (m *treeMerger) lineUp(src, dst string, other map[string]treeEntry, otherOrigin map[string]string) {
+	if _, ok := other[dst]; ok {
+		// The other side added its own file under the new name, leave the
+		// two files to the add/add handling.
+		return
+	}
+
+	if e, ok := other[src]; ok {
+		other[dst] = e
+		delete(other, src)
+		otherOrigin[dst] = src
+	} else {
+		m.renameDeleted[dst] = true
+	}
+	m.base[dst] = m.base[src]
+	delete(m.base, src)
+}
+
+func (m *treeMerger) mergePath(p string) error {
+	b, hasBase := m.base[p]
+	o, hasOurs := m.ours[p]
+	t, hasTheirs := m.theirs[p]
+
+	same := func(x treeEntry, hasX bool, y treeEntry, hasY bool) bool {
+		if hasX != hasY {
+			return false
+		}
+		return !hasX || (x.Mode == y.Mode && x.Sha == y.Sha)
+	}
+
+	switch {
+	case same(o, hasOurs, t, hasTheirs):
+		if hasOurs {
+			m.result.Files[p] = o
+		}
+		return nil
+	case same(b, hasBase, o, hasOurs):
+		if hasTheirs {
+			m.result.Files[p] = t
+		}
+		return nil
+	case same(b, hasBase, t, hasTheirs):
+		if hasOurs {
+			m.result.Files[p] = o
+		}
+		return nil
+	}
+
+	var stages [3]*treeEntry
+	if hasBase {
+		stages[0] = &b
+	}
+	if hasOurs {
+		stages[1] = &o
+	}
+	if hasTheirs {
+		stages[2] = &t
+	}
+
+	if !hasOurs || !hasTheirs {
+		kind := "modify/delete"
+		if m.renameDeleted[p] {
+			kind = "rename/delete"
+		}
+		deletedIn, modifiedIn, kept := m.opts.OursLabel, m.opts.TheirsLabel, t
+		if hasOurs {
+			deletedIn, modifiedIn, kept = m.opts.TheirsLabel, m.opts.OursLabel, o
+		}
+		m.result.message("CONFLICT (%s): %s deleted in %s and modified in %s.  Version %s of %s left in tree.",
+			kind, p, deletedIn, modifiedIn, modifiedIn, p)
+		m.result.Files[p] = kept
+		m.result.Conflicts = append(m.result.Conflicts, mergeConflict{Path: p, Stages: stages})
+		return nil
+	}
+
+	if !isRegularFile(o.Mode) || !isRegularFile(t.Mode) ||
+		(hasBase && !isRegularFile(b.Mode)) {
+		m.result.message("CONFLICT (%s): Merge conflict in %s", conflictKind(hasBase), p)
+		m.result.Files[p] = o
+		m.result.Conflicts = append(m.result.Conflicts, mergeConflict{Path: p, Stages: stages})
+		return nil
+	}
+
+	clean := true
+	mode := o.Mode
+	if o.Mode != t.Mode {
+		switch {
+		case hasBase && b.Mode == o.Mode:
+			mode = t.Mode
+		case hasBase && b.Mode == t.Mode:
+			mode = o.Mode
+		default:
+			clean = false
+			m.result.message("CONFLICT (mode): %s has conflicting modes", p)
+		}
+	}
+
+	sha := o.Sha
+	switch {
+	case o.Sha == t.Sha:
+	case hasBase && b.Sha == o.Sha:
+		sha = t.Sha
+	case hasBase && b.Sha == t.Sha:
+	default:
+		m.result.message("Auto-merging %s", p)
+		merged, ok, err := m.mergeContents(p, b, hasBase, o, t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			clean = false
+			m.result.message("CONFLICT (%s): Merge conflict in %s", conflictKind(hasBase), p)
+		}
+		sha = merged
+	}
+
+	m.result.Files[p] = treeEntry{Mode: mode, Sha: sha}
+	if !clean {
+		m.result.Conflicts = append(m.result.Conflicts, mergeConflict{Path: p, Stages: stages})
+	}
+	return nil
+}
+
+// mergeContents merges the contents of a file and writes the resulting blob,
+// which holds conflict markers when the merge is not clean.
+func (m *treeMerger) mergeContents(p string, b treeEntry, hasBase bool, o, t treeEntry) (string, bool, error) {
+	var base []byte
+	if hasBase {
+		var err error
+		base, err = readObjectOfType(b.Sha, "blob")
+		if err != nil {
+			return "", false, err
+		}
+	}
+	ours, err := readObjectOfType(o.Sha, "blob")
+	if err != nil {
+		return "", false, err
+	}
+	theirs, err := readObjectOfType(t.Sha, "blob")
+	if err != nil {
+		return "", false, err
+	}
+
+	if isBinary(base) || isBinary(ours) || isBinary(theirs) {
+		m.result.message("warning: Cannot merge binary files: %s (%s vs. %s)",
+			p, m.opts.OursLabel, m.opts.TheirsLabel)
+		return o.Sha, false, nil
+	}
+
+	// Files renamed on one side are labelled with the path each side had.
+	oursLabel, theirsLabel := m.opts.OursLabel, m.opts.TheirsLabel
+	oursPath, oursMoved := m.oursOrigin[p]
+	theirsPath, theirsMoved := m.theirsOrigin[p]
+	if oursMoved || theirsMoved {
+		if !oursMoved {
+			oursPath = p
+		}
+		if !theirsMoved {
+			theirsPath = p
+		}
+		oursLabel += ":" + oursPath
+		theirsLabel += ":" + theirsPath
+	}
+
+	merged, conflicts := mergeFile(base, ours, theirs, mergeFileOptions{
+		OursLabel:   oursLabel,
+		BaseLabel:   m.opts.BaseLabel,
+		TheirsLabel: theirsLabel,
+		Style:       m.opts.Style,
+	})
+	checksum, err := writeObject("blob", merged)
+	if err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("%x", checksum), conflicts == 0, nil
+}
+
+// resolveDirectoryConflicts moves files out of the way when the merge result
+// also needs a directory of the same name.
+func (m *treeMerger) resolveDirectoryConflicts() {
+	dirs := make(map[string]bool)
+	for p := range m.result.Files {
+		for i := strings.IndexByte(p, '/'); i >= 0; i = nextSlash(p, i) {
+			dirs[p[:i]] = true
+		}
+	}
+
+	for p, e := range m.result.Files {
+		if !dirs[p] {
+			continue
+		}
+		label := m.opts.OursLabel
+		if _, ok := m.ours[p]; !ok {
+			label = m.opts.TheirsLabel
+		}
+		moved := p + "~" + strings.ReplaceAll(label, "/", "_")
+		m.result.message("CONFLICT (file/directory): directory in the way of %s; moving it to %s instead.", p, moved)
+
+		delete(m.result.Files, p)
+		m.result.Files[moved] = e
+		entry := e
+		m.result.Conflicts = append(m.result.Conflicts, mergeConflict{
+			Path:   moved,
+			Stages: [3]*treeEntry{nil, &entry, nil},
+		})
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func nextSlash(p string, i int) int {
+	j := strings.IndexByte(p[i+1:], '/')
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+func isRegularFile(mode int) bool {
+	return mode == modeBlob || mode == modeExecutable
+}
+
+func conflictKind(hasBase bool) string {
+	if hasBase {
+		return "content"
+	}
+	return "add/add"
+}
+
+// mergeTreeCommand implements "merge-tree --write-tree". It prints the merged
+// tree followed, for unclean merges, by the conflicted entries and messages,
+// and reports whether the merge was clean.
+func mergeTreeCommand(args []string) (bool, error) {
+	nameOnly, messages := false, true
+	mergeBase := ""
+	revs := make([]string, 0)
+	for _, arg := range args {
+		switch {
+		case arg == "--write-tree":
+		case arg == "--name-only":
+			nameOnly = true
+		case arg == "--messages":
+			messages = true
+		case arg == "--no-messages":
+			messages = false
+		case strings.HasPrefix(arg, "--merge-base="):
+			mergeBase = strings.TrimPrefix(arg, "--merge-base=")
+		case strings.HasPrefix(arg, "-"):
+			return false, fmt.Errorf("unknown option %s", arg)
+		default:
+			revs = append(revs, arg)
+		}
+	}
+	if len(revs) != 2 {
+		return false, errors.New("usage: mygit merge-tree [--write-tree] [--name-only] [--no-messages] [--merge-base=<commit>] <branch1> <branch2>")
+	}
+
+	opts := treeMergeOptions{OursLabel: revs[0], TheirsLabel: revs[1]}
+
+	var r *treeMergeResult
+	if mergeBase != "" {
+		base, err := resolveTree(mergeBase)
+		if err != nil {
+			return false, err
+		}
+		ours, err := resolveTree(revs[0])
+		if err != nil {
+			return false, err
+		}
+		theirs, err := resolveTree(revs[1])
+		if err != nil {
+			return false, err
+		}
+		opts.BaseLabel = mergeBase
+		r, err = mergeTrees(base, ours, theirs, opts)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		ours, err := resolveCommit(revs[0])
+		if err != nil {
+			return false, err
+		}
+		theirs, err := resolveCommit(revs[1])
+		if err != nil {
+			return false, err
+		}
+		r, err = mergeCommits(ours, theirs, opts)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	fmt.Println(r.Tree)
+	if r.Clean() {
+		return true, nil
+	}
+
+	printed := make(map[string]bool)
+	for _, c := range r.Conflicts {
+		if nameOnly {
+			if !printed[c.Path] {
+				fmt.Println(c.Path)
+				printed[c.Path] = true
+			}
+			continue
+		}
+		for stage, e := range c.Stages {
+			if e != nil {
+				fmt.Printf("%06o %s %d\t%s\n", e.Mode, e.Sha, stage+1, c.Path)
+			}
+		}
+	}
+	if messages {
+		fmt.Println()
+		for _, msg := range r.Messages {
+			fmt.Println(msg)
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMergeBases(t *testing.T) {
+	newTestRepo(t)
+	// A criss-cross history:
+	//
+	//   root - a1 - a2 (merges b1)
+	//       \    X
+	//        b1 - b2 (merges a1)
+	root := testCommit(t, map[string]string{"f": "root\n"}, 100)
+	a1 := testCommit(t, map[string]string{"f": "a1\n"}, 200, root)
+	b1 := testCommit(t, map[string]string{"f": "b1\n"}, 300, root)
+	a2 := testCommit(t, map[string]string{"f": "a2\n"}, 400, a1, b1)
+	b2 := testCommit(t, map[string]string{"f": "b2\n"}, 500, b1, a1)
+	other := testCommit(t, map[string]string{"g": "unrelated\n"}, 600)
+
+	for _, test := range []struct {
+		name        string
+		one, others []string
+		want        []string
+	}{
+		{"ancestor", []string{a1}, []string{root}, []string{root}},
+		{"same commit", []string{a1}, []string{a1}, []string{a1}},
+		{"fork", []string{a1}, []string{b1}, []string{root}},
+		{"criss-cross, newest first", []string{a2}, []string{b2}, []string{b1, a1}},
+		{"unrelated", []string{a1}, []string{other}, []string{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := mergeBases(test.one[0], test.others...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("mergeBases = %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	if ok, err := isAncestor(root, a2); err != nil || !ok {
+		t.Errorf("isAncestor(root, a2) = %v, %v, want true", ok, err)
+	}
+	if ok, err := isAncestor(a2, b2); err != nil || ok {
+		t.Errorf("isAncestor(a2, b2) = %v, %v, want false", ok, err)
+	}
+}
+
+func TestMergeTrees(t *testing.T) {
+	newTestRepo(t)
+	lines := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	for _, test := range []struct {
+		name               string
+		base, ours, theirs map[string]string
+		want               map[string]string
+		conflicts          []string
+	}{
+		{
+			name:   "changes to different files",
+			base:   map[string]string{"a": "a\n", "b": "b\n"},
+			ours:   map[string]string{"a": "A\n", "b": "b\n"},
+			theirs: map[string]string{"a": "a\n", "b": "B\n", "dir/c": "c\n"},
+			want:   map[string]string{"a": "A\n", "b": "B\n", "dir/c": "c\n"},
+		},
+		{
+			name:   "changes to different lines of a file",
+			base:   map[string]string{"f": lines},
+			ours:   map[string]string{"f": "one\n" + lines[2:]},
+			theirs: map[string]string{"f": lines[:18] + "ten\n"},
+			want:   map[string]string{"f": "one\n" + lines[2:18] + "ten\n"},
+		},
+		{
+			name:      "content conflict",
+			base:      map[string]string{"f": "base\n"},
+			ours:      map[string]string{"f": "ours\n"},
+			theirs:    map[string]string{"f": "theirs\n"},
+			want:      map[string]string{"f": "<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n"},
+			conflicts: []string{"f"},
+		},
+		{
+			name:      "modify/delete",
+			base:      map[string]string{"f": "base\n", "g": "g\n"},
+			ours:      map[string]string{"g": "g\n"},
+			theirs:    map[string]string{"f": "changed\n", "g": "g\n"},
+			want:      map[string]string{"f": "changed\n", "g": "g\n"},
+			conflicts: []string{"f"},
+		},
+		{
+			name:   "rename on one side, change on the other",
+			base:   map[string]string{"old": lines},
+			ours:   map[string]string{"new": lines},
+			theirs: map[string]string{"old": lines + "11\n"},
+			want:   map[string]string{"new": lines + "11\n"},
+		},
+		{
+			name:      "file and directory",
+			base:      map[string]string{},
+			ours:      map[string]string{"x": "file\n"},
+			theirs:    map[string]string{"x/y": "in a directory\n"},
+			want:      map[string]string{"x~ours": "file\n", "x/y": "in a directory\n"},
+			conflicts: []string{"x~ours"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := mergeTrees(testTree(t, test.base), testTree(t, test.ours), testTree(t, test.theirs),
+				treeMergeOptions{OursLabel: "ours", TheirsLabel: "theirs"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, err := flattenTree(r.Tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string, len(files))
+			for p, e := range files {
+				b, err := readObjectOfType(e.Sha, "blob")
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[p] = string(b)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("merged files = %q, want %q", got, test.want)
+			}
+
+			conflicts := make([]string, 0)
+			for _, c := range r.Conflicts {
+				conflicts = append(conflicts, c.Path)
+			}
+			sort.Strings(conflicts)
+			if test.conflicts == nil {
+				test.conflicts = []string{}
+			}
+			if !reflect.DeepEqual(conflicts, test.conflicts) {
+				t.Errorf("conflicts = %v, want %v (%v)", conflicts, test.conflicts, r.Messages)
+			}
+			if r.Clean() != (len(test.conflicts) == 0) {
+				t.Errorf("Clean() = %v", r.Clean())
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+func objectPath(sha string) string {
+	return path.Join(".git/objects", sha[:2], sha[2:])
+}
+
+func hasObject(sha string) bool {
+	if len(sha) != 40 {
+		return false
+	}
+	if _, err := os.Stat(objectPath(sha)); err == nil {
+		return true
+	}
+	return hasPackedObject(sha)
+}
+
+// readObject returns the type and the content of an object, loose or
+// packed.
+func readObject(sha string) (string, []byte, error) {
+	if len(sha) != 40 {
+		return "", nil, fmt.Errorf("invalid object name %s", sha)
+	}
+
+	f, err := os.Open(objectPath(sha))
+	if os.IsNotExist(err) {
+		t, content, ok, err := readPackedObject(sha)
+		if err == nil && !ok {
+			err = fmt.Errorf("object %s not found", sha)
+		}
+		return t, content, err
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	r, err := zlib.NewReader(f)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return parseObject(b)
+}
+
+// openObject returns the type and the size of an object with a reader of
+// its content. Loose objects are inflated as they are read, so that large
+// blobs need not be held in memory.
+func openObject(sha string) (string, int64, io.ReadCloser, error) {
+	if len(sha) != 40 {
+		return "", 0, nil, fmt.Errorf("invalid object name %s", sha)
+	}
+
+	f, err := os.Open(objectPath(sha))
+	if os.IsNotExist(err) {
+		t, content, ok, err := readPackedObject(sha)
+		if err == nil && !ok {
+			err = fmt.Errorf("object %s not found", sha)
+		}
+		return t, int64(len(content)), io.NopCloser(bytes.NewReader(content)), err
+	}
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	z, err := zlib.NewReader(f)
+	if err != nil {
+		f.Close()
+		return "", 0, nil, err
+	}
+	obj := objectReader{z: z, f: f}
+	r := bufio.NewReader(z)
+	header, err := r.ReadString(0)
+	if err != nil {
+		obj.Close()
+		return "", 0, nil, errors.New("malformed object header")
+	}
+	header = strings.TrimSuffix(header, "\x00")
+	sp := strings.IndexByte(header, ' ')
+	if sp < 0 {
+		obj.Close()
+		return "", 0, nil, fmt.Errorf("malformed object header %q", header)
+	}
+	n, err := strconv.ParseInt(header[sp+1:], 10, 64)
+	if err != nil {
+		obj.Close()
+		return "", 0, nil, fmt.Errorf("bad object size in header %q", header)
+	}
+	obj.Reader = io.LimitReader(r, n)
+	return header[:sp], n, obj, nil
+}
+
+// objectReader reads the content of a loose object, and closes both the
+// zlib reader and the file.
+type objectReader struct {
+	io.Reader
+	z io.ReadCloser
+	f *os.File
+}
+
+func (r objectReader) Close() error {
+	err := r.z.Close()
+	if ferr := r.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+func parseObject(b []byte) (string, []byte, error) {
+	i := bytes.IndexByte(b, '\x00')
+	if i < 0 {
+		return "", nil, errors.New("malformed object header")
+	}
+
+	header := string(b[:i])
+	sp := bytes.IndexByte(b[:i], ' ')
+	if sp < 0 {
+		return "", nil, fmt.Errorf("malformed object header %q", header)
+	}
+
+	size, err := strconv.Atoi(header[sp+1:])
+	if err != nil || size != len(b)-i-1 {
+		return "", nil, fmt.Errorf("bad object size in header %q", header)
+	}
+
+	return header[:sp], b[i+1:], nil
+}
+
+// readObjectOfType reads an object and makes sure it is of the given type.
+func readObjectOfType(sha, objectType string) ([]byte, error) {
+	t, content, err := readObject(sha)
+	if err != nil {
+		return nil, err
+	}
+	if t != objectType {
+		return nil, fmt.Errorf("object %s is a %s, not a %s", sha, t, objectType)
+	}
+	return content, nil
+}
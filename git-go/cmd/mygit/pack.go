@@ -5,6 +5,7 @@ import (
 	"crypto/sha1"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 )
 
@@ -27,6 +28,34 @@ const (
 	OBJ_REF_DELTA
 )
 
+func (t ObjectType) String() string {
+	switch t {
+	case OBJ_COMMIT:
+		return "commit"
+	case OBJ_TREE:
+		return "tree"
+	case OBJ_BLOB:
+		return "blob"
+	case OBJ_TAG:
+		return "tag"
+	case OBJ_OFS_DELTA:
+		return "ofs-delta"
+	case OBJ_REF_DELTA:
+		return "ref-delta"
+	}
+	return fmt.Sprintf("unknown type %d", int(t))
+}
+
+// objectTypeOf returns the pack type of a named object type.
+func objectTypeOf(name string) ObjectType {
+	for t := OBJ_COMMIT; t <= OBJ_TAG; t++ {
+		if t.String() == name {
+			return t
+		}
+	}
+	return 0
+}
+
 type pack struct {
 	Signature  []byte
 	Version    int
@@ -36,20 +65,18 @@ type pack struct {
 }
 
 func parsePack(b []byte) (*pack, error) {
+	// The header takes 12 bytes and the checksum 20.
+	if len(b) < 32 || string(b[:4]) != "PACK" {
+		return nil, errors.New("not a pack file")
+	}
 	p := pack{}
 	p.Signature = b[:4]
 
-	v, ok := binary.Varint(b[4:8])
-	if ok <= 0 {
-		return nil, errors.New("cannot read version number")
+	p.Version = int(binary.BigEndian.Uint32(b[4:8]))
+	if p.Version != 2 && p.Version != 3 {
+		return nil, fmt.Errorf("unsupported pack version %d", p.Version)
 	}
-	p.Version = int(v)
-
-	n, ok := binary.Varint(b[8:12])
-	if ok <= 0 {
-		return nil, errors.New("cannot read number of objects")
-	}
-	p.NumObjects = int(n)
+	p.NumObjects = int(binary.BigEndian.Uint32(b[8:12]))
 
 	checksum := sha1.Sum(b[:len(b)-20])
 	if !bytes.Equal(checksum[:], b[len(b)-20:]) {
@@ -61,57 +88,25 @@ func parsePack(b []byte) (*pack, error) {
 	return &p, nil
 }
 
-func unpack(dir string, p *pack) error {
-	r := bytes.NewReader(p.Data)
-
-	for r.Len() > 0 {
-		size, objType := parseHeader(r)
-		_ = size
-
-		// Remove this
-		io.ReadAll(r)
-
-		switch objType {
-		case OBJ_COMMIT:
-			// TODO: decompress commit
-
-		case OBJ_TREE:
-			// TODO: decompress tree
-
-		case OBJ_BLOB:
-			// TODO: decompress blob
-
-		case OBJ_OFS_DELTA:
-			// TODO: decompress ofs delta
-
-		case OBJ_REF_DELTA:
-			// TODO: decompress ref delta
-		}
-	}
-
-	return nil
-}
-
-func parseHeader(r *bytes.Reader) (int, ObjectType) {
+// parseHeader reads the type and the size at the start of a pack entry.
+func parseHeader(r *bytes.Reader) (int, ObjectType, error) {
 	b, err := r.ReadByte()
 	if err != nil {
-		panic(err)
+		return 0, 0, errors.New("truncated pack entry header")
 	}
 
 	objType := ObjectType((b & 0b01110000) >> 4)
 
-	var val int
-	haed := int(b) & 0b00001111
-	val = haed
+	val := int(b) & 0b00001111
 	if int(b)&128 != 0 {
 		tail, err := parseVarint(r)
 		if err != nil {
-			panic(err)
+			return 0, 0, errors.New("truncated pack entry header")
 		}
-		val += (tail << 4) + haed
+		val += tail << 4
 	}
 
-	return val, objType
+	return val, objType, nil
 }
 
 // Source: https://github.com/ChimeraCoder/gitgo/blob/master/delta.go
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Notes about pack indexes (version 2):
+// - The first 4 bytes are "\377tOc" and the next 4 the version number.
+// - A fan-out table of 256 counts follows: entry i is the number of objects
+//   whose first byte is at most i.
+// - Then come the sorted object names, a CRC-32 of each packed entry, and a
+//   4-byte offset of each entry. Offsets with the high bit set index a table
+//   of 8-byte offsets for packs over 2GiB.
+// - The pack checksum and a checksum of the index end the file.
+
+const packDir = ".git/objects/pack"
+
+var packIndexMagic = []byte{0xff, 't', 'O', 'c'}
+
+type packIndex struct {
+	PackPath string
+	Shas     []string
+	Offsets  []int64
+	CRCs     []uint32
+	Checksum [20]byte
+
+	// mu guards data and cache, so that objects can be read from several
+	// goroutines.
+	mu    sync.Mutex
+	data  []byte
+	cache map[int64]packedObject
+}
+
+type packedObject struct {
+	Type    string
+	Content []byte
+}
+
+// packEntry describes an entry of a pack as it is stored, before deltas are
+// resolved.
+type packEntry struct {
+	Type       ObjectType
+	Size       int
+	DataOffset int64
+	BaseOffset int64
+	BaseSha    string
+}
+
+var (
+	loadedPacks []*packIndex
+	packsMu     sync.Mutex
+)
+
+// readPackIndexes loads the index of every pack in the repository once.
+func readPackIndexes() ([]*packIndex, error) {
+	packsMu.Lock()
+	defer packsMu.Unlock()
+	if loadedPacks != nil {
+		return loadedPacks, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(packDir, "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	packs := make([]*packIndex, 0, len(paths))
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := parsePackIndex(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", p, err)
+		}
+		idx.PackPath = strings.TrimSuffix(p, ".idx") + ".pack"
+		packs = append(packs, idx)
+	}
+	loadedPacks = packs
+	return packs, nil
+}
+
+// forgetPacks makes the next read look at the pack directory again.
+func forgetPacks() {
+	packsMu.Lock()
+	defer packsMu.Unlock()
+	loadedPacks = nil
+}
+
+func parsePackIndex(b []byte) (*packIndex, error) {
+	if len(b) < 8+256*4+40 || !bytes.Equal(b[:4], packIndexMagic) {
+		return nil, errors.New("not a version 2 pack index")
+	}
+	if v := binary.BigEndian.Uint32(b[4:8]); v != 2 {
+		return nil, fmt.Errorf("unsupported pack index version %d", v)
+	}
+	checksum := sha1.Sum(b[:len(b)-20])
+	if !bytes.Equal(checksum[:], b[len(b)-20:]) {
+		return nil, errors.New("invalid checksum")
+	}
+
+	n := int(binary.BigEndian.Uint32(b[8+255*4:]))
+	shas := 8 + 256*4
+	crcs := shas + n*20
+	offsets := crcs + n*4
+	large := offsets + n*4
+	if len(b) < large+40 {
+		return nil, errors.New("truncated pack index")
+	}
+
+	idx := &packIndex{
+		Shas:    make([]string, n),
+		Offsets: make([]int64, n),
+		CRCs:    make([]uint32, n),
+		cache:   make(map[int64]packedObject),
+	}
+	for i := 0; i < n; i++ {
+		idx.Shas[i] = fmt.Sprintf("%x", b[shas+i*20:shas+i*20+20])
+		idx.CRCs[i] = binary.BigEndian.Uint32(b[crcs+i*4:])
+		off := binary.BigEndian.Uint32(b[offsets+i*4:])
+		if off&0x80000000 == 0 {
+			idx.Offsets[i] = int64(off)
+			continue
+		}
+		at := large + int(off&0x7fffffff)*8
+		if at+8 > len(b)-40 {
+			return nil, errors.New("bad large offset in pack index")
+		}
+		idx.Offsets[i] = int64(binary.BigEndian.Uint64(b[at:]))
+	}
+	copy(idx.Checksum[:], b[len(b)-40:len(b)-20])
+	return idx, nil
+}
+
+// find returns the position of an object in the index.
+func (idx *packIndex) find(sha string) (int, bool) {
+	i := sort.SearchStrings(idx.Shas, sha)
+	return i, i < len(idx.Shas) && idx.Shas[i] == sha
+}
+
+func (idx *packIndex) packData() ([]byte, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.data == nil {
+		b, err := os.ReadFile(idx.PackPath)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := parsePack(b); err != nil {
+			return nil, fmt.Errorf("%s: %s", idx.PackPath, err)
+		}
+		idx.data = b
+	}
+	return idx.data, nil
+}
+
+// entryAt decodes the header of the pack entry at the given offset.
+func (idx *packIndex) entryAt(offset int64) (*packEntry, error) {
+	data, err := idx.packData()
+	if err != nil {
+		return nil, err
+	}
+	if offset < 12 || offset >= int64(len(data))-20 {
+		return nil, fmt.Errorf("bad pack offset %d", offset)
+	}
+
+	r := bytes.NewReader(data[offset : len(data)-20])
+	size, objType, err := parseHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	e := &packEntry{Type: objType, Size: size}
+
+	switch objType {
+	case OBJ_OFS_DELTA:
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		back := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			back = (back+1)<<7 | int64(c&0x7f)
+		}
+		if back <= 0 || back > offset-12 {
+			return nil, fmt.Errorf("bad delta base offset in pack entry at %d", offset)
+		}
+		e.BaseOffset = offset - back
+	case OBJ_REF_DELTA:
+		sha := make([]byte, 20)
+		if _, err := io.ReadFull(r, sha); err != nil {
+			return nil, err
+		}
+		e.BaseSha = fmt.Sprintf("%x", sha)
+	}
+	e.DataOffset = int64(len(data)-20) - int64(r.Len())
+	return e, nil
+}
+
+// entryLengths returns how many bytes each entry, keyed by offset, takes in
+// the pack: an entry ends where the next one starts.
+func (idx *packIndex) entryLengths() (map[int64]int64, error) {
+	data, err := idx.packData()
+	if err != nil {
+		return nil, err
+	}
+	offsets := append([]int64(nil), idx.Offsets...)
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	offsets = append(offsets, int64(len(data)-20))
+
+	lengths := make(map[int64]int64, len(idx.Offsets))
+	for i := 0; i+1 < len(offsets); i++ {
+		lengths[offsets[i]] = offsets[i+1] - offsets[i]
+	}
+	return lengths, nil
+}
+
+// inflate returns the uncompressed data of an entry.
+func (idx *packIndex) inflate(e *packEntry) ([]byte, error) {
+	data, err := idx.packData()
+	if err != nil {
+		return nil, err
+	}
+	z, err := zlib.NewReader(bytes.NewReader(data[e.DataOffset : len(data)-20]))
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(z)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != e.Size {
+		return nil, errors.New("pack entry size mismatch")
+	}
+	return b, nil
+}
+
+// readAt returns the object stored at an offset, resolving deltas.
+func (idx *packIndex) readAt(offset int64) (string, []byte, error) {
+	idx.mu.Lock()
+	o, ok := idx.cache[offset]
+	idx.mu.Unlock()
+	if ok {
+		return o.Type, o.Content, nil
+	}
+
+	e, err := idx.entryAt(offset)
+	if err != nil {
+		return "", nil, err
+	}
+	b, err := idx.inflate(e)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var t string
+	var base []byte
+	switch e.Type {
+	case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+		return e.Type.String(), b, nil
+	case OBJ_OFS_DELTA:
+		t, base, err = idx.readAt(e.BaseOffset)
+	case OBJ_REF_DELTA:
+		t, base, err = readObject(e.BaseSha)
+	default:
+		return "", nil, fmt.Errorf("bad object type %d in pack", e.Type)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	content, err := applyDelta(base, b)
+	if err != nil {
+		return "", nil, err
+	}
+	// Objects that are deltas are likely bases of other deltas too.
+	idx.mu.Lock()
+	idx.cache[offset] = packedObject{Type: t, Content: content}
+	idx.mu.Unlock()
+	return t, content, nil
+}
+
+// readPackedObject looks an object up in the packs of the repository.
+func readPackedObject(sha string) (string, []byte, bool, error) {
+	packs, err := readPackIndexes()
+	if err != nil {
+		return "", nil, false, err
+	}
+	for _, idx := range packs {
+		if i, ok := idx.find(sha); ok {
+			t, content, err := idx.readAt(idx.Offsets[i])
+			return t, content, true, err
+		}
+	}
+	return "", nil, false, nil
+}
+
+func hasPackedObject(sha string) bool {
+	packs, err := readPackIndexes()
+	if err != nil {
+		return false
+	}
+	for _, idx := range packs {
+		if _, ok := idx.find(sha); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(random)
+	edited := append(append(append([]byte(nil), random[:50000]...), "inserted"...), random[50010:]...)
+	for _, test := range []struct {
+		name         string
+		base, target []byte
+	}{
+		{"empty target", []byte("base"), nil},
+		{"empty base", nil, []byte("target")},
+		{"identical", random, random},
+		{"edit in the middle", random, edited},
+		{"unrelated", []byte(strings.Repeat("a", 100)), []byte(strings.Repeat("b", 300))},
+		{"repeated blocks", []byte(strings.Repeat("0123456789abcdef", 10)), []byte(strings.Repeat("0123456789abcdef", 30))},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			delta := createDelta(test.base, test.target)
+			got, err := applyDelta(test.base, delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, test.target) {
+				t.Errorf("applyDelta(base, createDelta(base, target)) differs from target")
+			}
+		})
+	}
+	if delta := createDelta(random, edited); len(delta) > 100 {
+		t.Errorf("delta for a small edit takes %d bytes", len(delta))
+	}
+}
+
+func TestApplyDeltaErrors(t *testing.T) {
+	base := []byte("0123456789")
+	for _, test := range []struct {
+		name  string
+		delta []byte
+		want  string
+	}{
+		{"wrong base size", []byte{9, 1, 1, 'x'}, "delta base size mismatch"},
+		{"copy past the end", []byte{10, 5, 0x91, 8, 5}, "delta copies past the end of its base"},
+		{"truncated literal", []byte{10, 5, 5, 'a', 'b'}, "truncated delta"},
+		{"reserved instruction", []byte{10, 1, 0}, "invalid delta instruction"},
+		{"wrong result size", []byte{10, 5, 0x90, 3}, "delta result size mismatch"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := applyDelta(base, test.delta); err == nil || err.Error() != test.want {
+				t.Errorf("applyDelta error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	for _, test := range []struct {
+		typ  ObjectType
+		size int
+	}{
+		{OBJ_BLOB, 0},
+		{OBJ_COMMIT, 15},
+		{OBJ_TREE, 16},
+		{OBJ_OFS_DELTA, 1 << 20},
+		{OBJ_REF_DELTA, 123456789},
+	} {
+		b := appendPackHeader(nil, test.typ, test.size)
+		size, typ, err := parseHeader(bytes.NewReader(b))
+		if err != nil || size != test.size || typ != test.typ {
+			t.Errorf("parseHeader(appendPackHeader(%d, %d)) = %d, %d, %v", test.typ, test.size, size, typ, err)
+		}
+		if len(b) > 1 {
+			if _, _, err := parseHeader(bytes.NewReader(b[:len(b)-1])); err == nil {
+				t.Errorf("parseHeader accepted a truncated header for size %d", test.size)
+			}
+		}
+	}
+	if _, _, err := parseHeader(bytes.NewReader(nil)); err == nil {
+		t.Error("parseHeader accepted an empty entry")
+	}
+}
+
+func TestParsePackErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		b    []byte
+		want string
+	}{
+		{"empty", nil, "not a pack file"},
+		{"short", []byte("PACK"), "not a pack file"},
+		{"bad signature", make([]byte, 32), "not a pack file"},
+		{"bad version", append([]byte("PACK\x00\x00\x00\x04\x00\x00\x00\x00"), make([]byte, 20)...), "unsupported pack version 4"},
+		{"bad checksum", append([]byte("PACK\x00\x00\x00\x02\x00\x00\x00\x00"), make([]byte, 20)...), "invalid checksum"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parsePack(test.b); err == nil || err.Error() != test.want {
+				t.Errorf("parsePack error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestRepack(t *testing.T) {
+	newTestRepo(t)
+	lines := make([]string, 200)
+	for i := range lines {
+		lines[i] = strings.Repeat("line ", i%7+1)
+	}
+	content := strings.Join(lines, "\n") + "\n"
+	c1 := testCommit(t, map[string]string{"file": content, "other": "other\n"}, 100)
+	c2 := testCommit(t, map[string]string{"file": content + "one more line\n", "other": "other\n"}, 200, c1)
+	if err := updateRef("refs/heads/master", c2); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := looseObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string][]byte)
+	for _, sha := range objects {
+		typ, content, err := readObject(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[sha] = append([]byte(typ+" "), content...)
+	}
+
+	if err := repack(repackOptions{All: true, Delete: true, Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if loose, err := looseObjects(); err != nil || len(loose) != 0 {
+		t.Errorf("loose objects after repack -a -d: %v, %v", loose, err)
+	}
+	packs, err := readPackIndexes()
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("packs after repack = %d, %v", len(packs), err)
+	}
+	deltas := 0
+	for _, off := range packs[0].Offsets {
+		e, err := packs[0].entryAt(off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Type == OBJ_OFS_DELTA {
+			deltas++
+		}
+	}
+	if deltas == 0 {
+		t.Error("repack stored no deltas for two versions of a file")
+	}
+	for sha, b := range want {
+		typ, content, err := readObject(sha)
+		if err != nil {
+			t.Fatalf("reading %s from the pack: %s", sha, err)
+		}
+		if got := append([]byte(typ+" "), content...); !bytes.Equal(got, b) {
+			t.Errorf("%s reads back differently from the pack", sha)
+		}
+	}
+
+	// A pack cut short gives errors instead of a crash.
+	pack := packs[0].PackPath
+	b, err := os.ReadFile(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(pack, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pack, b[:len(b)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	forgetPacks()
+	for sha := range want {
+		if _, _, err := readObject(sha); err == nil {
+			t.Errorf("read %s from a truncated pack", sha)
+		}
+	}
+}
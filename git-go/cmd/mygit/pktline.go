@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Notes about pkt-lines:
+// - A packet starts with its length in four hex digits, counting the four
+//   digits themselves, followed by that much data. Text packets end in a
+//   newline, which readers must not rely on.
+// - Lengths below 4 are special packets without data: "0000" (flush) ends
+//   a list, "0001" (delim) separates the sections of a protocol v2
+//   message and "0002" (response-end) ends a stateless v2 response.
+//   "0003" is invalid.
+// - A packet is at most 65520 bytes long, so it carries at most 65516
+//   bytes of data.
+// - A text packet "ERR <message>" in place of an expected line is how a
+//   server reports a fatal error.
+
+const (
+	maxPacketLength = 65520
+	maxPacketData   = maxPacketLength - 4
+)
+
+type pktType int
+
+const (
+	pktData pktType = iota
+	pktFlush
+	pktDelim
+	pktResponseEnd
+)
+
+func (t pktType) String() string {
+	switch t {
+	case pktFlush:
+		return "flush packet"
+	case pktDelim:
+		return "delim packet"
+	case pktResponseEnd:
+		return "response-end packet"
+	}
+	return "data packet"
+}
+
+var errPacketTooLong = errors.New("packet write failed - data exceeds max packet size")
+
+type pktReader struct {
+	r *bufio.Reader
+}
+
+func newPktReader(r io.Reader) *pktReader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return &pktReader{r: br}
+	}
+	return &pktReader{r: bufio.NewReader(r)}
+}
+
+// read returns the next packet. Data packets come with their data, the
+// special ones without.
+func (p *pktReader) read() (pktType, []byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(p.r, head[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, nil, errors.New("the remote end hung up unexpectedly")
+		}
+		return 0, nil, err
+	}
+	n, err := strconv.ParseUint(string(head[:]), 16, 16)
+	if err != nil {
+		return 0, nil, fmt.Errorf("protocol error: bad line length character: %s", head[:])
+	}
+	switch {
+	case n == 0:
+		return pktFlush, nil, nil
+	case n == 1:
+		return pktDelim, nil, nil
+	case n == 2:
+		return pktResponseEnd, nil, nil
+	case n < 4 || n > maxPacketLength:
+		return 0, nil, fmt.Errorf("protocol error: bad line length %d", n)
+	}
+	data := make([]byte, n-4)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return 0, nil, errors.New("the remote end hung up unexpectedly")
+	}
+	return pktData, data, nil
+}
+
+// readLine returns the next packet as text without its newline. An "ERR"
+// packet becomes an error.
+func (p *pktReader) readLine() (pktType, string, error) {
+	t, data, err := p.read()
+	if err != nil || t != pktData {
+		return t, "", err
+	}
+	line := strings.TrimSuffix(string(data), "\n")
+	if strings.HasPrefix(line, "ERR ") {
+		return 0, "", fmt.Errorf("remote error: %s", strings.TrimPrefix(line, "ERR "))
+	}
+	return pktData, line, nil
+}
+
+// readLines returns the text packets up to the next flush packet.
+func (p *pktReader) readLines() ([]string, error) {
+	lines := make([]string, 0)
+	for {
+		t, line, err := p.readLine()
+		if err != nil {
+			return nil, err
+		}
+		switch t {
+		case pktFlush:
+			return lines, nil
+		case pktData:
+			lines = append(lines, line)
+		default:
+			return nil, fmt.Errorf("protocol error: unexpected %s", t)
+		}
+	}
+}
+
+// rest returns what follows the packets read so far, for raw data such
+// as a pack sent after the last line.
+func (p *pktReader) rest() io.Reader {
+	return p.r
+}
+
+type pktWriter struct {
+	w io.Writer
+}
+
+func newPktWriter(w io.Writer) *pktWriter {
+	return &pktWriter{w: w}
+}
+
+// write sends data as one packet.
+func (p *pktWriter) write(data []byte) error {
+	if len(data) > maxPacketData {
+		return errPacketTooLong
+	}
+	if _, err := fmt.Fprintf(p.w, "%04x", len(data)+4); err != nil {
+		return err
+	}
+	_, err := p.w.Write(data)
+	return err
+}
+
+// writeLine sends a line of text, adding the newline.
+func (p *pktWriter) writeLine(format string, args ...interface{}) error {
+	return p.write([]byte(fmt.Sprintf(format, args...) + "\n"))
+}
+
+// writeLines sends lines of text followed by a flush packet.
+func (p *pktWriter) writeLines(lines ...string) error {
+	for _, line := range lines {
+		if err := p.write([]byte(line + "\n")); err != nil {
+			return err
+		}
+	}
+	return p.flush()
+}
+
+// writeData sends data of any size, as many packets as it takes.
+func (p *pktWriter) writeData(b []byte) error {
+	for len(b) > 0 {
+		n := len(b)
+		if n > maxPacketData {
+			n = maxPacketData
+		}
+		if err := p.write(b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func (p *pktWriter) flush() error {
+	_, err := io.WriteString(p.w, "0000")
+	return err
+}
+
+func (p *pktWriter) delim() error {
+	_, err := io.WriteString(p.w, "0001")
+	return err
+}
+
+func (p *pktWriter) responseEnd() error {
+	_, err := io.WriteString(p.w, "0002")
+	return err
+}
+
+// Notes about the side-band:
+// - With side-band-64k each packet of a response starts with a band byte:
+//   1 for pack data, 2 for progress messages and 3 for a fatal error.
+// - Progress comes in pieces ending in "\n" or "\r", which are shown on
+//   stderr after "remote: " so the server's progress bars keep working.
+// - The response ends with a flush packet.
+
+// sidebandReader reads the pack data of a side-band response, showing
+// progress on the way.
+type sidebandReader struct {
+	r        *pktReader
+	progress io.Writer
+	partial  []byte
+	data     []byte
+	done     bool
+}
+
+func newSidebandReader(r *pktReader, progress io.Writer) *sidebandReader {
+	return &sidebandReader{r: r, progress: progress}
+}
+
+func (s *sidebandReader) Read(b []byte) (int, error) {
+	for len(s.data) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		t, data, err := s.r.read()
+		if err != nil {
+			return 0, err
+		}
+		if t == pktFlush {
+			s.done = true
+			s.showProgress(nil, true)
+			continue
+		}
+		if t != pktData || len(data) == 0 {
+			return 0, fmt.Errorf("protocol error: unexpected %s in side-band", t)
+		}
+		switch data[0] {
+		case 1:
+			s.data = data[1:]
+		case 2:
+			s.showProgress(data[1:], false)
+		case 3:
+			s.showProgress(nil, true)
+			return 0, fmt.Errorf("remote error: %s", strings.TrimRight(string(data[1:]), "\n"))
+		default:
+			return 0, fmt.Errorf("protocol error: bad band #%d", data[0])
+		}
+	}
+	n := copy(b, s.data)
+	s.data = s.data[n:]
+	return n, nil
+}
+
+// showProgress writes the complete lines of a progress message, keeping
+// the rest for the next one. At the end whatever is left is written too.
+func (s *sidebandReader) showProgress(msg []byte, end bool) {
+	s.partial = append(s.partial, msg...)
+	for {
+		i := bytes.IndexAny(s.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		if s.progress != nil {
+			fmt.Fprintf(s.progress, "remote: %s", s.partial[:i+1])
+		}
+		s.partial = s.partial[i+1:]
+	}
+	if end && len(s.partial) > 0 {
+		if s.progress != nil {
+			fmt.Fprintf(s.progress, "remote: %s\n", s.partial)
+		}
+		s.partial = nil
+	}
+}
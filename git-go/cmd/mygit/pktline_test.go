@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPktReaderRead(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		input   string
+		want    pktType
+		data    string
+		wantErr string
+	}{
+		{name: "data", input: "0009hello", want: pktData, data: "hello"},
+		{name: "empty data", input: "0004", want: pktData, data: ""},
+		{name: "flush", input: "0000", want: pktFlush},
+		{name: "delim", input: "0001", want: pktDelim},
+		{name: "response end", input: "0002", want: pktResponseEnd},
+		{name: "reserved length", input: "0003", wantErr: "bad line length 3"},
+		{name: "too long", input: "fff1", wantErr: "bad line length 65521"},
+		{name: "not hex", input: "00zz", wantErr: "bad line length character: 00zz"},
+		{name: "short length", input: "00", wantErr: "hung up unexpectedly"},
+		{name: "short data", input: "000ahi", wantErr: "hung up unexpectedly"},
+		{name: "nothing", input: "", wantErr: "hung up unexpectedly"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			typ, data, err := newPktReader(strings.NewReader(test.input)).read()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("read error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read: %s", err)
+			}
+			if typ != test.want || string(data) != test.data {
+				t.Errorf("read = %s %q, want %s %q", typ, data, test.want, test.data)
+			}
+		})
+	}
+}
+
+func TestPktReaderReadLines(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr string
+	}{
+		{name: "lines", input: "0008one\n0007two0000", want: []string{"one", "two"}},
+		{name: "empty list", input: "0000", want: []string{}},
+		{name: "server error", input: "0008one\n000eERR denied0000", wantErr: "remote error: denied"},
+		{name: "delim in list", input: "0008one\n0001", wantErr: "unexpected delim packet"},
+		{name: "no flush", input: "0008one\n", wantErr: "hung up unexpectedly"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lines, err := newPktReader(strings.NewReader(test.input)).readLines()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("readLines error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readLines: %s", err)
+			}
+			if !reflect.DeepEqual(lines, test.want) {
+				t.Errorf("readLines = %q, want %q", lines, test.want)
+			}
+		})
+	}
+}
+
+func TestPktReaderRest(t *testing.T) {
+	r := newPktReader(strings.NewReader("0008NAK\nPACKdata"))
+	if _, line, err := r.readLine(); err != nil || line != "NAK" {
+		t.Fatalf("readLine = %q, %v", line, err)
+	}
+	rest, err := io.ReadAll(r.rest())
+	if err != nil || string(rest) != "PACKdata" {
+		t.Errorf("rest = %q, %v, want %q", rest, err, "PACKdata")
+	}
+}
+
+func TestPktWriter(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		write func(w *pktWriter) error
+		want  string
+	}{
+		{"line", func(w *pktWriter) error { return w.writeLine("want %s", "x") }, "000bwant x\n"},
+		{"lines", func(w *pktWriter) error { return w.writeLines("a", "b") }, "0006a\n0006b\n0000"},
+		{"no lines", func(w *pktWriter) error { return w.writeLines() }, "0000"},
+		{"raw data", func(w *pktWriter) error { return w.write([]byte("ab")) }, "0006ab"},
+		{"flush", func(w *pktWriter) error { return w.flush() }, "0000"},
+		{"delim", func(w *pktWriter) error { return w.delim() }, "0001"},
+		{"response end", func(w *pktWriter) error { return w.responseEnd() }, "0002"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := test.write(newPktWriter(&b)); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != test.want {
+				t.Errorf("wrote %q, want %q", b.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestPktWriterLimits(t *testing.T) {
+	var b bytes.Buffer
+	w := newPktWriter(&b)
+	if err := w.write(make([]byte, maxPacketData+1)); err != errPacketTooLong {
+		t.Errorf("write of %d bytes = %v, want %v", maxPacketData+1, err, errPacketTooLong)
+	}
+
+	// writeData splits what does not fit in one packet, and the reader
+	// puts it back together.
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	b.Reset()
+	if err := w.writeData(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+	r := newPktReader(&b)
+	var got []byte
+	packets := 0
+	for {
+		typ, p, err := r.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ == pktFlush {
+			break
+		}
+		packets++
+		got = append(got, p...)
+	}
+	if packets != 2 || !bytes.Equal(got, data) {
+		t.Errorf("writeData sent %d packets and %d bytes, want 2 and %d", packets, len(got), len(data))
+	}
+}
+
+func TestSidebandReader(t *testing.T) {
+	band := func(n byte, s string) []byte { return append([]byte{n}, s...) }
+	for _, test := range []struct {
+		name     string
+		packets  [][]byte
+		data     string
+		progress string
+		wantErr  string
+	}{
+		{
+			name:     "data and progress",
+			packets:  [][]byte{band(2, "Counting: 1\r"), band(1, "PACK"), band(2, "Counting: 2, done.\n"), band(1, "data")},
+			data:     "PACKdata",
+			progress: "remote: Counting: 1\rremote: Counting: 2, done.\n",
+		},
+		{
+			name:     "progress split across packets",
+			packets:  [][]byte{band(2, "Compress"), band(2, "ing\nleft over")},
+			progress: "remote: Compressing\nremote: left over\n",
+		},
+		{
+			name:    "error band",
+			packets: [][]byte{band(1, "PA"), band(3, "upload-pack: not our ref\n")},
+			wantErr: "remote error: upload-pack: not our ref",
+		},
+		{
+			name:    "unknown band",
+			packets: [][]byte{band(4, "x")},
+			wantErr: "bad band #4",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var in bytes.Buffer
+			w := newPktWriter(&in)
+			for _, p := range test.packets {
+				if err := w.write(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			var progress bytes.Buffer
+			data, err := io.ReadAll(newSidebandReader(newPktReader(&in), &progress))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("read error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read: %s", err)
+			}
+			if string(data) != test.data {
+				t.Errorf("data = %q, want %q", data, test.data)
+			}
+			if progress.String() != test.progress {
+				t.Errorf("progress = %q, want %q", progress.String(), test.progress)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Notes about the wire protocol:
+// - Over smart HTTP a client first GETs info/refs?service=<service> and
+//   then POSTs requests to /<service>. The server keeps no state between
+//   requests.
+// - In protocol v0 (and v1, which only adds a "version 1" line) the
+//   answer to the GET lists every ref. The capabilities go after a NUL on
+//   the first ref, and annotated tags are followed by "<tag>^{}" with the
+//   commit they point to.
+// - A client asks for protocol v2 with the "Git-Protocol: version=2"
+//   header. A server that speaks it answers the GET with "version 2" and
+//   its capabilities instead of refs. Servers that don't just ignore the
+//   header, so the answer tells which version is in use.
+// - In v2 every request names a command and its capabilities, then after
+//   a delim packet the command's arguments. "ls-refs" lists only the refs
+//   under the "ref-prefix" arguments, which is what makes v2 worth it for
+//   repositories with many refs. "fetch" answers in sections
+//   (acknowledgments, shallow-info, wanted-refs, packfile) separated by
+//   delim packets; the packfile section always uses the side-band.
+// - protocol.version picks the version to ask for, 2 by default.
+
+// capabilities are what a server says it supports, with the values of
+// those that have one, like "agent=git/2.39.5".
+type capabilities map[string]string
+
+func parseCapabilities(s string) capabilities {
+	caps := make(capabilities)
+	for _, c := range strings.Fields(s) {
+		kv := strings.SplitN(c, "=", 2)
+		if len(kv) == 2 {
+			caps[kv[0]] = kv[1]
+		} else {
+			caps[kv[0]] = ""
+		}
+	}
+	return caps
+}
+
+func (c capabilities) has(name string) bool {
+	_, ok := c[name]
+	return ok
+}
+
+// userAgent names this client to servers that advertise "agent".
+const userAgent = "mygit/1.0"
+
+// refAdvertisement is what a server says it has: its refs, with peeled
+// tags as "<tag>^{}" whatever the protocol version, the targets of its
+// symbolic refs and its capabilities.
+type refAdvertisement struct {
+	Version int
+	Refs    []ref
+	Symrefs map[string]string
+	Caps    capabilities
+}
+
+// protocolVersion returns the protocol version to ask servers for.
+func protocolVersion() (int, error) {
+	v, err := configInt("protocol.version", 2)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > 2 {
+		return 0, fmt.Errorf("unknown value for config 'protocol.version': %d", v)
+	}
+	return int(v), nil
+}
+
+// findRefs reads the ref advertisement of a remote repository. With
+// protocol v2 only the refs under the prefixes are listed; without
+// prefixes all of them are.
+func findRefs(t transport, prefixes []string) (*refAdvertisement, error) {
+	version, err := protocolVersion()
+	if err != nil {
+		return nil, err
+	}
+	lines, err := t.advertise("git-upload-pack", version)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) > 0 && lines[0] == "version 2" {
+		adv := &refAdvertisement{Version: 2, Caps: parseCapabilities(strings.Join(lines[1:], " "))}
+		if !adv.Caps.has("ls-refs") || !adv.Caps.has("fetch") {
+			return nil, errors.New("the server does not support ls-refs and fetch")
+		}
+		return adv, listRefs2(t, adv, prefixes)
+	}
+	if len(lines) > 0 && lines[0] == "version 1" {
+		lines = lines[1:]
+	}
+	return parseAdvertisement(lines)
+}
+
+// parseAdvertisement reads the refs of a v0 or v1 advertisement.
+func parseAdvertisement(lines []string) (*refAdvertisement, error) {
+	adv := &refAdvertisement{
+		Refs:    make([]ref, 0, len(lines)),
+		Symrefs: make(map[string]string),
+		Caps:    make(capabilities),
+	}
+	for i, line := range lines {
+		// The first ref carries the capabilities after a NUL.
+		if i == 0 {
+			list := ""
+			if nul := strings.IndexByte(line, 0); nul >= 0 {
+				line, list = line[:nul], line[nul+1:]
+			}
+			adv.Caps = parseCapabilities(list)
+			// symref may come more than once, so the map only keeps one.
+			for _, c := range strings.Fields(list) {
+				if !strings.HasPrefix(c, "symref=") {
+					continue
+				}
+				if s := strings.SplitN(strings.TrimPrefix(c, "symref="), ":", 2); len(s) == 2 {
+					adv.Symrefs[s[0]] = s[1]
+				}
+			}
+		}
+		sp := strings.IndexByte(line, ' ')
+		if sp != 40 || !isHex(line[:sp]) {
+			return nil, fmt.Errorf("protocol error: unexpected '%s'", line)
+		}
+		sha, name := line[:sp], line[sp+1:]
+		// An empty repository advertises only its capabilities.
+		if name == "capabilities^{}" {
+			continue
+		}
+		adv.Refs = append(adv.Refs, ref{Sha: sha, Name: name})
+	}
+	return adv, nil
+}
+
+// command2 sends a protocol v2 command with its arguments and returns a
+// reader for the answer, which the caller closes.
+func command2(t transport, adv *refAdvertisement, command string, args []string) (io.ReadCloser, error) {
+	var body bytes.Buffer
+	w := newPktWriter(&body)
+	if err := w.writeLine("command=%s", command); err != nil {
+		return nil, err
+	}
+	if adv.Caps.has("agent") {
+		if err := w.writeLine("agent=%s", userAgent); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.delim(); err != nil {
+		return nil, err
+	}
+	if err := w.writeLines(args...); err != nil {
+		return nil, err
+	}
+
+	return t.request("git-upload-pack", &body, 2)
+}
+
+// listRefs2 lists the refs under the prefixes with the ls-refs command,
+// along with peeled tags and symbolic ref targets.
+func listRefs2(t transport, adv *refAdvertisement, prefixes []string) error {
+	args := []string{"symrefs", "peel"}
+	for _, prefix := range prefixes {
+		args = append(args, "ref-prefix "+prefix)
+	}
+	body, err := command2(t, adv, "ls-refs", args)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	lines, err := newPktReader(body).readLines()
+	if err != nil {
+		return err
+	}
+
+	adv.Refs = make([]ref, 0, len(lines))
+	adv.Symrefs = make(map[string]string)
+	for _, line := range lines {
+		// <oid> <name> [symref-target:<target>] [peeled:<oid>]
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields[0]) != 40 || !isHex(fields[0]) {
+			// An unborn HEAD comes as "unborn HEAD" and has no commit.
+			if len(fields) >= 2 && fields[0] == "unborn" {
+				continue
+			}
+			return fmt.Errorf("protocol error: unexpected '%s'", line)
+		}
+		name := fields[1]
+		adv.Refs = append(adv.Refs, ref{Sha: fields[0], Name: name})
+		for _, attr := range fields[2:] {
+			if strings.HasPrefix(attr, "symref-target:") {
+				adv.Symrefs[name] = strings.TrimPrefix(attr, "symref-target:")
+			} else if strings.HasPrefix(attr, "peeled:") {
+				adv.Refs = append(adv.Refs, ref{Sha: strings.TrimPrefix(attr, "peeled:"), Name: name + "^{}"})
+			}
+		}
+	}
+	return nil
+}
+
+// isTerminal reports whether f is a terminal, where progress is worth
+// showing.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAdvertisement(t *testing.T) {
+	a, b := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	for _, test := range []struct {
+		name    string
+		lines   []string
+		want    *refAdvertisement
+		wantErr string
+	}{
+		{
+			name: "refs with capabilities",
+			lines: []string{
+				a + " HEAD\x00multi_ack_detailed side-band-64k symref=HEAD:refs/heads/main agent=git/2.39.5",
+				a + " refs/heads/main",
+				b + " refs/tags/v1",
+				a + " refs/tags/v1^{}",
+			},
+			want: &refAdvertisement{
+				Refs: []ref{
+					{Sha: a, Name: "HEAD"},
+					{Sha: a, Name: "refs/heads/main"},
+					{Sha: b, Name: "refs/tags/v1"},
+					{Sha: a, Name: "refs/tags/v1^{}"},
+				},
+				Symrefs: map[string]string{"HEAD": "refs/heads/main"},
+				Caps: capabilities{
+					"multi_ack_detailed": "",
+					"side-band-64k":      "",
+					"symref":             "HEAD:refs/heads/main",
+					"agent":              "git/2.39.5",
+				},
+			},
+		},
+		{
+			name:  "empty repository",
+			lines: []string{strings.Repeat("0", 40) + " capabilities^{}\x00report-status delete-refs"},
+			want: &refAdvertisement{
+				Refs:    []ref{},
+				Symrefs: map[string]string{},
+				Caps:    capabilities{"report-status": "", "delete-refs": ""},
+			},
+		},
+		{
+			name:  "nothing",
+			lines: nil,
+			want:  &refAdvertisement{Refs: []ref{}, Symrefs: map[string]string{}, Caps: capabilities{}},
+		},
+		{
+			name:    "bad id",
+			lines:   []string{"xyz refs/heads/main"},
+			wantErr: "unexpected 'xyz refs/heads/main'",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseAdvertisement(test.lines)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("parseAdvertisement error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseAdvertisement = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// testSmartServer answers ref advertisements like a smart HTTP server
+// speaking protocol v2 when the client asks for it and v0 otherwise, and
+// records the ls-refs requests it gets.
+func testSmartServer(t *testing.T, requests *[]string) *httptest.Server {
+	a, b := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw := newPktWriter(w)
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/info/refs":
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+			if r.Header.Get("Git-Protocol") == "version=2" {
+				pw.writeLines("version 2", "agent=git/2.39.5", "ls-refs=unborn", "fetch=shallow")
+				return
+			}
+			pw.writeLines("# service=git-upload-pack")
+			pw.writeLines(a+" HEAD\x00side-band-64k symref=HEAD:refs/heads/main", a+" refs/heads/main", b+" refs/tags/v1", a+" refs/tags/v1^{}")
+		case r.Method == "POST" && r.URL.Path == "/git-upload-pack":
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			lines := make([]string, 0)
+			pr := newPktReader(bytes.NewReader(body))
+			for {
+				typ, line, err := pr.readLine()
+				if err != nil || typ == pktFlush {
+					break
+				}
+				if typ == pktData {
+					lines = append(lines, line)
+				}
+			}
+			*requests = append(*requests, strings.Join(lines, ","))
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+			pw.writeLines("unborn refs/heads/empty", a+" HEAD symref-target:refs/heads/main", a+" refs/heads/main", b+" refs/tags/v1 peeled:"+a)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestFindRefs(t *testing.T) {
+	a, b := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	refs := []ref{
+		{Sha: a, Name: "HEAD"},
+		{Sha: a, Name: "refs/heads/main"},
+		{Sha: b, Name: "refs/tags/v1"},
+		{Sha: a, Name: "refs/tags/v1^{}"},
+	}
+	symrefs := map[string]string{"HEAD": "refs/heads/main"}
+
+	newTestRepo(t)
+	for _, version := range []int{0, 1, 2} {
+		setTestConfig(t, fmt.Sprintf("[protocol]\n\tversion = %d\n", version))
+		requests := make([]string, 0)
+		server := testSmartServer(t, &requests)
+		adv, err := findRefs(&httpTransport{url: server.URL}, []string{"HEAD", "refs/heads/"})
+		server.Close()
+		if err != nil {
+			t.Fatalf("protocol.version=%d: %s", version, err)
+		}
+
+		want := 0
+		wantRequests := []string{}
+		if version == 2 {
+			want = 2
+			wantRequests = []string{"command=ls-refs,agent=" + userAgent + ",symrefs,peel,ref-prefix HEAD,ref-prefix refs/heads/"}
+		}
+		if adv.Version != want || !reflect.DeepEqual(adv.Refs, refs) || !reflect.DeepEqual(adv.Symrefs, symrefs) {
+			t.Errorf("protocol.version=%d: findRefs = %+v", version, adv)
+		}
+		if !reflect.DeepEqual(requests, wantRequests) {
+			t.Errorf("protocol.version=%d: requests %q, want %q", version, requests, wantRequests)
+		}
+	}
+
+	setTestConfig(t, "[protocol]\n\tversion = 3\n")
+	if _, err := findRefs(&httpTransport{url: "http://127.0.0.1:1"}, nil); err == nil {
+		t.Error("protocol.version=3 was accepted")
+	}
+}
+
+func TestWantObjects(t *testing.T) {
+	a, b := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	got := wantObjects([]ref{{Sha: a, Name: "HEAD"}, {Sha: a, Name: "refs/heads/main"}, {Sha: a, Name: "refs/tags/v1^{}"}, {Sha: b, Name: "refs/tags/v1"}})
+	if want := []string{a, b}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wantObjects = %q, want %q", got, want)
+	}
+
+	caps := parseCapabilities("ofs-delta side-band-64k agent=git/2.39.5 shallow")
+	if got, want := requestCapabilities(caps), []string{"side-band-64k", "ofs-delta", "agent=" + userAgent}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requestCapabilities = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Notes about pulling:
+// - A pull is a fetch followed by a merge or a rebase onto what FETCH_HEAD
+//   marks for merging. Without arguments the remote and the branch come
+//   from branch.<name>.remote and branch.<name>.merge.
+// - --rebase, --no-rebase, branch.<name>.rebase and pull.rebase pick
+//   between rebase and merge; --ff-only, --ff, --no-ff and pull.ff say how
+//   to merge.
+// - When nothing says how and the branches have diverged, the pull stops
+//   and asks, as git does since 2.33.
+
+type pullOptions struct {
+	Rebase      bool
+	Interactive bool
+	FFOnly      bool
+	NoFF        bool
+
+	// Whether anything said to rebase or merge, or how to merge.
+	rebaseSet bool
+	ffSet     bool
+}
+
+// pullCommand implements "pull". It reports whether the merge or rebase
+// completed without conflicts.
+func pullCommand(args []string) (bool, error) {
+	opts := pullOptions{}
+	rest := make([]string, 0)
+	for _, arg := range args {
+		switch arg {
+		case "-r", "--rebase", "--rebase=true":
+			opts.Rebase, opts.Interactive, opts.rebaseSet = true, false, true
+		case "--rebase=interactive", "--rebase=i":
+			opts.Rebase, opts.Interactive, opts.rebaseSet = true, true, true
+		case "--no-rebase", "--rebase=false":
+			opts.Rebase, opts.Interactive, opts.rebaseSet = false, false, true
+		case "--ff-only":
+			opts.FFOnly, opts.NoFF, opts.ffSet = true, false, true
+		case "--ff":
+			opts.FFOnly, opts.NoFF, opts.ffSet = false, false, true
+		case "--no-ff":
+			opts.FFOnly, opts.NoFF, opts.ffSet = false, true, true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return false, fmt.Errorf("unknown option %s", arg)
+			}
+			rest = append(rest, arg)
+		}
+	}
+
+	branch, err := currentBranch()
+	if err != nil {
+		return false, err
+	}
+	if err := opts.configure(branch); err != nil {
+		return false, err
+	}
+
+	idx, err := readIndex()
+	if err != nil {
+		return false, err
+	}
+	if isMerging() {
+		return false, errors.New("You have not concluded your merge (MERGE_HEAD exists).\nPlease, commit your changes before you merge.")
+	}
+	if len(idx.unmerged()) > 0 {
+		return false, errors.New("Pulling is not possible because you have unmerged files.\n" +
+			"Fix them up in the work tree, and then use 'mygit add/rm <file>'\n" +
+			"as appropriate to mark resolution and make a commit.")
+	}
+	head, err := headCommit()
+	if err != nil {
+		return false, err
+	}
+	if opts.Rebase && head != "" {
+		status, err := readStatus(idx)
+		if err != nil {
+			return false, err
+		}
+		if len(status.Unstaged) > 0 {
+			return false, errors.New("cannot pull with rebase: You have unstaged changes.\nPlease commit or stash them.")
+		}
+		if len(status.Staged) > 0 {
+			return false, errors.New("cannot pull with rebase: Your index contains uncommitted changes.\nPlease commit or stash them.")
+		}
+	}
+
+	ok, err := fetchCommand(rest)
+	if err != nil || !ok {
+		return false, err
+	}
+	heads, err := readFetchHead()
+	if err != nil {
+		return false, err
+	}
+	if len(heads) == 0 {
+		return false, noMergeCandidates(rest, branch)
+	}
+	if len(heads) > 1 {
+		if opts.Rebase {
+			return false, errors.New("Cannot rebase onto multiple branches.")
+		}
+		return false, errors.New("Cannot merge multiple branches.")
+	}
+	theirs := heads[0]
+
+	// An unborn branch just starts at what was fetched.
+	if head == "" {
+		return merge(theirs.Sha, mergeOptions{})
+	}
+
+	upToDate, err := isAncestor(theirs.Sha, head)
+	if err != nil {
+		return false, err
+	}
+	canFastForward, err := isAncestor(head, theirs.Sha)
+	if err != nil {
+		return false, err
+	}
+	if !upToDate && !canFastForward && !opts.rebaseSet && !opts.ffSet {
+		for _, line := range []string{
+			"You have divergent branches and need to specify how to reconcile them.",
+			"You can do so by running one of the following commands sometime before",
+			"your next pull:",
+			"",
+			"  mygit config pull.rebase false  # merge",
+			"  mygit config pull.rebase true   # rebase",
+			"  mygit config pull.ff only       # fast-forward only",
+			"",
+			"You can replace \"mygit config\" with \"mygit config --global\" to set a default",
+			"preference for all repositories. You can also pass --rebase, --no-rebase,",
+			"or --ff-only on the command line to override the configured default per",
+			"invocation.",
+		} {
+			fmt.Fprintf(os.Stderr, "hint: %s\n", line)
+		}
+		return false, errors.New("Need to specify how to reconcile divergent branches.")
+	}
+
+	if opts.Rebase {
+		if opts.FFOnly && !canFastForward && !upToDate {
+			return false, errors.New("Not possible to fast-forward, aborting.")
+		}
+		return rebaseStart(theirs.Sha, "", "", opts.Interactive)
+	}
+
+	msg := "Merge " + theirs.Description
+	if branch != "" && branch != "main" && branch != "master" {
+		msg += " into " + branch
+	}
+	return merge(theirs.Sha, mergeOptions{FFOnly: opts.FFOnly, NoFF: opts.NoFF, Message: msg})
+}
+
+// configure fills in what the command line left open from
+// branch.<name>.rebase, pull.rebase and pull.ff.
+func (opts *pullOptions) configure(branch string) error {
+	if !opts.rebaseSet {
+		value, ok := "", false
+		if branch != "" {
+			value, ok = configValue("branch." + branch + ".rebase")
+		}
+		if !ok {
+			value, ok = configValue("pull.rebase")
+		}
+		if ok {
+			switch value {
+			case "interactive", "i":
+				opts.Rebase, opts.Interactive = true, true
+			default:
+				b, valid := parseConfigBool(value, false)
+				if !valid {
+					return fmt.Errorf("invalid value for 'pull.rebase': '%s'", value)
+				}
+				opts.Rebase = b
+			}
+			opts.rebaseSet = true
+		}
+	}
+	if !opts.ffSet {
+		if value, ok := configValue("pull.ff"); ok {
+			if value == "only" {
+				opts.FFOnly = true
+			} else {
+				b, valid := parseConfigBool(value, false)
+				if !valid {
+					return fmt.Errorf("invalid value for 'pull.ff': '%s'", value)
+				}
+				opts.NoFF = !b
+			}
+			opts.ffSet = true
+		}
+	}
+	return nil
+}
+
+// fetchHead is a line of FETCH_HEAD marked for merging.
+type fetchHead struct {
+	Sha         string
+	Description string
+}
+
+// readFetchHead returns the refs of the last fetch that are to be merged.
+func readFetchHead() ([]fetchHead, error) {
+	b, err := os.ReadFile(".git/FETCH_HEAD")
+	if err != nil {
+		return nil, err
+	}
+	heads := make([]fetchHead, 0)
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 || fields[1] != "" {
+			continue
+		}
+		heads = append(heads, fetchHead{Sha: fields[0], Description: fields[2]})
+	}
+	return heads, nil
+}
+
+// noMergeCandidates explains why a fetch brought nothing to merge.
+func noMergeCandidates(args []string, branch string) error {
+	remote, _ := configValue("branch." + branch + ".remote")
+	merge, hasMerge := configValue("branch." + branch + ".merge")
+	switch {
+	case len(args) > 1:
+		return errors.New("There are no candidates for merging among the refs that you just fetched.\n" +
+			"Generally this means that you provided a wildcard refspec which had no\n" +
+			"matches on the remote end.")
+	case len(args) == 1 && (branch == "" || args[0] != remote):
+		return fmt.Errorf("You asked to pull from the remote '%s', but did not specify\n"+
+			"a branch. Because this is not the default configured remote\n"+
+			"for your current branch, you must specify a branch on the command line.", args[0])
+	case branch == "":
+		return errors.New("You are not currently on a branch.\n" +
+			"Please specify which branch you want to merge with.\n\n" +
+			"    mygit pull <remote> <branch>")
+	case !hasMerge:
+		return fmt.Errorf("There is no tracking information for the current branch.\n"+
+			"Please specify which branch you want to merge with.\n\n"+
+			"    mygit pull <remote> <branch>\n\n"+
+			"If you wish to set tracking information for this branch you can do so with:\n\n"+
+			"    mygit config branch.%s.remote <remote>\n"+
+			"    mygit config branch.%s.merge refs/heads/<branch>", branch, branch)
+	}
+	return fmt.Errorf("Your configuration specifies to merge with the ref '%s'\n"+
+		"from the remote, but no such ref was fetched.", merge)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPullConfigure(t *testing.T) {
+	newTestRepo(t)
+	tests := []struct {
+		config string
+		branch string
+		flags  pullOptions
+		want   pullOptions
+		err    bool
+	}{
+		{"", "main", pullOptions{}, pullOptions{}, false},
+		{"[pull]\n\trebase = true\n", "main", pullOptions{}, pullOptions{Rebase: true, rebaseSet: true}, false},
+		{"[pull]\n\trebase = interactive\n", "main", pullOptions{}, pullOptions{Rebase: true, Interactive: true, rebaseSet: true}, false},
+		{"[pull]\n\trebase = true\n[branch \"main\"]\n\trebase = false\n", "main", pullOptions{}, pullOptions{rebaseSet: true}, false},
+		{"[pull]\n\trebase = true\n[branch \"main\"]\n\trebase = false\n", "", pullOptions{}, pullOptions{Rebase: true, rebaseSet: true}, false},
+		{"[pull]\n\trebase = true\n", "main", pullOptions{rebaseSet: true}, pullOptions{rebaseSet: true}, false},
+		{"[pull]\n\tff = only\n", "main", pullOptions{}, pullOptions{FFOnly: true, ffSet: true}, false},
+		{"[pull]\n\tff = false\n", "main", pullOptions{}, pullOptions{NoFF: true, ffSet: true}, false},
+		{"[pull]\n\tff = only\n", "main", pullOptions{NoFF: true, ffSet: true}, pullOptions{NoFF: true, ffSet: true}, false},
+		{"[pull]\n\trebase = sometimes\n", "main", pullOptions{}, pullOptions{}, true},
+		{"[pull]\n\tff = maybe\n", "main", pullOptions{}, pullOptions{}, true},
+	}
+	for _, tt := range tests {
+		setTestConfig(t, tt.config)
+		opts := tt.flags
+		err := opts.configure(tt.branch)
+		if tt.err {
+			if err == nil {
+				t.Errorf("configure with %q did not fail", tt.config)
+			}
+			continue
+		}
+		if err != nil || opts != tt.want {
+			t.Errorf("configure(%q) with %q = %+v, %v; want %+v", tt.branch, tt.config, opts, err, tt.want)
+		}
+	}
+}
+
+func TestReadFetchHead(t *testing.T) {
+	newTestRepo(t)
+	a, b := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	writeTestFiles(t, map[string]string{".git/FETCH_HEAD": a + "\t\tbranch 'main' of /tmp/up\n" +
+		b + "\tnot-for-merge\tbranch 'topic' of /tmp/up\n" +
+		"garbage\n"})
+	heads, err := readFetchHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []fetchHead{{Sha: a, Description: "branch 'main' of /tmp/up"}}; !reflect.DeepEqual(heads, want) {
+		t.Errorf("readFetchHead = %+v, want %+v", heads, want)
+	}
+}
+
+func TestPull(t *testing.T) {
+	bundles := t.TempDir()
+	base := map[string]string{"a": "one\n", "b": "two\n"}
+	newTestRepo(t)
+	c1 := testCommit(t, map[string]string{"a": "one\n"}, 100)
+	c2 := testCommit(t, base, 200, c1)
+	if err := updateRef("refs/heads/master", c2); err != nil {
+		t.Fatal(err)
+	}
+	first := filepath.Join(bundles, "first.bundle")
+	if err := createBundle(first, []string{"master"}, 2); err != nil {
+		t.Fatal(err)
+	}
+	c4 := testCommit(t, map[string]string{"a": "one\n", "b": "two\nupstream\n"}, 400, c2)
+	if err := updateRef("refs/heads/master", c4); err != nil {
+		t.Fatal(err)
+	}
+	second := filepath.Join(bundles, "second.bundle")
+	if err := createBundle(second, []string{"master"}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new repository pulls into its unborn branch.
+	newTestRepo(t)
+	setTestConfig(t, "")
+	if ok, err := pullCommand([]string{first, "master"}); err != nil || !ok {
+		t.Fatalf("pull into an unborn branch = %v, %v", ok, err)
+	}
+	if head, _ := headCommit(); head != c2 {
+		t.Errorf("HEAD after the first pull = %s, want %s", head, c2)
+	}
+	if b, err := os.ReadFile("b"); err != nil || string(b) != "two\n" {
+		t.Errorf("b after the first pull = %q, %v", b, err)
+	}
+
+	files := map[string]string{"a": "one\nlocal\n", "b": "two\n"}
+	c3 := testCommit(t, files, 300, c2)
+	if err := resetCommand([]string{"--hard", c3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pullCommand([]string{second, "master"}); err == nil || !strings.Contains(err.Error(), "divergent") {
+		t.Errorf("pull of divergent branches without a choice = %v", err)
+	}
+	if _, err := pullCommand([]string{"--ff-only", second, "master"}); err == nil {
+		t.Error("pull --ff-only of divergent branches succeeded")
+	}
+	if head, _ := headCommit(); head != c3 {
+		t.Fatalf("HEAD moved to %s after refused pulls", head)
+	}
+
+	if ok, err := pullCommand([]string{"--rebase", second, "master"}); err != nil || !ok {
+		t.Fatalf("pull --rebase = %v, %v", ok, err)
+	}
+	head, err := headCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := readCommit(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Parents, []string{c4}) {
+		t.Errorf("rebased commit has parents %v, want [%s]", c.Parents, c4)
+	}
+	for name, want := range map[string]string{"a": "one\nlocal\n", "b": "two\nupstream\n"} {
+		if b, err := os.ReadFile(name); err != nil || string(b) != want {
+			t.Errorf("%s after pull --rebase = %q, %v; want %q", name, b, err, want)
+		}
+	}
+}
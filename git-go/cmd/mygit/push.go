@@ -0,0 +1,678 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Notes about pushing:
+// - A push reads the git-receive-pack advertisement, which is always v0,
+//   and then sends one "<old> <new> <ref>" command per ref to update, the
+//   capabilities after a NUL on the first, a flush packet and a pack of
+//   the objects the remote lacks. A push that only deletes sends no pack.
+// - What the server would refuse is refused up front: updates that are
+//   not fast-forwards and updates of existing tags, unless forced, and
+//   updates to commits we don't have ("fetch first").
+// - --force-with-lease forces an update only while the remote ref is
+//   where its remote-tracking ref, or the value given, says it is.
+// - With report-status the server answers "unpack ok" (or the reason it
+//   failed), then "ok <ref>" or "ng <ref> <reason>" for each command.
+//   With side-band-64k the report comes inside band 1.
+// - With --atomic the server updates all refs or none, and a ref refused
+//   up front fails the whole push.
+// - Without refspecs the ones in remote.<name>.push are used, else
+//   push.default (simple by default) picks the current branch.
+
+// pushUpdate is a remote ref to update and how it went.
+type pushUpdate struct {
+	Src string // what was pushed, as given
+	Ref string // the local ref pushed, if any
+	Dst string
+	Old string
+	New string
+
+	Force bool
+	// Lease is the value the remote ref must have for a forced update,
+	// when --force-with-lease covers the ref.
+	Lease    string
+	HasLease bool
+
+	flag    byte
+	summary string
+	reason  string
+}
+
+func (u *pushUpdate) rejected() bool {
+	return u.flag == '!'
+}
+
+type pushOptions struct {
+	Force       bool
+	Delete      bool
+	Atomic      bool
+	SetUpstream bool
+
+	// Leases by remote ref, "" for all refs; an empty value stands for
+	// the remote-tracking ref.
+	Leases map[string]string
+}
+
+// pushCommand implements "push". It returns false when some ref was not
+// updated.
+func pushCommand(args []string) (bool, error) {
+	opts := pushOptions{Leases: make(map[string]string)}
+	rest := make([]string, 0, len(args))
+	doubleDash := false
+	for _, arg := range args {
+		switch {
+		case doubleDash || !strings.HasPrefix(arg, "-"):
+			rest = append(rest, arg)
+		case arg == "--":
+			doubleDash = true
+		case arg == "-f" || arg == "--force":
+			opts.Force = true
+		case arg == "-d" || arg == "--delete":
+			opts.Delete = true
+		case arg == "--atomic":
+			opts.Atomic = true
+		case arg == "--no-atomic":
+			opts.Atomic = false
+		case arg == "-u" || arg == "--set-upstream":
+			opts.SetUpstream = true
+		case arg == "--force-with-lease":
+			opts.Leases[""] = ""
+		case strings.HasPrefix(arg, "--force-with-lease="):
+			name, expect := strings.TrimPrefix(arg, "--force-with-lease="), ""
+			if i := strings.IndexByte(name, ':'); i >= 0 {
+				name, expect = name[:i], name[i+1:]
+			}
+			opts.Leases[name] = expect
+		default:
+			return false, fmt.Errorf("unknown option '%s'", arg)
+		}
+	}
+
+	remote := ""
+	if len(rest) > 0 {
+		remote, rest = rest[0], rest[1:]
+	} else {
+		var err error
+		if remote, err = defaultRemote(); err != nil {
+			return false, err
+		}
+	}
+	url, named := configValue("remote." + remote + ".pushurl")
+	if !named {
+		url, named = configValue("remote." + remote + ".url")
+	}
+	if !named {
+		url = remote
+	}
+	t, err := openTransport(url)
+	if err != nil {
+		return false, err
+	}
+	defer t.close()
+
+	specs, err := pushRefspecs(remote, rest, opts)
+	if err != nil {
+		return false, err
+	}
+
+	lines, err := t.advertise("git-receive-pack", 0)
+	if err != nil {
+		return false, err
+	}
+	adv, err := parseAdvertisement(lines)
+	if err != nil {
+		return false, err
+	}
+	if opts.Atomic && !adv.Caps.has("atomic") {
+		return false, errors.New("the receiving end does not support --atomic push")
+	}
+
+	updates, err := pushUpdates(specs, adv.Refs)
+	if err != nil {
+		return false, err
+	}
+	if err := checkUpdates(updates, remote, opts); err != nil {
+		return false, err
+	}
+
+	send := make([]*pushUpdate, 0, len(updates))
+	rejected := false
+	for _, u := range updates {
+		switch {
+		case u.rejected():
+			rejected = true
+		case u.flag != '=':
+			send = append(send, u)
+		}
+	}
+	if rejected && opts.Atomic {
+		for _, u := range send {
+			u.flag, u.summary, u.reason = '!', "[rejected]", "atomic push failed"
+		}
+		send = nil
+	}
+	if len(send) == 0 && !rejected {
+		fmt.Fprintln(os.Stderr, "Everything up-to-date")
+		return true, nil
+	}
+
+	if len(send) > 0 {
+		if err := sendPack(t, adv, send, opts.Atomic); err != nil {
+			return false, err
+		}
+	}
+
+	ok := true
+	fmt.Fprintf(os.Stderr, "To %s\n", url)
+	for _, u := range updates {
+		if u.flag == '=' {
+			continue
+		}
+		ok = ok && !u.rejected()
+		from := ""
+		if u.New != zeroSha {
+			from = shortRefName(u.Src) + " -> "
+		}
+		reason := ""
+		if u.reason != "" {
+			reason = " (" + u.reason + ")"
+		}
+		fmt.Fprintf(os.Stderr, " %c %-17s %s%s%s\n", u.flag, u.summary, from, shortRefName(u.Dst), reason)
+	}
+
+	if named {
+		if err := updateTrackingRefs(remote, updates); err != nil {
+			return false, err
+		}
+	}
+	if opts.SetUpstream {
+		if err := setUpstreams(remote, updates); err != nil {
+			return false, err
+		}
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: failed to push some refs to '%s'\n", url)
+		pushHints(updates)
+	}
+	return ok, nil
+}
+
+// pushRefspecs returns the refspecs to push: those given, or with --delete
+// the refs to delete, else the configured ones or the current branch.
+func pushRefspecs(remote string, args []string, opts pushOptions) ([]refspec, error) {
+	specs := make([]refspec, 0, len(args))
+	if opts.Delete {
+		if len(args) == 0 {
+			return nil, errors.New("--delete doesn't make sense without any refs")
+		}
+		for _, arg := range args {
+			if strings.Contains(arg, ":") {
+				return nil, errors.New("--delete only accepts plain target ref names")
+			}
+			specs = append(specs, refspec{Dst: arg})
+		}
+		return specs, nil
+	}
+	for _, arg := range args {
+		r, err := parseRefspec(arg)
+		if err != nil {
+			return nil, err
+		}
+		r.Force = r.Force || opts.Force
+		specs = append(specs, r)
+	}
+	if len(specs) > 0 {
+		return specs, nil
+	}
+
+	for _, e := range configEntries("remote." + remote + ".push") {
+		r, err := parseRefspec(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		r.Force = r.Force || opts.Force
+		specs = append(specs, r)
+	}
+	if len(specs) > 0 {
+		return specs, nil
+	}
+
+	branch, err := currentBranch()
+	if err != nil {
+		return nil, err
+	}
+	if branch == "" {
+		return nil, errors.New("You are not currently on a branch.\n" +
+			"To push the history leading to the current (detached HEAD)\n" +
+			"state now, use\n\n" +
+			"    mygit push <remote> HEAD:<name-of-remote-branch>")
+	}
+	current := refspec{Src: "refs/heads/" + branch, Dst: "refs/heads/" + branch, Force: opts.Force}
+	mode, _ := configValue("push.default")
+	upstreamRemote, err := defaultRemote()
+	if err != nil {
+		return nil, err
+	}
+	merge, hasMerge := configValue("branch." + branch + ".merge")
+	switch mode {
+	case "", "simple", "upstream", "tracking":
+		// Pushing elsewhere than the upstream remote, or to set it up,
+		// pushes the current branch under its own name.
+		if mode != "upstream" && mode != "tracking" && (opts.SetUpstream || upstreamRemote != remote) {
+			return []refspec{current}, nil
+		}
+		if upstreamRemote != remote {
+			return nil, fmt.Errorf("You are pushing to remote '%s', which is not the upstream of\n"+
+				"your current branch '%s', without telling me what to push\n"+
+				"to update which remote branch.", remote, branch)
+		}
+		if !hasMerge {
+			return nil, fmt.Errorf("The current branch %s has no upstream branch.\n"+
+				"To push the current branch and set the remote as upstream, use\n\n"+
+				"    mygit push --set-upstream %s %s", branch, remote, branch)
+		}
+		if mode == "" || mode == "simple" {
+			if merge != current.Dst {
+				return nil, fmt.Errorf("The upstream branch of your current branch does not match\n"+
+					"the name of your current branch.  To push to the upstream branch\n"+
+					"on the remote, use\n\n"+
+					"    mygit push %s HEAD:%s\n\n"+
+					"To push to the branch of the same name on the remote, use\n\n"+
+					"    mygit push %s HEAD", remote, strings.TrimPrefix(merge, "refs/heads/"), remote)
+			}
+		}
+		current.Dst = merge
+		return []refspec{current}, nil
+	case "current":
+		return []refspec{current}, nil
+	case "nothing":
+		return nil, errors.New("You didn't specify any refspecs to push, and push.default is \"nothing\".")
+	}
+	return nil, fmt.Errorf("unsupported value for push.default: '%s'", mode)
+}
+
+// pushUpdates works out the remote refs the refspecs update, from what to
+// what.
+func pushUpdates(specs []refspec, remoteRefs []ref) ([]*pushUpdate, error) {
+	remote := make(map[string]string, len(remoteRefs))
+	for _, r := range remoteRefs {
+		remote[r.Name] = r.Sha
+	}
+	old := func(name string) string {
+		if sha, ok := remote[name]; ok {
+			return sha
+		}
+		return zeroSha
+	}
+
+	updates := make([]*pushUpdate, 0, len(specs))
+	for _, spec := range specs {
+		if i := strings.IndexByte(spec.Src, '*'); i >= 0 {
+			local, err := listRefs(spec.Src[:i])
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range sortedKeys(local) {
+				if dst, ok := spec.match(name); ok {
+					updates = append(updates, &pushUpdate{Src: name, Ref: name, Dst: dst, Old: old(dst), New: local[name], Force: spec.Force})
+				}
+			}
+			continue
+		}
+
+		if spec.Src == "" {
+			dst := ""
+			for _, name := range dwimRemoteNames(spec.Dst) {
+				if _, ok := remote[name]; ok && strings.HasPrefix(name, "refs/") {
+					dst = name
+					break
+				}
+			}
+			if dst == "" {
+				return nil, fmt.Errorf("unable to delete '%s': remote ref does not exist", spec.Dst)
+			}
+			updates = append(updates, &pushUpdate{Dst: dst, Old: old(dst), New: zeroSha, Force: spec.Force})
+			continue
+		}
+
+		sha, err := revParse(spec.Src)
+		if err != nil {
+			return nil, fmt.Errorf("src refspec %s does not match any", spec.Src)
+		}
+		src := ""
+		if spec.Src == "HEAD" {
+			if src, err = readSymbolicRef("HEAD"); err != nil {
+				return nil, err
+			}
+		} else if name, err := dwimRef(spec.Src); err == nil {
+			src = name
+		}
+
+		dst := spec.Dst
+		if dst == "" {
+			dst = src
+		}
+		if dst == "" {
+			dst = spec.Src
+		}
+		if !strings.HasPrefix(dst, "refs/") {
+			found := ""
+			for _, name := range dwimRemoteNames(dst)[1:] {
+				if _, ok := remote[name]; ok {
+					found = name
+					break
+				}
+			}
+			switch {
+			case found != "":
+				dst = found
+			case strings.HasPrefix(src, "refs/heads/"):
+				dst = "refs/heads/" + dst
+			case strings.HasPrefix(src, "refs/tags/"):
+				dst = "refs/tags/" + dst
+			default:
+				return nil, fmt.Errorf("The destination you provided is not a full refname (i.e.,\n"+
+					"starting with \"refs/\"). Unable to guess a prefix for '%s'.", dst)
+			}
+		}
+		updates = append(updates, &pushUpdate{Src: spec.Src, Ref: src, Dst: dst, Old: old(dst), New: sha, Force: spec.Force})
+	}
+	return updates, nil
+}
+
+// checkUpdates decides what each update is, refusing those the remote
+// would refuse.
+func checkUpdates(updates []*pushUpdate, remote string, opts pushOptions) error {
+	for _, u := range updates {
+		expect, lease := opts.Leases[u.Dst]
+		if !lease {
+			expect, lease = opts.Leases[shortRefName(u.Dst)]
+		}
+		if !lease {
+			expect, lease = opts.Leases[""]
+		}
+		if lease {
+			u.HasLease, u.Force = true, true
+			if expect == "" {
+				tracking, err := trackingRef(remote, u.Dst)
+				if err != nil {
+					return err
+				}
+				if u.Lease, err = resolveRef(tracking); err != nil && err != errRefNotFound {
+					return err
+				}
+			} else {
+				sha, err := revParse(expect)
+				if err != nil {
+					return fmt.Errorf("cannot parse expected object name '%s'", expect)
+				}
+				u.Lease = sha
+			}
+		}
+
+		switch {
+		case u.Old == u.New:
+			u.flag, u.summary = '=', "[up to date]"
+		case u.HasLease && u.Old != u.Lease && !(u.Old == zeroSha && u.Lease == ""):
+			u.flag, u.summary, u.reason = '!', "[rejected]", "stale info"
+		case u.New == zeroSha:
+			u.flag, u.summary = '-', "[deleted]"
+		case u.Old == zeroSha:
+			u.flag, u.summary = '*', "[new reference]"
+			if strings.HasPrefix(u.Dst, "refs/heads/") {
+				u.summary = "[new branch]"
+			} else if strings.HasPrefix(u.Dst, "refs/tags/") {
+				u.summary = "[new tag]"
+			}
+		case strings.HasPrefix(u.Dst, "refs/tags/") && !u.Force:
+			u.flag, u.summary, u.reason = '!', "[rejected]", "already exists"
+		case !hasObject(u.Old) && !u.Force:
+			u.flag, u.summary, u.reason = '!', "[rejected]", "fetch first"
+		default:
+			ff := false
+			if hasObject(u.Old) {
+				var err error
+				if ff, err = isAncestor(u.Old, u.New); err != nil {
+					return err
+				}
+			}
+			switch {
+			case ff:
+				u.flag, u.summary = ' ', u.Old[:7]+".."+u.New[:7]
+			case u.Force:
+				u.flag, u.summary, u.reason = '+', u.Old[:7]+"..."+u.New[:7], "forced update"
+			default:
+				u.flag, u.summary, u.reason = '!', "[rejected]", "non-fast-forward"
+			}
+		}
+	}
+	return nil
+}
+
+// trackingRef returns the local ref remote.<name>.fetch maps a remote
+// ref to, or an empty string.
+func trackingRef(remote, name string) (string, error) {
+	for _, e := range configEntries("remote." + remote + ".fetch") {
+		spec, err := parseRefspec(e.Value)
+		if err != nil {
+			return "", err
+		}
+		if local, ok := spec.match(name); ok && local != "" {
+			return local, nil
+		}
+	}
+	return "", nil
+}
+
+// sendPack sends the commands and the pack, and fills in what the server
+// reports for each ref.
+func sendPack(t transport, adv *refAdvertisement, updates []*pushUpdate, atomic bool) error {
+	caps := []string{"report-status"}
+	if adv.Caps.has("side-band-64k") {
+		caps = append(caps, "side-band-64k")
+	}
+	if atomic {
+		caps = append(caps, "atomic")
+	}
+	if adv.Caps.has("quiet") && !isTerminal(os.Stderr) {
+		caps = append(caps, "quiet")
+	}
+	if adv.Caps.has("agent") {
+		caps = append(caps, "agent="+userAgent)
+	}
+
+	var body bytes.Buffer
+	w := newPktWriter(&body)
+	deletesOnly := true
+	for i, u := range updates {
+		if u.New == zeroSha {
+			if !adv.Caps.has("delete-refs") {
+				return errors.New("the receiving end does not support deleting refs")
+			}
+		} else {
+			deletesOnly = false
+		}
+		line := fmt.Sprintf("%s %s %s", u.Old, u.New, u.Dst)
+		if i == 0 {
+			line += "\x00" + strings.Join(caps, " ")
+		}
+		if err := w.writeLine("%s", line); err != nil {
+			return err
+		}
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if !deletesOnly {
+		pack, err := pushPack(adv, updates)
+		if err != nil {
+			return err
+		}
+		body.Write(pack)
+	}
+
+	answer, err := t.request("git-receive-pack", &body, 0)
+	if err != nil {
+		return err
+	}
+	defer answer.Close()
+
+	var report io.Reader = answer
+	if adv.Caps.has("side-band-64k") {
+		report = newSidebandReader(newPktReader(answer), os.Stderr)
+	}
+	lines, err := newPktReader(report).readLines()
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "unpack ") {
+		return errors.New("protocol error: expected the unpack status")
+	}
+	if status := strings.TrimPrefix(lines[0], "unpack "); status != "ok" {
+		fmt.Fprintf(os.Stderr, "error: remote unpack failed: %s\n", status)
+	}
+
+	byName := make(map[string]*pushUpdate, len(updates))
+	for _, u := range updates {
+		byName[u.Dst] = u
+	}
+	reported := make(map[string]bool)
+	for _, line := range lines[1:] {
+		fields := append(strings.SplitN(line, " ", 3), "", "")
+		status, name, reason := fields[0], fields[1], fields[2]
+		u, ok := byName[name]
+		if !ok || status != "ok" && status != "ng" {
+			return fmt.Errorf("protocol error: unexpected report '%s'", line)
+		}
+		reported[name] = true
+		if status == "ng" {
+			u.flag, u.summary, u.reason = '!', "[remote rejected]", reason
+		}
+	}
+	for _, u := range updates {
+		if !reported[u.Dst] {
+			u.flag, u.summary, u.reason = '!', "[remote failure]", "remote failed to report status"
+		}
+	}
+	return nil
+}
+
+// pushPack packs the objects reachable from the new values that the
+// remote's refs don't already reach, as far as we can tell.
+func pushPack(adv *refAdvertisement, updates []*pushUpdate) ([]byte, error) {
+	include := make([]string, 0, len(updates))
+	refs := make([]ref, 0, len(updates))
+	for _, u := range updates {
+		if u.New == zeroSha {
+			continue
+		}
+		refs = append(refs, ref{Sha: u.New, Name: u.Dst})
+		if sha, err := peel(u.New, "commit"); err == nil {
+			include = append(include, sha)
+		}
+	}
+	exclude := make([]string, 0, len(adv.Refs))
+	for _, r := range adv.Refs {
+		if !hasObject(r.Sha) {
+			continue
+		}
+		if sha, err := peel(r.Sha, "commit"); err == nil {
+			exclude = append(exclude, sha)
+		}
+	}
+
+	commits, err := revList(include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	objects, _, err := bundleObjects(commits, refs)
+	if err != nil {
+		return nil, err
+	}
+	// Deltas are written against offsets, which the server must accept.
+	if adv.Caps.has("ofs-delta") {
+		findDeltas(objects)
+	}
+	return encodePack(objects)
+}
+
+// updateTrackingRefs moves the remote-tracking refs of the refs pushed.
+func updateTrackingRefs(remote string, updates []*pushUpdate) error {
+	for _, u := range updates {
+		if u.rejected() || u.flag == '=' {
+			continue
+		}
+		local, err := trackingRef(remote, u.Dst)
+		if err != nil {
+			return err
+		}
+		if local == "" {
+			continue
+		}
+		if u.New == zeroSha {
+			err = deleteRef(local)
+		} else {
+			err = updateRefLogged(local, u.New, "update by push")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setUpstreams makes the branches pushed track the remote branches.
+func setUpstreams(remote string, updates []*pushUpdate) error {
+	for _, u := range updates {
+		if !strings.HasPrefix(u.Ref, "refs/heads/") || u.rejected() || !strings.HasPrefix(u.Dst, "refs/heads/") {
+			continue
+		}
+		branch := strings.TrimPrefix(u.Ref, "refs/heads/")
+		if err := setConfig(".git/config", "branch."+branch+".remote", remote, "set"); err != nil {
+			return err
+		}
+		if err := setConfig(".git/config", "branch."+branch+".merge", u.Dst, "set"); err != nil {
+			return err
+		}
+		fmt.Printf("branch '%s' set up to track '%s/%s'.\n", branch, remote, strings.TrimPrefix(u.Dst, "refs/heads/"))
+	}
+	return nil
+}
+
+// pushHints explains the most common rejections.
+func pushHints(updates []*pushUpdate) {
+	var hint []string
+	for _, u := range updates {
+		switch u.reason {
+		case "non-fast-forward":
+			hint = []string{
+				"Updates were rejected because a pushed branch tip is behind its remote",
+				"counterpart. Integrate the remote changes (e.g.",
+				"'mygit pull ...') before pushing again.",
+			}
+		case "fetch first":
+			hint = []string{
+				"Updates were rejected because the remote contains work that you do",
+				"not have locally. This is usually caused by another repository pushing",
+				"to the same ref. You may want to first integrate the remote changes",
+				"(e.g., 'mygit pull ...') before pushing again.",
+			}
+		case "already exists":
+			hint = []string{"Updates were rejected because the tag already exists in the remote."}
+		default:
+			continue
+		}
+		break
+	}
+	for _, line := range hint {
+		fmt.Fprintf(os.Stderr, "hint: %s\n", line)
+	}
+}
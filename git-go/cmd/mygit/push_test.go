@@ -0,0 +1,144 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPushRefspecs(t *testing.T) {
+	newTestRepo(t)
+	if err := setSymbolicRef("HEAD", "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+	tracking := "[branch \"main\"]\n\tremote = origin\n\tmerge = refs/heads/main\n"
+	renamed := "[branch \"main\"]\n\tremote = origin\n\tmerge = refs/heads/trunk\n"
+	tests := []struct {
+		config string
+		remote string
+		args   []string
+		opts   pushOptions
+		want   []refspec
+		err    string
+	}{
+		{"", "origin", []string{"main:other", "+v1"}, pushOptions{}, []refspec{{Src: "main", Dst: "other"}, {Src: "v1", Force: true}}, ""},
+		{"", "origin", []string{"main"}, pushOptions{Force: true}, []refspec{{Src: "main", Force: true}}, ""},
+		{"", "origin", []string{"old"}, pushOptions{Delete: true}, []refspec{{Dst: "old"}}, ""},
+		{"", "origin", nil, pushOptions{Delete: true}, nil, "--delete doesn't make sense"},
+		{"", "origin", []string{"a:b"}, pushOptions{Delete: true}, nil, "plain target ref names"},
+		{"[remote \"origin\"]\n\tpush = refs/heads/*:refs/heads/up/*\n", "origin", nil, pushOptions{}, []refspec{{Src: "refs/heads/*", Dst: "refs/heads/up/*"}}, ""},
+		{tracking, "origin", nil, pushOptions{}, []refspec{{Src: "refs/heads/main", Dst: "refs/heads/main"}}, ""},
+		{"", "origin", nil, pushOptions{}, nil, "has no upstream branch"},
+		{"", "origin", nil, pushOptions{SetUpstream: true}, []refspec{{Src: "refs/heads/main", Dst: "refs/heads/main"}}, ""},
+		{tracking, "other", nil, pushOptions{}, []refspec{{Src: "refs/heads/main", Dst: "refs/heads/main"}}, ""},
+		{renamed, "origin", nil, pushOptions{}, nil, "does not match"},
+		{renamed + "[push]\n\tdefault = upstream\n", "origin", nil, pushOptions{}, []refspec{{Src: "refs/heads/main", Dst: "refs/heads/trunk"}}, ""},
+		{renamed + "[push]\n\tdefault = upstream\n", "other", nil, pushOptions{}, nil, "not the upstream"},
+		{renamed + "[push]\n\tdefault = current\n", "origin", nil, pushOptions{}, []refspec{{Src: "refs/heads/main", Dst: "refs/heads/main"}}, ""},
+		{"[push]\n\tdefault = nothing\n", "origin", nil, pushOptions{}, nil, "\"nothing\""},
+		{"[push]\n\tdefault = matching\n", "origin", nil, pushOptions{}, nil, "unsupported value"},
+	}
+	for _, tt := range tests {
+		setTestConfig(t, tt.config)
+		got, err := pushRefspecs(tt.remote, tt.args, tt.opts)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("pushRefspecs(%s, %q) with %q: error %v, want %q", tt.remote, tt.args, tt.config, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pushRefspecs(%s, %q) with %q = %+v, %v; want %+v", tt.remote, tt.args, tt.config, got, err, tt.want)
+		}
+	}
+}
+
+func TestPushUpdates(t *testing.T) {
+	newTestRepo(t)
+	setTestConfig(t, "[remote \"origin\"]\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n")
+	c1 := testCommit(t, map[string]string{"a": "1\n"}, 100)
+	c2 := testCommit(t, map[string]string{"a": "2\n"}, 200, c1)
+	c3 := testCommit(t, map[string]string{"a": "3\n"}, 300, c1)
+	unknown := strings.Repeat("f", 40)
+	for name, sha := range map[string]string{
+		"refs/heads/master":           c2,
+		"refs/heads/topic":            c3,
+		"refs/tags/v1":                c1,
+		"refs/remotes/origin/topic":   c2,
+		"refs/remotes/origin/feature": c1,
+	} {
+		if err := updateRef(name, sha); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remote := []ref{
+		{Sha: c1, Name: "refs/heads/master"},
+		{Sha: c2, Name: "refs/heads/topic"},
+		{Sha: c2, Name: "refs/heads/feature"},
+		{Sha: unknown, Name: "refs/heads/theirs"},
+		{Sha: c3, Name: "refs/tags/v1"},
+		{Sha: c1, Name: "refs/heads/old"},
+	}
+
+	tests := []struct {
+		spec    string
+		opts    pushOptions
+		dst     string
+		flag    byte
+		summary string
+	}{
+		{"master", pushOptions{}, "refs/heads/master", ' ', c1[:7] + ".." + c2[:7]},
+		{"topic", pushOptions{}, "refs/heads/topic", '!', "[rejected]"},
+		{"+topic", pushOptions{}, "refs/heads/topic", '+', c2[:7] + "..." + c3[:7]},
+		{"topic:feature", pushOptions{}, "refs/heads/feature", '!', "[rejected]"},
+		{"master:theirs", pushOptions{}, "refs/heads/theirs", '!', "[rejected]"},
+		{"v1", pushOptions{}, "refs/tags/v1", '!', "[rejected]"},
+		{"master:new", pushOptions{}, "refs/heads/new", '*', "[new branch]"},
+		{"HEAD:refs/heads/master", pushOptions{}, "refs/heads/master", ' ', c1[:7] + ".." + c2[:7]},
+		{"master:topic", pushOptions{}, "refs/heads/topic", '=', "[up to date]"},
+		{":old", pushOptions{}, "refs/heads/old", '-', "[deleted]"},
+		// The lease holds when the remote-tracking ref matches.
+		{"topic", pushOptions{Leases: map[string]string{"": ""}}, "refs/heads/topic", '+', c2[:7] + "..." + c3[:7]},
+		{"topic:feature", pushOptions{Leases: map[string]string{"": ""}}, "refs/heads/feature", '!', "[rejected]"},
+		{"topic:feature", pushOptions{Leases: map[string]string{"feature": c2}}, "refs/heads/feature", '+', c2[:7] + "..." + c3[:7]},
+	}
+	for _, tt := range tests {
+		spec, err := parseRefspec(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		updates, err := pushUpdates([]refspec{spec}, remote)
+		if err != nil {
+			t.Fatalf("pushUpdates(%s): %s", tt.spec, err)
+		}
+		if err := checkUpdates(updates, "origin", tt.opts); err != nil {
+			t.Fatalf("checkUpdates(%s): %s", tt.spec, err)
+		}
+		if len(updates) != 1 {
+			t.Fatalf("pushUpdates(%s) = %d updates", tt.spec, len(updates))
+		}
+		u := updates[0]
+		if u.Dst != tt.dst || u.flag != tt.flag || u.summary != tt.summary {
+			t.Errorf("push %s %+v = %s %q %s, want %s %q %s", tt.spec, tt.opts, u.Dst, u.flag, u.summary, tt.dst, tt.flag, tt.summary)
+		}
+	}
+
+	spec, _ := parseRefspec("refs/heads/*:refs/heads/*")
+	updates, err := pushUpdates([]refspec{spec}, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsts := make([]string, 0)
+	for _, u := range updates {
+		dsts = append(dsts, u.Dst)
+	}
+	if want := []string{"refs/heads/master", "refs/heads/topic"}; !reflect.DeepEqual(dsts, want) {
+		t.Errorf("glob push updates %q, want %q", dsts, want)
+	}
+	if _, err := pushUpdates([]refspec{{Dst: "gone"}}, remote); err == nil {
+		t.Error("deleting a ref the remote lacks did not fail")
+	}
+	if _, err := pushUpdates([]refspec{{Src: "nothing"}}, remote); err == nil {
+		t.Error("pushing an unknown ref did not fail")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// reachableObject is an object found by walking history, with the path it
+// was first seen at for trees and blobs.
+type reachableObject struct {
+	Sha     string
+	Type    string
+	Name    string
+	Content []byte
+}
+
+// objectRoots returns the objects the repository must keep: the tips of
+// all refs, the special refs like ORIG_HEAD, every value recorded in a
+// reflog and the blobs of the index.
+func objectRoots() ([]string, error) {
+	roots := make(map[string]string)
+	add := func(sha string) {
+		if sha != "" && sha != zeroSha && hasObject(sha) {
+			roots[sha] = sha
+		}
+	}
+
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	for _, sha := range refs {
+		add(sha)
+	}
+	for _, name := range []string{"HEAD", "ORIG_HEAD", "MERGE_HEAD", "CHERRY_PICK_HEAD", "REVERT_HEAD", "REBASE_HEAD"} {
+		if sha, err := resolveRef(name); err == nil {
+			add(sha)
+		}
+	}
+	for _, name := range []string{"orig-head", "onto"} {
+		if rebaseInProgress() {
+			add(readRebaseFile(name))
+		}
+	}
+
+	err = filepath.Walk(".git/logs", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		entries, err := readReflog(filepath.ToSlash(strings.TrimPrefix(p, ".git/logs/")))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			add(e.Old)
+			add(e.New)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := readIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range idx.Entries {
+		if e.Mode != modeGitlink {
+			add(e.Sha)
+		}
+	}
+	return sortedKeys(roots), nil
+}
+
+// reachableObjects lists every object reachable from the roots: commits
+// and tags first, then the trees and blobs they lead to.
+func reachableObjects(roots []string) ([]*reachableObject, error) {
+	seen := make(map[string]bool)
+	history := make([]*reachableObject, 0)
+	contents := make([]*reachableObject, 0)
+
+	var visit func(sha, name string) error
+	visit = func(sha, name string) error {
+		if seen[sha] {
+			return nil
+		}
+		seen[sha] = true
+
+		t, content, err := readObject(sha)
+		if err != nil {
+			return err
+		}
+		o := &reachableObject{Sha: sha, Type: t, Name: name, Content: content}
+
+		switch t {
+		case "commit":
+			history = append(history, o)
+			c, err := parseCommit(content)
+			if err != nil {
+				return err
+			}
+			if err := visit(c.Tree, ""); err != nil {
+				return err
+			}
+			for _, p := range c.Parents {
+				if err := visit(p, ""); err != nil {
+					return err
+				}
+			}
+		case "tag":
+			history = append(history, o)
+			target, err := tagTarget(content)
+			if err != nil {
+				return fmt.Errorf("tag %s: %s", sha, err)
+			}
+			return visit(target, "")
+		case "tree":
+			contents = append(contents, o)
+			entries, err := parseTree(content)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if e.Mode == modeGitlink {
+					continue
+				}
+				if err := visit(e.Sha, e.Name); err != nil {
+					return err
+				}
+			}
+		default:
+			contents = append(contents, o)
+		}
+		return nil
+	}
+
+	for _, sha := range roots {
+		if err := visit(sha, ""); err != nil {
+			return nil, err
+		}
+	}
+	return append(history, contents...), nil
+}
+
+// tagTarget returns the object an annotated tag points to.
+func tagTarget(content []byte) (string, error) {
+	line := content
+	if i := bytes.IndexByte(content, '\n'); i >= 0 {
+		line = content[:i]
+	}
+	if !bytes.HasPrefix(line, []byte("object ")) || len(line) != 47 {
+		return "", fmt.Errorf("malformed tag")
+	}
+	return string(line[7:]), nil
+}
@@ -0,0 +1,864 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// The rebase-merge directory holds the state of a rebase in progress, using
+// the same files as git:
+//   - head-name is the branch being rebased, or "detached HEAD".
+//   - onto and orig-head are the new base and where the branch started.
+//   - git-rebase-todo lists the steps still to run and done the ones that
+//     already ran.
+//   - interactive exists for "rebase -i".
+//   - stopped-sha, message, author-script and amend describe the step that
+//     stopped on a conflict, so that --continue can commit it.
+const rebaseDir = ".git/rebase-merge"
+
+const rebaseTodoHelp = `
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash" but keep only the previous
+#                    commit's log message
+# x, exec <command> = run command (the rest of the line) using shell
+# d, drop <commit> = remove commit
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+#
+# If you remove a line here THAT COMMIT WILL BE LOST.
+#
+# However, if you remove everything, the rebase will be aborted.
+#
+`
+
+type rebaseStep struct {
+	Command string
+	Sha     string
+	Arg     string
+}
+
+func (s rebaseStep) String() string {
+	if s.Command == "exec" {
+		return "exec " + s.Arg
+	}
+	return fmt.Sprintf("%s %s %s", s.Command, s.Sha, s.Arg)
+}
+
+func rebaseCommand(args []string) (bool, error) {
+	interactive := false
+	onto := ""
+	action := ""
+	revs := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-i" || arg == "--interactive":
+			interactive = true
+		case arg == "--onto":
+			if i+1 == len(args) {
+				return false, errors.New("option --onto requires a value")
+			}
+			i++
+			onto = args[i]
+		case strings.HasPrefix(arg, "--onto="):
+			onto = strings.TrimPrefix(arg, "--onto=")
+		case arg == "--continue" || arg == "--skip" || arg == "--abort":
+			action = arg
+		case strings.HasPrefix(arg, "-"):
+			return false, fmt.Errorf("unknown option %s", arg)
+		default:
+			revs = append(revs, arg)
+		}
+	}
+
+	if action != "" {
+		if !rebaseInProgress() {
+			return false, errors.New("No rebase in progress?")
+		}
+		switch action {
+		case "--continue":
+			return rebaseContinue()
+		case "--skip":
+			return rebaseSkip()
+		default:
+			return true, rebaseAbort()
+		}
+	}
+
+	if len(revs) < 1 || len(revs) > 2 {
+		return false, errors.New("usage: mygit rebase [-i] [--onto <newbase>] <upstream> [<branch>] | --continue | --skip | --abort")
+	}
+	branch := ""
+	if len(revs) == 2 {
+		branch = revs[1]
+	}
+	return rebaseStart(revs[0], onto, branch, interactive)
+}
+
+func rebaseInProgress() bool {
+	_, err := os.Stat(rebaseDir)
+	return err == nil
+}
+
+func rebaseStart(upstreamRev, ontoRev, branch string, interactive bool) (bool, error) {
+	if rebaseInProgress() {
+		return false, fmt.Errorf("It seems that there is already a rebase-merge directory.\n" +
+			"Use \"mygit rebase (--continue | --skip | --abort)\" to go on with it.")
+	}
+	if isMerging() || sequencerInProgress() {
+		return false, errors.New("cannot rebase while a merge, cherry-pick or revert is in progress")
+	}
+
+	idx, err := readIndex()
+	if err != nil {
+		return false, err
+	}
+	status, err := readStatus(idx)
+	if err != nil {
+		return false, err
+	}
+	if !status.Clean() {
+		return false, errors.New("cannot rebase: You have unstaged or uncommitted changes.\nPlease commit or stash them.")
+	}
+
+	if branch != "" {
+		if err := switchBranch(idx, branch); err != nil {
+			return false, err
+		}
+	}
+
+	upstream, err := resolveCommit(upstreamRev)
+	if err != nil {
+		return false, err
+	}
+	onto := upstream
+	if ontoRev != "" {
+		onto, err = resolveCommit(ontoRev)
+		if err != nil {
+			return false, err
+		}
+	}
+	head, err := headCommit()
+	if err != nil {
+		return false, err
+	}
+	if head == "" {
+		return false, errors.New("cannot rebase an unborn branch")
+	}
+	headName, err := readSymbolicRef("HEAD")
+	if err != nil {
+		return false, err
+	}
+	if headName == "" {
+		headName = "detached HEAD"
+	}
+
+	if !interactive && onto == upstream {
+		upToDate, err := isAncestor(upstream, head)
+		if err != nil {
+			return false, err
+		}
+		if upToDate {
+			fmt.Printf("Current branch %s is up to date.\n", strings.TrimPrefix(headName, "refs/heads/"))
+			return true, nil
+		}
+	}
+
+	steps, err := rebaseSteps(upstream, head)
+	if err != nil {
+		return false, err
+	}
+
+	if err := os.MkdirAll(rebaseDir, 0755); err != nil {
+		return false, err
+	}
+	for name, content := range map[string]string{
+		"head-name": headName,
+		"onto":      onto,
+		"orig-head": head,
+	} {
+		if err := writeRebaseFile(name, content+"\n"); err != nil {
+			return false, err
+		}
+	}
+	if err := writeRebaseTodo(steps); err != nil {
+		return false, err
+	}
+
+	if interactive {
+		if err := writeRebaseFile("interactive", ""); err != nil {
+			return false, err
+		}
+		steps, err = editRebaseTodo(steps, upstream, head, onto)
+		if err != nil {
+			os.RemoveAll(rebaseDir)
+			return false, err
+		}
+		if len(steps) == 0 {
+			os.RemoveAll(rebaseDir)
+			return false, errors.New("Nothing to do")
+		}
+		if err := writeRebaseTodo(steps); err != nil {
+			return false, err
+		}
+	}
+
+	// Detach HEAD at the new base, the steps are replayed on top of it.
+	from, err := commitFiles(head)
+	if err != nil {
+		return false, err
+	}
+	to, err := commitFiles(onto)
+	if err != nil {
+		return false, err
+	}
+	if err := checkoutFiles(idx, from, to, false, "rebase"); err != nil {
+		os.RemoveAll(rebaseDir)
+		return false, err
+	}
+	if err := idx.write(); err != nil {
+		return false, err
+	}
+	if err := updateRef("HEAD", onto); err != nil {
+		return false, err
+	}
+	return rebaseRun()
+}
+
+// rebaseSteps lists the commits of head that upstream does not have, oldest
+// first. Merges are left out, and so are commits whose change upstream
+// already carries.
+func rebaseSteps(upstream, head string) ([]rebaseStep, error) {
+	commits, err := revList([]string{head}, []string{upstream})
+	if err != nil {
+		return nil, err
+	}
+	upstreamOnly, err := revList([]string{upstream}, []string{head})
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[string]bool)
+	for _, sha := range upstreamOnly {
+		id, err := patchID(sha)
+		if err != nil {
+			return nil, err
+		}
+		applied[id] = true
+	}
+
+	steps := make([]rebaseStep, 0)
+	for _, sha := range commits {
+		c, err := readCommit(sha)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.Parents) > 1 {
+			continue
+		}
+		id, err := patchID(sha)
+		if err != nil {
+			return nil, err
+		}
+		if applied[id] {
+			continue
+		}
+		steps = append(steps, rebaseStep{Command: "pick", Sha: sha[:7], Arg: c.Subject()})
+	}
+	return steps, nil
+}
+
+// patchID hashes the change a commit makes, ignoring where in the files the
+// changed lines are, so that the same change applied elsewhere hashes alike.
+func patchID(sha string) (string, error) {
+	c, err := readCommit(sha)
+	if err != nil {
+		return "", err
+	}
+	parentTree := ""
+	if len(c.Parents) > 0 {
+		parentTree, err = peel(c.Parents[0], "tree")
+		if err != nil {
+			return "", err
+		}
+	}
+	before, err := flattenTree(parentTree)
+	if err != nil {
+		return "", err
+	}
+	after, err := flattenTree(c.Tree)
+	if err != nil {
+		return "", err
+	}
+
+	paths := make(map[string]string)
+	for p, e := range after {
+		if before[p] != e {
+			paths[p] = p
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			paths[p] = p
+		}
+	}
+
+	h := sha1.New()
+	for _, p := range sortedKeys(paths) {
+		fmt.Fprintf(h, "%s\x00", p)
+		var old, new []byte
+		if e, ok := before[p]; ok && e.Type() == "blob" {
+			old, err = readObjectOfType(e.Sha, "blob")
+			if err != nil {
+				return "", err
+			}
+		}
+		if e, ok := after[p]; ok && e.Type() == "blob" {
+			new, err = readObjectOfType(e.Sha, "blob")
+			if err != nil {
+				return "", err
+			}
+		}
+		a, b := splitLines(old), splitLines(new)
+		for _, hunk := range diffLines(a, b) {
+			for _, l := range a[hunk.A0:hunk.A1] {
+				fmt.Fprintf(h, "-%s", l)
+			}
+			for _, l := range b[hunk.B0:hunk.B1] {
+				fmt.Fprintf(h, "+%s", l)
+			}
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func editRebaseTodo(steps []rebaseStep, upstream, head, onto string) ([]rebaseStep, error) {
+	var b strings.Builder
+	for _, s := range steps {
+		b.WriteString(s.String() + "\n")
+	}
+	fmt.Fprintf(&b, "\n# Rebase %s..%s onto %s (%d commands)\n#", upstream[:7], head[:7], onto[:7], len(steps))
+	b.WriteString(rebaseTodoHelp)
+
+	p := path.Join(rebaseDir, "git-rebase-todo")
+	if err := os.WriteFile(p, []byte(b.String()), 0644); err != nil {
+		return nil, err
+	}
+	if err := launchEditor(p, true); err != nil {
+		return nil, err
+	}
+	steps, err := readRebaseTodo()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the first commit picked may not be melded into a previous one.
+	for _, s := range steps {
+		if s.Command == "squash" || s.Command == "fixup" {
+			return nil, fmt.Errorf("cannot '%s' without a previous commit", s.Command)
+		}
+		if s.Command != "exec" && s.Command != "drop" {
+			break
+		}
+	}
+	return steps, nil
+}
+
+func parseRebaseTodo(b []byte) ([]rebaseStep, error) {
+	aliases := map[string]string{
+		"p": "pick", "r": "reword", "s": "squash",
+		"f": "fixup", "x": "exec", "d": "drop",
+	}
+
+	steps := make([]rebaseStep, 0)
+	for n, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		command, rest := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			command, rest = line[:i], line[i+1:]
+		}
+		if full, ok := aliases[command]; ok {
+			command = full
+		}
+		rest = strings.TrimSpace(rest)
+
+		switch command {
+		case "exec":
+			if rest == "" {
+				return nil, fmt.Errorf("missing command on line %d of the todo list", n+1)
+			}
+			steps = append(steps, rebaseStep{Command: command, Arg: rest})
+		case "pick", "reword", "squash", "fixup", "drop":
+			sha, subject := rest, ""
+			if i := strings.IndexByte(rest, ' '); i >= 0 {
+				sha, subject = rest[:i], rest[i+1:]
+			}
+			if _, err := resolveCommit(sha); err != nil {
+				return nil, fmt.Errorf("invalid commit %q on line %d of the todo list", sha, n+1)
+			}
+			steps = append(steps, rebaseStep{Command: command, Sha: sha, Arg: subject})
+		default:
+			return nil, fmt.Errorf("invalid command %q on line %d of the todo list", command, n+1)
+		}
+	}
+
+	return steps, nil
+}
+
+func readRebaseTodo() ([]rebaseStep, error) {
+	b, err := os.ReadFile(path.Join(rebaseDir, "git-rebase-todo"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return parseRebaseTodo(b)
+}
+
+func writeRebaseTodo(steps []rebaseStep) error {
+	var b strings.Builder
+	for _, s := range steps {
+		b.WriteString(s.String() + "\n")
+	}
+	return writeRebaseFile("git-rebase-todo", b.String())
+}
+
+func readRebaseFile(name string) string {
+	b, err := os.ReadFile(path.Join(rebaseDir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func writeRebaseFile(name, content string) error {
+	return os.WriteFile(path.Join(rebaseDir, name), []byte(content), 0644)
+}
+
+// rebaseRun executes the todo list one step at a time, saving progress after
+// each step so that the rebase can stop and resume.
+func rebaseRun() (bool, error) {
+	for {
+		steps, err := readRebaseTodo()
+		if err != nil {
+			return false, err
+		}
+		if len(steps) == 0 {
+			return true, rebaseFinish()
+		}
+
+		step := steps[0]
+		if err := writeRebaseTodo(steps[1:]); err != nil {
+			return false, err
+		}
+		done, err := os.OpenFile(path.Join(rebaseDir, "done"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintln(done, step.String())
+		done.Close()
+
+		ok, err := rebaseStepRun(step)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+}
+
+func rebaseStepRun(step rebaseStep) (bool, error) {
+	switch step.Command {
+	case "drop":
+		return true, nil
+	case "exec":
+		fmt.Printf("Executing: %s\n", step.Arg)
+		cmd := exec.Command("sh", "-c", step.Arg)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: execution failed: %s\n", step.Arg)
+			fmt.Fprintf(os.Stderr, "You can fix the problem, and then run\n\n  mygit rebase --continue\n\n")
+			return false, nil
+		}
+		return true, nil
+	}
+
+	sha, err := resolveCommit(step.Sha)
+	if err != nil {
+		return false, err
+	}
+	c, err := readCommit(sha)
+	if err != nil {
+		return false, err
+	}
+	head, err := headCommit()
+	if err != nil {
+		return false, err
+	}
+	squash := step.Command == "squash" || step.Command == "fixup"
+
+	// A commit that already sits on HEAD is reused as is.
+	if !squash && len(c.Parents) == 1 && c.Parents[0] == head {
+		if err := rebaseCheckout(head, sha); err != nil {
+			return false, err
+		}
+		if step.Command == "reword" {
+			return true, rebaseCommit(c.Tree, c.Parents, c.Author, c.Message, true)
+		}
+		return true, nil
+	}
+
+	p, err := applyPick(c, pickOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	msg := c.Message
+	author := c.Author
+	parents := []string{head}
+	if squash {
+		prev, err := readCommit(head)
+		if err != nil {
+			return false, err
+		}
+		msg = squashCommitMessage(prev.Message, c.Message, step.Command == "fixup")
+		author = prev.Author
+		parents = prev.Parents
+	}
+
+	if !p.Merge.Clean() {
+		for _, m := range p.Merge.Messages {
+			fmt.Println(m)
+		}
+		if err := rebaseStop(c, msg, author, squash); err != nil {
+			return false, err
+		}
+		fmt.Fprintf(os.Stderr, "error: could not apply %s... %s\n", sha[:7], c.Subject())
+		fmt.Fprintf(os.Stderr, "hint: Resolve all conflicts manually, mark them as resolved with\n")
+		fmt.Fprintf(os.Stderr, "hint: \"mygit add <pathspec>\", then run \"mygit rebase --continue\".\n")
+		fmt.Fprintf(os.Stderr, "hint: You can instead skip this commit: run \"mygit rebase --skip\".\n")
+		fmt.Fprintf(os.Stderr, "hint: To abort and get back to the state before \"mygit rebase\", run \"mygit rebase --abort\".\n")
+		return false, nil
+	}
+
+	if p.Merge.Tree == p.HeadTree && !squash {
+		fmt.Printf("dropping %s %s -- patch contents already upstream\n", sha, c.Subject())
+		return true, nil
+	}
+	edit := step.Command == "reword" || step.Command == "squash"
+	return true, rebaseCommit(p.Merge.Tree, parents, author, msg, edit)
+}
+
+// squashCommitMessage combines the message of the commit being melded into
+// with the one of the commit being squashed or fixed up.
+func squashCommitMessage(prev, msg string, fixup bool) string {
+	var b strings.Builder
+	b.WriteString("# This is a combination of 2 commits.\n")
+	b.WriteString("# This is the 1st commit message:\n\n")
+	b.WriteString(strings.TrimRight(prev, "\n") + "\n\n")
+	if fixup {
+		b.WriteString("# The commit message #2 will be skipped:\n\n")
+		for _, line := range strings.Split(strings.TrimRight(msg, "\n"), "\n") {
+			b.WriteString("# " + line + "\n")
+		}
+	} else {
+		b.WriteString("# This is the commit message #2:\n\n")
+		b.WriteString(strings.TrimRight(msg, "\n") + "\n")
+	}
+	return b.String()
+}
+
+// rebaseCommit commits the index on top of the given parents, letting the
+// user edit the message first when asked to.
+func rebaseCommit(tree string, parents []string, author, msg string, edit bool) error {
+	if edit {
+		p := ".git/COMMIT_EDITMSG"
+		if err := os.WriteFile(p, []byte(msg), 0644); err != nil {
+			return err
+		}
+		if err := launchEditor(p, false); err != nil {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		msg = string(b)
+	}
+	msg = cleanupMessage(msg)
+	if msg == "" {
+		return errors.New("Aborting commit due to empty commit message.")
+	}
+
+	sha, err := writeCommitObject(&commit{
+		Tree:      tree,
+		Parents:   parents,
+		Author:    author,
+		Committer: signature("COMMITTER"),
+		Message:   msg,
+	})
+	if err != nil {
+		return err
+	}
+	return updateRef("HEAD", sha)
+}
+
+// rebaseCheckout moves the detached HEAD, the index and the working tree
+// from one commit to another.
+func rebaseCheckout(from, to string) error {
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	fromFiles, err := commitFiles(from)
+	if err != nil {
+		return err
+	}
+	toFiles, err := commitFiles(to)
+	if err != nil {
+		return err
+	}
+	if err := checkoutFiles(idx, fromFiles, toFiles, false, "rebase"); err != nil {
+		return err
+	}
+	if err := idx.write(); err != nil {
+		return err
+	}
+	return updateRef("HEAD", to)
+}
+
+// rebaseStop records the step that hit a conflict.
+func rebaseStop(c *commit, msg, author string, amend bool) error {
+	if err := writeRebaseFile("stopped-sha", c.Sha+"\n"); err != nil {
+		return err
+	}
+	if err := writeRebaseFile("message", msg); err != nil {
+		return err
+	}
+	if err := writeRebaseFile("author-script", authorScript(author)); err != nil {
+		return err
+	}
+	if amend {
+		head, err := headCommit()
+		if err != nil {
+			return err
+		}
+		if err := writeRebaseFile("amend", head+"\n"); err != nil {
+			return err
+		}
+	}
+	return updateRef("REBASE_HEAD", c.Sha)
+}
+
+func clearRebaseStop() {
+	for _, name := range []string{"stopped-sha", "message", "author-script", "amend"} {
+		os.Remove(path.Join(rebaseDir, name))
+	}
+	os.Remove(".git/REBASE_HEAD")
+}
+
+func rebaseContinue() (bool, error) {
+	idx, err := readIndex()
+	if err != nil {
+		return false, err
+	}
+	if len(idx.unmerged()) > 0 {
+		return false, errors.New("You must edit all merge conflicts and then\nmark them as resolved using mygit add")
+	}
+
+	if readRebaseFile("stopped-sha") != "" {
+		tree, err := idx.writeTree()
+		if err != nil {
+			return false, err
+		}
+		head, err := headCommit()
+		if err != nil {
+			return false, err
+		}
+		headTree, err := peel(head, "tree")
+		if err != nil {
+			return false, err
+		}
+
+		parents := []string{head}
+		amend := readRebaseFile("amend") != ""
+		if amend {
+			c, err := readCommit(head)
+			if err != nil {
+				return false, err
+			}
+			parents = c.Parents
+		}
+
+		if tree != headTree || amend {
+			b, err := os.ReadFile(path.Join(rebaseDir, "message"))
+			if err != nil {
+				return false, err
+			}
+			author, err := readAuthorScript()
+			if err != nil {
+				return false, err
+			}
+			if err := rebaseCommit(tree, parents, author, string(b), false); err != nil {
+				return false, err
+			}
+		}
+		clearRebaseStop()
+	}
+	return rebaseRun()
+}
+
+func rebaseSkip() (bool, error) {
+	idx, err := readIndex()
+	if err != nil {
+		return false, err
+	}
+	files, err := headFiles()
+	if err != nil {
+		return false, err
+	}
+	if err := resetMerge(idx, files); err != nil {
+		return false, err
+	}
+	if err := idx.write(); err != nil {
+		return false, err
+	}
+	clearRebaseStop()
+	return rebaseRun()
+}
+
+func rebaseAbort() error {
+	orig := readRebaseFile("orig-head")
+	headName := readRebaseFile("head-name")
+
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(orig)
+	if err != nil {
+		return err
+	}
+	if err := resetMerge(idx, files); err != nil {
+		return err
+	}
+	if err := idx.write(); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(headName, "refs/") {
+		err = setSymbolicRef("HEAD", headName)
+	} else {
+		err = updateRef("HEAD", orig)
+	}
+	if err != nil {
+		return err
+	}
+	clearRebaseStop()
+	return os.RemoveAll(rebaseDir)
+}
+
+func rebaseFinish() error {
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	headName := readRebaseFile("head-name")
+	if strings.HasPrefix(headName, "refs/") {
+		if err := updateRef(headName, head); err != nil {
+			return err
+		}
+		if err := setSymbolicRef("HEAD", headName); err != nil {
+			return err
+		}
+	}
+	if err := updateRef("ORIG_HEAD", readRebaseFile("orig-head")); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(rebaseDir); err != nil {
+		return err
+	}
+	fmt.Printf("Successfully rebased and updated %s.\n", headName)
+	return nil
+}
+
+// switchBranch checks out a branch, or detaches HEAD at any other revision.
+func switchBranch(idx *index, rev string) error {
+	sha, err := resolveCommit(rev)
+	if err != nil {
+		return err
+	}
+	from, err := headFiles()
+	if err != nil {
+		return err
+	}
+	to, err := commitFiles(sha)
+	if err != nil {
+		return err
+	}
+	if err := checkoutFiles(idx, from, to, false, "checkout"); err != nil {
+		return err
+	}
+	if err := idx.write(); err != nil {
+		return err
+	}
+
+	if _, err := resolveRef("refs/heads/" + rev); err == nil {
+		return setSymbolicRef("HEAD", "refs/heads/"+rev)
+	}
+	return updateRef("HEAD", sha)
+}
+
+// authorScript formats an identity the way git's author-script file does.
+func authorScript(sig string) string {
+	name, email, date := splitSignature(sig)
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	return fmt.Sprintf("GIT_AUTHOR_NAME=%s\nGIT_AUTHOR_EMAIL=%s\nGIT_AUTHOR_DATE=%s\n",
+		quote(name), quote(email), quote("@"+date))
+}
+
+func readAuthorScript() (string, error) {
+	b, err := os.ReadFile(path.Join(rebaseDir, "author-script"))
+	if err != nil {
+		return "", err
+	}
+
+	values := make(map[string]string)
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		i := bytes.IndexByte(line, '=')
+		if i < 0 {
+			continue
+		}
+		key := string(line[:i])
+		value := strings.TrimSuffix(strings.TrimPrefix(string(line[i+1:]), "'"), "'")
+		values[key] = strings.ReplaceAll(value, `'\''`, "'")
+	}
+	return fmt.Sprintf("%s <%s> %s", values["GIT_AUTHOR_NAME"], values["GIT_AUTHOR_EMAIL"],
+		strings.TrimPrefix(values["GIT_AUTHOR_DATE"], "@")), nil
+}
+
+// splitSignature splits "Name <email> 1700000000 +0000" into its parts.
+func splitSignature(sig string) (string, string, string) {
+	lt := strings.IndexByte(sig, '<')
+	gt := strings.LastIndexByte(sig, '>')
+	if lt < 0 || gt < lt {
+		return sig, "", ""
+	}
+	return strings.TrimSpace(sig[:lt]), sig[lt+1 : gt], strings.TrimSpace(sig[gt+1:])
+}
+
+// rebaseHeadName returns the short name of the branch being rebased.
+func rebaseHeadName() string {
+	return strings.TrimPrefix(readRebaseFile("head-name"), "refs/heads/")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRebaseTodo(t *testing.T) {
+	newTestRepo(t)
+	c1 := testCommit(t, map[string]string{"f": "1\n"}, 100)
+	c2 := testCommit(t, map[string]string{"f": "2\n"}, 200, c1)
+	short1, short2 := c1[:7], c2[:7]
+
+	for _, test := range []struct {
+		name    string
+		todo    string
+		want    []rebaseStep
+		wantErr string
+	}{
+		{
+			name: "commands and aliases",
+			todo: "pick " + short1 + " First\n" +
+				"# a comment\n\n" +
+				"  r " + short2 + " Second one  \n" +
+				"s " + short1 + "\n" +
+				"f " + c2 + " Full id\n" +
+				"x make test\n" +
+				"d " + short1 + " Gone\n" +
+				rebaseTodoHelp,
+			want: []rebaseStep{
+				{Command: "pick", Sha: short1, Arg: "First"},
+				{Command: "reword", Sha: short2, Arg: "Second one"},
+				{Command: "squash", Sha: short1},
+				{Command: "fixup", Sha: c2, Arg: "Full id"},
+				{Command: "exec", Arg: "make test"},
+				{Command: "drop", Sha: short1, Arg: "Gone"},
+			},
+		},
+		{
+			name: "everything removed",
+			todo: "# pick " + short1 + "\n",
+			want: []rebaseStep{},
+		},
+		{
+			name:    "unknown command",
+			todo:    "pick " + short1 + "\nedit " + short2 + "\n",
+			wantErr: `invalid command "edit" on line 2 of the todo list`,
+		},
+		{
+			name:    "unknown commit",
+			todo:    "pick 0000000 Nothing\n",
+			wantErr: `invalid commit "0000000" on line 1 of the todo list`,
+		},
+		{
+			name:    "exec without a command",
+			todo:    "exec\n",
+			wantErr: "missing command on line 1 of the todo list",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseRebaseTodo([]byte(test.todo))
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("parseRebaseTodo error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseRebaseTodo = %+v, want %+v", got, test.want)
+			}
+
+			// What writeRebaseTodo writes reads back the same.
+			var b strings.Builder
+			for _, s := range got {
+				b.WriteString(s.String() + "\n")
+			}
+			again, err := parseRebaseTodo([]byte(b.String()))
+			if err != nil || !reflect.DeepEqual(again, got) {
+				t.Errorf("parseRebaseTodo(%q) = %+v, %v", b.String(), again, err)
+			}
+		})
+	}
+}
+
+func TestRebaseSteps(t *testing.T) {
+	newTestRepo(t)
+	base := testCommit(t, map[string]string{"a": "a\n", "b": "b\n"}, 100)
+	// upstream makes the same change to b as the second commit on the
+	// branch, so that one is left out.
+	upstream := testCommit(t, map[string]string{"a": "a\n", "b": "b\nmore\n"}, 200, base)
+	one := testCommit(t, map[string]string{"a": "a1\n", "b": "b\n"}, 300, base)
+	two := testCommit(t, map[string]string{"a": "a1\n", "b": "b\nmore\n"}, 400, one)
+	merge := testCommit(t, map[string]string{"a": "a1\n", "b": "b\nmore\n"}, 500, two, one)
+	three := testCommit(t, map[string]string{"a": "a1\n", "b": "b\nmore\n", "c": "c\n"}, 600, merge)
+
+	steps, err := rebaseSteps(upstream, three)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rebaseStep{
+		{Command: "pick", Sha: one[:7], Arg: "commit at 300"},
+		{Command: "pick", Sha: three[:7], Arg: "commit at 600"},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("rebaseSteps = %+v, want %+v", steps, want)
+	}
+}
+
+func TestAuthorScript(t *testing.T) {
+	newTestRepo(t)
+	if err := os.MkdirAll(rebaseDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, sig := range []string{
+		"A U Thor <author@example.com> 1700000000 +0100",
+		"O'Brien <ob@example.com> 1 -0700",
+	} {
+		if err := writeRebaseFile("author-script", authorScript(sig)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readAuthorScript()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != sig {
+			t.Errorf("readAuthorScript = %q, want %q", got, sig)
+		}
+	}
+}
+
+func TestSquashCommitMessage(t *testing.T) {
+	squash := squashCommitMessage("First\n", "Second\n\nBody\n", false)
+	if want := "# This is a combination of 2 commits.\n# This is the 1st commit message:\n\nFirst\n\n" +
+		"# This is the commit message #2:\n\nSecond\n\nBody\n"; squash != want {
+		t.Errorf("squash message = %q, want %q", squash, want)
+	}
+	fixup := squashCommitMessage("First\n", "fixup! First\n", true)
+	if cleaned := cleanupMessage(fixup); cleaned != "First\n" {
+		t.Errorf("fixup message after cleanup = %q, want %q", cleaned, "First\n")
+	}
+}
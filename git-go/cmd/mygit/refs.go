@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var errRefNotFound = errors.New("ref not found")
+
+// zeroSha stands for a missing object, such as the old value of a new ref.
+const zeroSha = "0000000000000000000000000000000000000000"
+
+// readSymbolicRef returns the target of a symbolic ref such as HEAD, or an
+// empty string when the ref is not symbolic.
+func readSymbolicRef(name string) (string, error) {
+	b, err := os.ReadFile(path.Join(".git", name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	s := strings.TrimSpace(string(b))
+	if strings.HasPrefix(s, "ref: ") {
+		return strings.TrimPrefix(s, "ref: "), nil
+	}
+	return "", nil
+}
+
+// resolveRef follows symbolic refs and returns the object id a ref points to.
+func resolveRef(name string) (string, error) {
+	for i := 0; i < 5; i++ {
+		b, err := os.ReadFile(path.Join(".git", name))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+
+		if err == nil {
+			s := strings.TrimSpace(string(b))
+			if strings.HasPrefix(s, "ref: ") {
+				name = strings.TrimPrefix(s, "ref: ")
+				continue
+			}
+			if len(s) != 40 {
+				return "", fmt.Errorf("ref %s is corrupt", name)
+			}
+			return s, nil
+		}
+
+		packed, err := readPackedRefs()
+		if err != nil {
+			return "", err
+		}
+		if sha, ok := packed[name]; ok {
+			return sha, nil
+		}
+		return "", errRefNotFound
+	}
+	return "", fmt.Errorf("ref %s is a symbolic ref loop", name)
+}
+
+func readPackedRefs() (map[string]string, error) {
+	refs := make(map[string]string)
+
+	f, err := os.Open(".git/packed-refs")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return refs, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			refs[line[i+1:]] = line[:i]
+		}
+	}
+	return refs, s.Err()
+}
+
+func updateRef(name, sha string) error {
+	p := path.Join(".git", name)
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, []byte(sha+"\n"), 0644)
+}
+
+// deleteRef removes a ref, loose or packed, along with its reflog.
+func deleteRef(name string) error {
+	if err := os.Remove(path.Join(".git", name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(path.Join(".git/logs", name))
+
+	packed, err := readPackedRefs()
+	if err != nil {
+		return err
+	}
+	if _, ok := packed[name]; !ok {
+		return nil
+	}
+	delete(packed, name)
+	return writePackedRefs(packed)
+}
+
+// updateRefLogged moves a ref and records the move in its reflog, and in the
+// reflog of HEAD when HEAD points to the ref.
+func updateRefLogged(name, sha, msg string) error {
+	old, err := resolveRef(name)
+	if err == errRefNotFound {
+		old = zeroSha
+	} else if err != nil {
+		return err
+	}
+	if err := updateRef(name, sha); err != nil {
+		return err
+	}
+
+	if err := appendReflog(name, old, sha, msg); err != nil {
+		return err
+	}
+	if name != "HEAD" {
+		target, err := readSymbolicRef("HEAD")
+		if err != nil {
+			return err
+		}
+		if target == name {
+			return appendReflog("HEAD", old, sha, msg)
+		}
+	}
+	return nil
+}
+
+// appendReflog adds an entry to the log of a ref under .git/logs.
+func appendReflog(name, old, sha, msg string) error {
+	p := path.Join(".git/logs", name)
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	msg = strings.ReplaceAll(strings.TrimRight(msg, "\n"), "\n", " ")
+	_, err = fmt.Fprintf(f, "%s %s %s\t%s\n", old, sha, signature("COMMITTER"), msg)
+	return err
+}
+
+type reflogEntry struct {
+	Old, New string
+	Who      string
+	Message  string
+}
+
+// readReflog returns the entries of a ref's log, newest first.
+func readReflog(name string) ([]reflogEntry, error) {
+	b, err := os.ReadFile(path.Join(".git/logs", name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	entries := make([]reflogEntry, 0)
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		head, msg := line, ""
+		if i := strings.IndexByte(line, '\t'); i >= 0 {
+			head, msg = line[:i], line[i+1:]
+		}
+		fields := strings.SplitN(head, " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		entries = append(entries, reflogEntry{Old: fields[0], New: fields[1], Who: fields[2], Message: msg})
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// writeReflog replaces the log of a ref with the given entries, newest first.
+func writeReflog(name string, entries []reflogEntry) error {
+	var b strings.Builder
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		fmt.Fprintf(&b, "%s %s %s\t%s\n", e.Old, e.New, e.Who, e.Message)
+	}
+	return os.WriteFile(path.Join(".git/logs", name), []byte(b.String()), 0644)
+}
+
+// setSymbolicRef points a symbolic ref such as HEAD at another ref.
+func setSymbolicRef(name, target string) error {
+	p := path.Join(".git", name)
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, []byte("ref: "+target+"\n"), 0644)
+}
+
+// headCommit returns the commit HEAD points to, or an empty string when the
+// current branch has no commits yet.
+func headCommit() (string, error) {
+	sha, err := resolveRef("HEAD")
+	if err == errRefNotFound {
+		return "", nil
+	}
+	return sha, err
+}
+
+// currentBranch returns the short name of the checked out branch, or an
+// empty string when HEAD is detached.
+func currentBranch() (string, error) {
+	target, err := readSymbolicRef("HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(target, "refs/heads/"), nil
+}
+
+// updateHead moves the current branch, or HEAD itself when it is detached.
+func updateHead(sha string) error {
+	target, err := readSymbolicRef("HEAD")
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		target = "HEAD"
+	}
+	return updateRef(target, sha)
+}
+
+// listRefs returns every ref under the given prefix, loose refs taking
+// precedence over packed ones.
+func listRefs(prefix string) (map[string]string, error) {
+	refs, err := readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for name := range refs {
+		if !strings.HasPrefix(name, prefix) {
+			delete(refs, name)
+		}
+	}
+
+	root := path.Join(".git", prefix)
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		name := filepath.ToSlash(strings.TrimPrefix(p, ".git/"))
+		sha, err := resolveRef(name)
+		if err != nil {
+			return err
+		}
+		refs[name] = sha
+		return nil
+	})
+	return refs, err
+}
+
+// revParse turns a revision such as "HEAD~2", "main^2", "v1.0^{tree}" or
+// an abbreviated object name into a full object id.
+func revParse(rev string) (string, error) {
+	if rev == "" {
+		return "", errors.New("empty revision")
+	}
+
+	i := strings.IndexAny(rev, "^~")
+	if i < 0 {
+		return resolveName(rev)
+	}
+
+	sha, err := resolveName(rev[:i])
+	if err != nil {
+		return "", err
+	}
+
+	s := rev[i:]
+	for len(s) > 0 {
+		op := s[0]
+		s = s[1:]
+
+		j := 0
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			j++
+		}
+		n := 1
+		if j > 0 {
+			n, _ = strconv.Atoi(s[:j])
+		}
+		s = s[j:]
+
+		// "^{<type>}" peels to an object of that type, "^{}" to the first
+		// object that is not a tag.
+		if op == '^' && j == 0 && strings.HasPrefix(s, "{") {
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				return "", fmt.Errorf("invalid revision %s", rev)
+			}
+			objectType := s[1:end]
+			s = s[end+1:]
+			switch objectType {
+			case "", "commit", "tree", "blob", "tag":
+				sha, err = peel(sha, objectType)
+			case "object":
+				_, _, err = readObject(sha)
+			default:
+				return "", fmt.Errorf("invalid object type in %s", rev)
+			}
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		sha, err = peel(sha, "commit")
+		if err != nil {
+			return "", err
+		}
+
+		switch op {
+		case '^':
+			if n == 0 {
+				continue
+			}
+			c, err := readCommit(sha)
+			if err != nil {
+				return "", err
+			}
+			if n > len(c.Parents) {
+				return "", fmt.Errorf("%s has no parent %d", rev, n)
+			}
+			sha = c.Parents[n-1]
+		case '~':
+			for k := 0; k < n; k++ {
+				c, err := readCommit(sha)
+				if err != nil {
+					return "", err
+				}
+				if len(c.Parents) == 0 {
+					return "", fmt.Errorf("%s goes past the root commit", rev)
+				}
+				sha = c.Parents[0]
+			}
+		default:
+			return "", fmt.Errorf("invalid revision %s", rev)
+		}
+	}
+	return sha, nil
+}
+
+func resolveName(name string) (string, error) {
+	if base, n, ok := parseReflogSelector(name); ok {
+		return resolveReflogEntry(base, n)
+	}
+	if name == "@" {
+		name = "HEAD"
+	}
+
+	ref, err := dwimRef(name)
+	if err == nil {
+		return resolveRef(ref)
+	}
+	if err != errRefNotFound {
+		return "", err
+	}
+
+	if isHex(name) && len(name) >= 4 && len(name) <= 40 {
+		return expandSha(name)
+	}
+
+	return "", fmt.Errorf("unknown revision %s", name)
+}
+
+// dwimRef finds the full name of the ref a short name such as "main" or
+// "origin/main" stands for.
+func dwimRef(name string) (string, error) {
+	for _, candidate := range []string{
+		name,
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+		"refs/remotes/" + name + "/HEAD",
+	} {
+		if candidate != "HEAD" && !strings.HasPrefix(candidate, "refs/") &&
+			!isSpecialRef(candidate) {
+			continue
+		}
+		_, err := resolveRef(candidate)
+		if err == nil {
+			return candidate, nil
+		}
+		if err != errRefNotFound {
+			return "", err
+		}
+	}
+	return "", errRefNotFound
+}
+
+// parseReflogSelector splits a revision such as "stash@{2}" into the ref
+// and the position in its reflog.
+func parseReflogSelector(rev string) (string, int, bool) {
+	i := strings.LastIndex(rev, "@{")
+	if i < 0 || !strings.HasSuffix(rev, "}") {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(rev[i+2 : len(rev)-1])
+	if err != nil || n < 0 {
+		return "", 0, false
+	}
+	return rev[:i], n, true
+}
+
+// resolveReflogEntry returns the value a ref had n updates ago.
+func resolveReflogEntry(name string, n int) (string, error) {
+	ref := "HEAD"
+	if name != "" {
+		var err error
+		ref, err = dwimRef(name)
+		if err != nil {
+			return "", fmt.Errorf("unknown revision %s", name)
+		}
+	}
+	entries, err := readReflog(ref)
+	if err != nil {
+		return "", err
+	}
+	if n >= len(entries) {
+		return "", fmt.Errorf("log for '%s' only has %d entries", name, len(entries))
+	}
+	return entries[n].New, nil
+}
+
+// isSpecialRef reports whether name is one of the pseudo refs git keeps at the
+// top of the repository, like HEAD or MERGE_HEAD.
+func isSpecialRef(name string) bool {
+	for _, c := range name {
+		if (c < 'A' || c > 'Z') && c != '_' {
+			return false
+		}
+	}
+	return strings.HasSuffix(name, "HEAD")
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// expandSha finds the single object whose name starts with the given prefix.
+func expandSha(prefix string) (string, error) {
+	if len(prefix) == 40 {
+		if !hasObject(prefix) {
+			return "", fmt.Errorf("object %s not found", prefix)
+		}
+		return prefix, nil
+	}
+
+	entries, err := os.ReadDir(path.Join(".git/objects", prefix[:2]))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	found := make(map[string]string)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix[2:]) {
+			found[prefix[:2]+e.Name()] = ""
+		}
+	}
+	packs, err := readPackIndexes()
+	if err != nil {
+		return "", err
+	}
+	for _, idx := range packs {
+		for i, _ := idx.find(prefix); i < len(idx.Shas) && strings.HasPrefix(idx.Shas[i], prefix); i++ {
+			found[idx.Shas[i]] = ""
+		}
+	}
+	matches := sortedKeys(found)
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("unknown revision %s", prefix)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("short object id %s is ambiguous", prefix)
+	}
+}
+
+// peel follows tags until it reaches an object of the wanted type. Asking for
+// a tree from a commit returns the commit's tree. An empty type peels tags
+// down to the first non-tag object.
+func peel(sha, objectType string) (string, error) {
+	for {
+		t, content, err := readObject(sha)
+		if err != nil {
+			return "", err
+		}
+		if t == objectType || (objectType == "" && t != "tag") {
+			return sha, nil
+		}
+
+		switch t {
+		case "tag":
+			sha, err = tagTarget(content)
+			if err != nil {
+				return "", err
+			}
+		case "commit":
+			if objectType != "tree" {
+				return "", fmt.Errorf("object %s is a commit, not a %s", sha, objectType)
+			}
+			c, err := parseCommit(content)
+			if err != nil {
+				return "", err
+			}
+			sha = c.Tree
+		default:
+			return "", fmt.Errorf("object %s is a %s, not a %s", sha, t, objectType)
+		}
+	}
+}
+
+// resolveCommit parses a revision and peels it to a commit.
+func resolveCommit(rev string) (string, error) {
+	sha, err := revParse(rev)
+	if err != nil {
+		return "", err
+	}
+	return peel(sha, "commit")
+}
+
+// resolveTree parses a revision and peels it to a tree.
+func resolveTree(rev string) (string, error) {
+	sha, err := revParse(rev)
+	if err != nil {
+		return "", err
+	}
+	return peel(sha, "tree")
+}
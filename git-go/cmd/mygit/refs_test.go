@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRevParse(t *testing.T) {
+	newTestRepo(t)
+	c1 := testCommit(t, map[string]string{"a": "1\n"}, 100)
+	c2 := testCommit(t, map[string]string{"a": "2\n"}, 200, c1)
+	c, err := readCommit(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := writeObject("blob", []byte("2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := writeObject("tag", []byte("object "+c2+"\ntype commit\ntag v1\n\nmsg\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, sha := range map[string]string{"refs/heads/master": c2, "refs/tags/v1": fmt.Sprintf("%x", tag)} {
+		if err := updateRef(name, sha); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		rev  string
+		want string
+	}{
+		{"master", c2},
+		{"master~1", c1},
+		{"master^", c1},
+		{"v1", fmt.Sprintf("%x", tag)},
+		{"v1^{}", c2},
+		{"v1^{tag}", fmt.Sprintf("%x", tag)},
+		{"v1^{commit}", c2},
+		{"v1^{commit}^", c1},
+		{"v1^{tree}", c.Tree},
+		{"master^{tree}", c.Tree},
+		{"master^{object}", c2},
+		{fmt.Sprintf("%x^{blob}", blob), fmt.Sprintf("%x", blob)},
+		{"master^{blob}", ""},
+		{"master^{tag}", ""},
+		{"master^{thing}", ""},
+		{"master^{tree", ""},
+		{"master~2", ""},
+	}
+	for _, tt := range tests {
+		got, err := revParse(tt.rev)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("revParse(%s) = %s, want an error", tt.rev, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("revParse(%s) = %s, %v; want %s", tt.rev, got, err, tt.want)
+		}
+	}
+}
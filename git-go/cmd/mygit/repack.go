@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// packWindow is how many neighbouring objects are tried as delta bases.
+	packWindow = 10
+	// packDepth limits the length of delta chains.
+	packDepth = 50
+)
+
+// packObject is an object on its way into a pack.
+type packObject struct {
+	Sha     string
+	Type    ObjectType
+	Content []byte
+	Name    string
+
+	Base  *packObject
+	Delta []byte
+	Depth int
+
+	offset  int64
+	crc     uint32
+	written bool
+}
+
+type repackOptions struct {
+	All    bool
+	Delete bool
+	Quiet  bool
+}
+
+func repackCommand(args []string) error {
+	opts := repackOptions{}
+	for _, arg := range args {
+		switch arg {
+		case "-a":
+			opts.All = true
+		case "-d":
+			opts.Delete = true
+		case "-ad", "-da":
+			opts.All, opts.Delete = true, true
+		case "-q", "--quiet":
+			opts.Quiet = true
+		default:
+			return fmt.Errorf("unknown option %s", arg)
+		}
+	}
+	return repack(opts)
+}
+
+// repack writes the reachable objects into a new pack. Without All only
+// loose objects are packed. With Delete, loose objects and packs the new
+// pack makes redundant are removed.
+func repack(opts repackOptions) error {
+	roots, err := objectRoots()
+	if err != nil {
+		return err
+	}
+	reachable, err := reachableObjects(roots)
+	if err != nil {
+		return err
+	}
+
+	objects := make([]*packObject, 0, len(reachable))
+	for _, o := range reachable {
+		if !opts.All && !hasLooseObject(o.Sha) {
+			continue
+		}
+		objects = append(objects, &packObject{
+			Sha:     o.Sha,
+			Type:    objectTypeOf(o.Type),
+			Content: o.Content,
+			Name:    o.Name,
+		})
+	}
+	if len(objects) == 0 {
+		if !opts.Quiet {
+			fmt.Println("Nothing new to pack.")
+		}
+		return nil
+	}
+
+	deltas := findDeltas(objects)
+	name, err := writePack(objects)
+	if err != nil {
+		return err
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Total %d (delta %d), reused 0 (delta 0)\n", len(objects), deltas)
+	}
+
+	forgetPacks()
+	if !opts.Delete {
+		return nil
+	}
+	if opts.All {
+		paths, err := filepath.Glob(filepath.Join(packDir, "pack-*.pack"))
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			if filepath.Base(p) == name+".pack" {
+				continue
+			}
+			base := strings.TrimSuffix(p, ".pack")
+			for _, ext := range []string{".pack", ".idx", ".rev"} {
+				if err := os.Remove(base + ext); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+		forgetPacks()
+	}
+	return prunePacked()
+}
+
+// hasLooseObject reports whether an object is stored as a loose file.
+func hasLooseObject(sha string) bool {
+	_, err := os.Stat(objectPath(sha))
+	return err == nil
+}
+
+// looseObjects lists the names of all loose objects.
+func looseObjects() ([]string, error) {
+	dirs, err := filepath.Glob(".git/objects/[0-9a-f][0-9a-f]")
+	if err != nil {
+		return nil, err
+	}
+	shas := make([]string, 0)
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			sha := filepath.Base(dir) + e.Name()
+			if len(sha) == 40 && isHex(sha) {
+				shas = append(shas, sha)
+			}
+		}
+	}
+	sort.Strings(shas)
+	return shas, nil
+}
+
+// prunePacked removes the loose objects that are also in a pack.
+func prunePacked() error {
+	shas, err := looseObjects()
+	if err != nil {
+		return err
+	}
+	for _, sha := range shas {
+		if hasPackedObject(sha) {
+			if err := removeLooseObject(sha); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func removeLooseObject(sha string) error {
+	p := objectPath(sha)
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+	// Fails harmlessly when other objects share the directory.
+	os.Remove(path.Dir(p))
+	return nil
+}
+
+// packNameHash is git's hash of a path, which groups files with the same
+// name, and then the same extension, next to each other.
+func packNameHash(name string) uint32 {
+	var hash uint32
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == ' ' || c == '\t' || c == '\n' {
+			continue
+		}
+		hash = (hash >> 2) + uint32(c)<<24
+	}
+	return hash
+}
+
+// findDeltas picks a delta base for the objects worth storing as deltas.
+// Objects are sorted by type, name hash and decreasing size, and each is
+// tried against the packWindow objects before it. It returns the number of
+// deltas found.
+func findDeltas(objects []*packObject) int {
+	sorted := append([]*packObject(nil), objects...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if ha, hb := packNameHash(a.Name), packNameHash(b.Name); ha != hb {
+			return ha < hb
+		}
+		return len(a.Content) > len(b.Content)
+	})
+
+	deltas := 0
+	for i, target := range sorted {
+		maxSize := len(target.Content)/2 - 20
+		for j := i - 1; j >= 0 && j >= i-packWindow; j-- {
+			base := sorted[j]
+			if base.Type != target.Type || base.Depth >= packDepth {
+				continue
+			}
+			if len(base.Content) < len(target.Content)/32 || len(base.Content) == 0 {
+				continue
+			}
+			delta := createDelta(base.Content, target.Content)
+			if len(delta) < maxSize {
+				target.Base, target.Delta, target.Depth = base, delta, base.Depth+1
+				maxSize = len(delta)
+			}
+		}
+		if target.Base != nil {
+			deltas++
+		}
+	}
+	return deltas
+}
+
+// writePack writes the objects into a pack and its index, and returns the
+// base name of the two files.
+func writePack(objects []*packObject) (string, error) {
+	b, err := encodePack(objects)
+	if err != nil {
+		return "", err
+	}
+	return storePackFiles(b, objects)
+}
+
+// encodePack lays the objects out as a pack, deltas after their bases,
+// ending with the checksum. It records where each object went.
+func encodePack(objects []*packObject) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("PACK")
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	binary.Write(&buf, binary.BigEndian, uint32(len(objects)))
+
+	var write func(o *packObject) error
+	write = func(o *packObject) error {
+		if o.written {
+			return nil
+		}
+		// Offset deltas can only point backwards.
+		if o.Base != nil {
+			if err := write(o.Base); err != nil {
+				return err
+			}
+		}
+		o.written = true
+		o.offset = int64(buf.Len())
+
+		entry := make([]byte, 0, 32)
+		data := o.Content
+		if o.Base != nil {
+			entry = appendPackHeader(entry, OBJ_OFS_DELTA, len(o.Delta))
+			entry = appendOfsOffset(entry, o.offset-o.Base.offset)
+			data = o.Delta
+		} else {
+			entry = appendPackHeader(entry, o.Type, len(o.Content))
+		}
+
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		entry = append(entry, z.Bytes()...)
+		o.crc = crc32.ChecksumIEEE(entry)
+		buf.Write(entry)
+		return nil
+	}
+
+	// Recent history goes first, and the objects of each type together.
+	ordered := append([]*packObject(nil), objects...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return packTypeOrder(ordered[i].Type) < packTypeOrder(ordered[j].Type)
+	})
+	for _, o := range ordered {
+		if err := write(o); err != nil {
+			return nil, err
+		}
+	}
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+	return buf.Bytes(), nil
+}
+
+// storePackFiles writes a pack and the index of its objects into the
+// object store, and returns the base name of the two files.
+func storePackFiles(b []byte, objects []*packObject) (string, error) {
+	var checksum [20]byte
+	copy(checksum[:], b[len(b)-20:])
+	name := fmt.Sprintf("pack-%x", checksum)
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(filepath.Join(packDir, name+".pack"), b, 0444); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(filepath.Join(packDir, name+".idx"), encodePackIndex(objects, checksum), 0444); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func packTypeOrder(t ObjectType) int {
+	switch t {
+	case OBJ_COMMIT:
+		return 0
+	case OBJ_TAG:
+		return 1
+	case OBJ_TREE:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// appendPackHeader appends the type and size header of a pack entry: 3
+// bits of type and 4 bits of size, then the rest of the size 7 bits at a
+// time.
+func appendPackHeader(b []byte, t ObjectType, size int) []byte {
+	c := byte(t)<<4 | byte(size&0x0f)
+	size >>= 4
+	if size == 0 {
+		return append(b, c)
+	}
+	return appendVarint(append(b, c|0x80), size)
+}
+
+// appendOfsOffset appends the distance back to an OFS_DELTA base, big
+// endian with 7 bits per byte, where each continuation also adds one.
+func appendOfsOffset(b []byte, n int64) []byte {
+	var tmp [10]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		n--
+		i--
+		tmp[i] = 0x80 | byte(n&0x7f)
+	}
+	return append(b, tmp[i:]...)
+}
+
+func encodePackIndex(objects []*packObject, packChecksum [20]byte) []byte {
+	sorted := append([]*packObject(nil), objects...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Sha < sorted[j].Sha })
+
+	var buf bytes.Buffer
+	buf.Write(packIndexMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+
+	var fanout [256]uint32
+	for _, o := range sorted {
+		b, _ := decodeSha(o.Sha)
+		for i := int(b[0]); i < 256; i++ {
+			fanout[i]++
+		}
+	}
+	binary.Write(&buf, binary.BigEndian, fanout)
+
+	for _, o := range sorted {
+		b, _ := decodeSha(o.Sha)
+		buf.Write(b[:])
+	}
+	for _, o := range sorted {
+		binary.Write(&buf, binary.BigEndian, o.crc)
+	}
+	large := make([]int64, 0)
+	for _, o := range sorted {
+		if o.offset < 0x80000000 {
+			binary.Write(&buf, binary.BigEndian, uint32(o.offset))
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(0x80000000|len(large)))
+		large = append(large, o.offset)
+	}
+	for _, off := range large {
+		binary.Write(&buf, binary.BigEndian, uint64(off))
+	}
+
+	buf.Write(packChecksum[:])
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+	return buf.Bytes()
+}
+
+// writeFileAtomic writes a file under a temporary name and renames it into
+// place, so that readers never see it half written.
+func writeFileAtomic(p string, b []byte, perm os.FileMode) error {
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// packRefs moves all refs into packed-refs, recording what annotated tags
+// point to, and deletes the loose files.
+func packRefs() error {
+	refs, err := listRefs("refs/")
+	if err != nil {
+		return err
+	}
+	if err := writePackedRefs(refs); err != nil {
+		return err
+	}
+
+	for name, sha := range refs {
+		p := path.Join(".git", name)
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		// Only remove files that still hold what was packed.
+		if strings.TrimSpace(string(b)) != sha {
+			continue
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		for dir := path.Dir(p); strings.Count(dir, "/") > 2; dir = path.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// writePackedRefs replaces packed-refs with the given refs.
+func writePackedRefs(refs map[string]string) error {
+	var b strings.Builder
+	b.WriteString("# pack-refs with: peeled fully-peeled sorted \n")
+	for _, name := range sortedKeys(refs) {
+		sha := refs[name]
+		fmt.Fprintf(&b, "%s %s\n", sha, name)
+		if t, _, err := readObject(sha); err == nil && t == "tag" {
+			peeled, err := peel(sha, "")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&b, "^%s\n", peeled)
+		}
+	}
+	return writeFileAtomic(".git/packed-refs", []byte(b.String()), 0644)
+}
+
+// pruneLoose removes the unreachable loose objects older than the expiry.
+func pruneLoose(expire time.Time) error {
+	roots, err := objectRoots()
+	if err != nil {
+		return err
+	}
+	reachable, err := reachableObjects(roots)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool)
+	for _, o := range reachable {
+		keep[o.Sha] = true
+	}
+
+	shas, err := looseObjects()
+	if err != nil {
+		return err
+	}
+	for _, sha := range shas {
+		if keep[sha] {
+			continue
+		}
+		info, err := os.Stat(objectPath(sha))
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(expire) {
+			if err := removeLooseObject(sha); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// gcCommand packs refs and objects and prunes unreachable loose objects,
+// by default those older than two weeks.
+func gcCommand(args []string) error {
+	expire := time.Now().Add(-14 * 24 * time.Hour)
+	for _, arg := range args {
+		switch arg {
+		case "--prune=now":
+			expire = time.Now().Add(time.Second)
+		case "--no-prune":
+			expire = time.Time{}
+		case "-q", "--quiet":
+		default:
+			return fmt.Errorf("unknown option %s", arg)
+		}
+	}
+
+	if err := packRefs(); err != nil {
+		return err
+	}
+	if err := repack(repackOptions{All: true, Delete: true, Quiet: true}); err != nil {
+		return err
+	}
+	if expire.IsZero() {
+		return nil
+	}
+	return pruneLoose(expire)
+}
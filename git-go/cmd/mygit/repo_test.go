@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// newTestRepo creates an empty repository in a temporary directory and
+// makes it the working directory until the test ends.
+func newTestRepo(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		forgetPacks()
+		forgetAttributes()
+		forgetStagedIndex()
+	})
+	forgetPacks()
+	forgetAttributes()
+	forgetStagedIndex()
+	if err := initGit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testTree writes a tree holding the given files, keyed by path, and
+// returns its id.
+func testTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	entries := make(map[string]treeEntry, len(files))
+	for p, content := range files {
+		sha, err := writeObject("blob", []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[p] = treeEntry{Mode: modeBlob, Sha: fmt.Sprintf("%x", sha)}
+	}
+	tree, err := buildTree(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// testCommit writes a commit of files made at the given time, in seconds,
+// and returns its id.
+func testCommit(t *testing.T, files map[string]string, time int64, parents ...string) string {
+	t.Helper()
+	sig := fmt.Sprintf("A U Thor <author@example.com> %d +0000", time)
+	sha, err := writeObject("commit", encodeCommit(&commit{
+		Tree:      testTree(t, files),
+		Parents:   parents,
+		Author:    sig,
+		Committer: sig,
+		Message:   fmt.Sprintf("commit at %d\n", time),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%x", sha)
+}
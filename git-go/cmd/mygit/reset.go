@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// resetCommand implements "reset". Without paths it moves the current
+// branch, and depending on the mode the index and the working tree with it.
+// With paths it only resets their index entries.
+func resetCommand(args []string) error {
+	mode := ""
+	revs := make([]string, 0)
+	paths := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--soft", "--mixed", "--hard", "--keep":
+			mode = strings.TrimPrefix(arg, "--")
+		case "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option %s", arg)
+			}
+			revs = append(revs, arg)
+		}
+	}
+
+	// Without "--", the first argument is the commit if it names one.
+	rev := "HEAD"
+	if len(revs) > 0 {
+		if _, err := resolveTree(revs[0]); err == nil {
+			rev, revs = revs[0], revs[1:]
+		}
+	}
+	paths = append(revs, paths...)
+
+	if len(paths) > 0 {
+		if mode != "" && mode != "mixed" {
+			return fmt.Errorf("Cannot do %s reset with paths.", mode)
+		}
+		return resetPaths(rev, paths)
+	}
+	if mode == "" {
+		mode = "mixed"
+	}
+	return reset(rev, mode)
+}
+
+func reset(rev, mode string) error {
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	merging := isMerging() || len(idx.unmerged()) > 0
+	if merging && (mode == "soft" || mode == "keep") {
+		return fmt.Errorf("Cannot do a %s reset in the middle of a merge.", mode)
+	}
+
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	sha := ""
+	if rev != "HEAD" || head != "" {
+		sha, err = resolveCommit(rev)
+		if err != nil {
+			return err
+		}
+	}
+	to := make(map[string]treeEntry)
+	if sha != "" {
+		to, err = commitFiles(sha)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch mode {
+	case "mixed":
+		resetIndex(idx, to, nil)
+	case "hard":
+		if err := resetHard(idx, to); err != nil {
+			return err
+		}
+	case "keep":
+		from, err := headFiles()
+		if err != nil {
+			return err
+		}
+		if err := checkoutFiles(idx, from, to, false, "reset"); err != nil {
+			return err
+		}
+	}
+	if mode != "soft" {
+		if err := idx.write(); err != nil {
+			return err
+		}
+	}
+
+	if sha != "" {
+		if head != "" {
+			if err := updateRef("ORIG_HEAD", head); err != nil {
+				return err
+			}
+		}
+		target, err := readSymbolicRef("HEAD")
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			target = "HEAD"
+		}
+		if err := updateRefLogged(target, sha, "reset: moving to "+rev); err != nil {
+			return err
+		}
+	}
+	removeMergeState()
+
+	switch mode {
+	case "hard":
+		// On an unborn branch there is no commit to show.
+		if sha == "" {
+			return nil
+		}
+		c, err := readCommit(sha)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("HEAD is now at %s %s\n", sha[:7], c.Subject())
+	case "mixed":
+		return printUnstaged(idx)
+	}
+	return nil
+}
+
+// resetHard makes the index and the working tree match the given files,
+// throwing away staged and unstaged changes alike.
+func resetHard(idx *index, files map[string]treeEntry) error {
+	if err := resetMerge(idx, files); err != nil {
+		return err
+	}
+	for p, e := range files {
+		if err := resetPath(idx, p, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetPaths sets the index entries of the given paths back to what a
+// commit has, leaving HEAD and the working tree alone.
+func resetPaths(rev string, specs []string) error {
+	to := make(map[string]treeEntry)
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	if rev != "HEAD" || head != "" {
+		tree, err := resolveTree(rev)
+		if err != nil {
+			return err
+		}
+		to, err = flattenTree(tree)
+		if err != nil {
+			return err
+		}
+	}
+
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	for i, spec := range specs {
+		specs[i] = cleanPathspec(spec)
+	}
+	resetIndex(idx, to, specs)
+	if err := idx.write(); err != nil {
+		return err
+	}
+	return printUnstaged(idx)
+}
+
+// resetIndex makes the index match the given files, for all paths or for
+// the ones matching specs. Entries that already match keep their stat data.
+func resetIndex(idx *index, files map[string]treeEntry, specs []string) {
+	matches := func(p string) bool {
+		if specs == nil {
+			return true
+		}
+		for _, spec := range specs {
+			if matchPathspec(spec, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	entries := make([]indexEntry, 0, len(idx.Entries))
+	kept := make(map[string]bool)
+	for _, e := range idx.Entries {
+		if !matches(e.Path) {
+			entries = append(entries, e)
+			continue
+		}
+		if f, ok := files[e.Path]; ok && e.Stage == 0 && e.Sha == f.Sha && int(e.Mode) == f.Mode {
+			entries = append(entries, e)
+			kept[e.Path] = true
+		}
+	}
+	for p, f := range files {
+		if matches(p) && !kept[p] {
+			entries = append(entries, indexEntry{Mode: uint32(f.Mode), Sha: f.Sha, Path: p})
+		}
+	}
+	idx.Entries = entries
+	idx.sort()
+}
+
+// printUnstaged lists the working tree changes left after a reset.
+func printUnstaged(idx *index) error {
+	s, err := readStatus(idx)
+	if err != nil {
+		return err
+	}
+	if len(s.Unstaged) == 0 {
+		return nil
+	}
+	fmt.Println("Unstaged changes after reset:")
+	for _, p := range sortedKeys(s.Unstaged) {
+		code := "M"
+		if s.Unstaged[p] == "deleted" {
+			code = "D"
+		}
+		fmt.Printf("%s\t%s\n", code, p)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReset(t *testing.T) {
+	newTestRepo(t)
+	// On an unborn branch a hard reset only empties the index.
+	if err := os.WriteFile("staged", []byte("staged\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := addCommand([]string{"staged"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := resetCommand([]string{"--hard"}); err != nil {
+		t.Fatalf("reset --hard on an unborn branch: %s", err)
+	}
+	if idx, err := readIndex(); err != nil || len(idx.Entries) != 0 {
+		t.Errorf("index after reset --hard on an unborn branch = %+v, %v", idx, err)
+	}
+
+	c1 := testCommit(t, map[string]string{"a": "1\n", "b": "b\n"}, 100)
+	c2 := testCommit(t, map[string]string{"a": "2\n", "c": "c\n"}, 200, c1)
+	tree := func(sha string) string {
+		t.Helper()
+		c, err := readCommit(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Tree
+	}
+	check := func(wantHead, wantIndex string, files map[string]string) {
+		t.Helper()
+		if head, err := headCommit(); err != nil || head != wantHead {
+			t.Errorf("HEAD = %s, %v, want %s", head, err, wantHead)
+		}
+		idx, err := readIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := idx.writeTree(); err != nil || got != wantIndex {
+			t.Errorf("index tree = %s, %v, want %s", got, err, wantIndex)
+		}
+		for p, want := range files {
+			b, err := os.ReadFile(p)
+			if want == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("%s exists, want it removed", p)
+				}
+			} else if string(b) != want {
+				t.Errorf("%s = %q, %v, want %q", p, b, err, want)
+			}
+		}
+	}
+
+	if err := reset(c2, "hard"); err != nil {
+		t.Fatal(err)
+	}
+	check(c2, tree(c2), map[string]string{"a": "2\n", "b": "", "c": "c\n"})
+
+	if err := resetCommand([]string{"--soft", c1}); err != nil {
+		t.Fatal(err)
+	}
+	check(c1, tree(c2), map[string]string{"a": "2\n", "c": "c\n"})
+	if orig, err := resolveRef("ORIG_HEAD"); err != nil || orig != c2 {
+		t.Errorf("ORIG_HEAD = %s, %v, want %s", orig, err, c2)
+	}
+
+	if err := resetCommand([]string{c1}); err != nil {
+		t.Fatal(err)
+	}
+	check(c1, tree(c1), map[string]string{"a": "2\n", "c": "c\n"})
+
+	// Only the index entry of a changes, HEAD stays.
+	if err := resetCommand([]string{c2, "a"}); err != nil {
+		t.Fatal(err)
+	}
+	check(c1, testTree(t, map[string]string{"a": "2\n", "b": "b\n"}), nil)
+
+	if err := resetCommand([]string{"--hard"}); err != nil {
+		t.Fatal(err)
+	}
+	// c is not tracked by c1, so it stays as an untracked file.
+	check(c1, tree(c1), map[string]string{"a": "1\n", "b": "b\n", "c": "c\n"})
+
+	if err := resetCommand([]string{"--hard", "--", "a"}); err == nil || err.Error() != "Cannot do hard reset with paths." {
+		t.Errorf("reset --hard with paths: %v", err)
+	}
+
+	for _, name := range []string{"refs/heads/master", "HEAD"} {
+		b, err := os.ReadFile(".git/logs/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+		want := []string{
+			zeroSha + " " + c2 + "\treset: moving to " + c2,
+			c2 + " " + c1 + "\treset: moving to " + c1,
+			c1 + " " + c1 + "\treset: moving to " + c1,
+			c1 + " " + c1 + "\treset: moving to HEAD",
+		}
+		if len(lines) != len(want) {
+			t.Fatalf("%s reflog has %d entries, want %d:\n%s", name, len(lines), len(want), b)
+		}
+		for i, line := range lines {
+			ids := strings.Join(strings.Fields(line)[:2], " ")
+			msg := line[strings.IndexByte(line, '\t'):]
+			if ids+msg != want[i] {
+				t.Errorf("%s reflog entry %d = %q, want %q", name, i, line, want[i])
+			}
+		}
+	}
+}
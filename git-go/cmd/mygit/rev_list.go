@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+)
+
+// parseRevisions resolves revision arguments such as "main", "^v1.0" or
+// "v1.0..main" into the commits to include and the commits to exclude.
+func parseRevisions(args []string) ([]string, []string, error) {
+	include := make([]string, 0)
+	exclude := make([]string, 0)
+	for _, arg := range args {
+		if i := strings.Index(arg, ".."); i >= 0 {
+			from, to := arg[:i], arg[i+2:]
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			a, err := resolveCommit(from)
+			if err != nil {
+				return nil, nil, err
+			}
+			b, err := resolveCommit(to)
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, a)
+			include = append(include, b)
+			continue
+		}
+
+		negated := strings.HasPrefix(arg, "^")
+		sha, err := resolveCommit(strings.TrimPrefix(arg, "^"))
+		if err != nil {
+			return nil, nil, err
+		}
+		if negated {
+			exclude = append(exclude, sha)
+		} else {
+			include = append(include, sha)
+		}
+	}
+	return include, exclude, nil
+}
+
+// revList returns the commits reachable from include but not from exclude,
+// with parents always listed before their children.
+func revList(include, exclude []string) ([]string, error) {
+	excluded := make(map[string]bool)
+	if len(exclude) > 0 {
+		var err error
+		excluded, err = ancestors(exclude...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	list := make([]string, 0)
+	visited := make(map[string]bool)
+	var visit func(sha string) error
+	visit = func(sha string) error {
+		if visited[sha] || excluded[sha] {
+			return nil
+		}
+		visited[sha] = true
+
+		c, err := readCommit(sha)
+		if err != nil {
+			return err
+		}
+		for _, p := range c.Parents {
+			if err := visit(p); err != nil {
+				return err
+			}
+		}
+		list = append(list, sha)
+		return nil
+	}
+
+	for _, sha := range include {
+		if err := visit(sha); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
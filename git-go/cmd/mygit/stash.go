@@ -0,0 +1,444 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A stash entry is a commit of the working tree whose parents are HEAD, a
+// commit of the index and, with --include-untracked, a parentless commit of
+// the untracked files. Entries are kept in the reflog of refs/stash.
+const stashRef = "refs/stash"
+
+// stashCommand implements "stash". It reports false when applying an entry
+// ran into conflicts.
+func stashCommand(args []string) (bool, error) {
+	sub := "push"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub, args = args[0], args[1:]
+	}
+
+	switch sub {
+	case "push":
+		untracked := false
+		msg := ""
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; arg {
+			case "-u", "--include-untracked":
+				untracked = true
+			case "-m", "--message":
+				if i+1 == len(args) {
+					return false, fmt.Errorf("option %s requires a value", arg)
+				}
+				i++
+				msg = args[i]
+			default:
+				return false, fmt.Errorf("unknown option %s", arg)
+			}
+		}
+		return true, stashPush(msg, untracked)
+	case "list":
+		return true, stashList()
+	case "show":
+		n, err := stashArgs(args, nil)
+		if err != nil {
+			return false, err
+		}
+		return true, stashShow(n)
+	case "drop":
+		n, err := stashArgs(args, nil)
+		if err != nil {
+			return false, err
+		}
+		return true, stashDrop(n)
+	case "apply", "pop":
+		restoreIndex := false
+		n, err := stashArgs(args, map[string]*bool{"--index": &restoreIndex})
+		if err != nil {
+			return false, err
+		}
+		clean, err := stashApply(n, restoreIndex)
+		if err != nil || sub == "apply" {
+			return clean, err
+		}
+		if !clean {
+			fmt.Println("The stash entry is kept in case you need it again.")
+			return false, nil
+		}
+		return true, stashDrop(n)
+	default:
+		return false, fmt.Errorf("unknown subcommand: %s", sub)
+	}
+}
+
+// stashArgs parses the flags and the optional entry argument, such as
+// "stash@{1}" or "1", of the stash subcommands.
+func stashArgs(args []string, flags map[string]*bool) (int, error) {
+	n := 0
+	seen := false
+	for _, arg := range args {
+		if flag, ok := flags[arg]; ok {
+			*flag = true
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			return 0, fmt.Errorf("unknown option %s", arg)
+		}
+		if seen {
+			return 0, errors.New("Too many revisions specified")
+		}
+		seen = true
+
+		if base, i, ok := parseReflogSelector(arg); ok && (base == "stash" || base == stashRef) {
+			n = i
+		} else if i, err := strconv.Atoi(arg); err == nil && i >= 0 {
+			n = i
+		} else {
+			return 0, fmt.Errorf("'%s' is not a stash reference", arg)
+		}
+	}
+
+	entries, err := readReflog(stashRef)
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, errors.New("No stash entries found.")
+	}
+	if n >= len(entries) {
+		return 0, fmt.Errorf("stash@{%d} is not a valid reference", n)
+	}
+	return n, nil
+}
+
+func stashPush(msg string, includeUntracked bool) error {
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return errors.New("You do not have the initial commit yet")
+	}
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	if paths := idx.unmerged(); len(paths) > 0 {
+		return fmt.Errorf("%s: needs merge", strings.Join(paths, ", "))
+	}
+
+	headTree, err := peel(head, "tree")
+	if err != nil {
+		return err
+	}
+	indexTree, err := idx.writeTree()
+	if err != nil {
+		return err
+	}
+
+	// The working tree commit holds the tracked files as they are on disk.
+	worktree := &index{Entries: append([]indexEntry(nil), idx.Entries...)}
+	for _, e := range idx.Entries {
+		if _, err := os.Lstat(e.Path); os.IsNotExist(err) {
+			worktree.remove(e.Path)
+			continue
+		}
+		if err := stageFile(worktree, e.Path); err != nil {
+			return err
+		}
+	}
+	worktreeTree, err := worktree.writeTree()
+	if err != nil {
+		return err
+	}
+
+	untracked := make([]string, 0)
+	if includeUntracked {
+		untracked, err = untrackedFiles(idx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if indexTree == headTree && worktreeTree == headTree && len(untracked) == 0 {
+		fmt.Println("No local changes to save")
+		return nil
+	}
+
+	c, err := readCommit(head)
+	if err != nil {
+		return err
+	}
+	branch, err := currentBranch()
+	if err != nil {
+		return err
+	}
+	if branch == "" {
+		branch = "(no branch)"
+	}
+	subject := fmt.Sprintf("%s: %s %s", branch, head[:7], c.Subject())
+
+	indexCommit, err := newCommit(indexTree, []string{head}, "index on "+subject+"\n")
+	if err != nil {
+		return err
+	}
+	parents := []string{head, indexCommit}
+	if len(untracked) > 0 {
+		files := make(map[string]treeEntry)
+		for _, p := range untracked {
+			b, info, err := readWorktreeFile(p, true)
+			if err != nil {
+				return err
+			}
+			checksum, err := writeObject("blob", b)
+			if err != nil {
+				return err
+			}
+			files[p] = treeEntry{Mode: fileMode(info), Sha: fmt.Sprintf("%x", checksum)}
+		}
+		tree, err := buildTree(files)
+		if err != nil {
+			return err
+		}
+		untrackedCommit, err := newCommit(tree, nil, "untracked files on "+subject+"\n")
+		if err != nil {
+			return err
+		}
+		parents = append(parents, untrackedCommit)
+	}
+
+	title := "WIP on " + subject
+	if msg != "" {
+		title = fmt.Sprintf("On %s: %s", branch, msg)
+	}
+	stash, err := newCommit(worktreeTree, parents, title+"\n")
+	if err != nil {
+		return err
+	}
+	if err := updateRefLogged(stashRef, stash, title); err != nil {
+		return err
+	}
+
+	files, err := flattenTree(headTree)
+	if err != nil {
+		return err
+	}
+	if err := resetHard(idx, files); err != nil {
+		return err
+	}
+	if err := idx.write(); err != nil {
+		return err
+	}
+	for _, p := range untracked {
+		if err := removeWorktreeFile(p); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Saved working directory and index state %s\n", title)
+	return nil
+}
+
+// untrackedFiles lists the working tree files the index does not track,
+// leaving out ignored ones.
+func untrackedFiles(idx *index) ([]string, error) {
+	tracked := make(map[string]bool)
+	for _, e := range idx.Entries {
+		tracked[e.Path] = true
+	}
+	ignore, err := newIgnoreMatcher()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := listWorktree(idx, ignore)
+	if err != nil {
+		return nil, err
+	}
+	untracked := make([]string, 0)
+	for _, p := range paths {
+		if !tracked[p] {
+			untracked = append(untracked, p)
+		}
+	}
+	return untracked, nil
+}
+
+func stashList() error {
+	entries, err := readReflog(stashRef)
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		fmt.Printf("stash@{%d}: %s\n", i, e.Message)
+	}
+	return nil
+}
+
+// readStash returns the working tree commit of a stash entry.
+func readStash(n int) (*commit, error) {
+	sha, err := resolveReflogEntry(stashRef, n)
+	if err != nil {
+		return nil, err
+	}
+	c, err := readCommit(sha)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Parents) < 2 {
+		return nil, fmt.Errorf("'%s' is not a stash-like commit", sha)
+	}
+	return c, nil
+}
+
+func stashShow(n int) error {
+	c, err := readStash(n)
+	if err != nil {
+		return err
+	}
+	from, err := commitFiles(c.Parents[0])
+	if err != nil {
+		return err
+	}
+	to, err := flattenTree(c.Tree)
+	if err != nil {
+		return err
+	}
+	return printDiffStat(from, to)
+}
+
+func stashDrop(n int) error {
+	entries, err := readReflog(stashRef)
+	if err != nil {
+		return err
+	}
+	sha := entries[n].New
+	entries = append(entries[:n], entries[n+1:]...)
+
+	if len(entries) == 0 {
+		if err := deleteRef(stashRef); err != nil {
+			return err
+		}
+	} else {
+		if err := writeReflog(stashRef, entries); err != nil {
+			return err
+		}
+		if err := updateRef(stashRef, entries[0].New); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Dropped stash@{%d} (%s)\n", n, sha)
+	return nil
+}
+
+// stashApply merges the changes of a stash entry into the working tree.
+// Only files the entry added end up staged, unless restoreIndex asks for
+// the entry's index to be restored as well.
+func stashApply(n int, restoreIndex bool) (bool, error) {
+	c, err := readStash(n)
+	if err != nil {
+		return false, err
+	}
+	idx, err := readIndex()
+	if err != nil {
+		return false, err
+	}
+	if len(idx.unmerged()) > 0 {
+		return false, errors.New("Cannot apply a stash in the middle of a merge")
+	}
+
+	baseTree, err := peel(c.Parents[0], "tree")
+	if err != nil {
+		return false, err
+	}
+	indexTree, err := peel(c.Parents[1], "tree")
+	if err != nil {
+		return false, err
+	}
+	oursTree, err := idx.writeTree()
+	if err != nil {
+		return false, err
+	}
+	ours := idx.files()
+
+	var staged *treeMergeResult
+	if restoreIndex && indexTree != baseTree {
+		staged, err = mergeTrees(baseTree, oursTree, indexTree, treeMergeOptions{})
+		if err != nil {
+			return false, err
+		}
+		if !staged.Clean() {
+			return false, errors.New("Conflicts in index. Try without --index.")
+		}
+	}
+
+	untracked := make(map[string]treeEntry)
+	if len(c.Parents) > 2 {
+		untracked, err = commitFiles(c.Parents[2])
+		if err != nil {
+			return false, err
+		}
+		for _, p := range sortedTreeKeys(untracked) {
+			if _, err := os.Lstat(p); err == nil {
+				return false, fmt.Errorf("%s already exists, no checkout\nerror: could not restore untracked files from stash", p)
+			}
+		}
+	}
+
+	r, err := mergeTrees(baseTree, oursTree, c.Tree, treeMergeOptions{
+		OursLabel:   "Updated upstream",
+		TheirsLabel: "Stashed changes",
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := applyMergeResult(idx, ours, r, "merge"); err != nil {
+		return false, err
+	}
+	for _, m := range r.Messages {
+		fmt.Println(m)
+	}
+
+	if r.Clean() {
+		if staged != nil {
+			resetIndex(idx, staged.Files, nil)
+		} else {
+			// Keep what was staged before, plus the files the stash adds.
+			resetIndex(idx, ours, nil)
+			for p, e := range r.Files {
+				if _, ok := ours[p]; !ok {
+					if entry, err := newIndexEntry(p, e.Mode, e.Sha); err == nil {
+						idx.add(entry)
+					}
+				}
+			}
+		}
+		if err := idx.write(); err != nil {
+			return false, err
+		}
+	}
+
+	for _, p := range sortedTreeKeys(untracked) {
+		if err := writeWorktreeFile(p, untracked[p]); err != nil {
+			return false, err
+		}
+	}
+
+	s, err := readStatus(idx)
+	if err != nil {
+		return false, err
+	}
+	if err := printLongStatus(s); err != nil {
+		return false, err
+	}
+	return r.Clean(), nil
+}
+
+func sortedTreeKeys(files map[string]treeEntry) []string {
+	paths := make(map[string]string, len(files))
+	for p := range files {
+		paths[p] = p
+	}
+	return sortedKeys(paths)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReflog(t *testing.T) {
+	newTestRepo(t)
+	sha := func(c string) string { return strings.Repeat(c, 40) }
+	entries := []reflogEntry{
+		{Old: sha("b"), New: sha("c"), Who: "A <a@example.com> 300 +0000", Message: "third"},
+		{Old: sha("a"), New: sha("b"), Who: "A <a@example.com> 200 +0000", Message: "second: with\ttab"},
+		{Old: zeroSha, New: sha("a"), Who: "A <a@example.com> 100 +0000", Message: ""},
+	}
+	if err := os.MkdirAll(".git/logs/refs/heads", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeReflog("refs/heads/master", entries); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readReflog("refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("readReflog = %+v, want %+v", got, entries)
+	}
+	if err := updateRef("refs/heads/master", sha("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		rev     string
+		want    string
+		wantErr string
+	}{
+		{rev: "master@{0}", want: sha("c")},
+		{rev: "refs/heads/master@{2}", want: sha("a")},
+		{rev: "master@{3}", wantErr: "log for 'master' only has 3 entries"},
+		{rev: "nothing@{0}", wantErr: "unknown revision nothing"},
+	} {
+		got, err := resolveName(test.rev)
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("resolveName(%q) error = %v, want %q", test.rev, err, test.wantErr)
+			}
+		} else if err != nil || got != test.want {
+			t.Errorf("resolveName(%q) = %s, %v, want %s", test.rev, got, err, test.want)
+		}
+	}
+}
+
+func TestParseReflogSelector(t *testing.T) {
+	for _, test := range []struct {
+		rev  string
+		name string
+		n    int
+		ok   bool
+	}{
+		{"stash@{0}", "stash", 0, true},
+		{"refs/heads/main@{12}", "refs/heads/main", 12, true},
+		{"@{1}", "", 1, true},
+		{"main@{-1}", "", 0, false},
+		{"main@{yesterday}", "", 0, false},
+		{"main", "", 0, false},
+		{"main@{1", "", 0, false},
+	} {
+		name, n, ok := parseReflogSelector(test.rev)
+		if name != test.name || n != test.n || ok != test.ok {
+			t.Errorf("parseReflogSelector(%q) = %q, %d, %v, want %q, %d, %v",
+				test.rev, name, n, ok, test.name, test.n, test.ok)
+		}
+	}
+}
+
+func TestStash(t *testing.T) {
+	newTestRepo(t)
+	base := testCommit(t, map[string]string{"a": "a\n", "b": "b\n"}, 100)
+	if err := reset(base, "hard"); err != nil {
+		t.Fatal(err)
+	}
+	write := func(p, content string) {
+		t.Helper()
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(p string) string {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return "<missing>"
+		}
+		return string(b)
+	}
+
+	write("a", "changed\n")
+	write("new", "staged\n")
+	if err := addCommand([]string{"new"}); err != nil {
+		t.Fatal(err)
+	}
+	write("untracked", "untracked\n")
+
+	if _, err := stashCommand([]string{"push", "-u", "-m", "work in progress"}); err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range map[string]string{"a": "a\n", "b": "b\n", "new": "<missing>", "untracked": "<missing>"} {
+		if got := read(p); got != want {
+			t.Errorf("after push, %s = %q, want %q", p, got, want)
+		}
+	}
+	entries, err := readReflog(stashRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Message != "On master: work in progress" {
+		t.Fatalf("stash entries = %+v", entries)
+	}
+	if n, err := stashArgs([]string{"stash@{0}"}, nil); err != nil || n != 0 {
+		t.Errorf("stashArgs(stash@{0}) = %d, %v", n, err)
+	}
+	if _, err := stashArgs([]string{"1"}, nil); err == nil || err.Error() != "stash@{1} is not a valid reference" {
+		t.Errorf("stashArgs(1) error = %v", err)
+	}
+
+	clean, err := stashCommand([]string{"pop"})
+	if err != nil || !clean {
+		t.Fatalf("stash pop = %v, %v", clean, err)
+	}
+	for p, want := range map[string]string{"a": "changed\n", "b": "b\n", "new": "staged\n", "untracked": "untracked\n"} {
+		if got := read(p); got != want {
+			t.Errorf("after pop, %s = %q, want %q", p, got, want)
+		}
+	}
+	idx, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := idx.entry("new"); !ok {
+		t.Error("the file added before the stash is not staged after pop")
+	}
+	if _, ok := idx.entry("untracked"); ok {
+		t.Error("the untracked file is staged after pop")
+	}
+	if _, err := os.Stat(".git/" + stashRef); !os.IsNotExist(err) {
+		t.Errorf("refs/stash still exists after popping the only entry")
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+type worktreeStatus struct {
+	// Changes between HEAD and the index, and between the index and the
+	// working tree, keyed by path.
+	Staged   map[string]string
+	Unstaged map[string]string
+
+	// Unmerged paths keyed by path, with the kind of conflict.
+	Unmerged map[string]string
+
+	Untracked []string
+}
+
+// Clean reports whether there is nothing to commit, ignoring untracked files.
+func (s *worktreeStatus) Clean() bool {
+	return len(s.Staged) == 0 && len(s.Unstaged) == 0 && len(s.Unmerged) == 0
+}
+
+func headFiles() (map[string]treeEntry, error) {
+	head, err := headCommit()
+	if err != nil || head == "" {
+		return make(map[string]treeEntry), err
+	}
+	tree, err := peel(head, "tree")
+	if err != nil {
+		return nil, err
+	}
+	return flattenTree(tree)
+}
+
+func readStatus(idx *index) (*worktreeStatus, error) {
+	s := &worktreeStatus{
+		Staged:   make(map[string]string),
+		Unstaged: make(map[string]string),
+		Unmerged: make(map[string]string),
+	}
+
+	head, err := headFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	stages := make(map[string][4]bool)
+	tracked := make(map[string]bool)
+	for _, e := range idx.Entries {
+		tracked[e.Path] = true
+		st := stages[e.Path]
+		st[e.Stage] = true
+		stages[e.Path] = st
+	}
+
+	for p, st := range stages {
+		if !st[0] {
+			s.Unmerged[p] = unmergedKind(st)
+		}
+	}
+
+	files := idx.files()
+	for p, e := range files {
+		if h, ok := head[p]; !ok {
+			s.Staged[p] = "new file"
+		} else if h != e {
+			s.Staged[p] = "modified"
+		}
+	}
+	for p := range head {
+		if _, ok := files[p]; !ok && s.Unmerged[p] == "" {
+			s.Staged[p] = "deleted"
+		}
+	}
+
+	for _, e := range idx.Entries {
+		if e.Stage != 0 {
+			continue
+		}
+		e := e
+		sha, mode, err := worktreeSha(e.Path, &e)
+		if err != nil {
+			if os.IsNotExist(err) || os.IsPermission(err) {
+				s.Unstaged[e.Path] = "deleted"
+				continue
+			}
+			return nil, err
+		}
+		if sha != e.Sha || mode != int(e.Mode) {
+			s.Unstaged[e.Path] = "modified"
+		}
+	}
+
+	ignore, err := newIgnoreMatcher()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := listWorktree(idx, ignore)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]bool)
+	for p := range tracked {
+		for d := path.Dir(p); d != "."; d = path.Dir(d) {
+			dirs[d] = true
+		}
+	}
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		if tracked[p] {
+			continue
+		}
+		// Show a whole directory when none of its files are tracked.
+		shown := p
+		for d := path.Dir(p); d != "."; d = path.Dir(d) {
+			if !dirs[d] {
+				shown = d + "/"
+			}
+		}
+		if !seen[shown] {
+			seen[shown] = true
+			s.Untracked = append(s.Untracked, shown)
+		}
+	}
+	return s, nil
+}
+
+func unmergedKind(st [4]bool) string {
+	switch {
+	case st[1] && st[2] && st[3]:
+		return "both modified"
+	case st[2] && st[3]:
+		return "both added"
+	case st[1] && st[3]:
+		return "deleted by us"
+	case st[1] && st[2]:
+		return "deleted by them"
+	case st[2]:
+		return "added by us"
+	case st[3]:
+		return "added by them"
+	default:
+		return "both deleted"
+	}
+}
+
+func isMerging() bool {
+	_, err := os.Stat(".git/MERGE_HEAD")
+	return err == nil
+}
+
+func statusCommand(args []string) error {
+	short := false
+	for _, arg := range args {
+		switch arg {
+		case "-s", "--short", "--porcelain":
+			short = true
+		default:
+			return fmt.Errorf("unknown option %s", arg)
+		}
+	}
+
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	s, err := readStatus(idx)
+	if err != nil {
+		return err
+	}
+
+	if short {
+		printShortStatus(s)
+		return nil
+	}
+	return printLongStatus(s)
+}
+
+func printShortStatus(s *worktreeStatus) {
+	codes := map[string]string{
+		"new file": "A", "modified": "M", "deleted": "D",
+		"both modified": "UU", "both added": "AA", "both deleted": "DD",
+		"added by us": "AU", "added by them": "UA",
+		"deleted by us": "DU", "deleted by them": "UD",
+	}
+
+	paths := make(map[string]string)
+	for _, m := range []map[string]string{s.Staged, s.Unstaged, s.Unmerged} {
+		for p, kind := range m {
+			paths[p] = kind
+		}
+	}
+	for _, p := range sortedKeys(paths) {
+		if kind, ok := s.Unmerged[p]; ok {
+			fmt.Printf("%s %s\n", codes[kind], p)
+			continue
+		}
+		x, y := " ", " "
+		if kind, ok := s.Staged[p]; ok {
+			x = codes[kind]
+		}
+		if kind, ok := s.Unstaged[p]; ok {
+			y = codes[kind]
+		}
+		fmt.Printf("%s%s %s\n", x, y, p)
+	}
+	for _, p := range s.Untracked {
+		fmt.Printf("?? %s\n", p)
+	}
+}
+
+func printLongStatus(s *worktreeStatus) error {
+	branch, err := currentBranch()
+	if err != nil {
+		return err
+	}
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+
+	if branch != "" {
+		fmt.Printf("On branch %s\n", branch)
+	} else {
+		fmt.Printf("HEAD detached at %s\n", head[:7])
+	}
+	if head == "" {
+		fmt.Print("\nNo commits yet\n")
+	}
+
+	if isMerging() {
+		if len(s.Unmerged) > 0 {
+			fmt.Println("You have unmerged paths.")
+			fmt.Println("  (fix conflicts and run \"mygit commit\")")
+			fmt.Println("  (use \"mygit merge --abort\" to abort the merge)")
+		} else {
+			fmt.Println("All conflicts fixed but you are still merging.")
+			fmt.Println("  (use \"mygit commit\" to conclude merge)")
+		}
+	}
+
+	if rebaseInProgress() {
+		onto := readRebaseFile("onto")
+		if len(onto) > 7 {
+			onto = onto[:7]
+		}
+		fmt.Printf("You are currently rebasing branch '%s' on '%s'.\n", rebaseHeadName(), onto)
+		if len(s.Unmerged) > 0 {
+			fmt.Println("  (fix conflicts and then run \"mygit rebase --continue\")")
+		} else {
+			fmt.Println("  (all conflicts fixed: run \"mygit rebase --continue\")")
+		}
+		fmt.Println("  (use \"mygit rebase --skip\" to skip this patch)")
+		fmt.Println("  (use \"mygit rebase --abort\" to check out the original branch)")
+	}
+
+	for _, op := range []struct{ state, verb, name string }{
+		{"CHERRY_PICK_HEAD", "cherry-picking", "cherry-pick"},
+		{"REVERT_HEAD", "reverting", "revert"},
+	} {
+		sha, err := resolveRef(op.state)
+		if err != nil {
+			continue
+		}
+		fmt.Printf("You are currently %s commit %s.\n", op.verb, sha[:7])
+		if len(s.Unmerged) > 0 {
+			fmt.Printf("  (fix conflicts and run \"mygit %s --continue\")\n", op.name)
+		} else {
+			fmt.Printf("  (all conflicts fixed: run \"mygit %s --continue\")\n", op.name)
+		}
+		fmt.Printf("  (use \"mygit %s --abort\" to cancel the %s operation)\n", op.name, op.name)
+	}
+
+	// Labels are padded to the width of the longest one of their kind.
+	section := func(title string, changes map[string]string, width int) {
+		if len(changes) == 0 {
+			return
+		}
+		fmt.Printf("\n%s:\n", title)
+		for _, p := range sortedKeys(changes) {
+			fmt.Printf("\t%-*s%s\n", width, changes[p]+":", p)
+		}
+	}
+	section("Changes to be committed", s.Staged, 12)
+	section("Unmerged paths", s.Unmerged, 17)
+	section("Changes not staged for commit", s.Unstaged, 12)
+
+	if len(s.Untracked) > 0 {
+		fmt.Print("\nUntracked files:\n")
+		for _, p := range s.Untracked {
+			fmt.Printf("\t%s\n", p)
+		}
+	}
+
+	switch {
+	case !s.Clean():
+	case len(s.Untracked) > 0:
+		fmt.Print("\nnothing added to commit but untracked files present\n")
+	case head == "":
+		fmt.Print("\nnothing to commit\n")
+	default:
+		fmt.Print("\nnothing to commit, working tree clean\n")
+	}
+	return nil
+}
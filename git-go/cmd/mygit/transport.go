@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Notes about transports:
+// - A transport carries the requests of the wire protocol to a remote
+//   repository and brings back the answers: first the advertisement of a
+//   service, then requests that each get a whole answer.
+// - Smart HTTP GETs info/refs?service=<service> and POSTs requests to
+//   /<service>. The answer to the GET starts with a "# service=" line and
+//   a flush packet, except in protocol v2.
+// - A repository on this machine, given as a path or a file:// URL, is
+//   served by running git-upload-pack or git-receive-pack with
+//   --stateless-rpc, as git http-backend does, once per request.
+// - Cloning from a plain path skips the protocol for the objects and
+//   copies objects/, hard-linking the files where it can.
+// - ssh://[user@]host[:port]/path and scp-like [user@]host:path URLs run
+//   GIT_SSH_COMMAND, core.sshCommand or else "ssh" with the command line
+//   of the service, like "git-upload-pack '/path'". The connection stays
+//   up from the advertisement to the last request and the server keeps
+//   state between them, which changes how v0 fetches negotiate. The
+//   protocol version travels in GIT_PROTOCOL, which OpenSSH is asked to
+//   send along.
+
+type transport interface {
+	// advertise returns the lines of the advertisement of a service.
+	advertise(service string, version int) ([]string, error)
+	// request sends a request to a service and returns the answer, which
+	// the caller closes.
+	request(service string, body io.Reader, version int) (io.ReadCloser, error)
+	// stateless reports whether the server forgets each request once it
+	// has answered it.
+	stateless() bool
+	// close hangs up.
+	close() error
+}
+
+// openTransport picks the transport for a URL.
+func openTransport(url string) (transport, error) {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return &httpTransport{url: url}, nil
+	}
+	if host, port, path, ok := sshAddress(url); ok {
+		return &sshTransport{host: host, port: port, path: path}, nil
+	}
+	if p, ok := localPath(url); ok {
+		return &localTransport{path: p}, nil
+	}
+	return nil, fmt.Errorf("'%s' does not appear to be a git repository", url)
+}
+
+// localPath returns the directory of a file:// URL or of a path to a
+// repository.
+func localPath(url string) (string, bool) {
+	p := url
+	if strings.HasPrefix(url, "file://") {
+		p = strings.TrimPrefix(url, "file://")
+	} else if _, _, _, ssh := sshAddress(url); ssh || strings.Contains(url, "://") {
+		return "", false
+	}
+	if fi, err := os.Stat(p); err != nil || !fi.IsDir() {
+		return "", false
+	}
+	return p, true
+}
+
+type httpTransport struct {
+	url string
+}
+
+func (t *httpTransport) advertise(service string, version int) ([]string, error) {
+	resp, err := smartRequest("GET", t.url+"/info/refs?service="+service, "", nil, version)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/x-"+service+"-advertisement" {
+		return nil, fmt.Errorf("%s is not a smart HTTP git server", t.url)
+	}
+
+	// A v2 answer starts right away with the version; the others first
+	// name the service.
+	r := newPktReader(resp.Body)
+	typ, line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{line}
+	if typ != pktData || line != "version 2" {
+		if typ != pktData || line != "# service="+service {
+			return nil, fmt.Errorf("invalid server response; got '%s'", line)
+		}
+		if typ, _, err = r.read(); err != nil {
+			return nil, err
+		}
+		if typ != pktFlush {
+			return nil, fmt.Errorf("protocol error: expected flush after service line, got %s", typ)
+		}
+		lines = nil
+	}
+	rest, err := r.readLines()
+	if err != nil {
+		return nil, err
+	}
+	return append(lines, rest...), nil
+}
+
+func (t *httpTransport) request(service string, body io.Reader, version int) (io.ReadCloser, error) {
+	resp, err := smartRequest("POST", t.url+"/"+service, "application/x-"+service+"-request", body, version)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (t *httpTransport) stateless() bool { return true }
+
+func (t *httpTransport) close() error { return nil }
+
+// smartRequest sends a request to a smart HTTP server and checks that the
+// answer is one.
+func smartRequest(method, url, contentType string, body io.Reader, version int) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if version > 0 {
+		req.Header.Set("Git-Protocol", fmt.Sprintf("version=%d", version))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unable to access '%s': the server returned %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+type localTransport struct {
+	path string
+}
+
+func (t *localTransport) advertise(service string, version int) ([]string, error) {
+	out, err := t.run(service, version, nil, "--advertise-refs")
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	return newPktReader(out).readLines()
+}
+
+func (t *localTransport) request(service string, body io.Reader, version int) (io.ReadCloser, error) {
+	return t.run(service, version, body)
+}
+
+func (t *localTransport) stateless() bool { return true }
+
+func (t *localTransport) close() error { return nil }
+
+// run starts the program for a service on the repository, feeding it the
+// body, and returns its output.
+func (t *localTransport) run(service string, version int, body io.Reader, args ...string) (io.ReadCloser, error) {
+	args = append(append([]string{"--stateless-rpc"}, args...), t.path)
+	cmd := exec.Command(service, args...)
+	cmd.Stdin = body
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	if version > 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_PROTOCOL=version=%d", version))
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &processOutput{ReadCloser: out, cmd: cmd}, nil
+}
+
+// processOutput is the output of a program that is done with once the
+// program exits.
+type processOutput struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (p *processOutput) Close() error {
+	// The program may still be writing what nobody wants to read.
+	io.Copy(io.Discard, p.ReadCloser)
+	if err := p.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %s", filepath.Base(p.cmd.Path), err)
+	}
+	return nil
+}
+
+// sshAddress splits an ssh:// or scp-like URL into the host, with the
+// user if any, the port and the path of the repository.
+func sshAddress(url string) (host, port, path string, ok bool) {
+	if strings.HasPrefix(url, "ssh://") {
+		rest := strings.TrimPrefix(url, "ssh://")
+		slash := strings.IndexByte(rest, '/')
+		if slash <= 0 {
+			return "", "", "", false
+		}
+		host, path = rest[:slash], rest[slash:]
+		// ssh://host/~user/repo names a path in a home directory.
+		if strings.HasPrefix(path, "/~") {
+			path = path[1:]
+		}
+		if i := strings.LastIndex(host, ":"); i >= 0 && isDigits(host[i+1:]) {
+			host, port = host[:i], host[i+1:]
+		}
+		return host, port, path, true
+	}
+	// A colon before any slash makes it scp-like rather than a path.
+	colon := strings.Index(url, ":")
+	slash := strings.Index(url, "/")
+	if colon <= 0 || slash >= 0 && slash < colon || strings.Contains(url, "://") {
+		return "", "", "", false
+	}
+	return url[:colon], "", url[colon+1:], true
+}
+
+type sshTransport struct {
+	host, port, path string
+
+	// The connection to the service last advertised.
+	service string
+	cmd     *exec.Cmd
+	in      io.WriteCloser
+	out     io.ReadCloser
+}
+
+func (t *sshTransport) advertise(service string, version int) ([]string, error) {
+	if err := t.close(); err != nil {
+		return nil, err
+	}
+	if err := t.connect(service, version); err != nil {
+		return nil, err
+	}
+	lines, err := newPktReader(t.out).readLines()
+	if err != nil {
+		// What went wrong came from ssh or the server on stderr.
+		t.close()
+		return nil, errors.New("Could not read from remote repository.\n\n" +
+			"Please make sure you have the correct access rights\nand the repository exists.")
+	}
+	return lines, nil
+}
+
+// request writes to the connection and returns it for the answer. The
+// server answers only once it has the whole request and sends nothing
+// more until the next, so each answer can be read on its own.
+func (t *sshTransport) request(service string, body io.Reader, version int) (io.ReadCloser, error) {
+	if t.cmd == nil || t.service != service {
+		return nil, fmt.Errorf("not connected to %s", service)
+	}
+	if _, err := io.Copy(t.in, body); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(t.out), nil
+}
+
+func (t *sshTransport) stateless() bool { return false }
+
+// connect runs the ssh command with the command line of the service.
+func (t *sshTransport) connect(service string, version int) error {
+	command := sshCommand()
+	args := make([]string, 0)
+	// OpenSSH only passes on the variables it is told to.
+	if fields := strings.Fields(command); len(fields) > 0 && filepath.Base(fields[0]) == "ssh" && version > 0 {
+		args = append(args, "-o", "SendEnv=GIT_PROTOCOL")
+	}
+	if t.port != "" {
+		args = append(args, "-p", t.port)
+	}
+	args = append(args, t.host, service+" "+shellQuote(t.path))
+
+	// The command is a shell snippet, so the arguments go after it.
+	cmd := exec.Command("sh", append([]string{"-c", command + ` "$@"`, command}, args...)...)
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	if version > 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_PROTOCOL=version=%d", version))
+	}
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	t.service, t.cmd, t.in, t.out = service, cmd, in, out
+	return nil
+}
+
+// close tells the server there is nothing more to ask, in case it is
+// still listening, and waits for the ssh command to exit.
+func (t *sshTransport) close() error {
+	if t.cmd == nil {
+		return nil
+	}
+	newPktWriter(t.in).flush()
+	t.in.Close()
+	io.Copy(io.Discard, t.out)
+	err := t.cmd.Wait()
+	t.cmd = nil
+	if err != nil {
+		return fmt.Errorf("ssh: %s", err)
+	}
+	return nil
+}
+
+// sshCommand returns the shell command that runs ssh.
+func sshCommand() string {
+	if command := os.Getenv("GIT_SSH_COMMAND"); command != "" {
+		return command
+	}
+	if command, ok := configValue("core.sshCommand"); ok && command != "" {
+		return command
+	}
+	return "ssh"
+}
+
+// copyObjects fills the object store with the objects of a repository on
+// this machine, hard-linking the files or else copying them.
+func copyObjects(path string) error {
+	src := filepath.Join(path, ".git", "objects")
+	if fi, err := os.Stat(src); err != nil || !fi.IsDir() {
+		src = filepath.Join(path, "objects")
+	}
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(".git", "objects", rel)
+		if info.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		if rel == filepath.Join("info", "alternates") {
+			return nil
+		}
+		if err := os.Link(p, dst); err == nil || os.IsExist(err) {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dst, b, info.Mode().Perm())
+	})
+	forgetPacks()
+	return err
+}
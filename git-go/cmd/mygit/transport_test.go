@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testUpstream makes a repository with one commit on master in its own
+// directory, and returns the directory and the commit.
+func testUpstream(t *testing.T) (string, string) {
+	t.Helper()
+	newTestRepo(t)
+	c := testCommit(t, map[string]string{"a": "one\n", "dir/b": "two\n"}, 100)
+	if err := updateRef("refs/heads/master", c); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, c
+}
+
+func TestLocalPath(t *testing.T) {
+	dir, _ := testUpstream(t)
+	if err := os.WriteFile("file", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url  string
+		want string
+		ok   bool
+	}{
+		{dir, dir, true},
+		{"file://" + dir, dir, true},
+		{".", ".", true},
+		{filepath.Join(dir, "missing"), "", false},
+		{"file", "", false},
+		{"https://example.com/repo.git", "", false},
+		{"ssh://example.com/repo.git", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := localPath(tt.url)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("localPath(%q) = %q, %v; want %q, %v", tt.url, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLocalTransport(t *testing.T) {
+	dir, c := testUpstream(t)
+	newTestRepo(t)
+	for _, version := range []int{0, 1, 2} {
+		setTestConfig(t, fmt.Sprintf("[protocol]\n\tversion = %d\n", version))
+		tr, err := openTransport("file://" + dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adv, err := findRefs(tr, []string{"refs/heads/"})
+		if err != nil {
+			t.Fatalf("protocol.version=%d: findRefs: %s", version, err)
+		}
+		found := false
+		for _, r := range adv.Refs {
+			found = found || r == ref{Sha: c, Name: "refs/heads/master"}
+		}
+		if !found {
+			t.Errorf("protocol.version=%d: refs = %+v, want master at %s", version, adv.Refs, c)
+		}
+		pack, err := fetchPack(tr, adv, []string{c}, nil)
+		if err != nil {
+			t.Fatalf("protocol.version=%d: fetchPack: %s", version, err)
+		}
+		if len(pack) < 4 || string(pack[:4]) != "PACK" {
+			t.Errorf("protocol.version=%d: fetchPack returned %q", version, pack)
+		}
+	}
+
+	// A plain path is cloned by copying the objects.
+	if err := clone(dir, "copy"); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := headCommit(); err != nil || head != c {
+		t.Errorf("HEAD of the clone = %s, %v; want %s", head, err, c)
+	}
+	if b, err := os.ReadFile(filepath.Join("dir", "b")); err != nil || string(b) != "two\n" {
+		t.Errorf("dir/b in the clone = %q, %v", b, err)
+	}
+}
+
+func TestSSHAddress(t *testing.T) {
+	tests := []struct {
+		url              string
+		host, port, path string
+		ok               bool
+	}{
+		{"ssh://git@example.com/srv/repo.git", "git@example.com", "", "/srv/repo.git", true},
+		{"ssh://example.com:2222/repo.git", "example.com", "2222", "/repo.git", true},
+		{"ssh://example.com/~alice/repo.git", "example.com", "", "~alice/repo.git", true},
+		{"git@example.com:team/repo.git", "git@example.com", "", "team/repo.git", true},
+		{"host:/abs/repo", "host", "", "/abs/repo", true},
+		{"ssh://example.com", "", "", "", false},
+		{"./dir:with/colon", "", "", "", false},
+		{"/srv/repo.git", "", "", "", false},
+		{"file:///srv/repo.git", "", "", "", false},
+		{"https://example.com/repo.git", "", "", "", false},
+	}
+	for _, tt := range tests {
+		host, port, path, ok := sshAddress(tt.url)
+		if host != tt.host || port != tt.port || path != tt.path || ok != tt.ok {
+			t.Errorf("sshAddress(%q) = %q, %q, %q, %v; want %q, %q, %q, %v",
+				tt.url, host, port, path, ok, tt.host, tt.port, tt.path, tt.ok)
+		}
+	}
+}
+
+func TestSSHTransport(t *testing.T) {
+	dir, c := testUpstream(t)
+	newTestRepo(t)
+	// The "ssh" command runs the service here, whatever the host.
+	fake := filepath.Join(t.TempDir(), "fake-ssh")
+	if err := os.WriteFile(fake, []byte("#!/bin/sh\nfor last; do :; done\nexec sh -c \"$last\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []int{0, 2} {
+		setTestConfig(t, fmt.Sprintf("[core]\n\tsshCommand = %s\n[protocol]\n\tversion = %d\n", fake, version))
+		tr, err := openTransport("localhost:" + dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tr.stateless() {
+			t.Error("the ssh transport says it is stateless")
+		}
+		adv, err := findRefs(tr, []string{"refs/heads/"})
+		if err != nil {
+			t.Fatalf("protocol.version=%d: findRefs: %s", version, err)
+		}
+		if want := wantObjects(adv.Refs); len(want) != 1 || want[0] != c {
+			t.Errorf("protocol.version=%d: refs = %+v, want master at %s", version, adv.Refs, c)
+		}
+		pack, err := fetchPack(tr, adv, []string{c}, nil)
+		if err != nil {
+			t.Fatalf("protocol.version=%d: fetchPack: %s", version, err)
+		}
+		if len(pack) < 4 || string(pack[:4]) != "PACK" {
+			t.Errorf("protocol.version=%d: fetchPack returned %q", version, pack)
+		}
+		if err := tr.close(); err != nil {
+			t.Errorf("protocol.version=%d: close: %s", version, err)
+		}
+	}
+}
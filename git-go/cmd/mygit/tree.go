@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	modeTree       = 0o040000
+	modeBlob       = 0o100644
+	modeExecutable = 0o100755
+	modeSymlink    = 0o120000
+	modeGitlink    = 0o160000
+)
+
+type treeEntry struct {
+	Mode int
+	Name string
+	Sha  string
+}
+
+func (e treeEntry) IsTree() bool {
+	return e.Mode == modeTree
+}
+
+func (e treeEntry) Type() string {
+	switch e.Mode {
+	case modeTree:
+		return "tree"
+	case modeGitlink:
+		return "commit"
+	default:
+		return "blob"
+	}
+}
+
+func readTree(sha string) ([]treeEntry, error) {
+	b, err := readObjectOfType(sha, "tree")
+	if err != nil {
+		return nil, err
+	}
+	return parseTree(b)
+}
+
+func parseTree(b []byte) ([]treeEntry, error) {
+	entries := make([]treeEntry, 0)
+	for len(b) > 0 {
+		sp := bytes.IndexByte(b, ' ')
+		if sp < 0 {
+			return nil, errors.New("malformed tree entry")
+		}
+		mode, err := strconv.ParseInt(string(b[:sp]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed tree entry mode %q", b[:sp])
+		}
+		b = b[sp+1:]
+
+		nul := bytes.IndexByte(b, '\x00')
+		if nul < 0 || len(b) < nul+21 {
+			return nil, errors.New("malformed tree entry")
+		}
+		name := string(b[:nul])
+		b = b[nul+1:]
+
+		entries = append(entries, treeEntry{
+			Mode: int(mode),
+			Name: name,
+			Sha:  fmt.Sprintf("%x", b[:20]),
+		})
+		b = b[20:]
+	}
+	return entries, nil
+}
+
+// sortTreeEntries sorts entries the way git expects them in a tree object.
+func sortTreeEntries(entries []treeEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return treeSortKey(entries[i]) < treeSortKey(entries[j])
+	})
+}
+
+// treeSortKey is the name an entry sorts by: directories sort as if their
+// name ended with a slash.
+func treeSortKey(e treeEntry) string {
+	if e.IsTree() {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+func encodeTree(entries []treeEntry) ([]byte, error) {
+	sorted := append([]treeEntry(nil), entries...)
+	sortTreeEntries(sorted)
+
+	var b bytes.Buffer
+	for _, e := range sorted {
+		sha, err := decodeSha(e.Sha)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "%o %s\x00", e.Mode, e.Name)
+		b.Write(sha[:])
+	}
+	return b.Bytes(), nil
+}
+
+func writeTreeEntries(entries []treeEntry) ([20]byte, error) {
+	b, err := encodeTree(entries)
+	if err != nil {
+		return [20]byte{}, err
+	}
+	return writeObject("tree", b)
+}
+
+// flattenTree walks a tree recursively and returns every non-tree entry keyed
+// by its slash separated path.
+func flattenTree(sha string) (map[string]treeEntry, error) {
+	files := make(map[string]treeEntry)
+	if sha == "" {
+		return files, nil
+	}
+	err := walkTree(sha, "", func(p string, e treeEntry) error {
+		files[p] = treeEntry{Mode: e.Mode, Sha: e.Sha}
+		return nil
+	})
+	return files, err
+}
+
+func walkTree(sha, prefix string, fn func(string, treeEntry) error) error {
+	entries, err := readTree(sha)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		p := prefix + e.Name
+		if e.IsTree() {
+			err = walkTree(e.Sha, p+"/", fn)
+		} else {
+			err = fn(p, e)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildTree writes the nested tree objects for a flat path to entry map and
+// returns the id of the root tree.
+func buildTree(files map[string]treeEntry) (string, error) {
+	type dir struct {
+		entries []treeEntry
+		subdirs map[string]*dir
+	}
+	newDir := func() *dir {
+		return &dir{subdirs: make(map[string]*dir)}
+	}
+
+	root := newDir()
+	for p, e := range files {
+		d := root
+		parts := strings.Split(p, "/")
+		for _, part := range parts[:len(parts)-1] {
+			sub, ok := d.subdirs[part]
+			if !ok {
+				sub = newDir()
+				d.subdirs[part] = sub
+			}
+			d = sub
+		}
+		e.Name = parts[len(parts)-1]
+		d.entries = append(d.entries, e)
+	}
+
+	var write func(d *dir) (string, error)
+	write = func(d *dir) (string, error) {
+		entries := d.entries
+		for name, sub := range d.subdirs {
+			sha, err := write(sub)
+			if err != nil {
+				return "", err
+			}
+			entries = append(entries, treeEntry{Mode: modeTree, Name: name, Sha: sha})
+		}
+		checksum, err := writeTreeEntries(entries)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%x", checksum), nil
+	}
+	return write(root)
+}
+
+func decodeSha(sha string) ([20]byte, error) {
+	var b [20]byte
+	if len(sha) != 40 {
+		return b, fmt.Errorf("invalid object name %s", sha)
+	}
+	_, err := hex.Decode(b[:], []byte(sha))
+	if err != nil {
+		return b, fmt.Errorf("invalid object name %s", sha)
+	}
+	return b, nil
+}
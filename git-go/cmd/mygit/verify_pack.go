@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// verifyPackCommand implements "verify-pack". It reports false when a pack
+// fails verification.
+func verifyPackCommand(args []string) (bool, error) {
+	verbose, statOnly := false, false
+	paths := make([]string, 0)
+	for _, arg := range args {
+		switch arg {
+		case "-v", "--verbose":
+			verbose = true
+		case "-s", "--stat-only":
+			statOnly = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return false, fmt.Errorf("unknown option %s", arg)
+			}
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) == 0 {
+		return false, errors.New("usage: mygit verify-pack [-v | -s] <pack>.idx...")
+	}
+
+	ok := true
+	for _, p := range paths {
+		base := strings.TrimSuffix(strings.TrimSuffix(p, ".idx"), ".pack")
+		if err := verifyPack(base, verbose, statOnly); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			if verbose {
+				fmt.Printf("%s.pack: bad\n", base)
+			}
+			ok = false
+		}
+	}
+	return ok, nil
+}
+
+// verifyPack checks a pack against its index and lists its entries with
+// their delta chains.
+func verifyPack(base string, verbose, statOnly bool) error {
+	b, err := os.ReadFile(base + ".idx")
+	if err != nil {
+		return err
+	}
+	idx, err := parsePackIndex(b)
+	if err != nil {
+		return fmt.Errorf("%s.idx: %s", base, err)
+	}
+	idx.PackPath = base + ".pack"
+
+	// packData checks the pack header and checksum through parsePack.
+	data, err := idx.packData()
+	if err != nil {
+		return err
+	}
+	p, err := parsePack(data)
+	if err != nil {
+		return err
+	}
+	if p.Checksum != idx.Checksum {
+		return fmt.Errorf("%s.idx does not match its pack", base)
+	}
+	if p.NumObjects != len(idx.Shas) {
+		return fmt.Errorf("%s.pack has %d objects, its index %d", base, p.NumObjects, len(idx.Shas))
+	}
+	lengths, err := idx.entryLengths()
+	if err != nil {
+		return err
+	}
+
+	order := make([]int, len(idx.Shas))
+	shaAt := make(map[int64]string, len(idx.Shas))
+	for i := range order {
+		order[i] = i
+		shaAt[idx.Offsets[i]] = idx.Shas[i]
+	}
+	sort.Slice(order, func(i, j int) bool { return idx.Offsets[order[i]] < idx.Offsets[order[j]] })
+
+	nonDelta := 0
+	chains := make(map[int]int)
+	maxDepth := 0
+	for _, i := range order {
+		offset := idx.Offsets[i]
+		e, err := idx.entryAt(offset)
+		if err != nil {
+			return fmt.Errorf("%s: %s", idx.Shas[i], err)
+		}
+		t, content, err := idx.readAt(offset)
+		if err != nil {
+			return fmt.Errorf("%s: %s", idx.Shas[i], err)
+		}
+		if hashObject(t, content) != idx.Shas[i] {
+			return fmt.Errorf("%s: hash mismatch in %s.pack", idx.Shas[i], base)
+		}
+
+		depth, baseSha := 0, ""
+		for d := e; d.Type == OBJ_OFS_DELTA || d.Type == OBJ_REF_DELTA; depth++ {
+			next := d.BaseSha
+			if d.Type == OBJ_OFS_DELTA {
+				next = shaAt[d.BaseOffset]
+			}
+			if depth == 0 {
+				baseSha = next
+			}
+			j, ok := idx.find(next)
+			if !ok {
+				// A thin pack's base lives elsewhere and ends the chain.
+				depth++
+				break
+			}
+			if d, err = idx.entryAt(idx.Offsets[j]); err != nil {
+				return err
+			}
+		}
+
+		if depth == 0 {
+			nonDelta++
+		} else {
+			chains[depth]++
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+		}
+		if verbose && !statOnly {
+			line := fmt.Sprintf("%s %-6s %d %d %d", idx.Shas[i], t, e.Size, lengths[offset], offset)
+			if depth > 0 {
+				line += fmt.Sprintf(" %d %s", depth, baseSha)
+			}
+			fmt.Println(line)
+		}
+	}
+
+	if verbose || statOnly {
+		if nonDelta > 0 {
+			fmt.Printf("non delta: %d object%s\n", nonDelta, plural(nonDelta))
+		}
+		for d := 1; d <= maxDepth; d++ {
+			if chains[d] > 0 {
+				fmt.Printf("chain length = %d: %d object%s\n", d, chains[d], plural(chains[d]))
+			}
+		}
+	}
+	if verbose && !statOnly {
+		fmt.Printf("%s.pack: ok\n", base)
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import "strings"
+
+// wildmatch follows git's wildmatch.c: '*', '?', '[...]' classes including
+// [:alpha:] and friends, backslash escapes, and with wmPathname a '**' that
+// spans directories while single wildcards stop at '/'.
+
+const (
+	wmCaseFold = 1 << iota
+	wmPathname
+)
+
+const (
+	wmMatch = iota
+	wmNoMatch
+	wmAbortAll
+	wmAbortToStarStar
+)
+
+func wildmatch(pattern, text string, flags int) bool {
+	return doWild(pattern, text, flags) == wmMatch
+}
+
+// charAt returns the byte at i, or 0 past the end like a C string does.
+func charAt(s string, i int) byte {
+	if i < len(s) {
+		return s[i]
+	}
+	return 0
+}
+
+func toLowerByte(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func doWild(p, text string, flags int) int {
+	pi, ti := 0, 0
+	for ; pi < len(p); pi, ti = pi+1, ti+1 {
+		pc := p[pi]
+		tc := charAt(text, ti)
+		if tc == 0 && pc != '*' {
+			return wmAbortAll
+		}
+		if flags&wmCaseFold != 0 {
+			tc = toLowerByte(tc)
+			pc = toLowerByte(pc)
+		}
+
+		switch pc {
+		case '\\':
+			pi++
+			pc = charAt(p, pi)
+			if flags&wmCaseFold != 0 {
+				pc = toLowerByte(pc)
+			}
+			if tc != pc {
+				return wmNoMatch
+			}
+		case '?':
+			if flags&wmPathname != 0 && tc == '/' {
+				return wmNoMatch
+			}
+		case '*':
+			var matchSlash bool
+			pi++
+			if charAt(p, pi) == '*' {
+				prev := pi - 2
+				for pi++; charAt(p, pi) == '*'; pi++ {
+				}
+				next := charAt(p, pi)
+				if (prev < 0 || p[prev] == '/') &&
+					(next == 0 || next == '/' || (next == '\\' && charAt(p, pi+1) == '/')) {
+					if next == '/' && doWild(p[pi+1:], text[ti:], flags) == wmMatch {
+						return wmMatch
+					}
+					matchSlash = true
+				}
+			} else {
+				matchSlash = flags&wmPathname == 0
+			}
+
+			if pi >= len(p) {
+				if !matchSlash && strings.IndexByte(text[ti:], '/') >= 0 {
+					return wmNoMatch
+				}
+				return wmMatch
+			}
+			if !matchSlash && p[pi] == '/' {
+				slash := strings.IndexByte(text[ti:], '/')
+				if slash < 0 {
+					return wmNoMatch
+				}
+				// The loop's increments step over the slashes on both sides.
+				ti += slash
+				continue
+			}
+
+			for tc != 0 {
+				if !isGlobSpecial(p[pi]) {
+					want := p[pi]
+					if flags&wmCaseFold != 0 {
+						want = toLowerByte(want)
+					}
+					for tc = charAt(text, ti); tc != 0 && (matchSlash || tc != '/'); tc = charAt(text, ti) {
+						if flags&wmCaseFold != 0 {
+							tc = toLowerByte(tc)
+						}
+						if tc == want {
+							break
+						}
+						ti++
+					}
+					if tc != want {
+						return wmNoMatch
+					}
+				}
+				if m := doWild(p[pi:], text[ti:], flags); m != wmNoMatch {
+					if !matchSlash || m != wmAbortToStarStar {
+						return m
+					}
+				} else if !matchSlash && tc == '/' {
+					return wmAbortToStarStar
+				}
+				ti++
+				tc = charAt(text, ti)
+				if flags&wmCaseFold != 0 {
+					tc = toLowerByte(tc)
+				}
+			}
+			return wmAbortAll
+		case '[':
+			end, matched, ok := matchClass(p, pi, tc, flags)
+			if !ok {
+				return wmAbortAll
+			}
+			pi = end
+			if !matched || (flags&wmPathname != 0 && tc == '/') {
+				return wmNoMatch
+			}
+		default:
+			if tc != pc {
+				return wmNoMatch
+			}
+		}
+	}
+	if ti < len(text) {
+		return wmNoMatch
+	}
+	return wmMatch
+}
+
+// matchClass matches c against the bracket expression starting at p[start].
+// It returns the position of the closing bracket, whether c matched taking
+// negation into account, and false for a malformed class.
+func matchClass(p string, start int, c byte, flags int) (int, bool, bool) {
+	pi := start + 1
+	pc := charAt(p, pi)
+	if pc == '^' {
+		pc = '!'
+	}
+	negated := pc == '!'
+	if negated {
+		pi++
+		pc = charAt(p, pi)
+	}
+
+	var prev byte
+	matched := false
+	for {
+		if pc == 0 {
+			return 0, false, false
+		}
+		switch {
+		case pc == '\\':
+			pi++
+			pc = charAt(p, pi)
+			if pc == 0 {
+				return 0, false, false
+			}
+			if c == pc {
+				matched = true
+			}
+		case pc == '-' && prev != 0 && charAt(p, pi+1) != 0 && charAt(p, pi+1) != ']':
+			pi++
+			pc = charAt(p, pi)
+			if pc == '\\' {
+				pi++
+				pc = charAt(p, pi)
+				if pc == 0 {
+					return 0, false, false
+				}
+			}
+			if c <= pc && c >= prev {
+				matched = true
+			} else if flags&wmCaseFold != 0 && c >= 'a' && c <= 'z' {
+				if upper := c - 'a' + 'A'; upper <= pc && upper >= prev {
+					matched = true
+				}
+			}
+			pc = 0
+		case pc == '[' && charAt(p, pi+1) == ':':
+			s := pi + 2
+			end := s
+			for end < len(p) && p[end] != ']' {
+				end++
+			}
+			if end >= len(p) {
+				return 0, false, false
+			}
+			if end-s-1 < 0 || p[end-1] != ':' {
+				// No ":]", so the '[' is an ordinary member of the set.
+				if c == '[' {
+					matched = true
+				}
+				break
+			}
+			in, known := charClass(p[s:end-1], c, flags)
+			if !known {
+				return 0, false, false
+			}
+			if in {
+				matched = true
+			}
+			pi = end
+			pc = 0
+		default:
+			if c == pc {
+				matched = true
+			}
+		}
+		prev = pc
+		pi++
+		if pc = charAt(p, pi); pc == ']' {
+			break
+		}
+	}
+	return pi, matched != negated, true
+}
+
+func charClass(name string, c byte, flags int) (bool, bool) {
+	lower := c >= 'a' && c <= 'z'
+	upper := c >= 'A' && c <= 'Z'
+	digit := c >= '0' && c <= '9'
+	switch name {
+	case "alnum":
+		return lower || upper || digit, true
+	case "alpha":
+		return lower || upper, true
+	case "blank":
+		return c == ' ' || c == '\t', true
+	case "cntrl":
+		return c < 0x20 || c == 0x7f, true
+	case "digit":
+		return digit, true
+	case "graph":
+		return c > 0x20 && c < 0x7f, true
+	case "lower":
+		return lower || (flags&wmCaseFold != 0 && upper), true
+	case "print":
+		return c >= 0x20 && c < 0x7f, true
+	case "punct":
+		return c > 0x20 && c < 0x7f && !lower && !upper && !digit, true
+	case "space":
+		return c == ' ' || (c >= '\t' && c <= '\r'), true
+	case "upper":
+		return upper || (flags&wmCaseFold != 0 && lower), true
+	case "xdigit":
+		return digit || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F'), true
+	}
+	return false, false
+}
+
+func isGlobSpecial(c byte) bool {
+	return c == '*' || c == '?' || c == '[' || c == '\\'
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestWildmatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern, text string
+		flags         int
+		want          bool
+	}{
+		{"foo", "foo", 0, true},
+		{"foo", "bar", 0, false},
+		{"*.c", "main.c", 0, true},
+		{"*.c", "src/main.c", 0, true},
+		{"*.c", "src/main.c", wmPathname, false},
+		{"src/*.c", "src/main.c", wmPathname, true},
+		{"**/main.c", "a/b/main.c", wmPathname, true},
+		{"**/main.c", "main.c", wmPathname, true},
+		{"a/**/b", "a/b", wmPathname, true},
+		{"a/**/b", "a/x/y/b", wmPathname, true},
+		{"a/**", "a/x/y", wmPathname, true},
+		{"f?o", "foo", 0, true},
+		{"f?o", "f/o", wmPathname, false},
+		{"[a-c]x", "bx", 0, true},
+		{"[!a-c]x", "bx", 0, false},
+		{"[[:digit:]]*", "7up", 0, true},
+		{"[[:upper:]]", "a", 0, false},
+		{`\*`, "*", 0, true},
+		{`\*`, "x", 0, false},
+		{"FOO", "foo", 0, false},
+		{"FOO", "foo", wmCaseFold, true},
+	} {
+		if got := wildmatch(test.pattern, test.text, test.flags); got != test.want {
+			t.Errorf("wildmatch(%q, %q, %d) = %v, want %v", test.pattern, test.text, test.flags, got, test.want)
+		}
+	}
+}
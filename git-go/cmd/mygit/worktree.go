@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// fileMode returns the git mode of a file in the working tree.
+func fileMode(info os.FileInfo) int {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return modeSymlink
+	case info.IsDir():
+		return modeTree
+	case info.Mode()&0o111 != 0:
+		return modeExecutable
+	default:
+		return modeBlob
+	}
+}
+
+// readWorktreeFile returns the content git stores for a working tree file:
+// the converted file data, or the link target for a symlink. write tells
+// whether the content is about to be stored.
+func readWorktreeFile(p string, write bool) ([]byte, os.FileInfo, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(p)
+		return []byte(target), info, err
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err = convertToGit(p, b, write)
+	return b, info, err
+}
+
+// worktreeSha returns the blob id a working tree file would get, reusing
+// the index entry when the stat data says the file did not change.
+func worktreeSha(p string, e *indexEntry) (string, int, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return "", 0, err
+	}
+	if e != nil && e.statMatches(info) {
+		return e.Sha, int(e.Mode), nil
+	}
+
+	b, info, err := readWorktreeFile(p, false)
+	if err != nil {
+		return "", 0, err
+	}
+	return hashObject("blob", b), fileMode(info), nil
+}
+
+// trackedPaths returns the paths in the index and the directories holding
+// them, which ignore patterns do not apply to.
+func trackedPaths(idx *index) map[string]bool {
+	tracked := make(map[string]bool)
+	if idx == nil {
+		return tracked
+	}
+	for _, e := range idx.Entries {
+		tracked[e.Path] = true
+		for d := path.Dir(e.Path); d != "."; d = path.Dir(d) {
+			tracked[d] = true
+		}
+	}
+	return tracked
+}
+
+// listWorktree returns the paths of the files in the working tree. With an
+// ignore matcher, untracked files it excludes are left out, and ignored
+// directories holding no tracked files are not walked.
+func listWorktree(idx *index, ignore *ignoreMatcher) ([]string, error) {
+	tracked := trackedPaths(idx)
+
+	paths := make([]string, 0)
+	err := filepath.Walk(".", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+		name := filepath.ToSlash(p)
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if ignore != nil && !tracked[name] {
+			ignored, err := ignore.ignored(name, info.IsDir())
+			if err != nil {
+				return err
+			}
+			if ignored && info.IsDir() {
+				return filepath.SkipDir
+			}
+			if ignored {
+				return nil
+			}
+		}
+		if !info.IsDir() {
+			paths = append(paths, name)
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+func writeWorktreeFile(p string, e treeEntry) error {
+	if e.Mode == modeGitlink {
+		return os.MkdirAll(p, 0755)
+	}
+
+	b, err := readObjectOfType(e.Sha, "blob")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+	// Whatever is in the way, a directory or a file with other permissions,
+	// has to go first.
+	if err := os.RemoveAll(p); err != nil {
+		return err
+	}
+
+	if e.Mode == modeSymlink {
+		return os.Symlink(string(b), p)
+	}
+	if b, err = convertToWorktree(p, b); err != nil {
+		return err
+	}
+	if path.Base(p) == ".gitattributes" {
+		forgetAttributes()
+	}
+	switch e.Mode {
+	case modeExecutable:
+		return os.WriteFile(p, b, 0755)
+	default:
+		return os.WriteFile(p, b, 0644)
+	}
+}
+
+// removeWorktreeFile deletes a file and the directories it leaves empty.
+func removeWorktreeFile(p string) error {
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTDIR) {
+		return err
+	}
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// checkoutFiles moves the working tree and the index from the files of one
+// tree to the files of another. Unless force is set, it refuses to touch
+// paths with staged or unstaged changes, or untracked files in the way.
+func checkoutFiles(idx *index, from, to map[string]treeEntry, force bool, action string) error {
+	changed := make([]string, 0)
+	for p, e := range to {
+		if old, ok := from[p]; !ok || old != e {
+			changed = append(changed, p)
+		}
+	}
+	for p := range from {
+		if _, ok := to[p]; !ok {
+			changed = append(changed, p)
+		}
+	}
+	sort.Strings(changed)
+
+	if !force {
+		if err := checkOverwrite(idx, from, changed, action); err != nil {
+			return err
+		}
+	}
+
+	// Removals go first so that files can replace directories they leave.
+	for _, p := range changed {
+		if _, ok := to[p]; !ok {
+			if err := removeWorktreeFile(p); err != nil {
+				return err
+			}
+			idx.remove(p)
+		}
+	}
+	for _, p := range changed {
+		if e, ok := to[p]; ok {
+			if err := writeWorktreeFile(p, e); err != nil {
+				return err
+			}
+			entry, err := newIndexEntry(p, e.Mode, e.Sha)
+			if err != nil {
+				return err
+			}
+			idx.add(entry)
+		}
+	}
+
+	if force {
+		// Also throw away changes to paths the two trees agree on.
+		for p, e := range to {
+			if err := resetPath(idx, p, e); err != nil {
+				return err
+			}
+		}
+		for _, e := range append([]indexEntry(nil), idx.Entries...) {
+			if _, ok := to[e.Path]; !ok {
+				idx.remove(e.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// resetPath makes the index and the working tree hold the given entry for a
+// path, rewriting the file only when it differs.
+func resetPath(idx *index, p string, e treeEntry) error {
+	current, ok := idx.entry(p)
+	if ok && current.Sha == e.Sha && int(current.Mode) == e.Mode {
+		sha, mode, err := worktreeSha(p, &current)
+		if err == nil && sha == e.Sha && mode == e.Mode {
+			return nil
+		}
+	}
+	if err := writeWorktreeFile(p, e); err != nil {
+		return err
+	}
+	entry, err := newIndexEntry(p, e.Mode, e.Sha)
+	if err != nil {
+		return err
+	}
+	idx.add(entry)
+	return nil
+}
+
+// checkOverwrite makes sure none of the given paths has local changes that
+// updating them would lose.
+func checkOverwrite(idx *index, head map[string]treeEntry, paths []string, action string) error {
+	dirty := make([]string, 0)
+	untracked := make([]string, 0)
+	for _, p := range paths {
+		e, tracked := idx.entry(p)
+		h, inHead := head[p]
+
+		if !tracked {
+			if inHead {
+				// Deleted from the index, a staged change.
+				dirty = append(dirty, p)
+			} else if _, err := os.Lstat(p); err == nil {
+				untracked = append(untracked, p)
+			}
+			continue
+		}
+		if !inHead || h.Sha != e.Sha || h.Mode != int(e.Mode) {
+			dirty = append(dirty, p)
+			continue
+		}
+
+		sha, mode, err := worktreeSha(p, &e)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if sha != e.Sha || mode != int(e.Mode) {
+			dirty = append(dirty, p)
+		}
+	}
+
+	var msg strings.Builder
+	if len(dirty) > 0 {
+		fmt.Fprintf(&msg, "Your local changes to the following files would be overwritten by %s:\n", action)
+		for _, p := range dirty {
+			fmt.Fprintf(&msg, "\t%s\n", p)
+		}
+		fmt.Fprintf(&msg, "Please commit your changes or stash them before you %s.\n", action)
+	}
+	if len(untracked) > 0 {
+		fmt.Fprintf(&msg, "The following untracked working tree files would be overwritten by %s:\n", action)
+		for _, p := range untracked {
+			fmt.Fprintf(&msg, "\t%s\n", p)
+		}
+		fmt.Fprintf(&msg, "Please move or remove them before you %s.\n", action)
+	}
+	if msg.Len() > 0 {
+		return errors.New(strings.TrimSuffix(msg.String(), "\n"))
+	}
+	return nil
+}
+
+// resetMerge puts back the given files for every path whose index entry
+// differs from them, including unmerged paths. Paths the index agrees on
+// keep their unstaged changes, like "reset --merge" does.
+func resetMerge(idx *index, files map[string]treeEntry) error {
+	paths := make(map[string]string, len(idx.Entries)+len(files))
+	current := make(map[string]indexEntry, len(idx.Entries))
+	unmerged := make(map[string]bool)
+	for _, e := range idx.Entries {
+		paths[e.Path] = e.Path
+		if e.Stage == 0 {
+			current[e.Path] = e
+		} else {
+			unmerged[e.Path] = true
+		}
+	}
+	for p := range files {
+		paths[p] = p
+	}
+
+	entries := make([]indexEntry, 0, len(files))
+	for _, p := range sortedKeys(paths) {
+		e, hasEntry := files[p]
+		cur, ok := current[p]
+		if hasEntry && ok && !unmerged[p] && cur.Sha == e.Sha && int(cur.Mode) == e.Mode {
+			entries = append(entries, cur)
+			continue
+		}
+
+		if !hasEntry {
+			if err := removeWorktreeFile(p); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeWorktreeFile(p, e); err != nil {
+			return err
+		}
+		entry, err := newIndexEntry(p, e.Mode, e.Sha)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	idx.Entries = entries
+	return nil
+}
@@ -10,22 +10,34 @@ import (
 	"path/filepath"
 )
 
-func writeObject(objectType string, content []byte) ([20]byte, error) {
+func objectStore(objectType string, content []byte) []byte {
 	header := fmt.Sprintf("%s %d", objectType, len(content))
 	store := []byte(header)
 	store = append(store, '\x00')
-	store = append(store, content...)
+	return append(store, content...)
+}
+
+// hashObject computes the id an object would have without writing it.
+func hashObject(objectType string, content []byte) string {
+	return fmt.Sprintf("%x", sha1.Sum(objectStore(objectType, content)))
+}
+
+func writeObject(objectType string, content []byte) ([20]byte, error) {
+	store := objectStore(objectType, content)
 
 	checksum := sha1.Sum(store)
 	sumstr := fmt.Sprintf("%x", checksum)
 
-	dirpath := path.Join(".git/objects", sumstr[:2])
-	err := os.Mkdir(dirpath, 0755)
+	objpath := objectPath(sumstr)
+	if hasObject(sumstr) {
+		return checksum, nil
+	}
+
+	err := os.MkdirAll(path.Dir(objpath), 0755)
 	if err != nil {
 		return [20]byte{}, err
 	}
 
-	objpath := path.Join(dirpath, sumstr[2:])
 	f, err := os.Create(objpath)
 	if err != nil {
 		return [20]byte{}, err
@@ -41,54 +53,125 @@ func writeObject(objectType string, content []byte) ([20]byte, error) {
 	return checksum, nil
 }
 
-func writeBlob(filepath string) ([20]byte, error) {
+// hashFile returns the id a file gets as a blob, storing the blob when
+// write is set.
+func hashFile(filepath string, write bool) ([20]byte, error) {
 	content, err := os.ReadFile(filepath)
 	if err != nil {
 		return [20]byte{}, err
 	}
+	content, err = convertToGit(cleanPathspec(filepath), content, write)
+	if err != nil {
+		return [20]byte{}, err
+	}
 
+	if !write {
+		return sha1.Sum(objectStore("blob", content)), nil
+	}
 	return writeObject("blob", content)
 }
 
 func writeTree(rootpath string) ([20]byte, error) {
-	entries, err := os.ReadDir(rootpath)
+	ignore, err := newIgnoreMatcher()
 	if err != nil {
 		return [20]byte{}, err
 	}
+	idx, err := readIndex()
+	if err != nil {
+		return [20]byte{}, err
+	}
+	sha, _, err := writeTreeDir(ignore, trackedPaths(idx), rootpath, "")
+	return sha, err
+}
+
+// writeTreeDir writes the tree of a directory, prefix being its path in the
+// working tree. Ignored files are left out unless they are tracked. It
+// reports whether the tree is empty, as git keeps no empty subtrees.
+func writeTreeDir(ignore *ignoreMatcher, tracked map[string]bool, rootpath, prefix string) ([20]byte, bool, error) {
+	entries, err := os.ReadDir(rootpath)
+	if err != nil {
+		return [20]byte{}, false, err
+	}
 
 	var b bytes.Buffer
 	for _, entry := range entries {
 		if entry.Name() == ".git" {
 			continue
 		}
+		if name := path.Join(prefix, entry.Name()); !tracked[name] {
+			ignored, err := ignore.ignored(name, entry.IsDir())
+			if err != nil {
+				return [20]byte{}, false, err
+			}
+			if ignored {
+				continue
+			}
+		}
 
 		var mode int
 		var checksum [20]byte
-		var err error
 		objpath := filepath.Join(rootpath, entry.Name())
 		if entry.IsDir() {
+			var empty bool
 			mode = 0o040000
-			checksum, err = writeTree(objpath)
+			checksum, empty, err = writeTreeDir(ignore, tracked, objpath, path.Join(prefix, entry.Name()))
+			if err == nil && empty {
+				continue
+			}
 		} else {
 			mode = 0o100644
-			checksum, err = writeBlob(objpath)
+			checksum, err = writeWorktreeBlob(objpath, path.Join(prefix, entry.Name()))
 		}
 		if err != nil {
-			return [20]byte{}, err
+			return [20]byte{}, false, err
 		}
 
 		s := fmt.Sprintf("%o %s\x00%s", mode, entry.Name(), checksum)
 		b.WriteString(s)
 	}
 
-	return writeObject("tree", b.Bytes())
+	sha, err := writeObject("tree", b.Bytes())
+	return sha, b.Len() == 0, err
+}
+
+// writeWorktreeBlob stores a file of the working tree, converting it as
+// the attributes of its path in the tree say.
+func writeWorktreeBlob(filepath, name string) ([20]byte, error) {
+	content, err := os.ReadFile(filepath)
+	if err != nil {
+		return [20]byte{}, err
+	}
+	content, err = convertToGit(name, content, true)
+	if err != nil {
+		return [20]byte{}, err
+	}
+	return writeObject("blob", content)
 }
 
 func writeCommit(treeSha, commitSha, msg string) ([20]byte, error) {
 	content := fmt.Sprintf("tree %s\n", treeSha)
 	content += fmt.Sprintf("parent %s\n", commitSha)
-	content += fmt.Sprintf("author %s\n", "user@example.com")
-	content += fmt.Sprintf("committer %s\n\n", "user@example.com")
+	content += fmt.Sprintf("author %s\n", signature("AUTHOR"))
+	content += fmt.Sprintf("committer %s\n\n", signature("COMMITTER"))
 	content += fmt.Sprintf("%s\n", msg)
 	return writeObject("commit", []byte(content))
 }
+
+// newCommit writes a commit of the given tree on top of the given parents.
+func newCommit(tree string, parents []string, msg string) (string, error) {
+	return writeCommitObject(&commit{
+		Tree:      tree,
+		Parents:   parents,
+		Author:    signature("AUTHOR"),
+		Committer: signature("COMMITTER"),
+		Message:   msg,
+	})
+}
+
+func writeCommitObject(c *commit) (string, error) {
+	checksum, err := writeObject("commit", encodeCommit(c))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", checksum), nil
+}